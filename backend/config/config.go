@@ -20,8 +20,9 @@ type Config struct {
 
 // ServerConfig HTTP 服务器配置
 type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Host string `yaml:"host"`
+	Port           int      `yaml:"port"`
+	Host           string   `yaml:"host"`
+	TrustedProxies []string `yaml:"trusted_proxies"` // 可信反向代理地址或网段（仅其转发的 X-Forwarded-* 头生效）
 }
 
 // CoreConfig 代理核心配置
@@ -50,17 +50,38 @@ func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 从请求头获取令牌
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			// 尝试从 cookie 获取
-			token, _ = c.Cookie("SkyNeT-token")
+		if !h.service.ValidateToken(requestToken(c)) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "未授权，请先登录",
+			})
+			c.Abort()
+			return
 		}
 
-		// 移除 Bearer 前缀
-		token = strings.TrimPrefix(token, "Bearer ")
+		c.Next()
+	}
+}
+
+// WebSocketAuthMiddleware WebSocket 认证中间件
+// 浏览器无法为 WebSocket 设置请求头，额外接受 token 查询参数（升级前校验，校验后移除，不转发给核心）
+func (h *Handler) WebSocketAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		token := query.Get("token")
+		if token != "" {
+			query.Del("token")
+			c.Request.URL.RawQuery = query.Encode()
+		}
 
-		if token == "" || !h.service.ValidateToken(token) {
+		if !h.service.IsEnabled() {
+			c.Next()
+			return
+		}
+
+		if token == "" {
+			token = requestToken(c)
+		}
+		if !h.service.ValidateToken(token) {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "未授权，请先登录",
 			})
@@ -72,6 +93,16 @@ func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// requestToken 从请求头或 cookie 获取令牌
+func requestToken(c *gin.Context) string {
+	token := c.GetHeader("Authorization")
+	if token == "" {
+		token, _ = c.Cookie("SkyNeT-token")
+	}
+	// 移除 Bearer 前缀
+	return strings.TrimPrefix(token, "Bearer ")
+}
+
 // GetConfig 获取认证配置
 func (h *Handler) GetConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, h.service.GetConfig())
@@ -29,6 +29,17 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 	r.POST("/test-batch", h.TestDelayBatch)
 	r.GET("/:id/share", h.GetShareURL)
 	r.GET("/protocols/:protocol/fields", h.GetProtocolFields)
+	// 健康检查与自动隔离
+	r.GET("/health", h.GetHealth)
+	r.PUT("/health/config", h.UpdateHealthConfig)
+	r.POST("/health/check", h.RunHealthCheck)
+	r.POST("/:id/release", h.ReleaseQuarantine)
+	// 收藏与定时测速
+	r.PUT("/:id/favorite", h.SetFavorite)
+	r.GET("/:id/history", h.GetDelayHistory)
+	r.GET("/latency-schedule", h.GetLatencySchedule)
+	r.PUT("/latency-schedule", h.UpdateLatencySchedule)
+	r.POST("/latency-schedule/run", h.RunLatencySchedule)
 }
 
 // GetService 获取节点服务
@@ -268,3 +279,151 @@ func (h *Handler) GetProtocolFields(c *gin.Context) {
 		},
 	})
 }
+
+// GetHealth 获取健康检查配置与节点隔离状态
+func (h *Handler) GetHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"config": h.service.GetHealthConfig(),
+			"states": h.service.ListHealth(),
+		},
+	})
+}
+
+// UpdateHealthConfig 更新健康检查配置
+func (h *Handler) UpdateHealthConfig(c *gin.Context) {
+	var config HealthConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.UpdateHealthConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetHealthConfig(),
+	})
+}
+
+// RunHealthCheck 立即执行一次健康检查
+func (h *Handler) RunHealthCheck(c *gin.Context) {
+	changed := h.service.RunHealthCheck(true)
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"changed": changed,
+			"states":  h.service.ListHealth(),
+		},
+	})
+}
+
+// ReleaseQuarantine 手动解除节点隔离
+func (h *Handler) ReleaseQuarantine(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.service.ReleaseQuarantine(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+	})
+}
+
+// SetFavorite 设置节点收藏
+func (h *Handler) SetFavorite(c *gin.Context) {
+	var req struct {
+		Favorite bool `json:"favorite"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.SetFavorite(c.Param("id"), req.Favorite); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+	})
+}
+
+// GetDelayHistory 获取节点延迟历史
+func (h *Handler) GetDelayHistory(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetDelayHistory(c.Param("id")),
+	})
+}
+
+// GetLatencySchedule 获取定时测速配置
+func (h *Handler) GetLatencySchedule(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetLatencySchedule(),
+	})
+}
+
+// UpdateLatencySchedule 更新定时测速配置
+func (h *Handler) UpdateLatencySchedule(c *gin.Context) {
+	var schedule LatencySchedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.UpdateLatencySchedule(schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetLatencySchedule(),
+	})
+}
+
+// RunLatencySchedule 立即按定时测速配置执行一次测速（后台执行，完成后通过 WebSocket 推送）
+func (h *Handler) RunLatencySchedule(c *gin.Context) {
+	go h.service.RunScheduledLatencyTest()
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "测速已开始",
+	})
+}
@@ -0,0 +1,291 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HealthConfig 节点健康检查配置
+type HealthConfig struct {
+	Enabled          bool `json:"enabled"`          // 是否启用自动隔离
+	Interval         int  `json:"interval"`         // 检查间隔（秒）
+	Timeout          int  `json:"timeout"`          // 单次测试超时（毫秒）
+	FailThreshold    int  `json:"failThreshold"`    // 连续失败 N 次后隔离
+	RecoverThreshold int  `json:"recoverThreshold"` // 连续成功 M 次后恢复
+}
+
+// NodeHealth 节点健康状态
+type NodeHealth struct {
+	NodeID               string     `json:"nodeId"`
+	ConsecutiveFailures  int        `json:"consecutiveFailures"`
+	ConsecutiveSuccesses int        `json:"consecutiveSuccesses"`
+	Quarantined          bool       `json:"quarantined"`
+	Reason               string     `json:"reason,omitempty"`
+	QuarantinedAt        *time.Time `json:"quarantinedAt,omitempty"`
+	RestoredAt           *time.Time `json:"restoredAt,omitempty"`
+	LastCheck            *time.Time `json:"lastCheck,omitempty"`
+}
+
+// savedHealth 持久化结构
+type savedHealth struct {
+	Config HealthConfig           `json:"config"`
+	States map[string]*NodeHealth `json:"states"`
+}
+
+// GetDefaultHealthConfig 默认健康检查配置
+func GetDefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		Enabled:          false,
+		Interval:         300,
+		Timeout:          5000,
+		FailThreshold:    3,
+		RecoverThreshold: 2,
+	}
+}
+
+func (s *Service) loadHealth() {
+	filePath := filepath.Join(s.dataDir, "node_health.json")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	var saved savedHealth
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return
+	}
+	s.healthConfig = saved.Config
+	if saved.States != nil {
+		s.health = saved.States
+	}
+}
+
+func (s *Service) saveHealth() error {
+	s.mu.RLock()
+	data, err := json.MarshalIndent(savedHealth{
+		Config: s.healthConfig,
+		States: s.health,
+	}, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(s.dataDir, "node_health.json")
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// SetOnQuarantineChange 设置隔离集合变化回调（用于重新生成配置）
+func (s *Service) SetOnQuarantineChange(callback func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onQuarantineChange = callback
+}
+
+// GetHealthConfig 获取健康检查配置
+func (s *Service) GetHealthConfig() HealthConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.healthConfig
+}
+
+// UpdateHealthConfig 更新健康检查配置
+func (s *Service) UpdateHealthConfig(config HealthConfig) error {
+	if config.Interval < 30 {
+		return fmt.Errorf("检查间隔不能小于 30 秒")
+	}
+	if config.FailThreshold < 1 || config.RecoverThreshold < 1 {
+		return fmt.Errorf("失败/恢复阈值必须大于 0")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5000
+	}
+
+	s.mu.Lock()
+	s.healthConfig = config
+	s.mu.Unlock()
+
+	// 唤醒检查循环以应用新的间隔
+	select {
+	case s.healthWake <- struct{}{}:
+	default:
+	}
+	return s.saveHealth()
+}
+
+// ListHealth 获取所有节点健康状态
+func (s *Service) ListHealth() []*NodeHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*NodeHealth, 0, len(s.health))
+	for _, h := range s.health {
+		copied := *h
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NodeID < result[j].NodeID
+	})
+	return result
+}
+
+// IsQuarantined 检查节点是否处于隔离状态
+func (s *Service) IsQuarantined(nodeID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.health[nodeID]
+	return ok && h.Quarantined
+}
+
+// ReleaseQuarantine 手动解除隔离
+func (s *Service) ReleaseQuarantine(nodeID string) error {
+	s.mu.Lock()
+	h, ok := s.health[nodeID]
+	if !ok || !h.Quarantined {
+		s.mu.Unlock()
+		return fmt.Errorf("节点未被隔离")
+	}
+	now := time.Now()
+	h.Quarantined = false
+	h.Reason = ""
+	h.ConsecutiveFailures = 0
+	h.RestoredAt = &now
+	callback := s.onQuarantineChange
+	s.mu.Unlock()
+
+	s.saveHealth()
+	if callback != nil {
+		go callback()
+	}
+	return nil
+}
+
+// applyNodeHealth 把健康状态填充到节点副本（调用者需持有读锁，不可传入共享节点）
+func (s *Service) applyNodeHealth(node *Node) {
+	h, ok := s.health[node.ID]
+	if !ok {
+		node.Quarantined = false
+		node.QuarantineReason = ""
+		node.QuarantinedAt = nil
+		node.RestoredAt = nil
+		return
+	}
+	node.Quarantined = h.Quarantined
+	node.QuarantineReason = h.Reason
+	node.QuarantinedAt = h.QuarantinedAt
+	node.RestoredAt = h.RestoredAt
+}
+
+// startHealthLoop 定时健康检查循环
+func (s *Service) startHealthLoop() {
+	for {
+		s.mu.RLock()
+		interval := time.Duration(s.healthConfig.Interval) * time.Second
+		s.mu.RUnlock()
+		if interval <= 0 {
+			interval = 5 * time.Minute
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+			s.RunHealthCheck(false)
+		case <-s.healthWake:
+			timer.Stop()
+		}
+	}
+}
+
+// RunHealthCheck 执行一次健康检查，force 为 true 时忽略启用开关
+// 返回隔离集合是否发生变化
+func (s *Service) RunHealthCheck(force bool) bool {
+	s.mu.RLock()
+	config := s.healthConfig
+	s.mu.RUnlock()
+	if !config.Enabled && !force {
+		return false
+	}
+
+	nodes := s.ListAll()
+	ids := make([]string, 0, len(nodes))
+	existing := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		existing[n.ID] = true
+		if n.Enabled {
+			ids = append(ids, n.ID)
+		}
+	}
+	// 没有启用的节点时仍需清理已删除节点的健康记录
+	results := make(map[string]int)
+	if len(ids) > 0 {
+		results = s.TestDelayBatch(ids, time.Duration(config.Timeout)*time.Millisecond)
+		s.SaveDelayBatch(results)
+	}
+
+	changed := s.recordHealthResults(results, existing, config)
+	if changed {
+		s.mu.RLock()
+		callback := s.onQuarantineChange
+		s.mu.RUnlock()
+		if callback != nil {
+			callback()
+		}
+	}
+	return changed
+}
+
+// recordHealthResults 根据测试结果更新连续计数与隔离状态，existing 为当前全部节点 ID
+func (s *Service) recordHealthResults(results map[string]int, existing map[string]bool, config HealthConfig) bool {
+	now := time.Now()
+	changed := false
+
+	s.mu.Lock()
+	for id, delay := range results {
+		h, ok := s.health[id]
+		if !ok {
+			h = &NodeHealth{NodeID: id}
+			s.health[id] = h
+		}
+		checkedAt := now
+		h.LastCheck = &checkedAt
+
+		if delay > 0 {
+			h.ConsecutiveSuccesses++
+			h.ConsecutiveFailures = 0
+			if h.Quarantined && h.ConsecutiveSuccesses >= config.RecoverThreshold {
+				h.Quarantined = false
+				h.Reason = ""
+				h.RestoredAt = &checkedAt
+				changed = true
+				fmt.Printf("✅ 节点已恢复: %s\n", id)
+			}
+			continue
+		}
+
+		h.ConsecutiveFailures++
+		h.ConsecutiveSuccesses = 0
+		if !h.Quarantined && h.ConsecutiveFailures >= config.FailThreshold {
+			h.Quarantined = true
+			h.Reason = fmt.Sprintf("连续 %d 次连接测试失败", h.ConsecutiveFailures)
+			h.QuarantinedAt = &checkedAt
+			changed = true
+			fmt.Printf("🚫 节点已隔离: %s (%s)\n", id, h.Reason)
+		}
+	}
+
+	// 清理已不存在节点的健康记录（无论是否处于隔离状态）
+	for id, h := range s.health {
+		if existing[id] {
+			continue
+		}
+		if h.Quarantined {
+			changed = true
+		}
+		delete(s.health, id)
+	}
+	s.mu.Unlock()
+
+	s.saveHealth()
+	return changed
+}
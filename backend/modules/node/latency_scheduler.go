@@ -0,0 +1,294 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 定时测速范围
+const (
+	LatencyScopeAll          = "all"          // 所有启用节点
+	LatencyScopeFavorites    = "favorites"    // 仅收藏节点
+	LatencyScopeSubscription = "subscription" // 指定订阅
+)
+
+// maxDelayHistory 每个节点保留的延迟历史条数
+const maxDelayHistory = 50
+
+// LatencySchedule 定时测速配置
+type LatencySchedule struct {
+	Enabled        bool   `json:"enabled"`
+	Interval       int    `json:"interval"`                 // 测速间隔（分钟）
+	Scope          string `json:"scope"`                    // all, favorites, subscription
+	SubscriptionID string `json:"subscriptionId,omitempty"` // scope=subscription 时使用
+	Concurrency    int    `json:"concurrency"`              // 并发数
+	Timeout        int    `json:"timeout"`                  // 超时（毫秒）
+	QuietStart     string `json:"quietStart,omitempty"`     // 静默时段开始 HH:MM
+	QuietEnd       string `json:"quietEnd,omitempty"`       // 静默时段结束 HH:MM
+}
+
+// LatencyTestResult 一次定时测速的结果摘要
+type LatencyTestResult struct {
+	Scope     string         `json:"scope"`
+	Total     int            `json:"total"`
+	Success   int            `json:"success"`
+	Failed    int            `json:"failed"`
+	StartedAt time.Time      `json:"startedAt"`
+	Duration  int64          `json:"duration"` // 毫秒
+	Results   map[string]int `json:"results"`
+}
+
+// DelayRecord 延迟历史记录
+type DelayRecord struct {
+	Time  int64 `json:"time"`
+	Delay int   `json:"delay"`
+}
+
+// GetDefaultLatencySchedule 默认定时测速配置
+func GetDefaultLatencySchedule() LatencySchedule {
+	return LatencySchedule{
+		Enabled:     false,
+		Interval:    30,
+		Scope:       LatencyScopeAll,
+		Concurrency: 20,
+		Timeout:     5000,
+	}
+}
+
+func (s *Service) loadLatencySchedule() {
+	filePath := filepath.Join(s.dataDir, "latency_schedule.json")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &s.latencySchedule)
+}
+
+func (s *Service) saveLatencySchedule() error {
+	s.mu.RLock()
+	data, err := json.MarshalIndent(s.latencySchedule, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(s.dataDir, "latency_schedule.json")
+	return os.WriteFile(filePath, data, 0644)
+}
+
+func (s *Service) loadDelayHistory() {
+	filePath := filepath.Join(s.dataDir, "delay_history.json")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(data, &s.delayHistory)
+}
+
+func (s *Service) saveDelayHistory() error {
+	s.mu.RLock()
+	data, err := json.Marshal(s.delayHistory)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(s.dataDir, "delay_history.json")
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// appendDelayHistory 追加延迟历史（调用者需持有写锁）
+func (s *Service) appendDelayHistory(nodeID string, delay int, now int64) {
+	history := append(s.delayHistory[nodeID], DelayRecord{Time: now, Delay: delay})
+	if len(history) > maxDelayHistory {
+		history = history[len(history)-maxDelayHistory:]
+	}
+	s.delayHistory[nodeID] = history
+}
+
+// GetDelayHistory 获取节点延迟历史
+func (s *Service) GetDelayHistory(nodeID string) []DelayRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.delayHistory[nodeID]
+	result := make([]DelayRecord, len(history))
+	copy(result, history)
+	return result
+}
+
+// SetOnLatencyTestComplete 设置定时测速完成回调（用于推送到前端）
+func (s *Service) SetOnLatencyTestComplete(callback func(*LatencyTestResult)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onLatencyTestComplete = callback
+}
+
+// GetLatencySchedule 获取定时测速配置
+func (s *Service) GetLatencySchedule() LatencySchedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latencySchedule
+}
+
+// UpdateLatencySchedule 更新定时测速配置
+func (s *Service) UpdateLatencySchedule(schedule LatencySchedule) error {
+	if schedule.Interval < 1 {
+		return fmt.Errorf("测速间隔不能小于 1 分钟")
+	}
+	switch schedule.Scope {
+	case "":
+		schedule.Scope = LatencyScopeAll
+	case LatencyScopeAll, LatencyScopeFavorites:
+	case LatencyScopeSubscription:
+		if schedule.SubscriptionID == "" {
+			return fmt.Errorf("请指定订阅")
+		}
+		if _, err := s.subService.Get(schedule.SubscriptionID); err != nil {
+			return fmt.Errorf("订阅不存在")
+		}
+	default:
+		return fmt.Errorf("不支持的测速范围: %s", schedule.Scope)
+	}
+	if schedule.Concurrency <= 0 {
+		schedule.Concurrency = 20
+	}
+	if schedule.Timeout <= 0 {
+		schedule.Timeout = 5000
+	}
+	if (schedule.QuietStart == "") != (schedule.QuietEnd == "") {
+		return fmt.Errorf("静默时段需要同时设置开始和结束时间")
+	}
+	if schedule.QuietStart != "" {
+		if _, err := parseClock(schedule.QuietStart); err != nil {
+			return err
+		}
+		if _, err := parseClock(schedule.QuietEnd); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.latencySchedule = schedule
+	s.mu.Unlock()
+
+	select {
+	case s.latencyWake <- struct{}{}:
+	default:
+	}
+	return s.saveLatencySchedule()
+}
+
+// parseClock 解析 HH:MM，返回当天的分钟数
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误 (应为 HH:MM): %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inQuietHours 检查当前是否处于静默时段（支持跨午夜）
+func (sc LatencySchedule) inQuietHours(now time.Time) bool {
+	if sc.QuietStart == "" || sc.QuietEnd == "" {
+		return false
+	}
+	start, err1 := parseClock(sc.QuietStart)
+	end, err2 := parseClock(sc.QuietEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+	current := now.Hour()*60 + now.Minute()
+	if start < end {
+		return current >= start && current < end
+	}
+	return current >= start || current < end
+}
+
+// startLatencyLoop 定时测速循环
+func (s *Service) startLatencyLoop() {
+	for {
+		s.mu.RLock()
+		interval := time.Duration(s.latencySchedule.Interval) * time.Minute
+		s.mu.RUnlock()
+		if interval <= 0 {
+			interval = 30 * time.Minute
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+			s.mu.RLock()
+			schedule := s.latencySchedule
+			s.mu.RUnlock()
+			if !schedule.Enabled {
+				continue
+			}
+			if schedule.inQuietHours(time.Now()) {
+				fmt.Println("🌙 处于静默时段，跳过定时测速")
+				continue
+			}
+			s.RunScheduledLatencyTest()
+		case <-s.latencyWake:
+			timer.Stop()
+		}
+	}
+}
+
+// scheduledNodeIDs 按测速范围筛选节点
+func (s *Service) scheduledNodeIDs(schedule LatencySchedule) []string {
+	ids := make([]string, 0)
+	for _, n := range s.ListAll() {
+		if !n.Enabled {
+			continue
+		}
+		switch schedule.Scope {
+		case LatencyScopeFavorites:
+			if !n.Favorite {
+				continue
+			}
+		case LatencyScopeSubscription:
+			if n.SubscriptionID != schedule.SubscriptionID {
+				continue
+			}
+		}
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+// RunScheduledLatencyTest 按当前配置执行一次测速（忽略启用开关与静默时段）
+func (s *Service) RunScheduledLatencyTest() *LatencyTestResult {
+	s.mu.RLock()
+	schedule := s.latencySchedule
+	s.mu.RUnlock()
+
+	startedAt := time.Now()
+	ids := s.scheduledNodeIDs(schedule)
+	results := s.testDelayBatch(ids, time.Duration(schedule.Timeout)*time.Millisecond, schedule.Concurrency)
+	s.SaveDelayBatch(results)
+
+	result := &LatencyTestResult{
+		Scope:     schedule.Scope,
+		Total:     len(results),
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt).Milliseconds(),
+		Results:   results,
+	}
+	for _, delay := range results {
+		if delay > 0 {
+			result.Success++
+		} else {
+			result.Failed++
+		}
+	}
+	fmt.Printf("⏱️ 定时测速完成: %d 个节点, 成功 %d, 失败 %d\n", result.Total, result.Success, result.Failed)
+
+	s.mu.RLock()
+	callback := s.onLatencyTestComplete
+	s.mu.RUnlock()
+	if callback != nil {
+		callback(result)
+	}
+	return result
+}
@@ -27,6 +27,12 @@ type Node struct {
 	LastTest       int64  `json:"lastTest"` // 上次测速时间戳
 	Config         string `json:"config"`   // JSON格式的完整配置
 	ShareURL       string `json:"shareUrl"` // 分享链接
+	Favorite       bool   `json:"favorite"` // 收藏
+	// 健康隔离状态
+	Quarantined      bool       `json:"quarantined"`
+	QuarantineReason string     `json:"quarantineReason,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantinedAt,omitempty"`
+	RestoredAt       *time.Time `json:"restoredAt,omitempty"`
 }
 
 type Service struct {
@@ -35,6 +41,19 @@ type Service struct {
 	delayCache  map[string]int // 节点延迟缓存
 	subService  *subscription.Service
 	mu          sync.RWMutex
+
+	// 健康检查与自动隔离
+	healthConfig       HealthConfig
+	health             map[string]*NodeHealth
+	healthWake         chan struct{}
+	onQuarantineChange func()
+
+	// 收藏与定时测速
+	favorites             map[string]bool
+	delayHistory          map[string][]DelayRecord
+	latencySchedule       LatencySchedule
+	latencyWake           chan struct{}
+	onLatencyTestComplete func(*LatencyTestResult)
 }
 
 func NewService(dataDir string, subService *subscription.Service) *Service {
@@ -43,9 +62,24 @@ func NewService(dataDir string, subService *subscription.Service) *Service {
 		manualNodes: make(map[string]*Node),
 		delayCache:  make(map[string]int),
 		subService:  subService,
+
+		healthConfig: GetDefaultHealthConfig(),
+		health:       make(map[string]*NodeHealth),
+		healthWake:   make(chan struct{}, 1),
+
+		favorites:       make(map[string]bool),
+		delayHistory:    make(map[string][]DelayRecord),
+		latencySchedule: GetDefaultLatencySchedule(),
+		latencyWake:     make(chan struct{}, 1),
 	}
 	s.loadManualNodes()
 	s.loadDelayCache()
+	s.loadHealth()
+	s.loadFavorites()
+	s.loadDelayHistory()
+	s.loadLatencySchedule()
+	go s.startHealthLoop()
+	go s.startLatencyLoop()
 	return s
 }
 
@@ -69,28 +103,81 @@ func (s *Service) saveDelayCache() error {
 	return os.WriteFile(filePath, data, 0644)
 }
 
-// SaveDelay 保存节点延迟
+// SaveDelay 保存节点延迟（同时记录历史）
 func (s *Service) SaveDelay(nodeID string, delay int) {
 	s.mu.Lock()
 	s.delayCache[nodeID] = delay
+	s.appendDelayHistory(nodeID, delay, time.Now().Unix())
 	s.mu.Unlock()
 	s.saveDelayCache()
+	s.saveDelayHistory()
 }
 
-// SaveDelayBatch 批量保存延迟
+// SaveDelayBatch 批量保存延迟（同时记录历史）
 func (s *Service) SaveDelayBatch(results map[string]int) {
+	now := time.Now().Unix()
 	s.mu.Lock()
 	for id, delay := range results {
 		s.delayCache[id] = delay
+		s.appendDelayHistory(id, delay, now)
 	}
 	s.mu.Unlock()
 	s.saveDelayCache()
+	s.saveDelayHistory()
+}
+
+func (s *Service) loadFavorites() {
+	filePath := filepath.Join(s.dataDir, "node_favorites.json")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return
+	}
+	for _, id := range ids {
+		s.favorites[id] = true
+	}
+}
+
+func (s *Service) saveFavorites() error {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.favorites))
+	for id := range s.favorites {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(s.dataDir, "node_favorites.json")
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// SetFavorite 设置节点收藏状态
+func (s *Service) SetFavorite(nodeID string, favorite bool) error {
+	s.mu.Lock()
+	if favorite {
+		s.favorites[nodeID] = true
+	} else {
+		delete(s.favorites, nodeID)
+	}
+	s.mu.Unlock()
+	return s.saveFavorites()
 }
 
 // GetDelay 获取节点延迟
 func (s *Service) GetDelay(nodeID string) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.getDelayLocked(nodeID)
+}
+
+// getDelayLocked 获取节点延迟（调用者需持有锁）
+func (s *Service) getDelayLocked(nodeID string) int {
 	if delay, ok := s.delayCache[nodeID]; ok {
 		return delay
 	}
@@ -157,15 +244,23 @@ func (s *Service) ListAll() []*Node {
 				Config:         sn.Config,
 				ShareURL:       sn.ShareURL,
 			}
+			s.mu.RLock()
+			node.Favorite = s.favorites[nodeID]
+			s.applyNodeHealth(node)
+			s.mu.RUnlock()
 			nodes = append(nodes, node)
 		}
 	}
 
-	// 2. 添加手动节点
+	// 2. 添加手动节点（返回副本，读锁下不修改共享的节点）
 	s.mu.RLock()
-	for _, node := range s.manualNodes {
+	for _, manual := range s.manualNodes {
+		node := new(Node)
+		*node = *manual
 		// 更新手动节点的延迟
-		node.Delay = s.GetDelay(node.ID)
+		node.Delay = s.getDelayLocked(node.ID)
+		node.Favorite = s.favorites[node.ID]
+		s.applyNodeHealth(node)
 		nodes = append(nodes, node)
 	}
 	s.mu.RUnlock()
@@ -283,6 +378,14 @@ func (s *Service) TestDelay(server string, port int, timeout time.Duration) int
 
 // TestDelayBatch 批量测试延迟
 func (s *Service) TestDelayBatch(nodeIDs []string, timeout time.Duration) map[string]int {
+	return s.testDelayBatch(nodeIDs, timeout, 20)
+}
+
+// testDelayBatch 以指定并发数批量测试延迟
+func (s *Service) testDelayBatch(nodeIDs []string, timeout time.Duration, concurrency int) map[string]int {
+	if concurrency <= 0 {
+		concurrency = 20
+	}
 	results := make(map[string]int)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -294,7 +397,7 @@ func (s *Service) TestDelayBatch(nodeIDs []string, timeout time.Duration) map[st
 	}
 
 	// 限制并发数
-	sem := make(chan struct{}, 20)
+	sem := make(chan struct{}, concurrency)
 
 	for _, id := range nodeIDs {
 		node, ok := nodeMap[id]
@@ -0,0 +1,422 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// 导入 Clash / Mihomo 配置为配置模板
+// 提取 proxy-groups、rules、rule-providers；列出具体节点的代理组改为按过滤条件选节点，
+// 内联节点可选导入为手动节点，无法映射的内容逐条报告
+// ============================================================================
+
+// ClashImportOptions 导入选项
+type ClashImportOptions struct {
+	ImportProxies bool `json:"importProxies"` // 内联节点导入为手动节点
+	DryRun        bool `json:"dryRun"`        // 仅解析并报告，不保存
+}
+
+// ClashImportIssue 无法映射的条目
+type ClashImportIssue struct {
+	Kind   string `json:"kind"` // group, rule, rule-provider, proxy
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ClashImportResult 导入结果
+type ClashImportResult struct {
+	Template    *ConfigTemplate    `json:"template"`
+	ManualNodes []string           `json:"manualNodes,omitempty"` // 已导入（或将导入）的手动节点
+	Converted   []string           `json:"converted,omitempty"`   // 改为过滤选择的代理组说明
+	Unmapped    []ClashImportIssue `json:"unmapped,omitempty"`
+}
+
+// clashConfigFile 导入时关心的 Clash 配置字段
+type clashConfigFile struct {
+	Proxies       []map[string]interface{}          `yaml:"proxies"`
+	ProxyGroups   []map[string]interface{}          `yaml:"proxy-groups"`
+	Rules         []string                          `yaml:"rules"`
+	RuleProviders map[string]map[string]interface{} `yaml:"rule-providers"`
+}
+
+// ManualNodeImporter 手动节点导入函数（由节点模块提供）
+type ManualNodeImporter func(name, nodeType, server string, port int, config map[string]interface{}) error
+
+// clashBuiltinTargets Clash 内置出口：DIRECT / REJECT 可直接使用，其余映射或报告
+var clashBuiltinTargets = map[string]string{
+	PolicyDirect:  PolicyDirect,
+	PolicyReject:  PolicyReject,
+	"REJECT-DROP": PolicyReject,
+	"PASS":        "",
+	"COMPATIBLE":  "",
+}
+
+// clashImporter 单次导入的解析状态
+type clashImporter struct {
+	proxies map[string]bool // 内联节点名称
+	groups  map[string]bool // 可导入的代理组名称
+	result  *ClashImportResult
+}
+
+func (im *clashImporter) report(kind, name, format string, args ...interface{}) {
+	im.result.Unmapped = append(im.result.Unmapped, ClashImportIssue{Kind: kind, Name: name, Reason: fmt.Sprintf(format, args...)})
+}
+
+// ParseClashConfig 将 Clash / Mihomo 配置解析为配置模板（不保存）
+func ParseClashConfig(content string) (*ClashImportResult, []map[string]interface{}, error) {
+	var cfg clashConfigFile
+	if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+		return nil, nil, fmt.Errorf("解析 YAML 失败: %v", err)
+	}
+	if len(cfg.ProxyGroups) == 0 && len(cfg.Rules) == 0 {
+		return nil, nil, fmt.Errorf("配置中没有 proxy-groups 或 rules")
+	}
+
+	im := &clashImporter{
+		proxies: make(map[string]bool),
+		groups:  make(map[string]bool),
+		result:  &ClashImportResult{Template: &ConfigTemplate{}},
+	}
+	for _, p := range cfg.Proxies {
+		if name := yamlString(p["name"]); name != "" {
+			im.proxies[name] = true
+		}
+	}
+	for _, g := range cfg.ProxyGroups {
+		name := yamlString(g["name"])
+		if name != "" && policyGroupTypes[yamlString(g["type"])] {
+			im.groups[name] = true
+		}
+	}
+
+	tpl := im.result.Template
+	tpl.RuleProviders = im.importRuleProviders(cfg.RuleProviders)
+	providers := make(map[string]bool, len(tpl.RuleProviders))
+	for _, p := range tpl.RuleProviders {
+		providers[p.Name] = true
+	}
+	for _, g := range cfg.ProxyGroups {
+		if group, ok := im.importGroup(g); ok {
+			tpl.ProxyGroups = append(tpl.ProxyGroups, group)
+		}
+	}
+	for _, raw := range cfg.Rules {
+		if rule, ok := im.importRule(raw, providers); ok {
+			tpl.Rules = append(tpl.Rules, rule)
+		}
+	}
+	return im.result, cfg.Proxies, nil
+}
+
+// importGroup 转换代理组：引用其他组时保留组成员；只列出节点时改为过滤选择
+func (im *clashImporter) importGroup(g map[string]interface{}) (ProxyGroupTemplate, bool) {
+	name := yamlString(g["name"])
+	groupType := yamlString(g["type"])
+	if name == "" {
+		im.report("group", "", "缺少名称")
+		return ProxyGroupTemplate{}, false
+	}
+	if !policyGroupTypes[groupType] {
+		im.report("group", name, "不支持的代理组类型 %s", groupType)
+		return ProxyGroupTemplate{}, false
+	}
+
+	group := ProxyGroupTemplate{
+		Name:      name,
+		Type:      groupType,
+		Icon:      yamlString(g["icon"]),
+		Enabled:   true,
+		URL:       yamlString(g["url"]),
+		Interval:  yamlInt(g["interval"]),
+		Tolerance: yamlInt(g["tolerance"]),
+		Lazy:      yamlBool(g["lazy"]),
+		Hidden:    yamlBool(g["hidden"]),
+	}
+	if groupType == "load-balance" {
+		group.Strategy = yamlString(g["strategy"])
+	}
+
+	members := make([]string, 0)
+	nodes := make([]string, 0)
+	for _, item := range yamlStrings(g["proxies"]) {
+		if target, builtin := clashBuiltinTargets[item]; builtin {
+			if target == "" {
+				im.report("group", name, "成员 %s 无对应出口，已移除", item)
+				continue
+			}
+			members = append(members, target)
+			continue
+		}
+		if im.groups[item] {
+			members = append(members, item)
+			continue
+		}
+		nodes = append(nodes, item)
+	}
+
+	filter := yamlString(g["filter"])
+	includeAll := yamlBool(g["include-all"]) || yamlBool(g["include-all-proxies"]) || yamlBool(g["include-all-providers"])
+	if uses := yamlStrings(g["use"]); len(uses) > 0 {
+		im.report("group", name, "代理提供者 %s 未导入，改为从全部节点选择", strings.Join(uses, ", "))
+		includeAll = true
+	}
+
+	switch {
+	case len(members) > 0 && (len(nodes) > 0 || includeAll || filter != ""):
+		// 模板分组不能同时引用策略组与节点，保留策略组成员
+		group.Proxies = members
+		im.report("group", name, "同时包含策略组与节点，仅保留策略组成员（移除 %d 个节点）", len(nodes))
+	case len(members) > 0:
+		group.Proxies = members
+	case includeAll || filter != "":
+		group.UseAll = true
+		group.Filter = filter
+	case len(nodes) > 0:
+		group.UseAll = true
+		group.Filter = im.nodesFilter(nodes)
+		if group.Filter == "" {
+			im.result.Converted = append(im.result.Converted, fmt.Sprintf("%s: %d 个节点 → 全部节点", name, len(nodes)))
+		} else {
+			im.result.Converted = append(im.result.Converted, fmt.Sprintf("%s: %d 个节点 → 过滤 %s", name, len(nodes), group.Filter))
+		}
+	default:
+		group.Proxies = []string{PolicyDirect}
+		im.report("group", name, "没有可用成员，已设为 DIRECT")
+	}
+	return group, true
+}
+
+// nodesFilter 为具体节点列表生成过滤条件：包含全部节点时不过滤，同属一个地区时使用地区正则，
+// 否则精确匹配节点名称
+func (im *clashImporter) nodesFilter(nodes []string) string {
+	all := len(im.proxies) > 0
+	for name := range im.proxies {
+		if !containsString(nodes, name) {
+			all = false
+			break
+		}
+	}
+	if all {
+		return ""
+	}
+
+	patterns := ActiveRegions()
+	var region *RegionPattern
+	for _, node := range nodes {
+		r, ok := ClassifyNode(patterns, node)
+		if !ok || (region != nil && region.ID != r.ID) {
+			region = nil
+			break
+		}
+		region = &r
+	}
+	if region != nil {
+		return region.Pattern
+	}
+
+	quoted := make([]string, len(nodes))
+	for i, node := range nodes {
+		quoted[i] = regexp.QuoteMeta(node)
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
+// importRule 转换规则（TYPE,payload,target[,no-resolve]）
+func (im *clashImporter) importRule(raw string, providers map[string]bool) (RuleTemplate, bool) {
+	parts := strings.Split(raw, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	ruleType := strings.ToUpper(parts[0])
+	if ruleType == "FINAL" {
+		ruleType = "MATCH"
+	}
+	if !policyRuleTypes[ruleType] {
+		im.report("rule", raw, "不支持的规则类型 %s", parts[0])
+		return RuleTemplate{}, false
+	}
+
+	rule := RuleTemplate{Type: ruleType}
+	if ruleType == "MATCH" {
+		if len(parts) < 2 {
+			im.report("rule", raw, "缺少目标")
+			return RuleTemplate{}, false
+		}
+		rule.Proxy = parts[1]
+	} else {
+		if len(parts) < 3 {
+			im.report("rule", raw, "格式无效")
+			return RuleTemplate{}, false
+		}
+		rule.Payload = parts[1]
+		rule.Proxy = parts[2]
+		for _, opt := range parts[3:] {
+			if strings.EqualFold(opt, "no-resolve") {
+				rule.NoResolve = true
+			}
+		}
+	}
+
+	if target, builtin := clashBuiltinTargets[rule.Proxy]; builtin {
+		if target == "" {
+			im.report("rule", raw, "目标 %s 无对应出口", rule.Proxy)
+			return RuleTemplate{}, false
+		}
+		rule.Proxy = target
+	} else if !im.groups[rule.Proxy] {
+		reason := "目标策略组不存在"
+		if im.proxies[rule.Proxy] {
+			reason = "规则直接指向节点，请改为指向策略组"
+		}
+		im.report("rule", raw, "%s: %s", reason, rule.Proxy)
+		return RuleTemplate{}, false
+	}
+	if ruleType == "RULE-SET" && !providers[rule.Payload] {
+		im.report("rule", raw, "规则集 %s 未导入", rule.Payload)
+		return RuleTemplate{}, false
+	}
+	return rule, true
+}
+
+// importRuleProviders 转换规则提供者（inline 类型无法映射）
+func (im *clashImporter) importRuleProviders(providers map[string]map[string]interface{}) []RuleProviderTemplate {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]RuleProviderTemplate, 0, len(providers))
+	for _, name := range names {
+		p := providers[name]
+		providerType := yamlString(p["type"])
+		if providerType != "http" && providerType != "file" {
+			im.report("rule-provider", name, "不支持的规则提供者类型 %s", providerType)
+			continue
+		}
+		result = append(result, RuleProviderTemplate{
+			Name:     name,
+			Type:     providerType,
+			Behavior: yamlString(p["behavior"]),
+			URL:      yamlString(p["url"]),
+			Path:     yamlString(p["path"]),
+			Interval: yamlInt(p["interval"]),
+			Format:   yamlString(p["format"]),
+		})
+	}
+	return result
+}
+
+// ImportClashConfig 导入 Clash / Mihomo 配置为配置模板（同步到分流策略）
+func (s *Service) ImportClashConfig(content string, options ClashImportOptions) (*ClashImportResult, error) {
+	result, proxies, err := ParseClashConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateProxyGroups(result.Template.ProxyGroups); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	policy := s.routingPolicy
+	importer := s.manualNodeImporter
+	s.mu.RUnlock()
+	if policy == nil {
+		policy = &RoutingPolicy{}
+	}
+	if err := policy.withConfigTemplate(result.Template).Validate(); err != nil {
+		return nil, fmt.Errorf("导入后的分流策略无效: %w", err)
+	}
+
+	if options.ImportProxies {
+		for _, p := range proxies {
+			name, nodeType, server := yamlString(p["name"]), yamlString(p["type"]), yamlString(p["server"])
+			if name == "" || nodeType == "" || server == "" {
+				result.Unmapped = append(result.Unmapped, ClashImportIssue{Kind: "proxy", Name: name, Reason: "缺少 name、type 或 server"})
+				continue
+			}
+			if !options.DryRun {
+				if importer == nil {
+					return nil, fmt.Errorf("节点导入不可用")
+				}
+				if err := importer(name, nodeType, server, yamlInt(p["port"]), p); err != nil {
+					result.Unmapped = append(result.Unmapped, ClashImportIssue{Kind: "proxy", Name: name, Reason: err.Error()})
+					continue
+				}
+			}
+			result.ManualNodes = append(result.ManualNodes, name)
+		}
+	}
+	if options.DryRun {
+		return result, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configTemplate = result.Template
+	if err := s.saveConfigTemplate(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("✓ 已导入 Clash 配置: %d 个代理组, %d 条规则, %d 个规则提供者\n",
+		len(result.Template.ProxyGroups), len(result.Template.Rules), len(result.Template.RuleProviders))
+	return result, nil
+}
+
+// SetManualNodeImporter 设置手动节点导入函数
+func (s *Service) SetManualNodeImporter(importer ManualNodeImporter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manualNodeImporter = importer
+}
+
+// ============================================================================
+// YAML 值辅助
+// ============================================================================
+
+func yamlString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case int:
+		return strconv.Itoa(val)
+	}
+	return ""
+}
+
+func yamlInt(v interface{}) int {
+	switch val := v.(type) {
+	case int:
+		return val
+	case float64:
+		return int(val)
+	case string:
+		n, _ := strconv.Atoi(val)
+		return n
+	}
+	return 0
+}
+
+func yamlBool(v interface{}) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+func yamlStrings(v interface{}) []string {
+	if list, ok := v.([]string); ok {
+		return list
+	}
+	list, _ := v.([]interface{})
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if s := yamlString(item); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,418 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigDiffRequest 配置差异预览请求（字段为空时使用当前值）
+type ConfigDiffRequest struct {
+	CoreType string                 `json:"coreType,omitempty"`
+	Config   map[string]interface{} `json:"config,omitempty"`   // 拟修改的代理配置（部分字段，同 PATCH /config）
+	Template *ConfigTemplate        `json:"template,omitempty"` // 拟使用的配置模板
+	Policy   *RoutingPolicy         `json:"policy,omitempty"`   // 拟使用的分流策略（优先于模板）
+	Settings *ProxySettings         `json:"settings,omitempty"` // 拟使用的代理设置
+}
+
+// DiffItem 单项差异
+type DiffItem struct {
+	Key    string      `json:"key"`
+	Old    interface{} `json:"old,omitempty"`
+	New    interface{} `json:"new,omitempty"`
+	Fields []string    `json:"fields,omitempty"` // 发生变化的字段
+}
+
+// SectionDiff 配置段差异
+type SectionDiff struct {
+	Section   string     `json:"section"` // general, proxies, proxy-groups, rules, rule-providers, dns, tun, inbounds
+	Added     []DiffItem `json:"added"`
+	Removed   []DiffItem `json:"removed"`
+	Changed   []DiffItem `json:"changed"`
+	Reordered bool       `json:"reordered,omitempty"` // 条目相同但顺序变化
+}
+
+// ConfigDiff 待生成配置与当前配置的语义差异
+type ConfigDiff struct {
+	CoreType    string        `json:"coreType"`
+	CurrentPath string        `json:"currentPath"`
+	HasCurrent  bool          `json:"hasCurrent"` // 当前配置文件是否存在
+	Changed     bool          `json:"changed"`
+	Sections    []SectionDiff `json:"sections"`
+}
+
+// DiffConfig 在内存中按当前或拟修改的配置、模板与设置生成配置，并与正在使用的配置文件比较
+func (s *Service) DiffConfig(req ConfigDiffRequest) (*ConfigDiff, error) {
+	s.mu.RLock()
+	config := *s.config
+	template := s.configTemplate
+	policy := s.routingPolicy
+	coreType := s.coreType
+	currentPath := s.configPath
+	s.mu.RUnlock()
+
+	if req.CoreType != "" {
+		if req.CoreType != "mihomo" && req.CoreType != "singbox" {
+			return nil, fmt.Errorf("不支持的核心类型: %s", req.CoreType)
+		}
+		if req.CoreType != coreType {
+			currentPath = ""
+		}
+		coreType = req.CoreType
+	}
+	if req.Config != nil {
+		applyConfigPatch(&config, req.Config)
+	}
+	if req.Template != nil {
+		template = req.Template
+		if policy != nil {
+			policy = policy.withConfigTemplate(req.Template)
+		}
+	}
+	if req.Policy != nil {
+		if err := req.Policy.Validate(); err != nil {
+			return nil, err
+		}
+		policy = req.Policy
+	}
+	settings := req.Settings
+	if settings == nil && s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+
+	if s.nodeProvider == nil {
+		return nil, fmt.Errorf("节点提供者未设置")
+	}
+	nodes := s.nodeProvider()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("没有可用节点")
+	}
+
+	// 生成拟应用的配置（不写入文件）
+	options := buildGeneratorOptions(&config, template, policy, settings)
+	mihomoConfig, singboxConfig, err := s.generateInMemory(coreType, options, nodes)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.renderConfig(mihomoConfig, singboxConfig)
+	if err != nil {
+		return nil, err
+	}
+	proposed, err := parseConfigDocument(data, singboxConfig == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// 读取正在使用的配置
+	if currentPath == "" {
+		if coreType == "singbox" {
+			currentPath = filepath.Join(s.dataDir, "configs", "singbox-config.json")
+		} else {
+			currentPath = filepath.Join(s.dataDir, "configs", "config.yaml")
+		}
+	}
+	result := &ConfigDiff{CoreType: coreType, CurrentPath: currentPath}
+	current := map[string]interface{}{}
+	if data, err := os.ReadFile(currentPath); err == nil {
+		parsed, err := parseConfigDocument(data, coreType != "singbox")
+		if err != nil {
+			return nil, fmt.Errorf("解析当前配置失败: %w", err)
+		}
+		current = parsed
+		result.HasCurrent = true
+	}
+
+	if coreType == "singbox" {
+		result.Sections = diffSingBoxConfig(current, proposed)
+	} else {
+		result.Sections = diffMihomoConfig(current, proposed)
+	}
+	for _, section := range result.Sections {
+		if len(section.Added) > 0 || len(section.Removed) > 0 || len(section.Changed) > 0 || section.Reordered {
+			result.Changed = true
+			break
+		}
+	}
+	return result, nil
+}
+
+// parseConfigDocument 将 YAML/JSON 配置解析为通用结构（统一经 JSON 归一化数值类型）
+func parseConfigDocument(data []byte, isYAML bool) (map[string]interface{}, error) {
+	var doc interface{}
+	if isYAML {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		normalized, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		data = normalized
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// diffMihomoConfig 比较 mihomo 配置
+func diffMihomoConfig(old, updated map[string]interface{}) []SectionDiff {
+	sectioned := map[string]bool{
+		"proxies": true, "proxy-groups": true, "rules": true,
+		"rule-providers": true, "dns": true, "tun": true,
+	}
+	return []SectionDiff{
+		diffGeneral(old, updated, sectioned),
+		diffKeyedList("proxies", toList(old["proxies"]), toList(updated["proxies"]), "name"),
+		diffKeyedList("proxy-groups", toList(old["proxy-groups"]), toList(updated["proxy-groups"]), "name"),
+		diffRuleList("rules", toList(old["rules"]), toList(updated["rules"])),
+		diffObject("rule-providers", toObject(old["rule-providers"]), toObject(updated["rule-providers"])),
+		diffObject("dns", toObject(old["dns"]), toObject(updated["dns"])),
+		diffObject("tun", toObject(old["tun"]), toObject(updated["tun"])),
+	}
+}
+
+// diffSingBoxConfig 比较 sing-box 配置（出站拆分为节点与代理组，TUN 取 tun 入站）
+func diffSingBoxConfig(old, updated map[string]interface{}) []SectionDiff {
+	oldProxies, oldGroups := splitSingBoxOutbounds(toList(old["outbounds"]))
+	newProxies, newGroups := splitSingBoxOutbounds(toList(updated["outbounds"]))
+	oldInbounds, oldTun := splitSingBoxInbounds(toList(old["inbounds"]))
+	newInbounds, newTun := splitSingBoxInbounds(toList(updated["inbounds"]))
+	oldRoute := toObject(old["route"])
+	newRoute := toObject(updated["route"])
+
+	general := diffGeneral(flattenRoute(old), flattenRoute(updated), map[string]bool{
+		"outbounds": true, "inbounds": true, "dns": true, "route": true,
+	})
+
+	return []SectionDiff{
+		general,
+		diffKeyedList("proxies", oldProxies, newProxies, "tag"),
+		diffKeyedList("proxy-groups", oldGroups, newGroups, "tag"),
+		diffRuleList("rules", toList(oldRoute["rules"]), toList(newRoute["rules"])),
+		diffKeyedList("rule-providers", toList(oldRoute["rule_set"]), toList(newRoute["rule_set"]), "tag"),
+		diffObject("dns", toObject(old["dns"]), toObject(updated["dns"])),
+		diffObject("tun", oldTun, newTun),
+		diffKeyedList("inbounds", oldInbounds, newInbounds, "tag"),
+	}
+}
+
+// splitSingBoxOutbounds 将出站拆分为节点与代理组
+func splitSingBoxOutbounds(outbounds []interface{}) (proxies, groups []interface{}) {
+	for _, item := range outbounds {
+		switch toObject(item)["type"] {
+		case "selector", "urltest":
+			groups = append(groups, item)
+		default:
+			proxies = append(proxies, item)
+		}
+	}
+	return proxies, groups
+}
+
+// splitSingBoxInbounds 拆分出 tun 入站
+func splitSingBoxInbounds(inbounds []interface{}) ([]interface{}, map[string]interface{}) {
+	others := make([]interface{}, 0, len(inbounds))
+	tun := map[string]interface{}{}
+	for _, item := range inbounds {
+		if obj := toObject(item); obj["type"] == "tun" {
+			tun = obj
+			continue
+		}
+		others = append(others, item)
+	}
+	return others, tun
+}
+
+// flattenRoute 将 route 的非规则字段展开为 route.xxx 顶层键，便于归入 general
+func flattenRoute(config map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		result[k] = v
+	}
+	for k, v := range toObject(config["route"]) {
+		if k != "rules" && k != "rule_set" {
+			result["route."+k] = v
+		}
+	}
+	return result
+}
+
+// diffGeneral 比较未单独分段的顶层字段
+func diffGeneral(old, updated map[string]interface{}, exclude map[string]bool) SectionDiff {
+	filter := func(m map[string]interface{}) map[string]interface{} {
+		result := make(map[string]interface{})
+		for k, v := range m {
+			if !exclude[k] {
+				result[k] = v
+			}
+		}
+		return result
+	}
+	return diffObject("general", filter(old), filter(updated))
+}
+
+// diffObject 按键比较对象
+func diffObject(section string, old, updated map[string]interface{}) SectionDiff {
+	diff := newSectionDiff(section)
+	for _, key := range unionKeys(old, updated) {
+		oldVal, inOld := old[key]
+		newVal, inNew := updated[key]
+		switch {
+		case !inOld:
+			diff.Added = append(diff.Added, DiffItem{Key: key, New: newVal})
+		case !inNew:
+			diff.Removed = append(diff.Removed, DiffItem{Key: key, Old: oldVal})
+		case !reflect.DeepEqual(oldVal, newVal):
+			item := DiffItem{Key: key, Old: oldVal, New: newVal}
+			oldObj, oldIsObj := oldVal.(map[string]interface{})
+			newObj, newIsObj := newVal.(map[string]interface{})
+			if oldIsObj && newIsObj {
+				item.Fields = changedFields(oldObj, newObj)
+			}
+			diff.Changed = append(diff.Changed, item)
+		}
+	}
+	return diff
+}
+
+// diffKeyedList 比较以 name/tag 标识的列表（节点、代理组等）
+func diffKeyedList(section string, old, updated []interface{}, keyField string) SectionDiff {
+	diff := newSectionDiff(section)
+	oldMap := make(map[string]map[string]interface{})
+	oldOrder := make([]string, 0, len(old))
+	for _, item := range old {
+		obj := toObject(item)
+		key := fmt.Sprint(obj[keyField])
+		oldMap[key] = obj
+		oldOrder = append(oldOrder, key)
+	}
+
+	newKeys := make(map[string]bool)
+	commonNew := make([]string, 0, len(updated))
+	for _, item := range updated {
+		obj := toObject(item)
+		key := fmt.Sprint(obj[keyField])
+		newKeys[key] = true
+		oldObj, ok := oldMap[key]
+		if !ok {
+			diff.Added = append(diff.Added, DiffItem{Key: key, New: obj})
+			continue
+		}
+		commonNew = append(commonNew, key)
+		if !reflect.DeepEqual(oldObj, obj) {
+			diff.Changed = append(diff.Changed, DiffItem{
+				Key:    key,
+				Old:    oldObj,
+				New:    obj,
+				Fields: changedFields(oldObj, obj),
+			})
+		}
+	}
+
+	commonOld := make([]string, 0, len(oldOrder))
+	for _, key := range oldOrder {
+		if !newKeys[key] {
+			diff.Removed = append(diff.Removed, DiffItem{Key: key, Old: oldMap[key]})
+			continue
+		}
+		commonOld = append(commonOld, key)
+	}
+	diff.Reordered = !reflect.DeepEqual(commonOld, commonNew)
+	return diff
+}
+
+// diffRuleList 比较规则列表（规则按内容匹配，顺序变化单独标记）
+func diffRuleList(section string, old, updated []interface{}) SectionDiff {
+	diff := newSectionDiff(section)
+	ruleKey := func(rule interface{}) string {
+		if str, ok := rule.(string); ok {
+			return str
+		}
+		data, _ := json.Marshal(rule)
+		return string(data)
+	}
+
+	remaining := make(map[string]int)
+	for _, rule := range old {
+		remaining[ruleKey(rule)]++
+	}
+	commonNew := make([]string, 0, len(updated))
+	for i, rule := range updated {
+		key := ruleKey(rule)
+		if remaining[key] > 0 {
+			remaining[key]--
+			commonNew = append(commonNew, key)
+			continue
+		}
+		diff.Added = append(diff.Added, DiffItem{Key: fmt.Sprintf("%d", i), New: rule})
+	}
+
+	commonOld := make([]string, 0, len(old))
+	for i, rule := range old {
+		key := ruleKey(rule)
+		if remaining[key] > 0 {
+			remaining[key]--
+			diff.Removed = append(diff.Removed, DiffItem{Key: fmt.Sprintf("%d", i), Old: rule})
+			continue
+		}
+		commonOld = append(commonOld, key)
+	}
+	diff.Reordered = !reflect.DeepEqual(commonOld, commonNew)
+	return diff
+}
+
+func newSectionDiff(section string) SectionDiff {
+	return SectionDiff{
+		Section: section,
+		Added:   []DiffItem{},
+		Removed: []DiffItem{},
+		Changed: []DiffItem{},
+	}
+}
+
+// changedFields 返回两个对象间值不同的字段名
+func changedFields(old, updated map[string]interface{}) []string {
+	fields := make([]string, 0)
+	for _, key := range unionKeys(old, updated) {
+		if !reflect.DeepEqual(old[key], updated[key]) {
+			fields = append(fields, key)
+		}
+	}
+	return fields
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	keys := make([]string, 0, len(a)+len(b))
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toList(v interface{}) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	return nil
+}
+
+func toObject(v interface{}) map[string]interface{} {
+	if obj, ok := v.(map[string]interface{}); ok {
+		return obj
+	}
+	return map[string]interface{}{}
+}
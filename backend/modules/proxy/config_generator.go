@@ -25,8 +25,10 @@ type MihomoConfig struct {
 	Mode               string `yaml:"mode"`
 	LogLevel           string `yaml:"log-level"`
 	IPv6               bool   `yaml:"ipv6"`
-	ExternalController string `yaml:"external-controller"`
+	ExternalController string `yaml:"external-controller,omitempty"`
+	ControllerUnix     string `yaml:"external-controller-unix,omitempty"`
 	Secret             string `yaml:"secret,omitempty"`
+	RoutingMark        int    `yaml:"routing-mark,omitempty"`
 
 	// 高级配置
 	UnifiedDelay       bool     `yaml:"unified-delay,omitempty"`
@@ -48,16 +50,24 @@ type MihomoConfig struct {
 	DisableKeepAlive  bool `yaml:"disable-keep-alive,omitempty"` // 完全禁用 (省电模式)
 
 	// 模块配置
-	Profile *ProfileConfig `yaml:"profile,omitempty"`
-	DNS     *DNSConfig     `yaml:"dns,omitempty"`
-	TUN     *TUNConfig     `yaml:"tun,omitempty"`
-	Sniffer *SnifferConfig `yaml:"sniffer,omitempty"`
+	Profile *ProfileConfig         `yaml:"profile,omitempty"`
+	DNS     *DNSConfig             `yaml:"dns,omitempty"`
+	Hosts   map[string]interface{} `yaml:"hosts,omitempty"` // 自定义解析（域名 -> IP 或 IP 列表）
+	TUN     *TUNConfig             `yaml:"tun,omitempty"`
+	Sniffer *SnifferConfig         `yaml:"sniffer,omitempty"`
 
 	// 代理配置
-	Proxies       []map[string]interface{} `yaml:"proxies"`
-	ProxyGroups   []ProxyGroup             `yaml:"proxy-groups"`
-	RuleProviders map[string]RuleProvider  `yaml:"rule-providers,omitempty"`
-	Rules         []string                 `yaml:"rules"`
+	ProxyProviders map[string]ProxyProvider `yaml:"proxy-providers,omitempty"` // 提供者模式：订阅节点
+	Proxies        []map[string]interface{} `yaml:"proxies"`
+	ProxyGroups    []ProxyGroup             `yaml:"proxy-groups"`
+	RuleProviders  map[string]RuleProvider  `yaml:"rule-providers,omitempty"`
+	Rules          []string                 `yaml:"rules"`
+
+	// 额外入站（节点检测专用监听）
+	Listeners []map[string]interface{} `yaml:"listeners,omitempty"`
+
+	// 提供者文件内容（提供者名称 -> YAML），随配置一起写入，不参与序列化
+	providerFiles map[string][]byte
 }
 
 // GeoxURL GEO 数据源
@@ -109,6 +119,7 @@ type DNSConfig struct {
 	EnhancedMode          string              `yaml:"enhanced-mode,omitempty"`
 	FakeIPRange           string              `yaml:"fake-ip-range,omitempty"`
 	FakeIPFilter          []string            `yaml:"fake-ip-filter,omitempty"`
+	FakeIPFilterMode      string              `yaml:"fake-ip-filter-mode,omitempty"` // blacklist / whitelist
 	RespectRules          bool                `yaml:"respect-rules,omitempty"`
 	DefaultNameserver     []string            `yaml:"default-nameserver,omitempty"`
 	ProxyServerNameserver []string            `yaml:"proxy-server-nameserver,omitempty"`
@@ -158,6 +169,9 @@ type ProxyGroup struct {
 	Proxies  []string `yaml:"proxies"`
 	URL      string   `yaml:"url,omitempty"`
 	Interval int      `yaml:"interval,omitempty"`
+	Strategy string   `yaml:"strategy,omitempty"` // load-balance 策略
+	Use      []string `yaml:"use,omitempty"`      // 引用的代理提供者
+	Filter   string   `yaml:"filter,omitempty"`   // 提供者节点过滤（正则）
 }
 
 // ProxyNode 代理节点
@@ -169,6 +183,8 @@ type ProxyNode struct {
 	ServerPort int    `json:"serverPort"` // 兼容 node 模块的字段名
 	Config     string `json:"config"`     // JSON 格式的完整配置
 	IsManual   bool   `json:"isManual"`   // 是否手动添加的节点
+
+	SubscriptionID string `json:"subscriptionId,omitempty"` // 所属订阅（手动节点为空）
 }
 
 // GetPort 获取端口（兼容两种字段名）
@@ -191,21 +207,31 @@ type ConfigGeneratorOptions struct {
 	// 透明代理
 	EnableTProxy bool `json:"enableTProxy"`
 	TProxyPort   int  `json:"tproxyPort"`
+	RedirPort    int  `json:"redirPort"`
+	RoutingMark  int  `json:"routingMark"` // 核心出站标记，nftables 规则据此放行
 
 	// TUN 模式
 	EnableTUN bool `json:"enableTun"`
 
 	// DNS 设置
-	EnableDNS    bool     `json:"enableDns"`
-	DNSListen    string   `json:"dnsListen"`
-	EnhancedMode string   `json:"enhancedMode"` // fake-ip, redir-host
-	Nameservers  []string `json:"nameservers"`
-	Fallback     []string `json:"fallback"`
+	EnableDNS    bool         `json:"enableDns"`
+	DNSSettings  *DNSSettings `json:"dnsSettings"` // 代理设置中的 DNS 策略（域名策略、hosts、fake-ip 过滤、ECS）
+	DNSListen    string       `json:"dnsListen"`
+	EnhancedMode string       `json:"enhancedMode"` // fake-ip, redir-host
+	Nameservers  []string     `json:"nameservers"`
+	Fallback     []string     `json:"fallback"`
 
 	// API
 	ExternalController string `json:"externalController"`
+	ControllerSocket   string `json:"controllerSocket"` // Unix 套接字，设置后不再监听 TCP
 	Secret             string `json:"secret"`
 
+	// 节点检测端口（0 表示不生成检测入站）
+	CheckPort int `json:"checkPort"`
+
+	// 提供者模式：订阅节点写入提供者文件，通过 proxy-providers 引用
+	ProviderMode bool `json:"providerMode"`
+
 	// 性能优化设置（从 ProxySettings 读取）
 	UnifiedDelay            bool   `json:"unifiedDelay"`
 	TCPConcurrent           bool   `json:"tcpConcurrent"`
@@ -229,6 +255,11 @@ type ConfigGeneratorOptions struct {
 
 	// 配置模板（可选，为 nil 时使用默认生成）
 	Template *ConfigTemplate `json:"-"`
+
+	// 分流策略（可选，设置后优先于配置模板）
+	Policy *RoutingPolicy `json:"-"`
+	// 局域网设备策略（已解析来源地址）
+	Devices []resolvedDevice `json:"-"`
 }
 
 // ConfigGenerator 配置生成器
@@ -274,7 +305,9 @@ func (g *ConfigGenerator) GenerateConfig(nodes []ProxyNode, options ConfigGenera
 	if options.LogLevel == "" {
 		options.LogLevel = "info"
 	}
-	if options.ExternalController == "" {
+	if options.ControllerSocket != "" {
+		options.ExternalController = ""
+	} else if options.ExternalController == "" {
 		options.ExternalController = "127.0.0.1:9090"
 	}
 
@@ -286,6 +319,7 @@ func (g *ConfigGenerator) GenerateConfig(nodes []ProxyNode, options ConfigGenera
 		LogLevel:           options.LogLevel,
 		IPv6:               options.IPv6,
 		ExternalController: options.ExternalController,
+		ControllerUnix:     options.ControllerSocket,
 		Secret:             options.Secret,
 
 		// 高级配置 (从代理设置读取)
@@ -321,13 +355,18 @@ func (g *ConfigGenerator) GenerateConfig(nodes []ProxyNode, options ConfigGenera
 		if options.TProxyPort > 0 {
 			config.TProxyPort = options.TProxyPort
 		}
-		// Redir 端口 (用于 iptables REDIRECT)
-		config.RedirPort = 7892
+		// Redir 端口 (用于 nftables REDIRECT)
+		config.RedirPort = getOrDefaultInt(options.RedirPort, 7892)
+		// 核心出站打标，避免被透明代理规则再次拦截
+		config.RoutingMark = options.RoutingMark
 	}
 	// 系统代理模式不设置 redir-port 和 tproxy-port
 
 	// DNS 配置
 	config.DNS = g.generateDNSConfig(options)
+	if options.Policy != nil {
+		applyPolicyMihomoDNS(config.DNS, options.Policy.DNS)
+	}
 
 	// TUN 配置 (从代理设置读取)
 	if options.EnableTUN {
@@ -404,6 +443,9 @@ func (g *ConfigGenerator) GenerateConfig(nodes []ProxyNode, options ConfigGenera
 		}
 	}
 
+	// 代理设置中的 DNS 策略（在 TUN 调整之后应用，以便 fake-ip 过滤生效）
+	applyDNSSettingsMihomo(config, options.DNSSettings)
+
 	// 嗅探配置
 	config.Sniffer = &SnifferConfig{
 		Enable:          true,
@@ -420,25 +462,127 @@ func (g *ConfigGenerator) GenerateConfig(nodes []ProxyNode, options ConfigGenera
 		},
 	}
 
-	// 转换代理节点
-	config.Proxies = g.convertProxies(nodes)
+	// 转换代理节点（提供者模式下订阅节点移入提供者，配置中只保留手动节点）
+	groupNodes := nodes
+	var providerNames []string
+	if options.ProviderMode && options.Policy != nil {
+		groupNodes, config.ProxyProviders, config.providerFiles = g.buildProxyProviders(nodes)
+		providerNames = sortedProviderNames(config.ProxyProviders)
+	}
+	config.Proxies = g.convertProxies(groupNodes)
+
+	if options.Policy != nil {
+		// 使用分流策略生成代理组、规则提供者与规则
+		config.ProxyGroups, config.RuleProviders, config.Rules = translatePolicyToMihomo(options.Policy, groupNodes, providerNames, g.dataDir)
+	} else {
+		// 生成代理组（始终使用模板，确保名称一致）
+		template := options.Template
+		if template == nil {
+			template = GetDefaultConfigTemplate()
+		}
+		config.ProxyGroups = g.generateProxyGroupsFromTemplate(nodes, template.ProxyGroups)
+
+		// 生成规则提供者
+		config.RuleProviders = g.generateRuleProviders()
 
-	// 生成代理组（始终使用模板，确保名称一致）
-	template := options.Template
-	if template == nil {
-		template = GetDefaultConfigTemplate()
+		// 生成规则（使用模板中的规则）
+		config.Rules = g.generateRulesFromTemplate(template.Rules)
 	}
-	config.ProxyGroups = g.generateProxyGroupsFromTemplate(nodes, template.ProxyGroups)
 
-	// 生成规则提供者
-	config.RuleProviders = g.generateRuleProviders()
+	// 局域网设备规则优先于全局规则
+	if len(options.Devices) > 0 {
+		config.Rules = append(mihomoDeviceRules(options.Devices, config), config.Rules...)
+	}
 
-	// 生成规则（使用模板中的规则）
-	config.Rules = g.generateRulesFromTemplate(template.Rules)
+	// 节点检测入站：固定走检测组，不经过规则
+	if options.CheckPort > 0 && (len(config.Proxies) > 0 || len(providerNames) > 0) {
+		names := make([]string, 0, len(config.Proxies))
+		for _, p := range config.Proxies {
+			if name, ok := p["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+		config.ProxyGroups = append(config.ProxyGroups, ProxyGroup{
+			Name:    NodeCheckGroup,
+			Type:    "select",
+			Proxies: names,
+			Use:     providerNames,
+		})
+		config.Listeners = append(config.Listeners, map[string]interface{}{
+			"name":   "node-check",
+			"type":   "mixed",
+			"listen": "127.0.0.1",
+			"port":   options.CheckPort,
+			"proxy":  NodeCheckGroup,
+		})
+	}
 
 	return config, nil
 }
 
+// defaultFakeIPFilter 默认不使用 fake-ip 的域名（代理设置中未自定义时使用）
+var defaultFakeIPFilter = []string{
+	// === 直连域名使用真实 IP (不使用 fake-ip) ===
+	"geosite:cn",      // 国内域名直接返回真实 IP
+	"geosite:private", // 私有域名
+
+	// === 本地域名 ===
+	"*.lan",
+	"*.local",
+	"*.localhost",
+	"*.localdomain",
+	"*.home.arpa",
+
+	// === 网络检测 ===
+	"+.msftconnecttest.com",
+	"+.msftncsi.com",
+	"connectivitycheck.gstatic.com",
+	"captive.apple.com",
+	"wifi.vivo.com.cn",
+	"connect.rom.miui.com",
+
+	// === NTP 时间同步 ===
+	"time.*.com",
+	"time.*.gov",
+	"time.*.apple.com",
+	"time.*.edu.cn",
+	"ntp.*.com",
+	"pool.ntp.org",
+
+	// === STUN/NAT 穿透 ===
+	"stun.*.*",
+	"stun.*.*.*",
+	"+.stun.playstation.net",
+	"+.stun.xbox.com",
+	"+.stun.l.google.com",
+
+	// === 本地服务发现 ===
+	"+._tcp.*",
+	"+._udp.*",
+
+	// === 国内常用服务 ===
+	"localhost.ptlogin2.qq.com",
+	"+.market.xiaomi.com",
+	"+.qq.com",
+	"+.tencent.com",
+	"+.weixin.qq.com",
+	"+.alipay.com",
+	"+.taobao.com",
+	"+.tmall.com",
+	"+.jd.com",
+	"+.baidu.com",
+	"+.bilibili.com",
+	"+.163.com",
+	"+.126.com",
+}
+
+// defaultProxyServerNameserver 默认的代理节点域名解析服务器
+var defaultProxyServerNameserver = []string{
+	"223.5.5.5",    // 阿里 DNS (IP 直连，更快)
+	"119.29.29.29", // 腾讯 DNS
+	"https://doh.pub/dns-query",
+}
+
 // generateDNSConfig 生成 DNS 配置 (防止 DNS 泄漏 + 性能优化)
 func (g *ConfigGenerator) generateDNSConfig(options ConfigGeneratorOptions) *DNSConfig {
 	dns := &DNSConfig{
@@ -464,60 +608,7 @@ func (g *ConfigGenerator) generateDNSConfig(options ConfigGeneratorOptions) *DNS
 
 	if dns.EnhancedMode == "fake-ip" {
 		dns.FakeIPRange = "198.18.0.1/16"
-		dns.FakeIPFilter = []string{
-			// === 直连域名使用真实 IP (不使用 fake-ip) ===
-			"geosite:cn",      // 国内域名直接返回真实 IP
-			"geosite:private", // 私有域名
-
-			// === 本地域名 ===
-			"*.lan",
-			"*.local",
-			"*.localhost",
-			"*.localdomain",
-			"*.home.arpa",
-
-			// === 网络检测 ===
-			"+.msftconnecttest.com",
-			"+.msftncsi.com",
-			"connectivitycheck.gstatic.com",
-			"captive.apple.com",
-			"wifi.vivo.com.cn",
-			"connect.rom.miui.com",
-
-			// === NTP 时间同步 ===
-			"time.*.com",
-			"time.*.gov",
-			"time.*.apple.com",
-			"time.*.edu.cn",
-			"ntp.*.com",
-			"pool.ntp.org",
-
-			// === STUN/NAT 穿透 ===
-			"stun.*.*",
-			"stun.*.*.*",
-			"+.stun.playstation.net",
-			"+.stun.xbox.com",
-			"+.stun.l.google.com",
-
-			// === 本地服务发现 ===
-			"+._tcp.*",
-			"+._udp.*",
-
-			// === 国内常用服务 ===
-			"localhost.ptlogin2.qq.com",
-			"+.market.xiaomi.com",
-			"+.qq.com",
-			"+.tencent.com",
-			"+.weixin.qq.com",
-			"+.alipay.com",
-			"+.taobao.com",
-			"+.tmall.com",
-			"+.jd.com",
-			"+.baidu.com",
-			"+.bilibili.com",
-			"+.163.com",
-			"+.126.com",
-		}
+		dns.FakeIPFilter = append([]string{}, defaultFakeIPFilter...)
 	}
 
 	// 默认 DNS (用于解析 DOH 域名) - 必须是 IP
@@ -527,11 +618,7 @@ func (g *ConfigGenerator) generateDNSConfig(options ConfigGeneratorOptions) *DNS
 	}
 
 	// 代理节点域名解析 - 使用国内 DNS (因为代理节点通常是国内购买的)
-	dns.ProxyServerNameserver = []string{
-		"223.5.5.5",    // 阿里 DNS (IP 直连，更快)
-		"119.29.29.29", // 腾讯 DNS
-		"https://doh.pub/dns-query",
-	}
+	dns.ProxyServerNameserver = append([]string{}, defaultProxyServerNameserver...)
 
 	// 直连出口 DNS - 用于直连流量的域名解析 (国内 DNS，更快)
 	dns.DirectNameserver = []string{
@@ -1104,13 +1191,15 @@ func (g *ConfigGenerator) generateProxyGroups(nodes []ProxyNode) []ProxyGroup {
 
 	// 常用地区（用于功能分组）
 	commonRegions := []string{}
-	preferredRegions := []string{"🇭🇰 香港节点", "🇨🇳 台湾节点", "🇯🇵 日本节点", "🇺🇲 美国节点", "🇸🇬 狮城节点", "🇰🇷 韩国节点"}
-	for _, r := range preferredRegions {
-		for _, rn := range regionNames {
-			if rn == r {
-				commonRegions = append(commonRegions, r)
-				break
-			}
+	aiRegions := []string{}
+	preferredRegions := map[string]bool{"hk": true, "tw": true, "jp": true, "us": true, "sg": true, "kr": true}
+	for _, r := range ActiveRegions() {
+		if _, ok := regionNodes[r.DisplayName()]; !ok || !preferredRegions[r.ID] {
+			continue
+		}
+		commonRegions = append(commonRegions, r.DisplayName())
+		if r.ID != "hk" && r.ID != "tw" {
+			aiRegions = append(aiRegions, r.DisplayName())
 		}
 	}
 
@@ -1157,7 +1246,7 @@ func (g *ConfigGenerator) generateProxyGroups(nodes []ProxyNode) []ProxyGroup {
 		{
 			Name:    "🤖 AI平台",
 			Type:    "select",
-			Proxies: []string{"🇯🇵 日本节点", "🇺🇲 美国节点", "🇸🇬 狮城节点", "🇰🇷 韩国节点", "🚀 节点选择", "🔯 故障转移"},
+			Proxies: append(aiRegions, "🚀 节点选择", "🔯 故障转移"),
 		},
 		{
 			Name:    "🔧 GitHub",
@@ -1305,6 +1394,16 @@ func (g *ConfigGenerator) generateRuleProviders() map[string]RuleProvider {
 func (g *ConfigGenerator) getGeoxURL() *GeoxURL {
 	baseURL := "https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@release"
 
+	// 未指定数据目录（导出给其他客户端）时全部使用远程地址
+	if g.dataDir == "" {
+		return &GeoxURL{
+			GeoIP:   baseURL + "/geoip.dat",
+			GeoSite: baseURL + "/geosite.dat",
+			MMDB:    baseURL + "/country.mmdb",
+			ASN:     baseURL + "/GeoLite2-ASN.mmdb",
+		}
+	}
+
 	// 本地文件路径
 	geoipPath := filepath.Join(g.dataDir, "geoip.dat")
 	geositePath := filepath.Join(g.dataDir, "geosite.dat")
@@ -1432,22 +1531,29 @@ func (g *ConfigGenerator) SaveConfig(config *MihomoConfig, filename string) (str
 
 	filePath := filepath.Join(configDir, filename)
 
-	// 序列化为 YAML
-	data, err := yaml.Marshal(config)
+	data, err := g.MarshalConfig(config)
 	if err != nil {
 		return "", err
 	}
 
-	// 解码 Unicode 转义序列 (如 \U0001F1ED -> 🇭🇰)
-	yamlStr := decodeUnicodeEscapes(string(data))
-
-	if err := os.WriteFile(filePath, []byte(yamlStr), 0644); err != nil {
+	if err := writePrivateFile(filePath, data); err != nil {
 		return "", err
 	}
 
 	return filePath, nil
 }
 
+// MarshalConfig 将配置序列化为 YAML（与保存到文件的内容一致）
+func (g *ConfigGenerator) MarshalConfig(config *MihomoConfig) ([]byte, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解码 Unicode 转义序列 (如 \U0001F1ED -> 🇭🇰)
+	return []byte(decodeUnicodeEscapes(string(data))), nil
+}
+
 // decodeUnicodeEscapes 将 YAML 中的 Unicode 转义序列转换回原始字符
 func decodeUnicodeEscapes(s string) string {
 	// 处理 \UXXXXXXXX 格式 (8位 Unicode)
@@ -1532,6 +1638,9 @@ func (g *ConfigGenerator) generateProxyGroupsFromTemplate(nodes []ProxyNode, tem
 			URL:      t.URL,
 			Interval: t.Interval,
 		}
+		if t.Type == "load-balance" {
+			group.Strategy = t.Strategy
+		}
 
 		// 处理代理列表
 		if t.UseAll {
@@ -0,0 +1,487 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// 用户配置覆盖：生成配置后的最后一步，合并用户维护的覆盖文档
+// mihomo 使用 YAML 深度合并，sing-box 使用 JSON Merge Patch（RFC 7396）
+//
+// 两者均支持以下键名指令：
+//   key    对象递归合并，其他值（含数组）整体替换，null 删除该键
+//   key!   整体替换（对象也不递归合并）
+//   key+   追加到数组末尾
+//   +key   插入到数组开头
+// ============================================================================
+
+// CoreOverride 单个核心的覆盖文档
+type CoreOverride struct {
+	Enabled bool   `json:"enabled"`
+	Content string `json:"content"` // mihomo 为 YAML，sing-box 为 JSON
+}
+
+// ConfigOverrides 配置覆盖
+type ConfigOverrides struct {
+	Mihomo  CoreOverride `json:"mihomo"`
+	SingBox CoreOverride `json:"singbox"`
+}
+
+// OverridePreview 覆盖文档引入的配置差异
+type OverridePreview struct {
+	CoreType string        `json:"coreType"`
+	Changed  bool          `json:"changed"`
+	Sections []SectionDiff `json:"sections"`
+}
+
+func configOverridesPath(dataDir string) string {
+	return filepath.Join(dataDir, "config_overrides.json")
+}
+
+// LoadConfigOverrides 加载配置覆盖
+func LoadConfigOverrides(dataDir string) (*ConfigOverrides, error) {
+	data, err := os.ReadFile(configOverridesPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var overrides ConfigOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, err
+	}
+	return &overrides, nil
+}
+
+// SaveConfigOverrides 保存配置覆盖
+func SaveConfigOverrides(dataDir string, overrides *ConfigOverrides) error {
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configOverridesPath(dataDir), data, 0644)
+}
+
+// forCore 获取指定核心的覆盖文档
+func (o *ConfigOverrides) forCore(coreType string) CoreOverride {
+	if coreType == "singbox" {
+		return o.SingBox
+	}
+	return o.Mihomo
+}
+
+// active 覆盖是否启用且内容非空
+func (o CoreOverride) active() bool {
+	return o.Enabled && strings.TrimSpace(o.Content) != ""
+}
+
+// parseOverride 解析覆盖文档并检查指令用法
+func parseOverride(coreType, content string) (map[string]interface{}, error) {
+	var patch map[string]interface{}
+	if coreType == "singbox" {
+		decoder := json.NewDecoder(strings.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&patch); err != nil {
+			return nil, fmt.Errorf("sing-box 覆盖必须是 JSON 对象: %v", err)
+		}
+		if decoder.More() {
+			return nil, fmt.Errorf("sing-box 覆盖只能包含一个 JSON 对象")
+		}
+	} else {
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+			return nil, fmt.Errorf("mihomo 覆盖 YAML 解析失败: %v", err)
+		}
+		if doc == nil {
+			return map[string]interface{}{}, nil
+		}
+		obj, ok := normalizeYAMLValue(doc).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("mihomo 覆盖必须是 YAML 映射")
+		}
+		patch = obj
+	}
+	if err := checkOverrideDirectives(patch, ""); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// normalizeYAMLValue 将 YAML 解码结果中的 map[interface{}]interface{} 统一为 map[string]interface{}
+func normalizeYAMLValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeYAMLValue(item)
+		}
+		return val
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			result[fmt.Sprint(k)] = normalizeYAMLValue(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeYAMLValue(item)
+		}
+		return val
+	}
+	return v
+}
+
+// splitOverrideKey 拆分键名指令，返回实际键名与指令（"", "!", "+", "^"）
+func splitOverrideKey(key string) (string, string) {
+	switch {
+	case len(key) > 1 && strings.HasPrefix(key, "+"):
+		return key[1:], "^"
+	case len(key) > 1 && strings.HasSuffix(key, "+"):
+		return key[:len(key)-1], "+"
+	case len(key) > 1 && strings.HasSuffix(key, "!"):
+		return key[:len(key)-1], "!"
+	}
+	return key, ""
+}
+
+func joinOverridePath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkOverrideDirectives 静态检查：数组指令的值必须是数组，同一键不能重复使用多个指令
+func checkOverrideDirectives(patch map[string]interface{}, path string) error {
+	seen := make(map[string]string)
+	for rawKey, value := range patch {
+		key, directive := splitOverrideKey(rawKey)
+		keyPath := joinOverridePath(path, rawKey)
+		if prev, ok := seen[key]; ok && (prev == "!" || directive == "!" || prev == "" || directive == "") {
+			return fmt.Errorf("%s: 与 %s 冲突", keyPath, joinOverridePath(path, key+prev))
+		}
+		seen[key] = directive
+		switch directive {
+		case "+", "^":
+			if _, ok := value.([]interface{}); !ok {
+				return fmt.Errorf("%s: 追加/前插的值必须是数组", keyPath)
+			}
+		case "":
+			if obj, ok := value.(map[string]interface{}); ok {
+				if err := checkOverrideDirectives(obj, keyPath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mergeOverride 将覆盖合并到目标对象
+func mergeOverride(target, patch map[string]interface{}, path string) error {
+	for rawKey, value := range patch {
+		key, directive := splitOverrideKey(rawKey)
+		keyPath := joinOverridePath(path, key)
+		switch directive {
+		case "!":
+			if value == nil {
+				delete(target, key)
+			} else {
+				target[key] = value
+			}
+		case "+", "^":
+			items := value.([]interface{})
+			existing, exists := target[key]
+			if !exists || existing == nil {
+				target[key] = items
+				continue
+			}
+			list, ok := existing.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s: 目标不是数组，无法追加", keyPath)
+			}
+			merged := make([]interface{}, 0, len(list)+len(items))
+			if directive == "^" {
+				merged = append(append(merged, items...), list...)
+			} else {
+				merged = append(append(merged, list...), items...)
+			}
+			target[key] = merged
+		default:
+			if value == nil {
+				delete(target, key)
+				continue
+			}
+			patchObj, patchIsObj := value.(map[string]interface{})
+			targetObj, targetIsObj := target[key].(map[string]interface{})
+			if patchIsObj && targetIsObj {
+				if err := mergeOverride(targetObj, patchObj, keyPath); err != nil {
+					return err
+				}
+				continue
+			}
+			if patchIsObj {
+				// 目标不存在或不是对象：按合并语义去掉指令与 null 后写入
+				obj := map[string]interface{}{}
+				if err := mergeOverride(obj, patchObj, keyPath); err != nil {
+					return err
+				}
+				target[key] = obj
+				continue
+			}
+			target[key] = value
+		}
+	}
+	return nil
+}
+
+// applyOverride 将覆盖文档应用到序列化后的配置，并检查结果仍能被解析为配置结构
+func applyOverride(coreType string, data []byte, override CoreOverride) ([]byte, error) {
+	if !override.active() {
+		return data, nil
+	}
+	patch, err := parseOverride(coreType, override.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if coreType == "singbox" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, err
+		}
+	} else {
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		doc, _ = normalizeYAMLValue(raw).(map[string]interface{})
+		if doc == nil {
+			doc = map[string]interface{}{}
+		}
+	}
+	if err := mergeOverride(doc, patch, ""); err != nil {
+		return nil, fmt.Errorf("应用配置覆盖失败: %w", err)
+	}
+
+	if coreType == "singbox" {
+		result, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		var config SingBoxConfig
+		if err := json.Unmarshal(result, &config); err != nil {
+			return nil, fmt.Errorf("覆盖后的 sing-box 配置无效: %v", err)
+		}
+		if err := validateSingBoxRoute(&config); err != nil {
+			return nil, fmt.Errorf("覆盖后的 sing-box 配置无效: %w", err)
+		}
+		return result, nil
+	}
+
+	result, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var config MihomoConfig
+	if err := yaml.Unmarshal(result, &config); err != nil {
+		return nil, fmt.Errorf("覆盖后的 mihomo 配置无效: %v", err)
+	}
+	return []byte(decodeUnicodeEscapes(string(result))), nil
+}
+
+// ============================================================================
+// Service 方法
+// ============================================================================
+
+// loadConfigOverrides 加载配置覆盖
+func (s *Service) loadConfigOverrides() {
+	overrides, err := LoadConfigOverrides(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("⚠️ 加载配置覆盖失败: %v\n", err)
+		}
+		s.overrides = &ConfigOverrides{}
+		return
+	}
+	s.overrides = overrides
+}
+
+// GetConfigOverrides 获取配置覆盖
+func (s *Service) GetConfigOverrides() *ConfigOverrides {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	overrides := *s.overrides
+	return &overrides
+}
+
+// UpdateConfigOverrides 校验并保存配置覆盖（可生成配置时对当前配置试合并）
+func (s *Service) UpdateConfigOverrides(overrides *ConfigOverrides) error {
+	for _, coreType := range []string{"mihomo", "singbox"} {
+		override := overrides.forCore(coreType)
+		if strings.TrimSpace(override.Content) == "" {
+			continue
+		}
+		if _, err := parseOverride(coreType, override.Content); err != nil {
+			return err
+		}
+		if !override.Enabled {
+			continue
+		}
+		if _, err := s.PreviewConfigOverride(coreType, &override); err != nil && !isNoNodesError(err) {
+			return err
+		}
+	}
+
+	if err := SaveConfigOverrides(s.dataDir, overrides); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.overrides = overrides
+	s.mu.Unlock()
+	return nil
+}
+
+// isNoNodesError 没有节点时无法试生成，跳过试合并
+func isNoNodesError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "没有可用节点") || strings.Contains(msg, "节点提供者未设置")
+}
+
+// renderConfig 序列化生成的配置并应用覆盖（返回值即写入文件的内容）
+func (s *Service) renderConfig(mihomoConfig *MihomoConfig, singboxConfig *SingBoxConfig) ([]byte, error) {
+	s.mu.RLock()
+	overrides := s.overrides
+	s.mu.RUnlock()
+
+	if singboxConfig != nil {
+		data, err := json.MarshalIndent(singboxConfig, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return applyOverride("singbox", data, overrides.SingBox)
+	}
+	data, err := s.configGenerator.MarshalConfig(mihomoConfig)
+	if err != nil {
+		return nil, err
+	}
+	return applyOverride("mihomo", data, overrides.Mihomo)
+}
+
+// saveRenderedConfig 将应用覆盖后的配置写入待生效文件（config.pending.yaml / singbox-config.pending.json），
+// 校验通过后由 commitPendingConfig 替换正在使用的配置
+func (s *Service) saveRenderedConfig(mihomoConfig *MihomoConfig, singboxConfig *SingBoxConfig) (string, error) {
+	data, err := s.renderConfig(mihomoConfig, singboxConfig)
+	if err != nil {
+		return "", err
+	}
+	configDir := filepath.Join(s.dataDir, "configs")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return "", err
+	}
+	filename := "config" + pendingConfigSuffix + ".yaml"
+	if singboxConfig != nil {
+		filename = "singbox-config" + pendingConfigSuffix + ".json"
+	}
+	if mihomoConfig != nil {
+		// 提供者文件需先于配置写入，核心校验时才能读取
+		if _, err := writeProviderFiles(s.dataDir, mihomoConfig.providerFiles); err != nil {
+			return "", err
+		}
+	}
+	configPath := filepath.Join(configDir, filename)
+	if err := writePrivateFile(configPath, data); err != nil {
+		return "", err
+	}
+	return configPath, nil
+}
+
+// pendingConfigSuffix 待生效配置文件名后缀
+const pendingConfigSuffix = ".pending"
+
+// commitPendingConfig 校验通过后将待生效配置原子替换为正式配置，返回正式配置路径
+func commitPendingConfig(pendingPath string) (string, error) {
+	dir, name := filepath.Split(pendingPath)
+	configPath := filepath.Join(dir, strings.Replace(name, pendingConfigSuffix, "", 1))
+	if err := os.Rename(pendingPath, configPath); err != nil {
+		return "", err
+	}
+	return configPath, nil
+}
+
+// PreviewConfigOverride 在内存中生成配置，比较应用覆盖前后的差异（override 为空时使用已保存的覆盖）
+func (s *Service) PreviewConfigOverride(coreType string, override *CoreOverride) (*OverridePreview, error) {
+	if coreType == "" {
+		coreType = s.GetCoreType()
+	}
+	if coreType != "mihomo" && coreType != "singbox" {
+		return nil, fmt.Errorf("不支持的核心类型: %s", coreType)
+	}
+	if override == nil {
+		saved := s.GetConfigOverrides().forCore(coreType)
+		override = &saved
+	}
+	if s.nodeProvider == nil {
+		return nil, fmt.Errorf("节点提供者未设置")
+	}
+	nodes := s.nodeProvider()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("没有可用节点")
+	}
+
+	var settings *ProxySettings
+	if s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+	s.mu.RLock()
+	options := buildGeneratorOptions(s.config, s.configTemplate, s.routingPolicy, settings)
+	s.mu.RUnlock()
+	mihomoConfig, singboxConfig, err := s.generateInMemory(coreType, options, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var base []byte
+	if singboxConfig != nil {
+		base, err = json.Marshal(singboxConfig)
+	} else {
+		base, err = s.configGenerator.MarshalConfig(mihomoConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	merged, err := applyOverride(coreType, base, *override)
+	if err != nil {
+		return nil, err
+	}
+
+	isYAML := coreType != "singbox"
+	before, err := parseConfigDocument(base, isYAML)
+	if err != nil {
+		return nil, err
+	}
+	after, err := parseConfigDocument(merged, isYAML)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &OverridePreview{CoreType: coreType}
+	if isYAML {
+		preview.Sections = diffMihomoConfig(before, after)
+	} else {
+		preview.Sections = diffSingBoxConfig(before, after)
+	}
+	for _, section := range preview.Sections {
+		if len(section.Added) > 0 || len(section.Removed) > 0 || len(section.Changed) > 0 || section.Reordered {
+			preview.Changed = true
+			break
+		}
+	}
+	return preview, nil
+}
@@ -1,5 +1,7 @@
 package proxy
 
+import "fmt"
+
 // ProxyGroupTemplate 代理组模板
 type ProxyGroupTemplate struct {
 	Name        string   `json:"name" yaml:"name"`
@@ -15,6 +17,46 @@ type ProxyGroupTemplate struct {
 	Hidden      bool     `json:"hidden,omitempty" yaml:"hidden,omitempty"`
 	Filter      string   `json:"filter,omitempty" yaml:"filter,omitempty"` // 节点过滤正则
 	UseAll      bool     `json:"useAll,omitempty" yaml:"-"`                // 使用所有节点
+	// 负载均衡（仅 load-balance）
+	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"` // consistent-hashing, round-robin, sticky-sessions
+}
+
+// 负载均衡策略
+const (
+	LoadBalanceConsistentHashing = "consistent-hashing" // 按目标地址哈希，同一站点固定节点
+	LoadBalanceRoundRobin        = "round-robin"        // 轮询
+	LoadBalanceStickySessions    = "sticky-sessions"    // 按来源+目标哈希并缓存
+)
+
+// loadBalanceStrategies 合法的负载均衡策略（哈希键与会话保持时长由 mihomo 固定，不可配置）
+var loadBalanceStrategies = map[string]bool{
+	LoadBalanceConsistentHashing: true,
+	LoadBalanceRoundRobin:        true,
+	LoadBalanceStickySessions:    true,
+}
+
+// validateLoadBalance 校验负载均衡策略：仅 load-balance 可设置
+func validateLoadBalance(name, groupType, strategy string) error {
+	if groupType != "load-balance" {
+		if strategy != "" {
+			return fmt.Errorf("策略组 %s 不是 load-balance，不能设置负载均衡策略", name)
+		}
+		return nil
+	}
+	if strategy != "" && !loadBalanceStrategies[strategy] {
+		return fmt.Errorf("策略组 %s 负载均衡策略无效: %s", name, strategy)
+	}
+	return nil
+}
+
+// ValidateProxyGroups 校验代理组模板
+func ValidateProxyGroups(groups []ProxyGroupTemplate) error {
+	for _, g := range groups {
+		if err := validateLoadBalance(g.Name, g.Type, g.Strategy); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // RuleTemplate 规则模板
@@ -84,13 +126,25 @@ func GetDefaultProxyGroups() []ProxyGroupTemplate {
 			Lazy:        true,
 			UseAll:      false,
 		},
+		// 2.1 负载均衡 - 在各地区分组之间分摊连接，同一站点固定使用同一地区
+		{
+			Name:        "负载均衡",
+			Type:        "load-balance",
+			Icon:        "split",
+			Description: "按目标站点哈希分配到各地区分组，同一站点保持同一出口",
+			Proxies:     []string{"香港节点", "台湾节点", "日本节点", "新加坡节点", "美国节点"},
+			URL:         "https://www.gstatic.com/generate_204",
+			Interval:    300,
+			Lazy:        true,
+			Strategy:    LoadBalanceConsistentHashing,
+		},
 		// 3. 节点选择
 		{
 			Name:        "节点选择",
 			Type:        "select",
 			Icon:        "rocket",
 			Description: "手动选择代理节点，是所有分流的默认出口",
-			Proxies:     []string{"自动选择", "故障转移", "香港节点", "台湾节点", "日本节点", "新加坡节点", "美国节点", "手动节点", "其他节点", "DIRECT"},
+			Proxies:     []string{"自动选择", "故障转移", "负载均衡", "香港节点", "台湾节点", "日本节点", "新加坡节点", "美国节点", "手动节点", "其他节点", "DIRECT"},
 			UseAll:      false,
 		},
 		// 3. 全球直连
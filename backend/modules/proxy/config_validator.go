@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConfigError 核心校验配置得到的结构化错误
+type ConfigError struct {
+	File    string `json:"file,omitempty"`
+	Path    string `json:"path,omitempty"` // 出错位置，如 proxies[3]、outbounds[2].server、line:12
+	Message string `json:"message"`
+}
+
+// ConfigValidationError 配置未通过核心校验
+type ConfigValidationError struct {
+	ConfigPath string        `json:"configPath"`
+	Errors     []ConfigError `json:"errors"`
+	Output     string        `json:"output,omitempty"` // 核心原始输出
+}
+
+func (e *ConfigValidationError) Error() string {
+	if len(e.Errors) == 0 {
+		return "配置校验失败"
+	}
+	first := e.Errors[0]
+	if first.Path != "" {
+		return fmt.Sprintf("配置校验失败: %s: %s", first.Path, first.Message)
+	}
+	return "配置校验失败: " + first.Message
+}
+
+var (
+	// mihomo 日志格式: time="..." level=error msg="..."
+	mihomoMsgRegex = regexp.MustCompile(`msg="((?:[^"\\]|\\.)*)"`)
+	// sing-box: decode config at /path/config.json: outbounds[3].server: message
+	singboxDecodeRegex = regexp.MustCompile(`decode config at ([^:]+):\s*([\w\-\.\[\]]+):\s*(.+)`)
+	// sing-box: initialize outbound[2]: message
+	singboxIndexRegex = regexp.MustCompile(`\b(inbound|outbound|endpoint|rule|rule_set|dns rule|server)\[(\d+)\]`)
+	// mihomo: proxy 3: / proxy group[2]: / rules[5] / rule-provider xxx
+	mihomoProxyRegex    = regexp.MustCompile(`\bproxy (\d+):`)
+	mihomoGroupRegex    = regexp.MustCompile(`proxy group\[(\d+)\]`)
+	mihomoRuleRegex     = regexp.MustCompile(`\brules\[(\d+)\]`)
+	mihomoProviderRegex = regexp.MustCompile(`rule-provider (\S+?):`)
+	yamlLineRegex       = regexp.MustCompile(`yaml: (?:unmarshal errors:\s*)?line (\d+)`)
+	ansiRegex           = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
+
+// singboxPathNames sing-box 错误中的单数名称对应配置字段
+var singboxPathNames = map[string]string{
+	"inbound":  "inbounds",
+	"outbound": "outbounds",
+	"endpoint": "endpoints",
+	"rule":     "route.rules",
+	"rule_set": "route.rule_set",
+	"dns rule": "dns.rules",
+	"server":   "dns.servers",
+}
+
+// parseConfigErrors 将核心校验输出解析为结构化错误
+func parseConfigErrors(coreType, configPath, output string) []ConfigError {
+	errs := make([]ConfigError, 0)
+	for _, raw := range strings.Split(output, "\n") {
+		line := strings.TrimSpace(ansiRegex.ReplaceAllString(raw, ""))
+		if line == "" {
+			continue
+		}
+		lower := strings.ToLower(line)
+		if !strings.Contains(lower, "error") && !strings.Contains(lower, "fatal") && !strings.Contains(lower, "failed") {
+			continue
+		}
+
+		ce := ConfigError{File: configPath, Message: line}
+		if coreType == "singbox" {
+			// 去掉 "FATAL[0000] " 前缀
+			if idx := strings.Index(line, "] "); idx > 0 && strings.HasPrefix(line, "FATAL") {
+				ce.Message = line[idx+2:]
+			}
+			if m := singboxDecodeRegex.FindStringSubmatch(ce.Message); m != nil {
+				ce.File, ce.Path, ce.Message = m[1], m[2], m[3]
+			} else if m := singboxIndexRegex.FindStringSubmatch(ce.Message); m != nil {
+				ce.Path = fmt.Sprintf("%s[%s]", singboxPathNames[m[1]], m[2])
+			}
+		} else {
+			if m := mihomoMsgRegex.FindStringSubmatch(line); m != nil {
+				ce.Message = strings.ReplaceAll(m[1], `\"`, `"`)
+			}
+			switch {
+			case yamlLineRegex.MatchString(ce.Message):
+				ce.Path = "line:" + yamlLineRegex.FindStringSubmatch(ce.Message)[1]
+			case mihomoGroupRegex.MatchString(ce.Message):
+				ce.Path = "proxy-groups[" + mihomoGroupRegex.FindStringSubmatch(ce.Message)[1] + "]"
+			case mihomoProxyRegex.MatchString(ce.Message):
+				ce.Path = "proxies[" + mihomoProxyRegex.FindStringSubmatch(ce.Message)[1] + "]"
+			case mihomoRuleRegex.MatchString(ce.Message):
+				ce.Path = "rules[" + mihomoRuleRegex.FindStringSubmatch(ce.Message)[1] + "]"
+			case mihomoProviderRegex.MatchString(ce.Message):
+				ce.Path = "rule-providers." + mihomoProviderRegex.FindStringSubmatch(ce.Message)[1]
+			}
+		}
+		errs = append(errs, ce)
+	}
+
+	if len(errs) == 0 {
+		msg := strings.TrimSpace(ansiRegex.ReplaceAllString(output, ""))
+		if msg == "" {
+			msg = "核心校验未通过"
+		}
+		errs = append(errs, ConfigError{File: configPath, Message: msg})
+	}
+	return errs
+}
+
+// validateConfig 使用当前核心校验配置文件（mihomo -t / sing-box check）
+// 未找到核心时跳过校验
+func (s *Service) validateConfig(configPath string) error {
+	return s.validateConfigFor(s.GetCoreType(), configPath)
+}
+
+// validateConfigFor 使用指定类型的核心校验配置文件
+func (s *Service) validateConfigFor(coreType, configPath string) error {
+	s.mu.RLock()
+	corePath := s.findCorePathFor(coreType)
+	s.mu.RUnlock()
+
+	if corePath == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if coreType == "singbox" {
+		cmd = exec.CommandContext(ctx, corePath, "check", "-D", s.dataDir, "-c", configPath)
+		cmd.Env = append(os.Environ(), "ENABLE_DEPRECATED_SPECIAL_OUTBOUNDS=true")
+	} else {
+		cmd = exec.CommandContext(ctx, corePath, "-t", "-d", s.dataDir, "-f", configPath)
+	}
+	cmd.Dir = s.dataDir
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return &ConfigValidationError{
+			ConfigPath: configPath,
+			Errors:     []ConfigError{{File: configPath, Message: "核心校验超时"}},
+		}
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		// 核心无法执行（权限等），不阻塞启动
+		fmt.Printf("⚠️ 无法执行核心校验: %v\n", err)
+		return nil
+	}
+
+	return &ConfigValidationError{
+		ConfigPath: configPath,
+		Errors:     parseConfigErrors(coreType, configPath, string(output)),
+		Output:     string(output),
+	}
+}
+
+// lastGoodConfigPath 最近一次通过校验的配置文件路径
+func (s *Service) lastGoodConfigPath(coreType string) string {
+	if coreType == "singbox" {
+		return filepath.Join(s.dataDir, "configs", "singbox-config.last-good.json")
+	}
+	return filepath.Join(s.dataDir, "configs", "config.last-good.yaml")
+}
+
+// saveLastGoodConfig 保存通过校验的配置副本
+func (s *Service) saveLastGoodConfig(coreType, configPath string) {
+	if err := copyFile(configPath, s.lastGoodConfigPath(coreType)); err != nil {
+		fmt.Printf("⚠️ 保存可用配置副本失败: %v\n", err)
+	}
+}
+
+// LastValidationError 获取最近一次校验失败信息（校验通过后清空）
+func (s *Service) LastValidationError() *ConfigValidationError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastValidationError
+}
+
+// copyFile 复制配置文件（含核心 API 密钥，仅所有者可读写）
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0600)
+}
+
+// writePrivateFile 写入含核心 API 密钥的文件，已存在的文件同样收紧为仅所有者可读写
+func writePrivateFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
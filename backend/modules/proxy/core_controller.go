@@ -0,0 +1,379 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// 核心控制接口
+// mihomo RESTful API 与 sing-box Clash API 路径基本一致，差异（配置重载、
+// 提供者刷新）由各自实现处理；地址与密钥取自当前运行配置。
+// mihomo 可改为仅监听 Unix 套接字（external-controller-unix），此时面板经套接字访问
+// ============================================================================
+
+// 核心流式接口
+const (
+	CoreStreamTraffic     = "/traffic"
+	CoreStreamLogs        = "/logs"
+	CoreStreamConnections = "/connections"
+	CoreStreamMemory      = "/memory"
+)
+
+const defaultControllerAddr = "127.0.0.1:9090"
+
+// CoreController 核心控制接口
+type CoreController interface {
+	// CoreType 核心类型：mihomo / singbox
+	CoreType() string
+
+	// Proxies 全部代理与策略组（原始 JSON）
+	Proxies() ([]byte, error)
+	// Proxy 单个代理或策略组（原始 JSON）
+	Proxy(name string) ([]byte, error)
+	// SelectProxy 切换选择组的节点
+	SelectProxy(group, name string) error
+	// Groups 全部策略组（原始 JSON）
+	Groups() ([]byte, error)
+
+	// ProxyDelay 测试单个代理延迟（原始 JSON）
+	ProxyDelay(name, testURL string, timeout int) ([]byte, error)
+	// GroupDelay 测试策略组内全部代理延迟（原始 JSON）
+	GroupDelay(name, testURL string, timeout int) ([]byte, error)
+
+	// Connections 当前连接（原始 JSON）
+	Connections() ([]byte, error)
+	// CloseConnections 关闭全部连接
+	CloseConnections() error
+
+	// Stream 连接流式接口（traffic / logs / connections / memory），rawQuery 原样转发
+	Stream(path, rawQuery string) (*websocket.Conn, error)
+
+	// ReloadConfig 重新加载配置文件
+	ReloadConfig(configPath string) error
+	// RefreshProvider 刷新代理提供者
+	RefreshProvider(name string) error
+
+	// Version 核心版本
+	Version() (string, error)
+}
+
+// CoreAPIError 核心 API 返回非成功状态
+type CoreAPIError struct {
+	Status int
+	Body   []byte
+}
+
+func (e *CoreAPIError) Error() string {
+	return fmt.Sprintf("核心 API 返回 %d: %s", e.Status, strings.TrimSpace(string(e.Body)))
+}
+
+// coreAPIClient 核心 HTTP / WebSocket 客户端
+type coreAPIClient struct {
+	addr   string // host:port（Unix 套接字时仅用作 Host 头）
+	secret string
+	socket string // Unix 套接字路径，为空时使用 TCP
+}
+
+// newCoreAPIClient 创建客户端（监听全部地址时使用本地回环）
+func newCoreAPIClient(addr, secret string) coreAPIClient {
+	if addr == "" {
+		addr = defaultControllerAddr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return coreAPIClient{addr: addr, secret: secret}
+}
+
+// dialContext 建立到核心 API 的连接
+func (c coreAPIClient) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if c.socket != "" {
+		return d.DialContext(ctx, "unix", c.socket)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// coreTransports 按连接目标复用的 HTTP 传输（保持连接，避免每次请求新建传输遗留空闲连接）
+var (
+	coreTransportsMu sync.Mutex
+	coreTransports   = make(map[string]*http.Transport)
+)
+
+// transport 获取连接目标（TCP 共用一个，Unix 套接字按路径区分）对应的传输
+func (c coreAPIClient) transport() *http.Transport {
+	key := "tcp"
+	if c.socket != "" {
+		key = "unix:" + c.socket
+	}
+	coreTransportsMu.Lock()
+	defer coreTransportsMu.Unlock()
+	if t, ok := coreTransports[key]; ok {
+		return t
+	}
+	t := &http.Transport{
+		DialContext:         c.dialContext,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     30 * time.Second,
+	}
+	coreTransports[key] = t
+	return t
+}
+
+// header 认证请求头
+func (c coreAPIClient) header() http.Header {
+	header := http.Header{}
+	if c.secret != "" {
+		header.Set("Authorization", "Bearer "+c.secret)
+	}
+	return header
+}
+
+// do 发送请求，2xx 以外返回 CoreAPIError
+func (c coreAPIClient) do(method, path string, body interface{}, timeout time.Duration) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, "http://"+c.addr+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: c.transport(),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &CoreAPIError{Status: resp.StatusCode, Body: data}
+	}
+	return data, nil
+}
+
+// delay 延迟测试请求（超时时间额外留出 2 秒）
+func (c coreAPIClient) delay(path, testURL string, timeout int) ([]byte, error) {
+	if testURL == "" {
+		testURL = providerHealthCheckURL
+	}
+	if timeout <= 0 {
+		timeout = 5000
+	}
+	query := url.Values{}
+	query.Set("url", testURL)
+	query.Set("timeout", strconv.Itoa(timeout))
+	return c.do(http.MethodGet, path+"?"+query.Encode(), nil, time.Duration(timeout)*time.Millisecond+2*time.Second)
+}
+
+func (c coreAPIClient) Proxies() ([]byte, error) {
+	return c.do(http.MethodGet, "/proxies", nil, 5*time.Second)
+}
+
+func (c coreAPIClient) Proxy(name string) ([]byte, error) {
+	return c.do(http.MethodGet, "/proxies/"+url.PathEscape(name), nil, 5*time.Second)
+}
+
+func (c coreAPIClient) SelectProxy(group, name string) error {
+	_, err := c.do(http.MethodPut, "/proxies/"+url.PathEscape(group), map[string]string{"name": name}, 5*time.Second)
+	return err
+}
+
+func (c coreAPIClient) Groups() ([]byte, error) {
+	return c.do(http.MethodGet, "/group", nil, 5*time.Second)
+}
+
+func (c coreAPIClient) ProxyDelay(name, testURL string, timeout int) ([]byte, error) {
+	return c.delay("/proxies/"+url.PathEscape(name)+"/delay", testURL, timeout)
+}
+
+func (c coreAPIClient) GroupDelay(name, testURL string, timeout int) ([]byte, error) {
+	return c.delay("/group/"+url.PathEscape(name)+"/delay", testURL, timeout)
+}
+
+func (c coreAPIClient) Connections() ([]byte, error) {
+	return c.do(http.MethodGet, "/connections", nil, 3*time.Second)
+}
+
+func (c coreAPIClient) CloseConnections() error {
+	_, err := c.do(http.MethodDelete, "/connections", nil, 5*time.Second)
+	return err
+}
+
+func (c coreAPIClient) Stream(path, rawQuery string) (*websocket.Conn, error) {
+	target := "ws://" + c.addr + path
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	dialer := &websocket.Dialer{
+		NetDialContext:   c.dialContext,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	conn, _, err := dialer.Dial(target, c.header())
+	return conn, err
+}
+
+func (c coreAPIClient) Version() (string, error) {
+	data, err := c.do(http.MethodGet, "/version", nil, 3*time.Second)
+	if err != nil {
+		return "", err
+	}
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	return v.Version, nil
+}
+
+// mihomoController mihomo RESTful API
+type mihomoController struct {
+	coreAPIClient
+}
+
+func (m *mihomoController) CoreType() string { return "mihomo" }
+
+// ReloadConfig PUT /configs?force=true
+func (m *mihomoController) ReloadConfig(configPath string) error {
+	_, err := m.do(http.MethodPut, "/configs?force=true", map[string]string{"path": configPath, "payload": ""}, 15*time.Second)
+	return err
+}
+
+// RefreshProvider PUT /providers/proxies/{name}
+func (m *mihomoController) RefreshProvider(name string) error {
+	_, err := m.do(http.MethodPut, "/providers/proxies/"+url.PathEscape(name), nil, 10*time.Second)
+	return err
+}
+
+// singBoxController sing-box Clash API（配置重载通过信号完成）
+type singBoxController struct {
+	coreAPIClient
+	signal func(syscall.Signal) error
+}
+
+func (b *singBoxController) CoreType() string { return "singbox" }
+
+// ReloadConfig sing-box 的 PUT /configs 不会重新读取文件，使用 SIGHUP（run 命令收到后重新读取配置）
+func (b *singBoxController) ReloadConfig(configPath string) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("Windows 不支持 sing-box 信号重载")
+	}
+	return b.signal(syscall.SIGHUP)
+}
+
+// RefreshProvider sing-box 没有代理提供者
+func (b *singBoxController) RefreshProvider(name string) error {
+	return fmt.Errorf("sing-box 不支持代理提供者")
+}
+
+// Controller 根据当前核心类型与运行配置创建控制接口
+func (s *Service) Controller() CoreController {
+	s.mu.RLock()
+	coreType := s.coreType
+	client := newCoreAPIClient(s.config.ExternalController, s.config.Secret)
+	socket := s.config.ControllerSocket
+	s.mu.RUnlock()
+
+	if coreType == "singbox" {
+		return &singBoxController{coreAPIClient: client, signal: s.signalCore}
+	}
+	client.socket = socket
+	return &mihomoController{coreAPIClient: client}
+}
+
+// generateControllerSecret 生成随机核心 API 密钥
+func generateControllerSecret() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ensureControllerSecret 首次运行生成并保存核心 API 密钥
+func (s *Service) ensureControllerSecret() {
+	if s.config.Secret != "" {
+		return
+	}
+	secret, err := generateControllerSecret()
+	if err != nil {
+		fmt.Printf("⚠️ 生成核心 API 密钥失败: %v\n", err)
+		return
+	}
+	s.config.Secret = secret
+	if err := s.saveConfig(); err != nil {
+		fmt.Printf("⚠️ 保存核心 API 密钥失败: %v\n", err)
+		return
+	}
+	fmt.Println("✓ 已生成核心 API 密钥")
+}
+
+// validateControllerSocket 校验 Unix 套接字路径
+func validateControllerSocket(path string) error {
+	if path != "" && !filepath.IsAbs(path) {
+		return fmt.Errorf("核心 API 套接字必须为绝对路径: %s", path)
+	}
+	return nil
+}
+
+// signalCore 向运行中的核心进程发送信号
+func (s *Service) signalCore(sig syscall.Signal) error {
+	s.mu.RLock()
+	process := s.process
+	s.mu.RUnlock()
+	if process == nil || process.Process == nil {
+		return fmt.Errorf("核心进程不存在")
+	}
+	return process.Process.Signal(sig)
+}
+
+// DialCoreStream 连接核心流式接口（供 WebSocket 代理使用）
+func (s *Service) DialCoreStream(path, rawQuery string) (*websocket.Conn, error) {
+	return s.Controller().Stream(path, rawQuery)
+}
+
+// MixedProxyAddress 混合代理入站地址（面板经核心访问外网时使用）
+func (s *Service) MixedProxyAddress() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	port := s.config.MixedPort
+	if port == 0 {
+		port = 7890
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
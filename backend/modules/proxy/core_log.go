@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志存储参数
+const (
+	logRingSize       = 2000            // 内存环形缓冲条数
+	logFileMaxSize    = 5 * 1024 * 1024 // 单个日志文件大小上限
+	logFileMaxBackups = 3               // 保留的历史日志文件数
+)
+
+// LogEntry 结构化核心日志
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"` // debug, info, warn, error, fatal
+	Component string    `json:"component,omitempty"`
+	Message   string    `json:"message"`
+	Raw       string    `json:"raw"`
+}
+
+// LogFilter 日志查询条件
+type LogFilter struct {
+	Level string    // all 或最低级别（debug < info < warn < error < fatal）
+	Query string    // 子串匹配（不区分大小写）
+	Since time.Time // 起始时间（含）
+	Until time.Time // 结束时间（含）
+	Limit int
+}
+
+var (
+	// mihomo: time="2024-01-01T00:00:00.000000000+08:00" level=info msg="[TCP] ..."
+	mihomoLogRegex = regexp.MustCompile(`^time="([^"]+)"\s+level=(\w+)\s+msg="((?:[^"\\]|\\.)*)"`)
+	// sing-box: +0800 2024-01-01 00:00:00 INFO [123 0ms] inbound/mixed[mixed-in]: message
+	singboxLogRegex = regexp.MustCompile(`^(?:([+-]\d{4})\s+)?(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\s+(TRACE|DEBUG|INFO|WARN|ERROR|FATAL|PANIC)\s+(?:\[[^\]]*\]\s+)?(.*)$`)
+	// sing-box 启动失败: FATAL[0000] message
+	singboxFatalRegex = regexp.MustCompile(`^(TRACE|DEBUG|INFO|WARN|ERROR|FATAL|PANIC)\[\d+\]\s*(.*)$`)
+	// 面板内部日志: [INFO] message
+	panelLogRegex = regexp.MustCompile(`^\[(DEBUG|INFO|WARN|WARNING|ERROR)\]\s*(.*)$`)
+	// 消息前缀组件: [TCP] / [DNS]
+	bracketComponentRegex = regexp.MustCompile(`^\[([\w\-/]+)\]\s*(.*)$`)
+	// sing-box 组件: inbound/mixed[mixed-in]: message
+	singboxComponentRegex = regexp.MustCompile(`^([a-z][\w\-]*(?:/[\w\-]+)?(?:\[[^\]]*\])?):\s+(.*)$`)
+)
+
+// logLevelRank 日志级别排序
+var logLevelRank = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3, "fatal": 4}
+
+// normalizeLevel 统一日志级别名称
+func normalizeLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "trace", "debug", "silent":
+		return "debug"
+	case "warn", "warning":
+		return "warn"
+	case "error", "err":
+		return "error"
+	case "fatal", "panic", "fata":
+		return "fatal"
+	default:
+		return "info"
+	}
+}
+
+// parseCoreLogLine 将核心输出行解析为结构化日志（兼容 mihomo、sing-box 与面板内部日志）
+func parseCoreLogLine(line string, now time.Time) LogEntry {
+	clean := strings.TrimSpace(ansiRegex.ReplaceAllString(line, ""))
+	entry := LogEntry{Time: now, Level: "info", Message: clean, Raw: clean}
+
+	switch {
+	case mihomoLogRegex.MatchString(clean):
+		m := mihomoLogRegex.FindStringSubmatch(clean)
+		if t, err := time.Parse(time.RFC3339Nano, m[1]); err == nil {
+			entry.Time = t
+		}
+		entry.Level = normalizeLevel(m[2])
+		entry.Message = strings.ReplaceAll(m[3], `\"`, `"`)
+		if c := bracketComponentRegex.FindStringSubmatch(entry.Message); c != nil {
+			entry.Component, entry.Message = c[1], c[2]
+		}
+	case singboxLogRegex.MatchString(clean):
+		m := singboxLogRegex.FindStringSubmatch(clean)
+		if m[1] != "" {
+			if t, err := time.Parse("-0700 2006-01-02 15:04:05", m[1]+" "+m[2]); err == nil {
+				entry.Time = t
+			}
+		} else if t, err := time.ParseInLocation("2006-01-02 15:04:05", m[2], time.Local); err == nil {
+			entry.Time = t
+		}
+		entry.Level = normalizeLevel(m[3])
+		entry.Message = m[4]
+		if c := singboxComponentRegex.FindStringSubmatch(entry.Message); c != nil {
+			entry.Component, entry.Message = c[1], c[2]
+		}
+	case singboxFatalRegex.MatchString(clean):
+		m := singboxFatalRegex.FindStringSubmatch(clean)
+		entry.Level = normalizeLevel(m[1])
+		entry.Message = m[2]
+	case panelLogRegex.MatchString(clean):
+		m := panelLogRegex.FindStringSubmatch(clean)
+		entry.Level = normalizeLevel(m[1])
+		entry.Component = "panel"
+		entry.Message = m[2]
+	default:
+		lower := strings.ToLower(clean)
+		if strings.Contains(lower, "error") || strings.Contains(clean, "失败") {
+			entry.Level = "error"
+		} else if strings.Contains(lower, "warn") || strings.Contains(clean, "警告") {
+			entry.Level = "warn"
+		}
+	}
+	return entry
+}
+
+// LogStore 日志存储：内存环形缓冲 + 滚动文件 + 实时订阅
+type LogStore struct {
+	dir         string
+	ring        []LogEntry
+	next        int
+	full        bool
+	file        *os.File
+	size        int64
+	subscribers map[chan LogEntry]struct{}
+	mu          sync.RWMutex
+}
+
+// NewLogStore 创建日志存储，并从日志文件恢复最近的记录
+func NewLogStore(dataDir string) *LogStore {
+	st := &LogStore{
+		dir:         filepath.Join(dataDir, "logs"),
+		ring:        make([]LogEntry, logRingSize),
+		subscribers: make(map[chan LogEntry]struct{}),
+	}
+	os.MkdirAll(st.dir, 0755)
+	st.restore()
+	return st
+}
+
+func (st *LogStore) filePath() string {
+	return filepath.Join(st.dir, "core.log")
+}
+
+// restore 从当前日志文件加载尾部记录到环形缓冲
+func (st *LogStore) restore() {
+	f, err := os.Open(st.filePath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry LogEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			st.push(entry)
+		}
+	}
+}
+
+// push 写入环形缓冲（调用者需持有写锁或处于初始化阶段）
+func (st *LogStore) push(entry LogEntry) {
+	st.ring[st.next] = entry
+	st.next = (st.next + 1) % len(st.ring)
+	if st.next == 0 {
+		st.full = true
+	}
+}
+
+// Append 追加一条日志
+func (st *LogStore) Append(entry LogEntry) {
+	st.mu.Lock()
+	st.push(entry)
+	st.writeFile(entry)
+	subs := make([]chan LogEntry, 0, len(st.subscribers))
+	for ch := range st.subscribers {
+		subs = append(subs, ch)
+	}
+	st.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- entry:
+		default:
+			// 订阅者过慢时丢弃
+		}
+	}
+}
+
+// writeFile 写入日志文件，超过大小后滚动（调用者需持有写锁）
+func (st *LogStore) writeFile(entry LogEntry) {
+	if st.file == nil {
+		f, err := os.OpenFile(st.filePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		info, _ := f.Stat()
+		if info != nil {
+			st.size = info.Size()
+		}
+		st.file = f
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	n, _ := st.file.Write(data)
+	st.size += int64(n)
+
+	if st.size >= logFileMaxSize {
+		st.rotate()
+	}
+}
+
+// rotate 滚动日志文件: core.log -> core.log.1 -> core.log.2 ...
+func (st *LogStore) rotate() {
+	st.file.Close()
+	st.file = nil
+	st.size = 0
+
+	base := st.filePath()
+	os.Remove(fmt.Sprintf("%s.%d", base, logFileMaxBackups))
+	for i := logFileMaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", base, i), fmt.Sprintf("%s.%d", base, i+1))
+	}
+	os.Rename(base, base+".1")
+}
+
+// entries 按时间顺序返回缓冲中的日志（调用者需持有读锁）
+func (st *LogStore) entries() []LogEntry {
+	if !st.full {
+		result := make([]LogEntry, st.next)
+		copy(result, st.ring[:st.next])
+		return result
+	}
+	result := make([]LogEntry, 0, len(st.ring))
+	result = append(result, st.ring[st.next:]...)
+	result = append(result, st.ring[:st.next]...)
+	return result
+}
+
+// Match 检查日志是否满足过滤条件
+func (f LogFilter) Match(entry LogEntry) bool {
+	if f.Level != "" && f.Level != "all" && logLevelRank[entry.Level] < logLevelRank[normalizeLevel(f.Level)] {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(entry.Raw), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
+
+// Query 查询日志，返回满足条件的最近 Limit 条
+// 内存缓冲已滚动覆盖且请求范围早于缓冲时，改为扫描日志文件（含已滚动的历史文件）
+func (st *LogStore) Query(filter LogFilter) []LogEntry {
+	st.mu.RLock()
+	all := st.entries()
+	truncated := st.full
+	st.mu.RUnlock()
+
+	result := filterLogEntries(all, filter)
+	if truncated && len(all) > 0 && needsLogHistory(filter, all[0], len(result)) {
+		if history, err := st.queryFiles(filter); err == nil {
+			result = history
+		} else {
+			fmt.Printf("⚠️ 读取历史日志文件失败: %v\n", err)
+		}
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result
+}
+
+// needsLogHistory 判断内存缓冲是否无法覆盖查询范围
+func needsLogHistory(filter LogFilter, oldest LogEntry, matched int) bool {
+	if filter.Limit > 0 && matched >= filter.Limit {
+		return false
+	}
+	return filter.Since.IsZero() || filter.Since.Before(oldest.Time)
+}
+
+// filterLogEntries 按条件过滤日志
+func filterLogEntries(entries []LogEntry, filter LogFilter) []LogEntry {
+	result := make([]LogEntry, 0)
+	for _, entry := range entries {
+		if filter.Match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// queryFiles 按时间顺序扫描历史文件与当前日志文件（core.log.N ... core.log.1, core.log）
+func (st *LogStore) queryFiles(filter LogFilter) ([]LogEntry, error) {
+	// 持有读锁，避免扫描期间文件被滚动
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	base := st.filePath()
+	paths := make([]string, 0, logFileMaxBackups+1)
+	for i := logFileMaxBackups; i >= 1; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", base, i))
+	}
+	paths = append(paths, base)
+
+	result := make([]LogEntry, 0)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry LogEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil || !filter.Match(entry) {
+				continue
+			}
+			result = append(result, entry)
+			// 只保留最近 Limit 条，限制内存占用
+			if filter.Limit > 0 && len(result) >= 2*filter.Limit {
+				result = append(result[:0], result[len(result)-filter.Limit:]...)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Clear 清空内存缓冲（日志文件保留）
+func (st *LogStore) Clear() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.ring = make([]LogEntry, logRingSize)
+	st.next = 0
+	st.full = false
+}
+
+// Subscribe 订阅实时日志，返回的取消函数需在结束时调用
+func (st *LogStore) Subscribe() (chan LogEntry, func()) {
+	ch := make(chan LogEntry, 256)
+	st.mu.Lock()
+	st.subscribers[ch] = struct{}{}
+	st.mu.Unlock()
+
+	return ch, func() {
+		st.mu.Lock()
+		delete(st.subscribers, ch)
+		st.mu.Unlock()
+	}
+}
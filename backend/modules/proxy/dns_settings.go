@@ -0,0 +1,337 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// DNS 高级策略
+// 代理设置中的按域名上游、hosts、fake-ip 过滤与 ECS，翻译为 mihomo dns/hosts 与 sing-box dns.servers/rules
+// ============================================================================
+
+// dnsUpstreamSchemes 支持的上游协议
+var dnsUpstreamSchemes = map[string]bool{
+	"udp": true, "tcp": true, "tls": true, "https": true, "quic": true, "h3": true, "dhcp": true,
+}
+
+// 旧版默认值（升级时替换为新的默认列表，用户修改过的不动）
+var (
+	legacyFakeIPFilter          = []string{"geosite:cn", "geosite:private", "*.lan", "*.local", "*.localhost"}
+	legacyProxyServerNameserver = []string{"223.5.5.5", "119.29.29.29"}
+)
+
+// migrateDNSSettings 将旧版默认的 fake-ip 过滤与节点域名解析升级为生成器使用的完整默认值
+func migrateDNSSettings(dns *DNSSettings) {
+	if stringSliceEqual(dns.FakeIPFilter, legacyFakeIPFilter) {
+		dns.FakeIPFilter = append([]string{}, defaultFakeIPFilter...)
+	}
+	if stringSliceEqual(dns.ProxyServerNameserver, legacyProxyServerNameserver) {
+		dns.ProxyServerNameserver = append([]string{}, defaultProxyServerNameserver...)
+	}
+}
+
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateDNSUpstream 校验上游地址：纯 IP（可带端口）、system 或 udp/tcp/tls/https/quic/h3/dhcp:// 地址
+func validateDNSUpstream(address string) error {
+	if address == "system" || address == "system://" {
+		return nil
+	}
+	if !strings.Contains(address, "://") {
+		host := address
+		if h, _, err := net.SplitHostPort(address); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("DNS 地址无效: %s（不带协议时必须是 IP）", address)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return fmt.Errorf("DNS 地址无效: %s", address)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "http3" {
+		scheme = "h3"
+	}
+	if !dnsUpstreamSchemes[scheme] {
+		return fmt.Errorf("DNS 地址协议不支持: %s（支持 https、tls、quic、h3、udp、tcp、dhcp）", address)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("DNS 地址缺少主机: %s", address)
+	}
+	return nil
+}
+
+// Validate 校验 DNS 设置中的上游地址、域名策略、hosts 与 ECS 子网
+func (d *DNSSettings) Validate() error {
+	lists := map[string][]string{
+		"默认 DNS":     d.DefaultNameserver,
+		"主 DNS":      d.Nameserver,
+		"后备 DNS":     d.Fallback,
+		"节点域名解析 DNS": d.ProxyServerNameserver,
+		"直连 DNS":     d.DirectNameserver,
+	}
+	for name, list := range lists {
+		for _, address := range list {
+			if err := validateDNSUpstream(address); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	switch d.FakeIPFilterMode {
+	case "", "blacklist", "whitelist":
+	default:
+		return fmt.Errorf("fake-ip 过滤模式无效: %s", d.FakeIPFilterMode)
+	}
+
+	for i, p := range d.NameserverPolicy {
+		if strings.TrimSpace(p.Match) == "" {
+			return fmt.Errorf("DNS 域名策略 %d 缺少匹配域名", i)
+		}
+		if len(p.Nameservers) == 0 {
+			return fmt.Errorf("DNS 域名策略 %s 缺少上游服务器", p.Match)
+		}
+		for _, address := range p.Nameservers {
+			if err := validateDNSUpstream(address); err != nil {
+				return fmt.Errorf("DNS 域名策略 %s: %v", p.Match, err)
+			}
+		}
+	}
+
+	for _, h := range d.Hosts {
+		if strings.TrimSpace(h.Domain) == "" {
+			return fmt.Errorf("hosts 记录缺少域名")
+		}
+		if len(h.IPs) == 0 {
+			return fmt.Errorf("hosts 记录 %s 缺少 IP", h.Domain)
+		}
+		for _, ip := range h.IPs {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("hosts 记录 %s 的 IP 无效: %s", h.Domain, ip)
+			}
+		}
+	}
+
+	if d.ClientSubnet != "" {
+		if _, _, err := net.ParseCIDR(d.ClientSubnet); err != nil && net.ParseIP(d.ClientSubnet) == nil {
+			return fmt.Errorf("ECS 子网无效: %s", d.ClientSubnet)
+		}
+	}
+	return nil
+}
+
+// ValidateSingBox 校验 sing-box 不支持的 DNS 设置（DNS 规则只能指向一个上游服务器）
+func (d *DNSSettings) ValidateSingBox() error {
+	for _, p := range d.NameserverPolicy {
+		if len(p.Nameservers) > 1 {
+			return fmt.Errorf("DNS 域名策略 %s: sing-box 的 DNS 规则只能指向一个上游服务器，请只保留一个或使用 mihomo 核心", p.Match)
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// mihomo
+// ============================================================================
+
+// applyDNSSettingsMihomo 应用 DNS 高级策略（域名策略优先于分流策略中的 DNS 设置）
+func applyDNSSettingsMihomo(config *MihomoConfig, settings *DNSSettings) {
+	dns := config.DNS
+	if dns == nil || settings == nil {
+		return
+	}
+
+	if dns.EnhancedMode == "fake-ip" && len(settings.FakeIPFilter) > 0 {
+		dns.FakeIPFilter = append([]string{}, settings.FakeIPFilter...)
+		if settings.FakeIPFilterMode == "whitelist" {
+			dns.FakeIPFilterMode = settings.FakeIPFilterMode
+		}
+	}
+	if len(settings.ProxyServerNameserver) > 0 {
+		dns.ProxyServerNameserver = settings.ProxyServerNameserver
+	}
+
+	if len(settings.NameserverPolicy) > 0 && dns.NameserverPolicy == nil {
+		dns.NameserverPolicy = make(map[string][]string)
+	}
+	for _, p := range settings.NameserverPolicy {
+		dns.NameserverPolicy[strings.TrimSpace(p.Match)] = p.Nameservers
+	}
+
+	if len(settings.Hosts) > 0 {
+		config.Hosts = make(map[string]interface{}, len(settings.Hosts))
+		for _, h := range settings.Hosts {
+			if len(h.IPs) == 1 {
+				config.Hosts[h.Domain] = h.IPs[0]
+			} else {
+				config.Hosts[h.Domain] = h.IPs
+			}
+		}
+		dns.UseHosts = true
+	}
+
+	// ECS 仅附加到主 DNS 与后备 DNS（海外上游），国内上游本身就能拿到就近结果
+	if settings.ClientSubnet != "" {
+		dns.Nameserver = withClientSubnet(dns.Nameserver, settings.ClientSubnet)
+		dns.Fallback = withClientSubnet(dns.Fallback, settings.ClientSubnet)
+	}
+}
+
+// withClientSubnet 为上游地址附加 mihomo 的 ecs 参数（#ecs=子网，已有参数时以 & 追加）
+func withClientSubnet(servers []string, subnet string) []string {
+	result := make([]string, 0, len(servers))
+	for _, server := range servers {
+		switch {
+		case strings.Contains(server, "ecs="):
+		case strings.Contains(server, "#"):
+			server += "&ecs=" + subnet
+		default:
+			server += "#ecs=" + subnet
+		}
+		result = append(result, server)
+	}
+	return result
+}
+
+// ============================================================================
+// sing-box
+// ============================================================================
+
+// applyDNSSettingsSingBox 应用 DNS 高级策略：hosts、域名策略与 fake-ip 过滤规则插入在最前面
+func applyDNSSettingsSingBox(dns *SBDNS, settings *DNSSettings) error {
+	if dns == nil || settings == nil {
+		return nil
+	}
+	if err := settings.ValidateSingBox(); err != nil {
+		return err
+	}
+	directTag, fakeIPTag := "", ""
+	for _, server := range dns.Servers {
+		switch {
+		case server.Tag == "local" || server.Tag == "localDns":
+			directTag = server.Tag
+		case server.Type == "fakeip":
+			fakeIPTag = server.Tag
+		}
+	}
+
+	rules := make([]SBDNSRule, 0)
+
+	// hosts：sing-box 仅支持精确域名
+	predefined := make(map[string][]string)
+	for _, h := range settings.Hosts {
+		if strings.ContainsAny(h.Domain, "*+") || strings.HasPrefix(h.Domain, ".") {
+			fmt.Printf("⚠️ sing-box hosts 不支持通配域名，跳过 %s\n", h.Domain)
+			continue
+		}
+		predefined[h.Domain] = h.IPs
+	}
+	if len(predefined) > 0 {
+		domains := make([]string, 0, len(predefined))
+		for domain := range predefined {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		dns.Servers = append(dns.Servers, SBDNSServer{Tag: "hosts", Type: "hosts", Predefined: predefined})
+		rules = append(rules, SBDNSRule{Domain: domains, Server: "hosts"})
+	}
+
+	// 域名策略：每条策略一个上游（sing-box 规则只能指向一个服务器）
+	for i, p := range settings.NameserverPolicy {
+		tag := fmt.Sprintf("policy-%d", i+1)
+		server := SBDNSServer{Tag: tag}
+		setSingBoxDNSServer(&server, p.Nameservers[0])
+		dns.Servers = append(dns.Servers, server)
+		for _, rule := range singBoxDNSMatch(p.Match) {
+			rule.Server = tag
+			rules = append(rules, rule)
+		}
+	}
+
+	// fake-ip 过滤：黑名单中的域名改用直连 DNS 返回真实 IP
+	// 默认列表由模板内置规则覆盖，仅翻译用户自定义的列表
+	customFilter := len(settings.FakeIPFilter) > 0 && !stringSliceEqual(settings.FakeIPFilter, defaultFakeIPFilter)
+	if fakeIPTag != "" && directTag != "" && customFilter {
+		if settings.FakeIPFilterMode == "whitelist" {
+			fmt.Println("⚠️ sing-box 不支持 fake-ip 白名单模式，已忽略 fake-ip 过滤")
+		} else {
+			for _, rule := range singBoxDNSMatch(strings.Join(settings.FakeIPFilter, ",")) {
+				rule.Server = directTag
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	dns.Rules = append(rules, dns.Rules...)
+	if settings.ClientSubnet != "" {
+		dns.ClientSubnet = settings.ClientSubnet
+	}
+	return nil
+}
+
+// singBoxDNSMatch 将 mihomo 的域名匹配写法转换为 sing-box DNS 规则（域名类条件与规则集分开为两条规则）
+// 支持 example.com、+.example.com、含 * 的通配（*.example.com 仅匹配一级子域名）、geosite:xx 与 rule-set:xx，可逗号分隔
+func singBoxDNSMatch(match string) []SBDNSRule {
+	var domainRule SBDNSRule
+	var ruleSets []string
+	for _, item := range strings.Split(match, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case strings.HasPrefix(item, "geosite:"):
+			ruleSets = append(ruleSets, "geosite-"+strings.ToLower(strings.TrimPrefix(item, "geosite:")))
+		case strings.HasPrefix(item, "rule-set:"):
+			ruleSets = append(ruleSets, strings.TrimPrefix(item, "rule-set:"))
+		case strings.HasPrefix(item, "+.") && !strings.Contains(item, "*"):
+			domainRule.DomainSuffix = append(domainRule.DomainSuffix, strings.TrimPrefix(item, "+."))
+		case strings.HasPrefix(item, "."):
+			domainRule.DomainSuffix = append(domainRule.DomainSuffix, item)
+		case strings.ContainsAny(item, "*+"):
+			domainRule.DomainRegex = append(domainRule.DomainRegex, wildcardDomainRegex(item))
+		default:
+			domainRule.Domain = append(domainRule.Domain, item)
+		}
+	}
+
+	var rules []SBDNSRule
+	if len(domainRule.Domain)+len(domainRule.DomainSuffix)+len(domainRule.DomainRegex) > 0 {
+		rules = append(rules, domainRule)
+	}
+	if len(ruleSets) > 0 {
+		rules = append(rules, SBDNSRule{RuleSet: ruleSets})
+	}
+	return rules
+}
+
+// wildcardDomainRegex mihomo 通配域名转正则：* 匹配单级，开头的 + 匹配任意级（含自身）
+func wildcardDomainRegex(pattern string) string {
+	prefix := ""
+	if strings.HasPrefix(pattern, "+.") {
+		prefix = `(.+\.)?`
+		pattern = pattern[2:]
+	}
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return "^" + prefix + strings.Join(parts, `[^.]+`) + "$"
+}
@@ -0,0 +1,546 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// 第三方客户端配置导出
+// 以当前节点与分流策略渲染 Surge / Shadowrocket / Quantumult X / Clash Meta / sing-box 配置，
+// 通过带令牌的下载地址提供给客户端订阅，客户端不支持的协议与规则跳过并给出警告
+// ============================================================================
+
+// 导出目标
+const (
+	ExportTargetSurge        = "surge"
+	ExportTargetShadowrocket = "shadowrocket"
+	ExportTargetQuantumultX  = "quantumultx"
+	ExportTargetClash        = "clash"
+	ExportTargetSingBox      = "singbox"
+)
+
+// exportTargets 导出目标的下载文件名与内容类型
+var exportTargets = map[string]struct {
+	Filename    string
+	ContentType string
+}{
+	ExportTargetSurge:        {"SkyNeT-surge.conf", "text/plain; charset=utf-8"},
+	ExportTargetShadowrocket: {"SkyNeT-shadowrocket.conf", "text/plain; charset=utf-8"},
+	ExportTargetQuantumultX:  {"SkyNeT-quantumultx.conf", "text/plain; charset=utf-8"},
+	ExportTargetClash:        {"SkyNeT-clash.yaml", "text/yaml; charset=utf-8"},
+	ExportTargetSingBox:      {"SkyNeT-singbox.json", "application/json; charset=utf-8"},
+}
+
+// errExportTokenNotFound 令牌不存在（已删除或地址错误）
+var errExportTokenNotFound = errors.New("导出令牌不存在")
+
+// ExportToken 导出下载令牌（令牌即下载凭据，无需登录）
+type ExportToken struct {
+	Token        string    `json:"token"`
+	Name         string    `json:"name"`
+	Target       string    `json:"target"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastAccessAt time.Time `json:"lastAccessAt,omitempty"`
+	AccessCount  int       `json:"accessCount"`
+}
+
+// ExportWarning 导出时跳过的节点、策略组或规则
+type ExportWarning struct {
+	Kind   string `json:"kind"` // proxy, group, rule, rule-set
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ExportResult 导出结果
+type ExportResult struct {
+	Target      string          `json:"target"`
+	Filename    string          `json:"filename"`
+	ContentType string          `json:"contentType"`
+	Content     string          `json:"content"`
+	Warnings    []ExportWarning `json:"warnings"`
+}
+
+// exportSource 渲染导出配置所需的当前状态快照
+type exportSource struct {
+	nodes    []ProxyNode
+	config   ProxyConfig
+	policy   *RoutingPolicy
+	template *ConfigTemplate
+	settings *ProxySettings
+}
+
+// exportsPath 导出令牌文件路径
+func exportsPath(dataDir string) string {
+	return filepath.Join(dataDir, "exports.json")
+}
+
+// LoadExportTokens 加载导出令牌
+func LoadExportTokens(dataDir string) ([]ExportToken, error) {
+	data, err := os.ReadFile(exportsPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var tokens []ExportToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// SaveExportTokens 保存导出令牌
+func SaveExportTokens(dataDir string, tokens []ExportToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(exportsPath(dataDir), data)
+}
+
+// generateExportToken 生成随机令牌
+func generateExportToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ============================================================================
+// Service 方法
+// ============================================================================
+
+// loadExports 加载导出令牌
+func (s *Service) loadExports() {
+	tokens, err := LoadExportTokens(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("⚠️ 加载导出令牌失败: %v\n", err)
+		}
+		tokens = []ExportToken{}
+	}
+	s.exports = tokens
+}
+
+// GetExportTokens 获取导出令牌列表
+func (s *Service) GetExportTokens() []ExportToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]ExportToken{}, s.exports...)
+}
+
+// CreateExportToken 为指定客户端创建下载令牌
+func (s *Service) CreateExportToken(name, target string) (*ExportToken, error) {
+	if _, ok := exportTargets[target]; !ok {
+		return nil, fmt.Errorf("不支持的导出目标: %s", target)
+	}
+	if strings.TrimSpace(name) == "" {
+		name = target
+	}
+	token := ExportToken{
+		Token:     generateExportToken(),
+		Name:      strings.TrimSpace(name),
+		Target:    target,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := append(append([]ExportToken{}, s.exports...), token)
+	if err := SaveExportTokens(s.dataDir, tokens); err != nil {
+		return nil, err
+	}
+	s.exports = tokens
+	return &token, nil
+}
+
+// DeleteExportToken 删除下载令牌（已分发的地址随即失效）
+func (s *Service) DeleteExportToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]ExportToken, 0, len(s.exports))
+	for _, t := range s.exports {
+		if t.Token != token {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == len(s.exports) {
+		return errExportTokenNotFound
+	}
+	if err := SaveExportTokens(s.dataDir, tokens); err != nil {
+		return err
+	}
+	s.exports = tokens
+	return nil
+}
+
+// ExportByToken 按令牌渲染配置并记录访问，updateURL 为客户端自动更新使用的下载地址
+func (s *Service) ExportByToken(token, updateURL string) (*ExportResult, error) {
+	s.mu.Lock()
+	index := -1
+	for i := range s.exports {
+		if s.exports[i].Token == token {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		s.mu.Unlock()
+		return nil, errExportTokenNotFound
+	}
+	s.exports[index].LastAccessAt = time.Now()
+	s.exports[index].AccessCount++
+	target := s.exports[index].Target
+	if err := SaveExportTokens(s.dataDir, s.exports); err != nil {
+		fmt.Printf("⚠️ 保存导出令牌访问记录失败: %v\n", err)
+	}
+	s.mu.Unlock()
+
+	return s.RenderExport(target, updateURL)
+}
+
+// RenderExport 以当前节点与分流策略渲染指定客户端的配置
+func (s *Service) RenderExport(target, updateURL string) (*ExportResult, error) {
+	info, ok := exportTargets[target]
+	if !ok {
+		return nil, fmt.Errorf("不支持的导出目标: %s", target)
+	}
+	src, err := s.exportSource()
+	if err != nil {
+		return nil, err
+	}
+
+	var content string
+	var warnings []ExportWarning
+	switch target {
+	case ExportTargetSurge, ExportTargetShadowrocket:
+		content, warnings = renderSurge(src, target, updateURL)
+	case ExportTargetQuantumultX:
+		content, warnings = renderQuantumultX(src, updateURL)
+	case ExportTargetClash:
+		content, warnings, err = renderClashMeta(src)
+	case ExportTargetSingBox:
+		content, warnings, err = renderSingBox(src, LoadSingBoxTemplate(s.dataDir))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if warnings == nil {
+		warnings = []ExportWarning{}
+	}
+	return &ExportResult{
+		Target:      target,
+		Filename:    info.Filename,
+		ContentType: info.ContentType,
+		Content:     content,
+		Warnings:    warnings,
+	}, nil
+}
+
+// exportSource 获取当前节点、配置与分流策略
+func (s *Service) exportSource() (*exportSource, error) {
+	if s.nodeProvider == nil {
+		return nil, fmt.Errorf("节点提供者未设置")
+	}
+	nodes := s.nodeProvider()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("没有可用节点")
+	}
+	var settings *ProxySettings
+	if s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &exportSource{
+		nodes:    nodes,
+		config:   *s.config,
+		policy:   s.routingPolicy,
+		template: s.configTemplate,
+		settings: settings,
+	}, nil
+}
+
+// clientGeneratorOptions 面向客户端设备的生成选项：仅本机监听、启用 TUN、不含服务端专用入站与密钥
+func (src *exportSource) clientGeneratorOptions() ConfigGeneratorOptions {
+	options := buildGeneratorOptions(&src.config, src.template, src.policy, src.settings)
+	options.AllowLan = false
+	options.EnableTProxy = false
+	options.EnableTUN = true
+	options.ExternalController = "127.0.0.1:9090"
+	options.ControllerSocket = ""
+	options.Secret = ""
+	options.CheckPort = 0
+	options.ProviderMode = false
+	return options
+}
+
+// ============================================================================
+// Clash Meta / sing-box
+// ============================================================================
+
+// renderClashMeta 渲染独立的 Clash Meta (mihomo) 配置
+func renderClashMeta(src *exportSource) (string, []ExportWarning, error) {
+	generator := NewConfigGenerator("")
+	config, err := generator.GenerateConfig(src.nodes, src.clientGeneratorOptions())
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 客户端 TUN：由客户端自行命名网卡，不使用 Linux 专用的 nftables 重定向与路由表设置
+	if config.TUN != nil {
+		config.TUN.Device = ""
+		config.TUN.AutoRedirect = false
+		config.TUN.GSO = false
+		config.TUN.GSOMaxSize = 0
+		config.TUN.Iproute2TableIndex = 0
+		config.TUN.Iproute2RuleIndex = 0
+		config.TUN.EndpointIndependentNat = false
+	}
+	if config.DNS != nil {
+		config.DNS.Listen = ""
+	}
+
+	// 仅存在于本机的规则集无法随配置分发
+	var warnings []ExportWarning
+	for name, provider := range config.RuleProviders {
+		if provider.Type != "file" {
+			continue
+		}
+		delete(config.RuleProviders, name)
+		warnings = append(warnings, ExportWarning{Kind: "rule-set", Name: name, Reason: "规则集没有远程地址，已移除"})
+		rules := config.Rules[:0]
+		for _, rule := range config.Rules {
+			if !strings.HasPrefix(rule, "RULE-SET,"+name+",") {
+				rules = append(rules, rule)
+			}
+		}
+		config.Rules = rules
+		if config.DNS != nil {
+			delete(config.DNS.NameserverPolicy, "rule-set:"+name)
+		}
+	}
+
+	data, err := generator.MarshalConfig(config)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data), warnings, nil
+}
+
+// renderSingBox 渲染独立的 sing-box 配置（TUN 入站）
+func renderSingBox(src *exportSource, template *SingBoxTemplate) (string, []ExportWarning, error) {
+	var warnings []ExportWarning
+	for _, node := range src.nodes {
+		if _, err := ParseNodeToSingBox(node); err != nil {
+			warnings = append(warnings, ExportWarning{Kind: "proxy", Name: node.Name, Reason: err.Error()})
+		}
+	}
+
+	options := src.clientGeneratorOptions()
+	sbOpts := buildSingBoxOptions(options)
+	sbOpts.Mode = "tun"
+	sbOpts.TUNStack = "mixed"
+	sbOpts.StrictRoute = true
+	sbOpts.AutoRedirect = false
+	sbOpts.Template = template
+
+	config, err := NewSingboxGenerator("").GenerateConfigV112(src.nodes, sbOpts)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := range config.Inbounds {
+		if config.Inbounds[i].Type == "tun" {
+			config.Inbounds[i].AutoRedirect = false
+		}
+	}
+
+	// 本机规则集：GEO 规则集改用远程地址，其余移除并跳过引用它的规则
+	if config.Route != nil {
+		removed := make(map[string]bool)
+		ruleSets := make([]SBRuleSet, 0, len(config.Route.RuleSet))
+		for _, rs := range config.Route.RuleSet {
+			if rs.Type == "local" {
+				if strings.HasPrefix(rs.Tag, "geosite-") || strings.HasPrefix(rs.Tag, "geoip-") {
+					rs = singBoxRemoteGeoRuleSet(rs.Tag)
+				} else {
+					removed[rs.Tag] = true
+					warnings = append(warnings, ExportWarning{Kind: "rule-set", Name: rs.Tag, Reason: "规则集没有远程地址，已移除"})
+					continue
+				}
+			}
+			ruleSets = append(ruleSets, rs)
+		}
+		config.Route.RuleSet = ruleSets
+
+		if len(removed) > 0 {
+			rules := make([]SBRouteRule, 0, len(config.Route.Rules))
+			for _, rule := range config.Route.Rules {
+				if refs, ok := pruneRuleSetRefs(rule.RuleSet, removed); ok {
+					rule.RuleSet = refs
+					rules = append(rules, rule)
+				}
+			}
+			config.Route.Rules = rules
+			if config.DNS != nil {
+				dnsRules := make([]SBDNSRule, 0, len(config.DNS.Rules))
+				for _, rule := range config.DNS.Rules {
+					if refs, ok := pruneRuleSetRefs(rule.RuleSet, removed); ok {
+						rule.RuleSet = refs
+						dnsRules = append(dnsRules, rule)
+					}
+				}
+				config.DNS.Rules = dnsRules
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data), warnings, nil
+}
+
+// pruneRuleSetRefs 从规则的规则集引用中移除已删除的规则集，全部被移除时规则不再保留
+func pruneRuleSetRefs(ref interface{}, removed map[string]bool) (interface{}, bool) {
+	switch v := ref.(type) {
+	case string:
+		return v, !removed[v]
+	case []string:
+		kept := make([]string, 0, len(v))
+		for _, tag := range v {
+			if !removed[tag] {
+				kept = append(kept, tag)
+			}
+		}
+		if len(kept) == 0 {
+			return nil, false
+		}
+		return kept, true
+	}
+	return ref, true
+}
+
+// ============================================================================
+// 文本格式客户端共用
+// ============================================================================
+
+// exportPrivateCIDRs GEOIP,LAN 在不支持该写法的客户端中展开的地址段
+var (
+	exportPrivateCIDRs  = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "100.64.0.0/10"}
+	exportPrivateCIDR6s = []string{"fc00::/7", "fe80::/10"}
+)
+
+// exportNode 已转换为 mihomo 字段的节点
+type exportNode struct {
+	node  ProxyNode
+	proxy map[string]interface{}
+}
+
+// exportNodes 将节点转换为 mihomo 字段，便于各文本格式按统一字段名读取
+func exportNodes(nodes []ProxyNode) []exportNode {
+	generator := NewConfigGenerator("")
+	result := make([]exportNode, 0, len(nodes))
+	for _, node := range nodes {
+		proxies := generator.convertProxies([]ProxyNode{node})
+		if len(proxies) == 0 {
+			continue
+		}
+		result = append(result, exportNode{node: node, proxy: proxies[0]})
+	}
+	return result
+}
+
+// exportNameSanitizer 将名称中的分隔符替换掉（文本格式以逗号和等号分隔字段），并保证替换后不重名
+type exportNameSanitizer struct {
+	names map[string]string
+	used  map[string]bool
+}
+
+func newExportNameSanitizer() *exportNameSanitizer {
+	return &exportNameSanitizer{names: make(map[string]string), used: make(map[string]bool)}
+}
+
+// name 返回名称在导出配置中使用的写法
+func (n *exportNameSanitizer) name(original string) string {
+	if name, ok := n.names[original]; ok {
+		return name
+	}
+	name := strings.TrimSpace(strings.NewReplacer(",", " ", "=", " ").Replace(original))
+	if name == "" {
+		name = "节点"
+	}
+	base := name
+	for i := 2; n.used[name]; i++ {
+		name = fmt.Sprintf("%s %d", base, i)
+	}
+	n.names[original] = name
+	n.used[name] = true
+	return name
+}
+
+// exportGroups 按导出的节点解析策略组成员
+func exportGroups(policy *RoutingPolicy, nodes []exportNode) []resolvedGroup {
+	nodeNames := make([]string, 0, len(nodes))
+	manualNames := make([]string, 0)
+	for _, n := range nodes {
+		nodeNames = append(nodeNames, n.node.Name)
+		if n.node.IsManual {
+			manualNames = append(manualNames, n.node.Name)
+		}
+	}
+	return policy.resolveGroups(nodeNames, manualNames)
+}
+
+// exportPorts 拆分端口列表（mihomo 以 / 或 , 分隔）
+func exportPorts(payload string) []string {
+	ports := make([]string, 0)
+	for _, item := range strings.FieldsFunc(payload, func(r rune) bool { return r == '/' || r == ',' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			ports = append(ports, item)
+		}
+	}
+	return ports
+}
+
+// exportBandwidth 解析 "100 Mbps" 形式的带宽为整数 Mbps
+func exportBandwidth(v interface{}) int {
+	s := strings.TrimSpace(strings.ToLower(yamlString(v)))
+	if s == "" {
+		return yamlInt(v)
+	}
+	s = strings.TrimSpace(strings.TrimSuffix(s, "mbps"))
+	var n int
+	fmt.Sscanf(s, "%d", &n)
+	return n
+}
+
+// proxyTransport 节点的传输层与 WebSocket 参数
+func proxyTransport(proxy map[string]interface{}) (network, path, host string) {
+	network = yamlString(proxy["network"])
+	if network == "" {
+		network = "tcp"
+	}
+	if opts, ok := proxy["ws-opts"].(map[string]interface{}); ok {
+		path = yamlString(opts["path"])
+		if headers, ok := opts["headers"].(map[string]interface{}); ok {
+			host = yamlString(headers["Host"])
+		}
+	}
+	return network, path, host
+}
+
+// proxySNI 节点的 TLS 服务器名称
+func proxySNI(proxy map[string]interface{}) string {
+	if sni := yamlString(proxy["sni"]); sni != "" {
+		return sni
+	}
+	return yamlString(proxy["servername"])
+}
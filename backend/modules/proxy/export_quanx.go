@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Quantumult X 导出
+// ============================================================================
+
+// quanxGroupTypes 策略组类型映射
+var quanxGroupTypes = map[string]string{
+	"select":       "static",
+	"url-test":     "url-latency-benchmark",
+	"fallback":     "available",
+	"load-balance": "round-robin",
+}
+
+// renderQuantumultX 渲染 Quantumult X 配置
+func renderQuantumultX(src *exportSource, updateURL string) (string, []ExportWarning) {
+	names := newExportNameSanitizer()
+	var warnings []ExportWarning
+	var b strings.Builder
+
+	if updateURL != "" {
+		fmt.Fprintf(&b, ";SkyNeT 导出配置，更新地址: %s\n\n", updateURL)
+	}
+	b.WriteString("[general]\n")
+	fmt.Fprintf(&b, "server_check_url=%s\n", providerHealthCheckURL)
+	b.WriteString("excluded_routes=10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, 100.64.0.0/10, 127.0.0.0/8\n")
+	b.WriteString("\n[dns]\n")
+	b.WriteString("server=223.5.5.5\nserver=119.29.29.29\n")
+	if !src.config.IPv6 {
+		b.WriteString("no-ipv6\n")
+	}
+
+	// 节点
+	servers := make([]string, 0)
+	exported := make([]exportNode, 0)
+	for _, n := range exportNodes(src.nodes) {
+		line, err := quanxServerLine(n.proxy)
+		if err != nil {
+			warnings = append(warnings, ExportWarning{Kind: "proxy", Name: n.node.Name, Reason: err.Error()})
+			continue
+		}
+		exported = append(exported, n)
+		servers = append(servers, line+", tag="+names.name(n.node.Name))
+	}
+
+	// 策略组（内置策略为小写 direct / reject）
+	groups := exportGroups(src.policy, exported)
+	enabled := make(map[string]bool)
+	for _, g := range groups {
+		enabled[g.Name] = true
+	}
+	member := func(name string) string {
+		switch name {
+		case PolicyDirect:
+			return "direct"
+		case PolicyReject:
+			return "reject"
+		}
+		return names.name(name)
+	}
+	b.WriteString("\n[policy]\n")
+	for _, g := range groups {
+		fields := []string{member(g.Name)}
+		for _, m := range g.members {
+			fields = append(fields, member(m))
+		}
+		if g.Type == "url-test" {
+			fields = append(fields, "check-interval="+strconv.Itoa(exportTestInterval(g.Interval)))
+			if g.Tolerance > 0 {
+				fields = append(fields, "tolerance="+strconv.Itoa(g.Tolerance))
+			}
+		}
+		fmt.Fprintf(&b, "%s=%s\n", quanxGroupTypes[g.Type], strings.Join(fields, ", "))
+	}
+
+	b.WriteString("\n[server_local]\n")
+	for _, line := range servers {
+		b.WriteString(line + "\n")
+	}
+
+	// 规则
+	b.WriteString("\n[filter_local]\n")
+	final := ""
+	for _, r := range src.policy.effectiveRules() {
+		if r.Target != PolicyDirect && r.Target != PolicyReject && !enabled[r.Target] {
+			continue
+		}
+		if strings.EqualFold(r.Type, "MATCH") {
+			final = member(r.Target)
+			// MATCH 之后的规则不会被匹配
+			break
+		}
+		lines, err := quanxFilters(r, member(r.Target))
+		if err != nil {
+			warnings = append(warnings, ExportWarning{Kind: "rule", Name: r.Type + "," + r.Payload, Reason: err.Error()})
+			continue
+		}
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	if final == "" {
+		final = "direct"
+		if len(groups) > 0 {
+			final = member(groups[0].Name)
+		}
+	}
+	fmt.Fprintf(&b, "final, %s\n", final)
+
+	return b.String(), warnings
+}
+
+// quanxServerLine 将 mihomo 字段的节点转换为 Quantumult X 节点行（不含 tag）
+func quanxServerLine(proxy map[string]interface{}) (string, error) {
+	address := yamlString(proxy["server"]) + ":" + strconv.Itoa(yamlInt(proxy["port"]))
+	proxyType := yamlString(proxy["type"])
+	fields := make([]string, 0, 8)
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	verify := func() {
+		if yamlBool(proxy["skip-cert-verify"]) {
+			add("tls-verification", "false")
+		}
+	}
+	// transport VMess / VLESS / Trojan 共用的 obfs 写法
+	transport := func(tls bool) error {
+		network, path, host := proxyTransport(proxy)
+		switch {
+		case network == "ws" && tls:
+			add("obfs", "wss")
+		case network == "ws":
+			add("obfs", "ws")
+		case network == "tcp" && tls:
+			add("obfs", "over-tls")
+		case network == "tcp":
+			return nil
+		default:
+			return fmt.Errorf("不支持 %s 传输", network)
+		}
+		if host == "" && tls {
+			host = proxySNI(proxy)
+		}
+		add("obfs-host", host)
+		add("obfs-uri", path)
+		if tls {
+			verify()
+		}
+		return nil
+	}
+
+	switch proxyType {
+	case "ss":
+		fields = append(fields, "shadowsocks="+address)
+		add("method", yamlString(proxy["cipher"]))
+		add("password", yamlString(proxy["password"]))
+		if plugin := yamlString(proxy["plugin"]); plugin != "" {
+			if plugin != "obfs" {
+				return "", fmt.Errorf("不支持 Shadowsocks 插件 %s", plugin)
+			}
+			opts, _ := proxy["plugin-opts"].(map[string]interface{})
+			add("obfs", yamlString(opts["mode"]))
+			add("obfs-host", yamlString(opts["host"]))
+		}
+		if yamlBool(proxy["udp"]) {
+			add("udp-relay", "true")
+		}
+	case "vmess":
+		fields = append(fields, "vmess="+address)
+		method := yamlString(proxy["cipher"])
+		if method == "" || method == "auto" {
+			method = "chacha20-ietf-poly1305"
+		}
+		add("method", method)
+		add("password", yamlString(proxy["uuid"]))
+		if err := transport(yamlBool(proxy["tls"])); err != nil {
+			return "", err
+		}
+		if yamlInt(proxy["alterId"]) > 0 {
+			add("aead", "false")
+		}
+	case "vless":
+		if _, ok := proxy["reality-opts"]; ok {
+			return "", fmt.Errorf("不支持 VLESS Reality")
+		}
+		if flow := yamlString(proxy["flow"]); flow != "" {
+			return "", fmt.Errorf("不支持 VLESS flow %s", flow)
+		}
+		fields = append(fields, "vless="+address, "method=none")
+		add("password", yamlString(proxy["uuid"]))
+		if err := transport(yamlBool(proxy["tls"])); err != nil {
+			return "", err
+		}
+	case "trojan":
+		fields = append(fields, "trojan="+address)
+		add("password", yamlString(proxy["password"]))
+		network, _, _ := proxyTransport(proxy)
+		if network == "tcp" {
+			add("over-tls", "true")
+			add("tls-host", proxySNI(proxy))
+			verify()
+		} else if err := transport(true); err != nil {
+			return "", err
+		}
+	case "http", "socks5":
+		fields = append(fields, proxyType+"="+address)
+		add("username", yamlString(proxy["username"]))
+		add("password", yamlString(proxy["password"]))
+		if yamlBool(proxy["tls"]) {
+			add("over-tls", "true")
+			add("tls-host", proxySNI(proxy))
+			verify()
+		}
+	default:
+		return "", fmt.Errorf("不支持 %s 协议", proxyType)
+	}
+	return strings.Join(fields, ", "), nil
+}
+
+// quanxFilters 将分流规则转换为 Quantumult X 规则行
+func quanxFilters(r PolicyRule, target string) ([]string, error) {
+	ruleType := strings.ToUpper(r.Type)
+	noResolve := ""
+	if r.NoResolve {
+		noResolve = ", no-resolve"
+	}
+
+	switch ruleType {
+	case "DOMAIN":
+		return []string{"host, " + r.Payload + ", " + target}, nil
+	case "DOMAIN-SUFFIX":
+		return []string{"host-suffix, " + r.Payload + ", " + target}, nil
+	case "DOMAIN-KEYWORD":
+		return []string{"host-keyword, " + r.Payload + ", " + target}, nil
+	case "IP-CIDR":
+		return []string{"ip-cidr, " + r.Payload + ", " + target + noResolve}, nil
+	case "IP-CIDR6":
+		return []string{"ip6-cidr, " + r.Payload + ", " + target + noResolve}, nil
+	case "GEOIP":
+		if strings.EqualFold(r.Payload, "LAN") || strings.EqualFold(r.Payload, "private") {
+			lines := make([]string, 0, len(exportPrivateCIDRs)+len(exportPrivateCIDR6s))
+			for _, cidr := range exportPrivateCIDRs {
+				lines = append(lines, "ip-cidr, "+cidr+", "+target+", no-resolve")
+			}
+			for _, cidr := range exportPrivateCIDR6s {
+				lines = append(lines, "ip6-cidr, "+cidr+", "+target+", no-resolve")
+			}
+			return lines, nil
+		}
+		return []string{"geoip, " + strings.ToLower(r.Payload) + ", " + target}, nil
+	}
+	return nil, fmt.Errorf("不支持 %s 规则", ruleType)
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Surge / Shadowrocket 导出
+// Shadowrocket 兼容 Surge 配置格式，区别在于文件头、自动更新写法与支持的规则类型
+// ============================================================================
+
+// renderSurge 渲染 Surge 或 Shadowrocket 配置
+func renderSurge(src *exportSource, target, updateURL string) (string, []ExportWarning) {
+	shadowrocket := target == ExportTargetShadowrocket
+	names := newExportNameSanitizer()
+	var warnings []ExportWarning
+	var b strings.Builder
+
+	if updateURL != "" && !shadowrocket {
+		fmt.Fprintf(&b, "#!MANAGED-CONFIG %s interval=86400 strict=false\n\n", updateURL)
+	}
+	b.WriteString("[General]\n")
+	if shadowrocket {
+		b.WriteString("bypass-system = true\n")
+		if updateURL != "" {
+			fmt.Fprintf(&b, "update-url = %s\n", updateURL)
+		}
+	} else {
+		b.WriteString("loglevel = notify\n")
+	}
+	b.WriteString("dns-server = system, 223.5.5.5, 119.29.29.29\n")
+	b.WriteString("skip-proxy = 127.0.0.1, 192.168.0.0/16, 10.0.0.0/8, 172.16.0.0/12, 100.64.0.0/10, localhost, *.local\n")
+	fmt.Fprintf(&b, "ipv6 = %t\n", src.config.IPv6)
+
+	// 节点
+	b.WriteString("\n[Proxy]\n")
+	exported := make([]exportNode, 0)
+	for _, n := range exportNodes(src.nodes) {
+		line, err := surgeProxyLine(n.proxy)
+		if err != nil {
+			warnings = append(warnings, ExportWarning{Kind: "proxy", Name: n.node.Name, Reason: err.Error()})
+			continue
+		}
+		exported = append(exported, n)
+		fmt.Fprintf(&b, "%s = %s\n", names.name(n.node.Name), line)
+	}
+
+	// 策略组
+	b.WriteString("\n[Proxy Group]\n")
+	groups := exportGroups(src.policy, exported)
+	enabled := make(map[string]bool)
+	for _, g := range groups {
+		enabled[g.Name] = true
+	}
+	member := func(name string) string {
+		if name == PolicyDirect || name == PolicyReject {
+			return name
+		}
+		return names.name(name)
+	}
+	for _, g := range groups {
+		fields := []string{g.Type}
+		for _, m := range g.members {
+			fields = append(fields, member(m))
+		}
+		if g.Type != "select" {
+			fields = append(fields, "url="+exportTestURL(g.URL), "interval="+strconv.Itoa(exportTestInterval(g.Interval)))
+			if g.Type == "url-test" && g.Tolerance > 0 {
+				fields = append(fields, "tolerance="+strconv.Itoa(g.Tolerance))
+			}
+		}
+		fmt.Fprintf(&b, "%s = %s\n", member(g.Name), strings.Join(fields, ", "))
+	}
+
+	// 规则
+	b.WriteString("\n[Rule]\n")
+	ruleSets := make(map[string]PolicyRuleSet, len(src.policy.RuleSets))
+	for _, rs := range src.policy.RuleSets {
+		ruleSets[rs.Name] = rs
+	}
+	final := ""
+	for _, r := range src.policy.effectiveRules() {
+		if r.Target != PolicyDirect && r.Target != PolicyReject && !enabled[r.Target] {
+			continue
+		}
+		if strings.EqualFold(r.Type, "MATCH") {
+			final = member(r.Target)
+			// MATCH 之后的规则不会被匹配
+			break
+		}
+		var lines []string
+		var err error
+		if strings.EqualFold(r.Type, "RULE-SET") {
+			lines, err = surgeRuleSet(ruleSets[r.Payload], member(r.Target))
+		} else {
+			lines, err = surgeRules(r, member(r.Target), shadowrocket)
+		}
+		if err != nil {
+			warnings = append(warnings, ExportWarning{Kind: "rule", Name: r.Type + "," + r.Payload, Reason: err.Error()})
+			continue
+		}
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	if final == "" {
+		final = PolicyDirect
+		if len(groups) > 0 {
+			final = member(groups[0].Name)
+		}
+	}
+	fmt.Fprintf(&b, "FINAL,%s\n", final)
+
+	return b.String(), warnings
+}
+
+// exportTestURL 测速地址（未设置时使用提供者健康检查地址）
+func exportTestURL(url string) string {
+	if url == "" {
+		return providerHealthCheckURL
+	}
+	return url
+}
+
+// exportTestInterval 测速间隔（秒）
+func exportTestInterval(interval int) int {
+	if interval <= 0 {
+		return providerHealthCheckInterval
+	}
+	return interval
+}
+
+// surgeProxyLine 将 mihomo 字段的节点转换为 Surge 节点行（不含名称）
+func surgeProxyLine(proxy map[string]interface{}) (string, error) {
+	server := yamlString(proxy["server"])
+	port := strconv.Itoa(yamlInt(proxy["port"]))
+	proxyType := yamlString(proxy["type"])
+	fields := make([]string, 0, 8)
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	tlsOptions := func() {
+		add("sni", proxySNI(proxy))
+		if yamlBool(proxy["skip-cert-verify"]) {
+			add("skip-cert-verify", "true")
+		}
+	}
+
+	switch proxyType {
+	case "ss":
+		fields = append(fields, "ss", server, port)
+		add("encrypt-method", yamlString(proxy["cipher"]))
+		add("password", yamlString(proxy["password"]))
+		if plugin := yamlString(proxy["plugin"]); plugin != "" {
+			if plugin != "obfs" {
+				return "", fmt.Errorf("不支持 Shadowsocks 插件 %s", plugin)
+			}
+			opts, _ := proxy["plugin-opts"].(map[string]interface{})
+			add("obfs", yamlString(opts["mode"]))
+			add("obfs-host", yamlString(opts["host"]))
+		}
+		if yamlBool(proxy["udp"]) {
+			add("udp-relay", "true")
+		}
+	case "vmess":
+		network, path, host := proxyTransport(proxy)
+		if network != "tcp" && network != "ws" {
+			return "", fmt.Errorf("VMess 不支持 %s 传输", network)
+		}
+		fields = append(fields, "vmess", server, port)
+		add("username", yamlString(proxy["uuid"]))
+		if network == "ws" {
+			add("ws", "true")
+			add("ws-path", path)
+			if host != "" {
+				add("ws-headers", "Host:"+host)
+			}
+		}
+		if yamlBool(proxy["tls"]) {
+			add("tls", "true")
+			tlsOptions()
+		}
+		if yamlInt(proxy["alterId"]) == 0 {
+			add("vmess-aead", "true")
+		}
+	case "trojan":
+		network, path, host := proxyTransport(proxy)
+		if network != "tcp" && network != "ws" {
+			return "", fmt.Errorf("Trojan 不支持 %s 传输", network)
+		}
+		fields = append(fields, "trojan", server, port)
+		add("password", yamlString(proxy["password"]))
+		tlsOptions()
+		if network == "ws" {
+			add("ws", "true")
+			add("ws-path", path)
+			if host != "" {
+				add("ws-headers", "Host:"+host)
+			}
+		}
+	case "hysteria2":
+		if yamlString(proxy["obfs"]) != "" {
+			return "", fmt.Errorf("不支持 Hysteria2 混淆")
+		}
+		fields = append(fields, "hysteria2", server, port)
+		add("password", yamlString(proxy["password"]))
+		tlsOptions()
+		if down := exportBandwidth(proxy["down"]); down > 0 {
+			add("download-bandwidth", strconv.Itoa(down))
+		}
+	case "tuic":
+		if yamlString(proxy["uuid"]) == "" {
+			return "", fmt.Errorf("仅支持 TUIC v5")
+		}
+		fields = append(fields, "tuic-v5", server, port)
+		add("uuid", yamlString(proxy["uuid"]))
+		add("password", yamlString(proxy["password"]))
+		add("alpn", strings.Join(yamlStrings(proxy["alpn"]), ","))
+		tlsOptions()
+	case "socks5", "http":
+		name := proxyType
+		if yamlBool(proxy["tls"]) {
+			name = map[string]string{"socks5": "socks5-tls", "http": "https"}[proxyType]
+		}
+		fields = append(fields, name, server, port)
+		if user := yamlString(proxy["username"]); user != "" {
+			fields = append(fields, user, yamlString(proxy["password"]))
+		}
+		if yamlBool(proxy["tls"]) {
+			tlsOptions()
+		}
+	case "snell":
+		fields = append(fields, "snell", server, port)
+		add("psk", yamlString(proxy["psk"]))
+		if version := yamlInt(proxy["version"]); version > 0 {
+			add("version", strconv.Itoa(version))
+		}
+		if opts, ok := proxy["obfs-opts"].(map[string]interface{}); ok {
+			add("obfs", yamlString(opts["mode"]))
+			add("obfs-host", yamlString(opts["host"]))
+		}
+	default:
+		return "", fmt.Errorf("不支持 %s 协议", proxyType)
+	}
+	return strings.Join(fields, ", "), nil
+}
+
+// surgeRuleSet 将规则集引用转换为远程规则行（仅文本格式的 mihomo 规则集与 Surge 兼容）
+func surgeRuleSet(rs PolicyRuleSet, target string) ([]string, error) {
+	if rs.Mihomo == nil || rs.Mihomo.URL == "" {
+		return nil, fmt.Errorf("规则集没有远程地址")
+	}
+	if rs.Mihomo.Format != "text" {
+		format := rs.Mihomo.Format
+		if format == "" {
+			format = "yaml"
+		}
+		return nil, fmt.Errorf("规则集为 %s 格式，客户端无法使用", format)
+	}
+	switch rs.Behavior {
+	case "domain":
+		return []string{"DOMAIN-SET," + rs.Mihomo.URL + "," + target}, nil
+	case "classical":
+		return []string{"RULE-SET," + rs.Mihomo.URL + "," + target}, nil
+	}
+	return nil, fmt.Errorf("不支持 %s 类型的文本规则集", rs.Behavior)
+}
+
+// surgeRules 将分流规则转换为 Surge 规则行（端口列表与 GEOIP,LAN 会展开为多行）
+func surgeRules(r PolicyRule, target string, shadowrocket bool) ([]string, error) {
+	ruleType := strings.ToUpper(r.Type)
+	noResolve := ""
+	if r.NoResolve {
+		noResolve = ",no-resolve"
+	}
+
+	switch ruleType {
+	case "DOMAIN", "DOMAIN-SUFFIX", "DOMAIN-KEYWORD":
+		return []string{ruleType + "," + r.Payload + "," + target}, nil
+	case "IP-CIDR", "IP-CIDR6":
+		return []string{ruleType + "," + r.Payload + "," + target + noResolve}, nil
+	case "GEOIP":
+		if strings.EqualFold(r.Payload, "LAN") || strings.EqualFold(r.Payload, "private") {
+			lines := make([]string, 0, len(exportPrivateCIDRs)+len(exportPrivateCIDR6s))
+			for _, cidr := range exportPrivateCIDRs {
+				lines = append(lines, "IP-CIDR,"+cidr+","+target+",no-resolve")
+			}
+			for _, cidr := range exportPrivateCIDR6s {
+				lines = append(lines, "IP-CIDR6,"+cidr+","+target+",no-resolve")
+			}
+			return lines, nil
+		}
+		return []string{"GEOIP," + strings.ToUpper(r.Payload) + "," + target + noResolve}, nil
+	case "DST-PORT":
+		name := "DEST-PORT"
+		if shadowrocket {
+			name = "DST-PORT"
+		}
+		lines := make([]string, 0)
+		for _, port := range exportPorts(r.Payload) {
+			lines = append(lines, name+","+port+","+target)
+		}
+		return lines, nil
+	}
+
+	if !shadowrocket {
+		switch ruleType {
+		case "SRC-IP-CIDR":
+			return []string{"SRC-IP," + r.Payload + "," + target}, nil
+		case "SRC-PORT":
+			lines := make([]string, 0)
+			for _, port := range exportPorts(r.Payload) {
+				lines = append(lines, "SRC-PORT,"+port+","+target)
+			}
+			return lines, nil
+		case "NETWORK":
+			return []string{"PROTOCOL," + strings.ToUpper(r.Payload) + "," + target}, nil
+		case "PROCESS-NAME":
+			return []string{"PROCESS-NAME," + r.Payload + "," + target}, nil
+		}
+	}
+	return nil, fmt.Errorf("不支持 %s 规则", ruleType)
+}
@@ -0,0 +1,533 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// TPROXY / REDIRECT 透明代理的 nftables 规则
+// 面板在核心启动后安装 inet skynet 表，停止或崩溃时移除
+// ============================================================================
+
+const (
+	firewallTable       = "skynet"
+	defaultDNSPort      = 1053 // 生成器默认 DNS 监听端口
+	defaultRoutingMark  = 6666 // 核心出站标记
+	defaultTProxyMark   = 6667
+	defaultRouteTable   = 2023
+	defaultRulePriority = 8999
+)
+
+// firewallBypassCIDRs 直连的保留/局域网 IPv4 网段（不含 Fake-IP 使用的 198.18.0.0/15）
+var firewallBypassCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+}
+
+// firewallBypassCIDR6s 直连的保留/局域网 IPv6 网段（不含 Fake-IP 使用的 fc00::/8）
+var firewallBypassCIDR6s = []string{
+	"::/128",
+	"::1/128",
+	"::ffff:0:0/96",
+	"fd00::/8",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// FirewallRuleset 生成的 nftables 规则与策略路由命令
+type FirewallRuleset struct {
+	Mode    string   `json:"mode"`    // tproxy, redirect
+	Table   string   `json:"table"`   // inet 表名
+	Script  string   `json:"script"`  // nft -f 输入
+	Routes  []string `json:"routes"`  // 安装时执行的策略路由命令
+	Cleanup []string `json:"cleanup"` // 移除时执行的命令
+}
+
+// FirewallStatus 防火墙规则状态
+type FirewallStatus struct {
+	Managed bool             `json:"managed"`
+	Active  bool             `json:"active"`
+	Error   string           `json:"error,omitempty"`
+	Ruleset *FirewallRuleset `json:"ruleset,omitempty"`
+}
+
+// firewallParams 生成规则所需的参数
+type firewallParams struct {
+	Mode        string
+	TProxyPort  int
+	RedirPort   int
+	RoutingMark int
+	DNSPort     int   // 局域网 DNS 劫持目标端口（核心 DNS 监听端口）
+	LocalPorts  []int // 本机服务端口（面板、API、代理入站），本机回包不被拦截
+	Settings    FirewallSettings
+}
+
+// defaultFirewallSettings 默认防火墙设置
+func defaultFirewallSettings() FirewallSettings {
+	return FirewallSettings{
+		Managed:      true,
+		DNSHijack:    true,
+		TProxyMark:   defaultTProxyMark,
+		RouteTable:   defaultRouteTable,
+		RulePriority: defaultRulePriority,
+		BypassCIDRs:  []string{},
+		BypassPorts:  []int{},
+	}
+}
+
+// migrateFirewallSettings 旧配置文件缺少防火墙设置时填充默认值
+func migrateFirewallSettings(f *FirewallSettings) {
+	if f.TProxyMark == 0 && f.RouteTable == 0 && f.RulePriority == 0 {
+		*f = defaultFirewallSettings()
+	}
+}
+
+// firewallRoutingMark 核心出站标记（未设置路由标记时使用默认值）
+func firewallRoutingMark(settings *ProxySettings) int {
+	if settings != nil && settings.RoutingMark > 0 {
+		return settings.RoutingMark
+	}
+	return defaultRoutingMark
+}
+
+// Validate 校验防火墙设置
+func (f *FirewallSettings) Validate() error {
+	if f.TProxyMark <= 0 || f.TProxyMark > 0xffffffff {
+		return fmt.Errorf("TPROXY 标记无效: %d", f.TProxyMark)
+	}
+	if f.RouteTable <= 0 || f.RouteTable >= 0xffffffff || f.RouteTable == 253 || f.RouteTable == 254 || f.RouteTable == 255 {
+		return fmt.Errorf("策略路由表无效或为系统保留表: %d", f.RouteTable)
+	}
+	if f.RulePriority <= 0 || f.RulePriority >= 32766 {
+		return fmt.Errorf("策略路由优先级需在 1-32765 之间: %d", f.RulePriority)
+	}
+	for _, cidr := range f.BypassCIDRs {
+		if _, _, err := parseFirewallCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	for _, port := range f.BypassPorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("直连端口无效: %d", port)
+		}
+	}
+	return nil
+}
+
+// parseFirewallCIDR 解析网段或单个地址，返回规范写法与是否为 IPv6
+func parseFirewallCIDR(value string) (string, bool, error) {
+	value = strings.TrimSpace(value)
+	if ip := net.ParseIP(value); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", false, nil
+		}
+		return ip.String() + "/128", true, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false, fmt.Errorf("直连网段无效: %s", value)
+	}
+	return ipNet.String(), ipNet.IP.To4() == nil, nil
+}
+
+// buildFirewallRuleset 生成 nftables 规则集（不执行任何命令，可用于预览）
+func buildFirewallRuleset(p firewallParams) (*FirewallRuleset, error) {
+	f := p.Settings
+	if p.Mode != "tproxy" && p.Mode != "redirect" {
+		return nil, fmt.Errorf("仅 tproxy / redirect 模式需要 nftables 规则，当前模式: %s", p.Mode)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if p.RoutingMark == f.TProxyMark {
+		return nil, fmt.Errorf("核心出站标记与 TPROXY 标记不能相同: %d", p.RoutingMark)
+	}
+	if p.Mode == "tproxy" && p.TProxyPort <= 0 {
+		return nil, fmt.Errorf("TPROXY 端口未设置")
+	}
+	if p.Mode == "redirect" && p.RedirPort <= 0 {
+		return nil, fmt.Errorf("REDIRECT 端口未设置")
+	}
+
+	bypass4 := append([]string{}, firewallBypassCIDRs...)
+	bypass6 := append([]string{}, firewallBypassCIDR6s...)
+	for _, value := range f.BypassCIDRs {
+		cidr, v6, _ := parseFirewallCIDR(value)
+		if v6 {
+			bypass6 = append(bypass6, cidr)
+		} else {
+			bypass4 = append(bypass4, cidr)
+		}
+	}
+
+	var b strings.Builder
+	// 先声明再删除，保证整个表原子替换
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n", firewallTable, firewallTable)
+	fmt.Fprintf(&b, "table inet %s {\n", firewallTable)
+	writeNftSet(&b, "bypass_v4", "ipv4_addr", true, bypass4)
+	if f.IPv6 {
+		writeNftSet(&b, "bypass_v6", "ipv6_addr", true, bypass6)
+	}
+	writeNftSet(&b, "local_ports", "inet_service", false, firewallPorts(p.LocalPorts, f.BypassPorts))
+
+	// 目标地址直连判断（各链共用）
+	bypass := []string{"ip daddr @bypass_v4 return"}
+	if f.IPv6 {
+		bypass = append(bypass, "ip6 daddr @bypass_v6 return")
+	} else {
+		bypass = append(bypass, "meta nfproto ipv6 return")
+	}
+	dnsRedirect := fmt.Sprintf("meta nfproto ipv4 meta l4proto { tcp, udp } th dport 53 redirect to :%d", p.DNSPort)
+	mark := strconv.Itoa(f.TProxyMark)
+	coreMark := strconv.Itoa(p.RoutingMark)
+
+	if p.Mode == "tproxy" {
+		if f.DNSHijack {
+			writeNftChain(&b, "dns_hijack", "type nat hook prerouting priority dstnat; policy accept;", []string{dnsRedirect})
+		}
+
+		rules := []string{"fib daddr type local return"}
+		rules = append(rules, bypass...)
+		if f.DNSHijack {
+			rules = append(rules, "meta l4proto { tcp, udp } th dport 53 return")
+		}
+		rules = append(rules, fmt.Sprintf("meta nfproto ipv4 meta l4proto { tcp, udp } meta mark set %s tproxy ip to :%d accept", mark, p.TProxyPort))
+		if f.IPv6 {
+			rules = append(rules, fmt.Sprintf("meta nfproto ipv6 meta l4proto { tcp, udp } meta mark set %s tproxy ip6 to :%d accept", mark, p.TProxyPort))
+		}
+		writeNftChain(&b, "prerouting", "type filter hook prerouting priority mangle; policy accept;", rules)
+
+		if f.ProxyLocal {
+			// 本机流量打标后经策略路由回环到 prerouting，再由 TPROXY 接管
+			rules = []string{
+				"meta mark " + coreMark + " return",
+				"meta l4proto { tcp, udp } th sport @local_ports return",
+				"oifname \"lo\" return",
+			}
+			rules = append(rules, bypass...)
+			if f.DNSHijack {
+				rules = append(rules, "meta l4proto { tcp, udp } th dport 53 return")
+			}
+			rules = append(rules, "meta l4proto { tcp, udp } meta mark set "+mark)
+			writeNftChain(&b, "output", "type route hook output priority mangle; policy accept;", rules)
+		}
+	} else {
+		// REDIRECT 仅处理 TCP
+		rules := []string{}
+		if f.DNSHijack {
+			rules = append(rules, dnsRedirect)
+		}
+		rules = append(rules, "fib daddr type local return")
+		rules = append(rules, bypass...)
+		rules = append(rules, fmt.Sprintf("meta l4proto tcp redirect to :%d", p.RedirPort))
+		writeNftChain(&b, "prerouting", "type nat hook prerouting priority dstnat; policy accept;", rules)
+
+		if f.ProxyLocal {
+			rules = []string{
+				"meta mark " + coreMark + " return",
+				"tcp sport @local_ports return",
+				"oifname \"lo\" return",
+			}
+			rules = append(rules, bypass...)
+			rules = append(rules, fmt.Sprintf("meta l4proto tcp redirect to :%d", p.RedirPort))
+			writeNftChain(&b, "output", "type nat hook output priority -100; policy accept;", rules)
+		}
+	}
+	b.WriteString("}\n")
+
+	ruleset := &FirewallRuleset{
+		Mode:    p.Mode,
+		Table:   "inet " + firewallTable,
+		Script:  b.String(),
+		Routes:  []string{},
+		Cleanup: []string{"nft delete table inet " + firewallTable},
+	}
+	// TPROXY 需要策略路由将打标流量交给本机
+	if p.Mode == "tproxy" {
+		families := []string{"ip"}
+		if f.IPv6 {
+			families = append(families, "ip -6")
+		}
+		for _, ip := range families {
+			rule := fmt.Sprintf("rule %%s fwmark %d table %d priority %d", f.TProxyMark, f.RouteTable, f.RulePriority)
+			route := fmt.Sprintf("route %%s local default dev lo table %d", f.RouteTable)
+			ruleset.Routes = append(ruleset.Routes, ip+" "+fmt.Sprintf(rule, "add"), ip+" "+fmt.Sprintf(route, "add"))
+			ruleset.Cleanup = append(ruleset.Cleanup, ip+" "+fmt.Sprintf(rule, "del"), ip+" "+fmt.Sprintf(route, "del"))
+		}
+	}
+	return ruleset, nil
+}
+
+// firewallPorts 合并并排序端口列表
+func firewallPorts(lists ...[]int) []string {
+	seen := make(map[int]bool)
+	ports := make([]int, 0)
+	for _, list := range lists {
+		for _, port := range list {
+			if port > 0 && port <= 65535 && !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	values := make([]string, 0, len(ports))
+	for _, port := range ports {
+		values = append(values, strconv.Itoa(port))
+	}
+	return values
+}
+
+// writeNftSet 写入命名集合
+func writeNftSet(b *strings.Builder, name, setType string, interval bool, elements []string) {
+	fmt.Fprintf(b, "\tset %s {\n\t\ttype %s\n", name, setType)
+	if interval {
+		b.WriteString("\t\tflags interval\n\t\tauto-merge\n")
+	}
+	if len(elements) > 0 {
+		fmt.Fprintf(b, "\t\telements = { %s }\n", strings.Join(elements, ", "))
+	}
+	b.WriteString("\t}\n\n")
+}
+
+// writeNftChain 写入基础链
+func writeNftChain(b *strings.Builder, name, hook string, rules []string) {
+	fmt.Fprintf(b, "\tchain %s {\n\t\t%s\n", name, hook)
+	for _, rule := range rules {
+		fmt.Fprintf(b, "\t\t%s\n", rule)
+	}
+	b.WriteString("\t}\n\n")
+}
+
+// installFirewall 执行 nft 与策略路由命令，失败时回滚
+func installFirewall(ruleset *FirewallRuleset) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("nftables 规则仅支持 Linux")
+	}
+	if _, err := exec.LookPath("nft"); err != nil {
+		return fmt.Errorf("未找到 nft 命令，请安装 nftables")
+	}
+
+	// 清理上次残留的策略路由，避免重复添加
+	runFirewallCommands(ruleset.Cleanup[1:])
+
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset.Script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("加载 nftables 规则失败: %s", firewallOutput(output, err))
+	}
+	for _, line := range ruleset.Routes {
+		fields := strings.Fields(line)
+		if output, err := exec.Command(fields[0], fields[1:]...).CombinedOutput(); err != nil {
+			runFirewallCommands(ruleset.Cleanup)
+			return fmt.Errorf("%s 失败: %s", line, firewallOutput(output, err))
+		}
+	}
+	return nil
+}
+
+// uninstallFirewall 移除规则（忽略不存在的条目）
+func uninstallFirewall(ruleset *FirewallRuleset) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	runFirewallCommands(ruleset.Cleanup)
+}
+
+// runFirewallCommands 依次执行命令并忽略错误
+func runFirewallCommands(lines []string) {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		exec.Command(fields[0], fields[1:]...).Run()
+	}
+}
+
+// firewallOutput 命令输出（为空时返回错误本身）
+func firewallOutput(output []byte, err error) string {
+	if text := strings.TrimSpace(string(output)); text != "" {
+		return text
+	}
+	return err.Error()
+}
+
+// ============================================================================
+// Service 集成
+// ============================================================================
+
+// SetPanelPort 设置面板监听端口（本机回包不被透明代理拦截）
+func (s *Service) SetPanelPort(port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panelPort = port
+}
+
+// dnsListenAddress 核心 DNS 监听地址（未设置或无法解析时使用默认 0.0.0.0:1053）
+func dnsListenAddress(dns *DNSSettings) (string, int) {
+	if dns != nil && dns.Listen != "" {
+		host, port, err := net.SplitHostPort(dns.Listen)
+		if p, convErr := strconv.Atoi(port); err == nil && convErr == nil && p > 0 {
+			return host, p
+		}
+	}
+	return "0.0.0.0", defaultDNSPort
+}
+
+// firewallDNSPort 局域网 DNS 劫持目标端口，取自 DNS 监听设置
+// 监听在本机回环时重定向的局域网查询无法送达，返回错误
+func firewallDNSPort(settings *ProxySettings) (int, error) {
+	var dns *DNSSettings
+	if settings != nil {
+		dns = &settings.DNS
+	}
+	host, port := dnsListenAddress(dns)
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() && settings.Firewall.DNSHijack {
+		return 0, fmt.Errorf("DNS 监听地址 %s 仅限本机，无法劫持局域网 DNS，请改为 0.0.0.0 或关闭 DNS 劫持", settings.DNS.Listen)
+	}
+	return port, nil
+}
+
+// firewallSettings 当前防火墙设置与核心出站标记
+func (s *Service) firewallSettings() (FirewallSettings, int) {
+	if s.settingsProvider != nil {
+		if settings := s.settingsProvider(); settings != nil {
+			return settings.Firewall, firewallRoutingMark(settings)
+		}
+	}
+	return defaultFirewallSettings(), defaultRoutingMark
+}
+
+// buildFirewall 根据当前配置生成规则（调用者需持有锁）
+func (s *Service) buildFirewall(mode string) (*FirewallRuleset, error) {
+	settings, routingMark := s.firewallSettings()
+	var proxySettings *ProxySettings
+	if s.settingsProvider != nil {
+		proxySettings = s.settingsProvider()
+	}
+	dnsPort, err := firewallDNSPort(proxySettings)
+	if err != nil {
+		return nil, err
+	}
+	redirPort := s.config.RedirPort
+	if redirPort == 0 {
+		redirPort = 7892
+	}
+	localPorts := []int{s.panelPort, s.config.MixedPort, s.config.SocksPort, redirPort, s.config.TProxyPort, s.config.CheckPort, dnsPort}
+	if _, port, err := net.SplitHostPort(s.config.ExternalController); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			localPorts = append(localPorts, p)
+		}
+	}
+	return buildFirewallRuleset(firewallParams{
+		Mode:        mode,
+		TProxyPort:  s.config.TProxyPort,
+		RedirPort:   redirPort,
+		RoutingMark: routingMark,
+		DNSPort:     dnsPort,
+		LocalPorts:  localPorts,
+		Settings:    settings,
+	})
+}
+
+// applyFirewall 核心启动后安装透明代理规则（调用者需持有写锁）
+func (s *Service) applyFirewall() {
+	mode := s.config.TransparentMode
+	if mode != "tproxy" && mode != "redirect" {
+		return
+	}
+	settings, _ := s.firewallSettings()
+	if !settings.Managed {
+		s.firewallError = ""
+		return
+	}
+
+	ruleset, err := s.buildFirewall(mode)
+	if err == nil {
+		err = installFirewall(ruleset)
+	}
+	if err != nil {
+		s.firewallError = err.Error()
+		fmt.Printf("⚠️ 安装 nftables 透明代理规则失败: %v\n", err)
+		s.addLog("[WARN] 安装 nftables 透明代理规则失败: " + err.Error())
+		return
+	}
+	s.firewall = ruleset
+	s.firewallError = ""
+	s.recordChange(SystemChange{Kind: ChangeFirewall, Target: ruleset.Table, Action: "install", Undo: ruleset.Cleanup})
+	fmt.Printf("✓ 已安装 nftables 透明代理规则 (%s)\n", mode)
+	s.addLog("[INFO] 已安装 nftables 透明代理规则 (" + mode + ")")
+}
+
+// removeFirewall 核心停止或崩溃后移除已安装的规则
+func (s *Service) removeFirewall() {
+	s.mu.Lock()
+	ruleset := s.firewall
+	s.firewall = nil
+	s.mu.Unlock()
+	if ruleset == nil {
+		return
+	}
+
+	uninstallFirewall(ruleset)
+	s.forgetChange(ChangeFirewall, ruleset.Table)
+	fmt.Println("✓ 已移除 nftables 透明代理规则")
+	s.addLog("[INFO] 已移除 nftables 透明代理规则")
+}
+
+// CleanupStaleFirewall 清理面板异常退出后残留且未记入变更日志的规则（启动时调用）
+func (s *Service) CleanupStaleFirewall() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if err := exec.Command("nft", "list", "table", "inet", firewallTable).Run(); err != nil {
+		return
+	}
+
+	s.mu.RLock()
+	ruleset, err := s.buildFirewall("tproxy")
+	s.mu.RUnlock()
+	if err != nil {
+		ruleset = &FirewallRuleset{Cleanup: []string{"nft delete table inet " + firewallTable}}
+	}
+	uninstallFirewall(ruleset)
+	fmt.Println("🔄 已清理残留的 nftables 透明代理规则")
+}
+
+// GetFirewallStatus 获取防火墙规则状态
+func (s *Service) GetFirewallStatus() *FirewallStatus {
+	settings, _ := s.firewallSettings()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &FirewallStatus{
+		Managed: settings.Managed,
+		Active:  s.firewall != nil,
+		Error:   s.firewallError,
+		Ruleset: s.firewall,
+	}
+}
+
+// PreviewFirewall 预览规则（不执行），mode 为空时使用当前透明代理模式
+func (s *Service) PreviewFirewall(mode string) (*FirewallRuleset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if mode == "" {
+		mode = s.config.TransparentMode
+	}
+	return s.buildFirewall(mode)
+}
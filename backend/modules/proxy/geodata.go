@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ============================================================================
+// 本地 GeoSite / GeoIP 数据读取（v2ray geosite.dat / geoip.dat 格式）
+// 仅解析所需字段，避免引入 protobuf 依赖
+// ============================================================================
+
+var errBadProtobuf = errors.New("数据格式错误")
+
+// pbWalk 遍历 protobuf 消息字段，varint 字段通过 v 返回，length-delimited 字段通过 data 返回
+func pbWalk(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProtobuf
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errBadProtobuf
+			}
+			b = b[n:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case 1:
+			if len(b) < 8 {
+				return errBadProtobuf
+			}
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errBadProtobuf
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := fn(field, 0, data); err != nil {
+				return err
+			}
+		case 5:
+			if len(b) < 4 {
+				return errBadProtobuf
+			}
+			b = b[4:]
+		default:
+			return errBadProtobuf
+		}
+	}
+	return nil
+}
+
+// domainSet 域名匹配集合
+type domainSet struct {
+	full    map[string]bool
+	suffix  []string // 匹配自身及子域名
+	subOnly []string // 仅匹配子域名
+	keyword []string
+	regex   []*regexp.Regexp
+}
+
+func newDomainSet() *domainSet {
+	return &domainSet{full: make(map[string]bool)}
+}
+
+func (d *domainSet) addRegex(expr string) {
+	if re, err := regexp.Compile(expr); err == nil {
+		d.regex = append(d.regex, re)
+	}
+}
+
+// addMihomo 按 mihomo domain 规则集语法添加（+.a.com / .a.com / *.a.com / a.com）
+func (d *domainSet) addMihomo(entry string) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	switch {
+	case entry == "":
+	case strings.HasPrefix(entry, "+."):
+		d.suffix = append(d.suffix, entry[2:])
+	case strings.HasPrefix(entry, "."):
+		d.subOnly = append(d.subOnly, entry[1:])
+	case strings.Contains(entry, "*"):
+		d.addRegex("^" + strings.ReplaceAll(regexp.QuoteMeta(entry), `\*`, `[^.]+`) + "$")
+	default:
+		d.full[entry] = true
+	}
+}
+
+func (d *domainSet) empty() bool {
+	return len(d.full) == 0 && len(d.suffix) == 0 && len(d.subOnly) == 0 && len(d.keyword) == 0 && len(d.regex) == 0
+}
+
+// match 检查域名是否命中
+func (d *domainSet) match(domain string) bool {
+	if domain == "" {
+		return false
+	}
+	if d.full[domain] {
+		return true
+	}
+	for _, s := range d.suffix {
+		if domain == s || strings.HasSuffix(domain, "."+s) {
+			return true
+		}
+	}
+	for _, s := range d.subOnly {
+		if strings.HasSuffix(domain, "."+s) {
+			return true
+		}
+	}
+	for _, k := range d.keyword {
+		if strings.Contains(domain, k) {
+			return true
+		}
+	}
+	for _, re := range d.regex {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCIDRs 检查 IP 是否属于任一网段
+func matchCIDRs(ip net.IP, cidrs []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range cidrs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// geoDataReader 按需读取本地 geosite.dat / geoip.dat（单次请求内缓存）
+type geoDataReader struct {
+	dataDir string
+	files   map[string][]byte
+	sites   map[string]*domainSet
+	ips     map[string][]*net.IPNet
+}
+
+func newGeoDataReader(dataDir string) *geoDataReader {
+	return &geoDataReader{
+		dataDir: dataDir,
+		files:   make(map[string][]byte),
+		sites:   make(map[string]*domainSet),
+		ips:     make(map[string][]*net.IPNet),
+	}
+}
+
+func (g *geoDataReader) readFile(name string) ([]byte, error) {
+	if data, ok := g.files[name]; ok {
+		return data, nil
+	}
+	data, err := os.ReadFile(filepath.Join(g.dataDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("本地 %s 不存在", name)
+	}
+	g.files[name] = data
+	return data, nil
+}
+
+// findGeoEntry 在 GeoSiteList / GeoIPList 中查找指定代码的条目
+func findGeoEntry(data []byte, code string) ([]byte, error) {
+	var entry []byte
+	errFound := errors.New("found")
+	err := pbWalk(data, func(field int, _ uint64, msg []byte) error {
+		if field != 1 || msg == nil {
+			return nil
+		}
+		var cc string
+		pbWalk(msg, func(f int, _ uint64, d []byte) error {
+			if f == 1 {
+				cc = string(d)
+				return errFound // 国家代码为首个字段，读到即停止
+			}
+			return nil
+		})
+		if strings.EqualFold(cc, code) {
+			entry = msg
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Site 获取 GeoSite 域名集合（支持 code@attr 属性过滤）
+func (g *geoDataReader) Site(code string) (*domainSet, error) {
+	code = strings.ToLower(code)
+	if set, ok := g.sites[code]; ok {
+		return set, nil
+	}
+	data, err := g.readFile("geosite.dat")
+	if err != nil {
+		return nil, err
+	}
+	name, attr, _ := strings.Cut(code, "@")
+	entry, err := findGeoEntry(data, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("geosite.dat 中不存在 %s", name)
+	}
+
+	set := newDomainSet()
+	err = pbWalk(entry, func(field int, _ uint64, msg []byte) error {
+		if field != 2 {
+			return nil
+		}
+		var typ uint64
+		var value string
+		hasAttr := attr == ""
+		if err := pbWalk(msg, func(f int, v uint64, d []byte) error {
+			switch f {
+			case 1:
+				typ = v
+			case 2:
+				value = strings.ToLower(string(d))
+			case 3:
+				pbWalk(d, func(af int, _ uint64, ad []byte) error {
+					if af == 1 && string(ad) == attr {
+						hasAttr = true
+					}
+					return nil
+				})
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !hasAttr {
+			return nil
+		}
+		switch typ {
+		case 0: // Plain
+			set.keyword = append(set.keyword, value)
+		case 1: // Regex
+			set.addRegex(value)
+		case 2: // RootDomain
+			set.suffix = append(set.suffix, value)
+		case 3: // Full
+			set.full[value] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.sites[code] = set
+	return set, nil
+}
+
+// IP 获取 GeoIP 网段列表
+func (g *geoDataReader) IP(code string) ([]*net.IPNet, error) {
+	code = strings.ToLower(code)
+	if cidrs, ok := g.ips[code]; ok {
+		return cidrs, nil
+	}
+	data, err := g.readFile("geoip.dat")
+	if err != nil {
+		return nil, err
+	}
+	entry, err := findGeoEntry(data, code)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("geoip.dat 中不存在 %s", code)
+	}
+
+	cidrs := make([]*net.IPNet, 0)
+	err = pbWalk(entry, func(field int, _ uint64, msg []byte) error {
+		if field != 2 {
+			return nil
+		}
+		var ip []byte
+		var prefix uint64
+		if err := pbWalk(msg, func(f int, v uint64, d []byte) error {
+			switch f {
+			case 1:
+				ip = d
+			case 2:
+				prefix = v
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			return nil
+		}
+		cidrs = append(cidrs, &net.IPNet{
+			IP:   net.IP(ip),
+			Mask: net.CIDRMask(int(prefix), len(ip)*8),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.ips[code] = cidrs
+	return cidrs, nil
+}
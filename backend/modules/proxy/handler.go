@@ -1,22 +1,28 @@
 package proxy
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
+var logUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
 type Handler struct {
-	service *Service
+	service        *Service
+	trustedProxies []*net.IPNet // 可信反向代理（用于导出链接）
 }
 
 func NewHandler(dataDir string) *Handler {
@@ -25,6 +31,33 @@ func NewHandler(dataDir string) *Handler {
 	}
 }
 
+// SetTrustedProxies 设置可信反向代理地址或网段，无效项忽略
+func (h *Handler) SetTrustedProxies(addrs []string) {
+	h.trustedProxies = nil
+	for _, addr := range addrs {
+		n, err := parseCIDROrIP(addr)
+		if err != nil {
+			fmt.Printf("⚠️ 可信代理地址无效，已忽略: %s\n", addr)
+			continue
+		}
+		h.trustedProxies = append(h.trustedProxies, n)
+	}
+}
+
+// fromTrustedProxy 请求是否直接来自可信反向代理
+func (h *Handler) fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetService 获取服务实例
 func (h *Handler) GetService() *Service {
 	return h.service
@@ -35,13 +68,24 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 	r.POST("/start", h.Start)
 	r.POST("/stop", h.Stop)
 	r.POST("/restart", h.Restart)
+	r.POST("/reload", h.Reload)
+	r.POST("/providers/refresh", h.RefreshProviders) // 节点变化生效（提供者模式仅刷新提供者）
 	r.PUT("/mode", h.SetMode)
 	r.PUT("/tun", h.SetTunMode)
-	r.PUT("/transparent", h.SetTransparentMode) // 透明代理模式切换
+	r.PUT("/transparent", h.SetTransparentMode)   // 透明代理模式切换
+	r.GET("/firewall", h.GetFirewallStatus)       // TPROXY / REDIRECT nftables 规则状态
+	r.GET("/firewall/preview", h.PreviewFirewall) // 预览规则（不执行）
 	r.GET("/config", h.GetConfig)
 	r.PUT("/config", h.UpdateConfig)
 	r.POST("/generate", h.GenerateConfig)
 	r.GET("/config/preview", h.GetConfigPreview)
+	r.POST("/config/diff", h.DiffConfig)
+	r.POST("/route/test", h.TestRoute)
+
+	// 用户配置覆盖（生成后合并）
+	r.GET("/overrides", h.GetConfigOverrides)
+	r.PUT("/overrides", h.UpdateConfigOverrides)
+	r.POST("/overrides/preview", h.PreviewConfigOverride)
 	r.GET("/logs", h.GetLogs)
 
 	// 配置模板管理
@@ -50,6 +94,50 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 	r.PUT("/template/rules", h.UpdateRules)
 	r.PUT("/template/providers", h.UpdateRuleProviders)
 	r.POST("/template/reset", h.ResetTemplate)
+	r.POST("/template/import", h.ImportClashConfig) // 导入 Clash / Mihomo 配置
+
+	// 分流策略（两种核心共用）
+	r.GET("/routing/policy", h.GetRoutingPolicy)
+	r.PUT("/routing/policy", h.UpdateRoutingPolicy)
+	r.POST("/routing/policy/migrate", h.MigrateRoutingPolicy)
+
+	// 局域网设备策略（网关模式）
+	r.GET("/devices", h.GetLanDevices)
+	r.PUT("/devices", h.UpdateLanDevices)
+	r.GET("/devices/arp", h.GetARPTable)
+
+	// 定时策略
+	r.GET("/schedules", h.GetSchedules)
+	r.PUT("/schedules", h.UpdateSchedules)
+	r.POST("/schedules/override", h.SetScheduleOverride)
+	r.GET("/schedules/events", h.GetScheduleEvents)
+	r.POST("/schedules/:id/run", h.RunSchedule)
+
+	// 地区分类表
+	r.GET("/regions", h.GetRegions)
+	r.PUT("/regions", h.UpdateRegions)
+	r.POST("/regions", h.AddRegion)
+	r.PUT("/regions/:id", h.UpdateRegion)
+	r.DELETE("/regions/:id", h.DeleteRegion)
+	r.POST("/regions/reset", h.ResetRegions)
+	r.POST("/regions/preview", h.PreviewRegions)
+
+	// 配置方案
+	r.GET("/profiles", h.GetProfiles)
+	r.POST("/profiles", h.CreateProfile)
+	r.POST("/profiles/import", h.ImportProfile)
+	r.PUT("/profiles/:id", h.UpdateProfile)
+	r.DELETE("/profiles/:id", h.DeleteProfile)
+	r.POST("/profiles/:id/capture", h.CaptureProfile)
+	r.POST("/profiles/:id/clone", h.CloneProfile)
+	r.GET("/profiles/:id/export", h.ExportProfile)
+	r.POST("/profiles/:id/switch", h.SwitchProfile)
+
+	// 第三方客户端配置导出
+	r.GET("/exports", h.GetExportTokens)
+	r.POST("/exports", h.CreateExportToken)
+	r.GET("/exports/preview", h.PreviewExport)
+	r.DELETE("/exports/:token", h.DeleteExportToken)
 
 	// Sing-Box 配置生成
 	r.POST("/singbox/generate", h.GenerateSingBoxConfig)
@@ -66,6 +154,13 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("/mihomo/proxies/:name", h.ProxyMihomoGetProxy)
 	r.PUT("/mihomo/proxies/:name", h.ProxyMihomoSelectProxy)
 	r.GET("/mihomo/proxies/:name/delay", h.ProxyMihomoTestDelay)
+
+	// 节点出口 IP 与服务可达性检测
+	r.GET("/checks/definitions", h.GetCheckDefinitions)
+	r.PUT("/checks/definitions", h.UpdateCheckDefinitions)
+	r.POST("/checks/definitions/reset", h.ResetCheckDefinitions)
+	r.POST("/checks/run", h.RunNodeChecks)
+	r.GET("/checks/results", h.GetCheckResults)
 }
 
 func (h *Handler) GetStatus(c *gin.Context) {
@@ -79,12 +174,34 @@ func (h *Handler) GetStatus(c *gin.Context) {
 
 func (h *Handler) Start(c *gin.Context) {
 	if err := h.service.Start(); err != nil {
+		var validationErr *ConfigValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    2,
+				"message": err.Error(),
+				"data":    validationErr,
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    1,
 			"message": err.Error(),
 		})
 		return
 	}
+
+	// 新配置未通过校验时已回退到最近可用配置
+	if validationErr := h.service.LastValidationError(); validationErr != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    0,
+			"message": "新配置校验失败，已使用最近一次可用配置启动",
+			"data": gin.H{
+				"fallback":   true,
+				"validation": validationErr,
+			},
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "success",
@@ -116,6 +233,63 @@ func (h *Handler) Restart(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "success",
+		"data": &ReloadResult{
+			Method:     ApplyMethodRestart,
+			ConfigPath: h.service.GetStatus().ConfigPath,
+		},
+	})
+}
+
+// Reload 应用配置变更（优先热重载，端口或 TUN 变化时重启）
+func (h *Handler) Reload(c *gin.Context) {
+	result, err := h.service.Reload()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    result,
+	})
+}
+
+// RefreshProviders 使节点变化生效：提供者模式下只刷新代理提供者，否则重新加载配置
+func (h *Handler) RefreshProviders(c *gin.Context) {
+	result, err := h.service.ApplyNodeChange()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    result,
+	})
+}
+
+// applyIfRunning 核心运行中时使设置变更生效，未运行时返回 nil
+func (h *Handler) applyIfRunning() (*ReloadResult, error) {
+	if !h.service.GetStatus().Running {
+		return nil, nil
+	}
+	return h.service.Reload()
+}
+
+// respondApplyError 返回配置应用失败（校验错误单独返回结构化信息）
+func respondApplyError(c *gin.Context, err error) {
+	var validationErr *ConfigValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+			"data":    validationErr,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"code":    1,
+		"message": "设置已保存，但应用失败: " + err.Error(),
 	})
 }
 
@@ -138,9 +312,18 @@ func (h *Handler) SetMode(c *gin.Context) {
 		})
 		return
 	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "success",
+		"data": gin.H{
+			"reload": result,
+		},
 	})
 }
 
@@ -163,9 +346,97 @@ func (h *Handler) SetTunMode(c *gin.Context) {
 		})
 		return
 	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"reload": result,
+		},
+	})
+}
+
+// RegisterSystemRoutes 注册主机变更日志路由（/api/system）
+func (h *Handler) RegisterSystemRoutes(r *gin.RouterGroup) {
+	r.GET("/journal", h.GetSystemJournal)
+	r.POST("/restore", h.RestoreSystem)
+	r.GET("/prepare/preview", h.PreviewSystemPrepare) // TUN 准备步骤预览
+}
+
+// GetSystemJournal 获取尚未撤销的主机变更
+func (h *Handler) GetSystemJournal(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetSystemJournal(),
+	})
+}
+
+// RestoreSystem 按相反顺序撤销全部主机变更
+func (h *Handler) RestoreSystem(c *gin.Context) {
+	results, err := h.service.RestoreSystemIfStopped()
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	message := "success"
+	if failed > 0 {
+		message = fmt.Sprintf("%d 项变更撤销失败", failed)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": message,
+		"data":    results,
+	})
+}
+
+// PreviewSystemPrepare 预览启动 TUN 时将执行的主机修改
+func (h *Handler) PreviewSystemPrepare(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.PreviewSystemPrepare(),
+	})
+}
+
+// GetFirewallStatus 获取 nftables 透明代理规则状态
+func (h *Handler) GetFirewallStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetFirewallStatus(),
+	})
+}
+
+// PreviewFirewall 预览 nftables 规则与策略路由命令（dry-run，不修改系统）
+// mode: tproxy / redirect，为空时使用当前透明代理模式
+func (h *Handler) PreviewFirewall(c *gin.Context) {
+	ruleset, err := h.service.PreviewFirewall(c.Query("mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "success",
+		"data":    ruleset,
 	})
 }
 
@@ -195,15 +466,26 @@ func (h *Handler) SetTransparentMode(c *gin.Context) {
 	modeDesc := map[string]string{
 		"off":      "已关闭透明代理",
 		"tun":      "TUN 模式已开启，需要 root 权限",
-		"tproxy":   "TPROXY 模式已开启，需配置 iptables",
-		"redirect": "REDIRECT 模式已开启，需配置 iptables",
+		"tproxy":   "TPROXY 模式已开启，启动后自动安装 nftables 规则",
+		"redirect": "REDIRECT 模式已开启，启动后自动安装 nftables 规则",
+	}
+	if !h.service.GetFirewallStatus().Managed {
+		modeDesc["tproxy"] = "TPROXY 模式已开启，需手动配置 nftables 规则"
+		modeDesc["redirect"] = "REDIRECT 模式已开启，需手动配置 nftables 规则"
+	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": modeDesc[req.Mode],
 		"data": gin.H{
-			"mode": req.Mode,
+			"mode":   req.Mode,
+			"reload": result,
 		},
 	})
 }
@@ -228,6 +510,14 @@ func (h *Handler) UpdateConfig(c *gin.Context) {
 		return
 	}
 
+	if err := h.service.ValidateConfigPatch(updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	if err := h.service.PatchConfig(updates); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    1,
@@ -235,9 +525,18 @@ func (h *Handler) UpdateConfig(c *gin.Context) {
 		})
 		return
 	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "success",
+		"data": gin.H{
+			"reload": result,
+		},
 	})
 }
 
@@ -260,6 +559,15 @@ func (h *Handler) GenerateConfig(c *gin.Context) {
 	}
 
 	if err != nil {
+		var validationErr *ConfigValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    2,
+				"message": err.Error(),
+				"data":    validationErr,
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    1,
 			"message": err.Error(),
@@ -276,6 +584,36 @@ func (h *Handler) GenerateConfig(c *gin.Context) {
 	})
 }
 
+// DiffConfig 预览配置变更：按当前或拟修改的模板与设置生成配置，返回与运行中配置的语义差异
+func (h *Handler) DiffConfig(c *gin.Context) {
+	var req ConfigDiffRequest
+	// 允许空 body，此时比较当前模板与设置生成的配置
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    1,
+				"message": "参数错误: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	diff, err := h.service.DiffConfig(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    diff,
+	})
+}
+
 // GetConfigPreview 获取生成的 config.yaml 内容用于预览
 func (h *Handler) GetConfigPreview(c *gin.Context) {
 	content, err := h.service.GetConfigContent()
@@ -294,49 +632,216 @@ func (h *Handler) GetConfigPreview(c *gin.Context) {
 		"code":    0,
 		"message": "success",
 		"data": gin.H{
-			"content": content,
+			"content":   content,
+			"overrides": h.overridePreview("mihomo"),
+		},
+	})
+}
+
+// overridePreview 配置预览附带的覆盖差异（未启用覆盖或无法生成时为 nil）
+func (h *Handler) overridePreview(coreType string) *OverridePreview {
+	if !h.service.GetConfigOverrides().forCore(coreType).active() {
+		return nil
+	}
+	preview, err := h.service.PreviewConfigOverride(coreType, nil)
+	if err != nil {
+		return nil
+	}
+	return preview
+}
+
+// GetConfigOverrides 获取用户配置覆盖
+func (h *Handler) GetConfigOverrides(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetConfigOverrides(),
+	})
+}
+
+// UpdateConfigOverrides 更新用户配置覆盖（核心运行中时立即生效）
+func (h *Handler) UpdateConfigOverrides(c *gin.Context) {
+	var overrides ConfigOverrides
+	if err := c.ShouldBindJSON(&overrides); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.UpdateConfigOverrides(&overrides); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"reload": result,
 		},
 	})
 }
 
+// PreviewConfigOverride 预览覆盖文档引入的配置差异（不保存）
+func (h *Handler) PreviewConfigOverride(c *gin.Context) {
+	var req struct {
+		CoreType string        `json:"coreType"`
+		Override *CoreOverride `json:"override"` // 为空时使用已保存的覆盖
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Override != nil {
+		// 预览时忽略启用开关
+		req.Override.Enabled = true
+	}
+
+	preview, err := h.service.PreviewConfigOverride(req.CoreType, req.Override)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    preview,
+	})
+}
+
 func (h *Handler) GetLogs(c *gin.Context) {
-	// 获取参数
-	limitStr := c.DefaultQuery("limit", "200")
-	level := c.DefaultQuery("level", "all") // all, info, warn, error
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 2, "message": err.Error()})
+		return
+	}
+	filter.Limit = 200
+	if l, err := strconv.Atoi(c.DefaultQuery("limit", "200")); err == nil && l > 0 {
+		filter.Limit = l
+	}
+
+	entries := h.service.QueryLogs(filter)
+
+	// format=structured 返回结构化日志，默认返回原始行（兼容旧前端）
+	if c.Query("format") == "structured" {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    0,
+			"message": "success",
+			"data":    entries,
+		})
+		return
+	}
+
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.Raw
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    lines,
+	})
+}
+
+// StreamLogs 通过 WebSocket 推送实时核心日志（不依赖核心 API，两种核心通用，挂载于 /ws/core-logs）
+// 查询参数与 GetLogs 相同，连接建立后先推送 tail 条历史日志
+func (h *Handler) StreamLogs(c *gin.Context) {
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 2, "message": err.Error()})
+		return
+	}
 
-	limit := 200
-	if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-		limit = l
+	conn, err := logUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Printf("⚠️ 日志流升级连接失败: %v\n", err)
+		return
 	}
+	defer conn.Close()
+
+	ch, cancel := h.service.SubscribeLogs()
+	defer cancel()
 
-	logs := h.service.GetLogs(limit)
+	tail := 100
+	if t, err := strconv.Atoi(c.DefaultQuery("tail", "100")); err == nil && t >= 0 {
+		tail = t
+	}
+	if tail > 0 {
+		history := filter
+		history.Limit = tail
+		for _, entry := range h.service.QueryLogs(history) {
+			if err := conn.WriteJSON(entry); err != nil {
+				return
+			}
+		}
+	}
 
-	// 根据级别过滤
-	var filteredLogs []string
-	for _, log := range logs {
-		switch level {
-		case "error":
-			if strings.Contains(log, "ERR") || strings.Contains(log, "FATA") || strings.Contains(log, "error") {
-				filteredLogs = append(filteredLogs, log)
+	// 读取协程用于检测客户端断开
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
 			}
-		case "warn":
-			if strings.Contains(log, "WARN") || strings.Contains(log, "warning") {
-				filteredLogs = append(filteredLogs, log)
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case entry := <-ch:
+			if !filter.Match(entry) {
+				continue
 			}
-		case "info":
-			if strings.Contains(log, "INFO") || strings.Contains(log, "info") {
-				filteredLogs = append(filteredLogs, log)
+			if err := conn.WriteJSON(entry); err != nil {
+				return
 			}
-		default:
-			filteredLogs = append(filteredLogs, log)
 		}
 	}
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"code":    0,
-		"message": "success",
-		"data":    filteredLogs,
-	})
+// parseLogFilter 解析日志查询参数: level, q, since, until（RFC3339 或 Unix 秒）
+func parseLogFilter(c *gin.Context) (LogFilter, error) {
+	filter := LogFilter{
+		Level: c.DefaultQuery("level", "all"),
+		Query: c.Query("q"),
+	}
+	var err error
+	if filter.Since, err = parseLogTime(c.Query("since")); err != nil {
+		return filter, fmt.Errorf("since 参数无效: %v", err)
+	}
+	if filter.Until, err = parseLogTime(c.Query("until")); err != nil {
+		return filter, fmt.Errorf("until 参数无效: %v", err)
+	}
+	return filter, nil
+}
+
+func parseLogTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
 }
 
 // GetConfigTemplate 获取配置模板
@@ -433,128 +938,754 @@ func (h *Handler) ResetTemplate(c *gin.Context) {
 	})
 }
 
-// ========== Mihomo API 代理 (避免 CORS 问题) ==========
-
-// ProxyMihomoGetProxies 代理获取所有代理组
-func (h *Handler) ProxyMihomoGetProxies(c *gin.Context) {
-	apiAddr := h.service.GetConfig().ExternalController
-	if apiAddr == "" {
-		apiAddr = "127.0.0.1:9090"
+// ImportClashConfig 导入 Clash / Mihomo 配置为配置模板，返回无法映射的条目
+func (h *Handler) ImportClashConfig(c *gin.Context) {
+	var req struct {
+		Content string `json:"content" binding:"required"`
+		ClashImportOptions
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.service.ImportClashConfig(req.Content, req.ClashImportOptions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+	var reload *ReloadResult
+	if !req.DryRun {
+		if reload, err = h.applyIfRunning(); err != nil {
+			respondApplyError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"import": result,
+			"reload": reload,
+		},
+	})
+}
+
+// TestRoute 模拟请求的路由结果
+func (h *Handler) TestRoute(c *gin.Context) {
+	var req RouteTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.service.TestRoute(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    result,
+	})
+}
+
+// GetRoutingPolicy 获取分流策略
+func (h *Handler) GetRoutingPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetRoutingPolicy(),
+	})
+}
+
+// UpdateRoutingPolicy 更新分流策略（核心运行中时立即生效）
+func (h *Handler) UpdateRoutingPolicy(c *gin.Context) {
+	var policy RoutingPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.UpdateRoutingPolicy(&policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"reload": result,
+		},
+	})
+}
+
+// MigrateRoutingPolicy 从配置模板重新迁移分流策略，同时返回迁移报告
+func (h *Handler) MigrateRoutingPolicy(c *gin.Context) {
+	policy, report, err := h.service.MigrateRoutingPolicyFromTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    1,
+			"message": err.Error(),
+			"data":    gin.H{"report": report},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"policy": policy,
+			"report": report,
+		},
+	})
+}
+
+// GetLanDevices 获取局域网设备及流量统计
+func (h *Handler) GetLanDevices(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetLanDevices(),
+	})
+}
+
+// UpdateLanDevices 更新局域网设备策略（核心运行中时立即生效）
+func (h *Handler) UpdateLanDevices(c *gin.Context) {
+	var devices []LanDevice
+	if err := c.ShouldBindJSON(&devices); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.UpdateLanDevices(devices); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"reload": result,
+		},
+	})
+}
+
+// GetARPTable 获取 ARP 表（用于选择设备）
+func (h *Handler) GetARPTable(c *gin.Context) {
+	entries, err := ReadARPTable()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    entries,
+	})
+}
+
+// GetSchedules 获取定时策略（含下次执行时间与全局覆盖）
+func (h *Handler) GetSchedules(c *gin.Context) {
+	schedules, overrideUntil := h.service.scheduler.Get()
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"schedules":     schedules,
+			"overrideUntil": overrideUntil,
+		},
+	})
+}
+
+// UpdateSchedules 更新定时策略列表
+func (h *Handler) UpdateSchedules(c *gin.Context) {
+	var schedules []Schedule
+	if err := c.ShouldBindJSON(&schedules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.scheduler.Update(schedules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, _ := h.service.scheduler.Get()
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    result,
+	})
+}
+
+// RunSchedule 立即执行定时任务（忽略手动覆盖）
+func (h *Handler) RunSchedule(c *gin.Context) {
+	events, err := h.service.scheduler.RunNow(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    events,
+	})
+}
+
+// SetScheduleOverride 手动覆盖：在指定时间前暂停定时任务（不指定 id 时暂停全部）
+func (h *Handler) SetScheduleOverride(c *gin.Context) {
+	var req struct {
+		ID    string     `json:"id"`
+		Until *time.Time `json:"until"` // RFC3339，null 表示取消覆盖
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.scheduler.SetOverride(req.ID, req.Until); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+	})
+}
+
+// GetScheduleEvents 获取定时任务执行记录
+func (h *Handler) GetScheduleEvents(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.scheduler.Events(limit),
+	})
+}
+
+// GetRegions 获取地区分类表
+func (h *Handler) GetRegions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetRegions(),
+	})
+}
+
+// UpdateRegions 替换地区分类表
+func (h *Handler) UpdateRegions(c *gin.Context) {
+	var regions []RegionDefinition
+	if err := c.ShouldBindJSON(&regions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	h.respondRegionChange(c, h.service.UpdateRegions(regions))
+}
+
+// AddRegion 添加地区
+func (h *Handler) AddRegion(c *gin.Context) {
+	var region RegionDefinition
+	if err := c.ShouldBindJSON(&region); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	h.respondRegionChange(c, h.service.AddRegion(region))
+}
+
+// UpdateRegion 修改地区
+func (h *Handler) UpdateRegion(c *gin.Context) {
+	var region RegionDefinition
+	if err := c.ShouldBindJSON(&region); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	h.respondRegionChange(c, h.service.UpdateRegion(c.Param("id"), region))
+}
+
+// DeleteRegion 删除地区
+func (h *Handler) DeleteRegion(c *gin.Context) {
+	h.respondRegionChange(c, h.service.DeleteRegion(c.Param("id")))
+}
+
+// ResetRegions 恢复默认地区分类表
+func (h *Handler) ResetRegions(c *gin.Context) {
+	h.respondRegionChange(c, h.service.ResetRegions())
+}
+
+// respondRegionChange 返回地区表修改结果（核心运行中时立即生效）
+func (h *Handler) respondRegionChange(c *gin.Context, err error) {
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.applyIfRunning()
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"regions": h.service.GetRegions(),
+			"reload":  result,
+		},
+	})
+}
+
+// PreviewRegions 预览当前节点的地区分类（可传入拟修改的地区表，不保存）
+func (h *Handler) PreviewRegions(c *gin.Context) {
+	var req struct {
+		Regions []RegionDefinition `json:"regions"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    1,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	groups, err := h.service.PreviewRegions(req.Regions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    groups,
+	})
+}
 
-	resp, err := http.Get("http://" + apiAddr + "/proxies")
-	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
+// GetProfiles 获取配置方案列表
+func (h *Handler) GetProfiles(c *gin.Context) {
+	profiles, active := h.service.GetProfiles()
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"active":   active,
+			"profiles": profiles,
+		},
+	})
+}
+
+// CreateProfile 以当前配置创建方案
+func (h *Handler) CreateProfile(c *gin.Context) {
+	var req struct {
+		Name        string               `json:"name" binding:"required"`
+		Description string               `json:"description"`
+		Nodes       ProfileNodeSelection `json:"nodes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    1,
-			"message": "Mihomo API 不可用: " + err.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", body)
+	profile, err := h.service.CreateProfile(req.Name, req.Description, req.Nodes)
+	h.respondProfile(c, profile, err)
 }
 
-// ProxyMihomoGetProxy 代理获取单个代理组
-func (h *Handler) ProxyMihomoGetProxy(c *gin.Context) {
-	name := c.Param("name")
-	apiAddr := h.service.GetConfig().ExternalController
-	if apiAddr == "" {
-		apiAddr = "127.0.0.1:9090"
+// ImportProfile 导入方案 JSON
+func (h *Handler) ImportProfile(c *gin.Context) {
+	var profile Profile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
 	}
+	imported, err := h.service.ImportProfile(&profile)
+	h.respondProfile(c, imported, err)
+}
 
-	resp, err := http.Get("http://" + apiAddr + "/proxies/" + name)
-	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
+// UpdateProfile 修改方案内容
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	var profile Profile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    1,
-			"message": "Mihomo API 不可用: " + err.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", body)
+	updated, err := h.service.UpdateProfile(c.Param("id"), &profile)
+	h.respondProfile(c, updated, err)
 }
 
-// ProxyMihomoSelectProxy 代理切换节点
-func (h *Handler) ProxyMihomoSelectProxy(c *gin.Context) {
-	name := c.Param("name")
-	apiAddr := h.service.GetConfig().ExternalController
-	if apiAddr == "" {
-		apiAddr = "127.0.0.1:9090"
+// DeleteProfile 删除方案
+func (h *Handler) DeleteProfile(c *gin.Context) {
+	if err := h.service.DeleteProfile(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+	})
+}
+
+// CaptureProfile 用当前配置更新方案
+func (h *Handler) CaptureProfile(c *gin.Context) {
+	profile, err := h.service.CaptureProfile(c.Param("id"))
+	h.respondProfile(c, profile, err)
+}
 
-	body, _ := io.ReadAll(c.Request.Body)
-	req, _ := http.NewRequest("PUT", "http://"+apiAddr+"/proxies/"+name, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
+// CloneProfile 复制方案（可指定新名称）
+func (h *Handler) CloneProfile(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    1,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	profile, err := h.service.CloneProfile(c.Param("id"), req.Name)
+	h.respondProfile(c, profile, err)
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+// ExportProfile 下载方案 JSON
+func (h *Handler) ExportProfile(c *gin.Context) {
+	profile, err := h.service.GetProfile(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
+		c.JSON(http.StatusNotFound, gin.H{
 			"code":    1,
-			"message": "Mihomo API 不可用: " + err.Error(),
+			"message": err.Error(),
 		})
 		return
 	}
-	defer resp.Body.Close()
+	if profile.Config != nil {
+		config := *profile.Config
+		config.Secret = ""
+		profile.Config = &config
+	}
+	c.Header("Content-Disposition", "attachment; filename=profile-"+profile.ID+".json")
+	c.IndentedJSON(http.StatusOK, profile)
+}
+
+// SwitchProfile 切换到指定方案并使其生效
+func (h *Handler) SwitchProfile(c *gin.Context) {
+	result, err := h.service.SwitchProfile(c.Param("id"))
+	if err != nil {
+		respondApplyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"status": h.service.GetStatus(),
+			"reload": result,
+		},
+	})
+}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", respBody)
+// RegisterExportRoutes 注册导出下载路由（令牌即凭据，不经过登录认证，供客户端自动更新）
+func (h *Handler) RegisterExportRoutes(r *gin.RouterGroup) {
+	r.GET("/:token", h.DownloadExport)
 }
 
-// ProxyMihomoTestDelay 代理测试节点延迟
-func (h *Handler) ProxyMihomoTestDelay(c *gin.Context) {
-	name := c.Param("name")
-	url := c.Query("url")
-	timeout := c.Query("timeout")
+// exportTokenView 导出令牌及其下载地址
+type exportTokenView struct {
+	ExportToken
+	URL string `json:"url"`
+}
+
+// exportURL 令牌的下载地址（按本次请求的地址拼接，仅可信反向代理的 X-Forwarded-* 头生效）
+func (h *Handler) exportURL(c *gin.Context, token string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if h.fromTrustedProxy(c) {
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host + "/api/export/" + token
+}
+
+// GetExportTokens 获取导出令牌列表
+func (h *Handler) GetExportTokens(c *gin.Context) {
+	tokens := h.service.GetExportTokens()
+	views := make([]exportTokenView, 0, len(tokens))
+	for _, t := range tokens {
+		views = append(views, exportTokenView{ExportToken: t, URL: h.exportURL(c, t.Token)})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    views,
+	})
+}
 
-	if url == "" {
-		url = "http://www.gstatic.com/generate_204"
+// CreateExportToken 创建导出令牌
+func (h *Handler) CreateExportToken(c *gin.Context) {
+	var req struct {
+		Name   string `json:"name"`
+		Target string `json:"target" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+	token, err := h.service.CreateExportToken(req.Name, req.Target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
 	}
-	if timeout == "" {
-		timeout = "5000"
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    exportTokenView{ExportToken: *token, URL: h.exportURL(c, token.Token)},
+	})
+}
+
+// DeleteExportToken 删除导出令牌
+func (h *Handler) DeleteExportToken(c *gin.Context) {
+	if err := h.service.DeleteExportToken(c.Param("token")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+	})
+}
 
-	apiAddr := h.service.GetConfig().ExternalController
-	if apiAddr == "" {
-		apiAddr = "127.0.0.1:9090"
+// PreviewExport 预览导出内容与跳过项
+func (h *Handler) PreviewExport(c *gin.Context) {
+	result, err := h.service.RenderExport(c.Query("target"), "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    result,
+	})
+}
 
-	targetURL := fmt.Sprintf("http://%s/proxies/%s/delay?url=%s&timeout=%s", apiAddr, name, url, timeout)
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(targetURL)
+// DownloadExport 按令牌下载客户端配置
+func (h *Handler) DownloadExport(c *gin.Context) {
+	token := c.Param("token")
+	result, err := h.service.ExportByToken(token, h.exportURL(c, token))
 	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, errExportTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
 			"code":    1,
-			"message": "Mihomo API 不可用: " + err.Error(),
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(result.Warnings) > 0 {
+		fmt.Printf("⚠️ 导出 %s 配置时跳过 %d 项不支持的节点或规则\n", result.Target, len(result.Warnings))
+	}
+	c.Header("Content-Disposition", "attachment; filename="+result.Filename)
+	c.Data(http.StatusOK, result.ContentType, []byte(result.Content))
+}
+
+// respondProfile 返回方案变更结果（校验失败为 code 2）
+func (h *Handler) respondProfile(c *gin.Context, profile *Profile, err error) {
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
 		})
 		return
 	}
-	defer resp.Body.Close()
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    profile,
+	})
+}
+
+// ========== Mihomo API 代理 (避免 CORS 问题) ==========
+
+// ProxyMihomoGetProxies 代理获取所有代理组
+func (h *Handler) ProxyMihomoGetProxies(c *gin.Context) {
+	body, err := h.service.Controller().Proxies()
+	writeCoreAPIResponse(c, body, err)
+}
+
+// ProxyMihomoGetProxy 代理获取单个代理组
+func (h *Handler) ProxyMihomoGetProxy(c *gin.Context) {
+	body, err := h.service.Controller().Proxy(c.Param("name"))
+	writeCoreAPIResponse(c, body, err)
+}
+
+// ProxyMihomoSelectProxy 代理切换节点
+func (h *Handler) ProxyMihomoSelectProxy(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 1, "message": err.Error()})
+		return
+	}
+	err := h.service.Controller().SelectProxy(c.Param("name"), req.Name)
+	if err == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	writeCoreAPIResponse(c, nil, err)
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, "application/json", body)
+// ProxyMihomoTestDelay 代理测试节点延迟
+func (h *Handler) ProxyMihomoTestDelay(c *gin.Context) {
+	timeout, _ := strconv.Atoi(c.Query("timeout"))
+	body, err := h.service.Controller().ProxyDelay(c.Param("name"), c.Query("url"), timeout)
+	writeCoreAPIResponse(c, body, err)
+}
+
+// writeCoreAPIResponse 原样返回核心 API 响应，核心不可用时返回 503
+func writeCoreAPIResponse(c *gin.Context, body []byte, err error) {
+	if err == nil {
+		c.Data(http.StatusOK, "application/json", body)
+		return
+	}
+	var apiErr *CoreAPIError
+	if errors.As(err, &apiErr) {
+		c.Data(apiErr.Status, "application/json", apiErr.Body)
+		return
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"code":    1,
+		"message": "核心 API 不可用: " + err.Error(),
+	})
 }
 
 // ========== Sing-Box 1.12+ 配置生成 ==========
 
 // GenerateSingBoxConfig 生成 Sing-Box 1.12+ 配置
+// 分流策略、模板与核心 API 密钥取自当前代理配置，请求只调整入站与性能选项
 func (h *Handler) GenerateSingBoxConfig(c *gin.Context) {
 	var req struct {
-		Mode           string `json:"mode"`           // tun, system
-		FakeIP         bool   `json:"fakeip"`         // 启用 FakeIP
-		MixedPort      int    `json:"mixedPort"`      // 混合代理端口
-		HTTPPort       int    `json:"httpPort"`       // HTTP 代理端口
-		SocksPort      int    `json:"socksPort"`      // SOCKS5 代理端口
-		ClashAPIAddr   string `json:"clashApiAddr"`   // Clash API 地址
-		ClashAPISecret string `json:"clashApiSecret"` // Clash API 密钥
-		TUNStack       string `json:"tunStack"`       // TUN 栈类型
-		TUNMTU         int    `json:"tunMtu"`         // TUN MTU
-		DNSStrategy    string `json:"dnsStrategy"`    // DNS 策略
-		LogLevel       string `json:"logLevel"`       // 日志级别
+		Mode         string `json:"mode"`         // tun, system
+		FakeIP       bool   `json:"fakeip"`       // 启用 FakeIP
+		MixedPort    int    `json:"mixedPort"`    // 混合代理端口
+		HTTPPort     int    `json:"httpPort"`     // HTTP 代理端口
+		SocksPort    int    `json:"socksPort"`    // SOCKS5 代理端口
+		ClashAPIAddr string `json:"clashApiAddr"` // Clash API 地址
+		TUNStack     string `json:"tunStack"`     // TUN 栈类型
+		TUNMTU       int    `json:"tunMtu"`       // TUN MTU
+		DNSStrategy  string `json:"dnsStrategy"`  // DNS 策略
+		LogLevel     string `json:"logLevel"`     // 日志级别
 		// 性能优化
 		AutoRedirect             bool `json:"autoRedirect"`             // Linux nftables
 		StrictRoute              bool `json:"strictRoute"`              // 严格路由
@@ -571,27 +1702,33 @@ func (h *Handler) GenerateSingBoxConfig(c *gin.Context) {
 		req.MixedPort = 7890
 	}
 
-	// 构建选项
-	opts := SingBoxGeneratorOptions{
-		Mode:                     req.Mode,
-		FakeIP:                   req.FakeIP,
-		MixedPort:                req.MixedPort,
-		HTTPPort:                 req.HTTPPort,
-		SocksPort:                req.SocksPort,
-		ClashAPIAddr:             req.ClashAPIAddr,
-		ClashAPISecret:           req.ClashAPISecret,
-		TUNStack:                 req.TUNStack,
-		TUNMTU:                   req.TUNMTU,
-		DNSStrategy:              req.DNSStrategy,
-		LogLevel:                 req.LogLevel,
-		AutoRedirect:             req.AutoRedirect,
-		StrictRoute:              req.StrictRoute,
-		TCPFastOpen:              req.TCPFastOpen,
-		TCPMultiPath:             req.TCPMultiPath,
-		UDPFragment:              req.UDPFragment,
-		Sniff:                    req.Sniff,
-		SniffOverrideDestination: req.SniffOverrideDestination,
+	// 构建选项（核心 API 密钥始终使用本地配置，不接受客户端传入）
+	opts := h.service.SingBoxGeneratorOptions()
+	if req.Mode != "" {
+		opts.Mode = req.Mode
+	}
+	opts.FakeIP = req.FakeIP
+	if req.MixedPort > 0 {
+		opts.MixedPort = req.MixedPort
+	}
+	opts.HTTPPort = req.HTTPPort
+	opts.SocksPort = req.SocksPort
+	if req.ClashAPIAddr != "" {
+		opts.ClashAPIAddr = req.ClashAPIAddr
+	}
+	opts.TUNStack = req.TUNStack
+	opts.TUNMTU = req.TUNMTU
+	opts.DNSStrategy = req.DNSStrategy
+	if req.LogLevel != "" {
+		opts.LogLevel = req.LogLevel
 	}
+	opts.AutoRedirect = req.AutoRedirect
+	opts.StrictRoute = req.StrictRoute
+	opts.TCPFastOpen = req.TCPFastOpen
+	opts.TCPMultiPath = req.TCPMultiPath
+	opts.UDPFragment = req.UDPFragment
+	opts.Sniff = req.Sniff
+	opts.SniffOverrideDestination = req.SniffOverrideDestination
 
 	// 获取所有节点
 	nodes, err := h.service.GetAllNodes()
@@ -614,9 +1751,14 @@ func (h *Handler) GenerateSingBoxConfig(c *gin.Context) {
 		return
 	}
 
-	// 保存配置
-	filePath, err := generator.SaveConfigV112(config, "singbox-config")
+	// 使用 sing-box 校验后替换正在使用的配置，校验失败返回结构化错误
+	filePath, err := h.service.CommitSingBoxConfig(config)
 	if err != nil {
+		var validationErr *ConfigValidationError
+		if errors.As(err, &validationErr) {
+			respondApplyError(c, err)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    1,
 			"message": "保存配置失败: " + err.Error(),
@@ -624,32 +1766,6 @@ func (h *Handler) GenerateSingBoxConfig(c *gin.Context) {
 		return
 	}
 
-	// 使用 sing-box check 验证配置
-	singboxPath := filepath.Join(h.service.dataDir, "cores", "sing-box")
-	if _, err := os.Stat(singboxPath); err == nil {
-		// sing-box 存在，进行配置验证
-		checkCmd := exec.Command(singboxPath, "check", "-c", filePath)
-		output, checkErr := checkCmd.CombinedOutput()
-		if checkErr != nil {
-			// 验证失败，返回错误信息
-			errorMsg := string(output)
-			if errorMsg == "" {
-				errorMsg = checkErr.Error()
-			}
-			c.JSON(http.StatusOK, gin.H{
-				"code":    2, // 使用 code 2 表示配置验证失败
-				"message": "配置验证失败",
-				"data": gin.H{
-					"configPath":      filePath,
-					"nodeCount":       len(nodes),
-					"mode":            opts.Mode,
-					"validationError": errorMsg,
-				},
-			})
-			return
-		}
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "success",
@@ -679,7 +1795,8 @@ func (h *Handler) GetSingBoxConfigPreview(c *gin.Context) {
 		"code":    0,
 		"message": "success",
 		"data": gin.H{
-			"content": content,
+			"content":   content,
+			"overrides": h.overridePreview("singbox"),
 		},
 	})
 }
@@ -721,6 +1838,14 @@ func (h *Handler) UpdateSingBoxTemplate(c *gin.Context) {
 		return
 	}
 
+	if err := template.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	if err := h.service.UpdateSingBoxTemplate(&template); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    1,
@@ -744,3 +1869,96 @@ func (h *Handler) ResetSingBoxTemplate(c *gin.Context) {
 		"data":    h.service.GetSingBoxTemplate(),
 	})
 }
+
+// ========== 节点检测 ==========
+
+// GetCheckDefinitions 获取检测项定义
+func (h *Handler) GetCheckDefinitions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetNodeChecker().GetDefinitions(),
+	})
+}
+
+// UpdateCheckDefinitions 更新检测项定义
+func (h *Handler) UpdateCheckDefinitions(c *gin.Context) {
+	var defs []CheckDefinition
+	if err := c.ShouldBindJSON(&defs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.GetNodeChecker().UpdateDefinitions(defs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetNodeChecker().GetDefinitions(),
+	})
+}
+
+// ResetCheckDefinitions 重置检测项为默认
+func (h *Handler) ResetCheckDefinitions(c *gin.Context) {
+	if err := h.service.GetNodeChecker().ResetDefinitions(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetNodeChecker().GetDefinitions(),
+	})
+}
+
+// RunNodeChecks 通过指定节点执行检测
+func (h *Handler) RunNodeChecks(c *gin.Context) {
+	var req struct {
+		Node   string   `json:"node" binding:"required"`
+		Checks []string `json:"checks"` // 为空时执行所有启用的检测项
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	report, err := h.service.RunNodeChecks(req.Node, req.Checks)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    1,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    report,
+	})
+}
+
+// GetCheckResults 获取检测结果缓存（可按 node 查询参数过滤）
+func (h *Handler) GetCheckResults(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    h.service.GetNodeChecker().GetReports(c.Query("node")),
+	})
+}
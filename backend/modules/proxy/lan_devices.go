@@ -0,0 +1,477 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// 局域网设备策略（网关模式）
+// 设备按 IP / 网段或 MAC（经 ARP 表解析）识别，策略编译为来源地址规则并置于全局规则之前
+// ============================================================================
+
+// 设备策略
+const (
+	DevicePolicyGlobal   = "global"    // 遵循全局规则
+	DevicePolicyDirect   = "direct"    // 始终直连
+	DevicePolicyGroup    = "group"     // 全部流量使用指定策略组
+	DevicePolicyBlockAds = "block-ads" // 拦截广告，其余遵循全局规则
+)
+
+// lanDeviceWatchInterval ARP 表检查间隔（MAC 设备地址变化时重新生成配置）
+const lanDeviceWatchInterval = 30 * time.Second
+
+// 广告拦截使用的规则集（存在时优先）与 GeoSite 分类
+const (
+	deviceAdsRuleSet = "ads-domain"
+	deviceAdsGeoSite = "category-ads-all"
+)
+
+// LanDevice 局域网设备
+type LanDevice struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	IP          string `json:"ip,omitempty"`  // IP 或网段
+	MAC         string `json:"mac,omitempty"` // 通过 ARP 表解析为 IP，地址变化时自动重新生成配置
+	Policy      string `json:"policy"`
+	Group       string `json:"group,omitempty"` // Policy 为 group 时使用
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description,omitempty"`
+}
+
+// LanDeviceStatus 设备状态（含解析地址与流量统计）
+type LanDeviceStatus struct {
+	LanDevice
+	Addresses   []string `json:"addresses"` // 生效的来源网段
+	Online      bool     `json:"online"`    // ARP 表中存在
+	Connections int      `json:"connections"`
+	Upload      int64    `json:"upload"`
+	Download    int64    `json:"download"`
+	HasTraffic  bool     `json:"hasTraffic"` // 是否获取到连接数据
+}
+
+// ARPEntry ARP 表项
+type ARPEntry struct {
+	IP     string `json:"ip"`
+	MAC    string `json:"mac"`
+	Device string `json:"device"`
+}
+
+func lanDevicesPath(dataDir string) string {
+	return filepath.Join(dataDir, "lan_devices.json")
+}
+
+// LoadLanDevices 加载设备列表
+func LoadLanDevices(dataDir string) ([]LanDevice, error) {
+	data, err := os.ReadFile(lanDevicesPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var devices []LanDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// SaveLanDevices 保存设备列表
+func SaveLanDevices(dataDir string, devices []LanDevice) error {
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(lanDevicesPath(dataDir), data, 0644)
+}
+
+// normalizeMAC 统一 MAC 地址格式
+func normalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil {
+		return "", fmt.Errorf("MAC 地址无效: %s", mac)
+	}
+	return hw.String(), nil
+}
+
+// normalizeDeviceCIDR IP 转为主机网段，网段保持不变
+func normalizeDeviceCIDR(addr string) (string, error) {
+	n, err := parseCIDROrIP(addr)
+	if err != nil {
+		return "", fmt.Errorf("IP 或网段无效: %s", addr)
+	}
+	return n.String(), nil
+}
+
+// ValidateLanDevices 校验设备列表，groups 为可用策略组名称
+func ValidateLanDevices(devices []LanDevice, groups map[string]bool) error {
+	ids := make(map[string]bool)
+	for i, d := range devices {
+		label := d.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		}
+		if ids[d.ID] {
+			return fmt.Errorf("设备 ID 重复: %s", d.ID)
+		}
+		ids[d.ID] = true
+
+		if d.IP == "" && d.MAC == "" {
+			return fmt.Errorf("设备 %s 需要 IP/网段或 MAC", label)
+		}
+		if d.IP != "" {
+			if _, err := normalizeDeviceCIDR(d.IP); err != nil {
+				return fmt.Errorf("设备 %s: %w", label, err)
+			}
+		}
+		if d.MAC != "" {
+			if _, err := normalizeMAC(d.MAC); err != nil {
+				return fmt.Errorf("设备 %s: %w", label, err)
+			}
+		}
+
+		switch d.Policy {
+		case DevicePolicyGlobal, DevicePolicyDirect, DevicePolicyBlockAds:
+		case DevicePolicyGroup:
+			if d.Group == "" {
+				return fmt.Errorf("设备 %s 未指定策略组", label)
+			}
+			if groups != nil && !groups[d.Group] {
+				return fmt.Errorf("设备 %s 引用的策略组不存在或未启用: %s", label, d.Group)
+			}
+		default:
+			return fmt.Errorf("设备 %s 策略无效: %s", label, d.Policy)
+		}
+	}
+	return nil
+}
+
+// ReadARPTable 读取系统 ARP 表（Linux /proc/net/arp）
+func ReadARPTable() ([]ARPEntry, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 ARP 表: %v", err)
+	}
+	defer f.Close()
+
+	entries := make([]ARPEntry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		// IP address  HW type  Flags  HW address  Mask  Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[2] == "0x0" {
+			continue // 未完成解析的表项
+		}
+		mac, err := normalizeMAC(fields[3])
+		if err != nil || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		entries = append(entries, ARPEntry{IP: fields[0], MAC: mac, Device: fields[5]})
+	}
+	return entries, nil
+}
+
+// deviceAddresses 解析设备生效的来源网段
+func deviceAddresses(d LanDevice, arp []ARPEntry) ([]string, bool) {
+	var addrs []string
+	online := false
+	if d.IP != "" {
+		if cidr, err := normalizeDeviceCIDR(d.IP); err == nil {
+			addrs = append(addrs, cidr)
+		}
+	}
+	if d.MAC != "" {
+		mac, _ := normalizeMAC(d.MAC)
+		for _, e := range arp {
+			if e.MAC != mac {
+				continue
+			}
+			online = true
+			if cidr, err := normalizeDeviceCIDR(e.IP); err == nil && !containsString(addrs, cidr) {
+				addrs = append(addrs, cidr)
+			}
+		}
+	} else if d.IP != "" {
+		for _, e := range arp {
+			if matchCIDRStrings(net.ParseIP(e.IP), addrs) {
+				online = true
+				break
+			}
+		}
+	}
+	return addrs, online
+}
+
+// ============================================================================
+// 规则编译
+// ============================================================================
+
+// resolvedDevice 已解析地址的设备（用于配置生成）
+type resolvedDevice struct {
+	LanDevice
+	Addresses []string
+}
+
+// mihomoDeviceRules 编译 mihomo 设备规则（SRC-IP-CIDR）
+func mihomoDeviceRules(devices []resolvedDevice, config *MihomoConfig) []string {
+	groups := make(map[string]bool, len(config.ProxyGroups))
+	for _, g := range config.ProxyGroups {
+		groups[g.Name] = true
+	}
+	ads := "GEOSITE," + deviceAdsGeoSite
+	if _, ok := config.RuleProviders[deviceAdsRuleSet]; ok {
+		ads = "RULE-SET," + deviceAdsRuleSet
+	}
+
+	rules := make([]string, 0)
+	for _, d := range devices {
+		if d.Policy == DevicePolicyGroup && !groups[d.Group] {
+			fmt.Printf("⚠️ 设备 %s 引用的策略组 %s 不存在，已忽略\n", d.Name, d.Group)
+			continue
+		}
+		for _, cidr := range d.Addresses {
+			switch d.Policy {
+			case DevicePolicyDirect:
+				rules = append(rules, fmt.Sprintf("SRC-IP-CIDR,%s,%s", cidr, PolicyDirect))
+			case DevicePolicyGroup:
+				rules = append(rules, fmt.Sprintf("SRC-IP-CIDR,%s,%s", cidr, d.Group))
+			case DevicePolicyBlockAds:
+				rules = append(rules, fmt.Sprintf("AND,((SRC-IP-CIDR,%s),(%s)),%s", cidr, ads, PolicyReject))
+			}
+		}
+	}
+	return rules
+}
+
+// singBoxDeviceRules 编译 sing-box 设备规则（source_ip_cidr）
+func singBoxDeviceRules(devices []resolvedDevice, config *SingBoxConfig) []SBRouteRule {
+	outbounds := make(map[string]bool, len(config.Outbounds))
+	for _, o := range config.Outbounds {
+		outbounds[o.Tag] = true
+	}
+	ads := "geosite-" + deviceAdsGeoSite
+	for _, rs := range config.Route.RuleSet {
+		if rs.Tag == deviceAdsRuleSet {
+			ads = deviceAdsRuleSet
+		}
+	}
+
+	rules := make([]SBRouteRule, 0)
+	for _, d := range devices {
+		if len(d.Addresses) == 0 {
+			continue
+		}
+		switch d.Policy {
+		case DevicePolicyDirect:
+			rules = append(rules, SBRouteRule{SourceIPCIDR: d.Addresses, Outbound: "direct"})
+		case DevicePolicyGroup:
+			if !outbounds[d.Group] {
+				fmt.Printf("⚠️ 设备 %s 引用的策略组 %s 不存在，已忽略\n", d.Name, d.Group)
+				continue
+			}
+			rules = append(rules, SBRouteRule{SourceIPCIDR: d.Addresses, Outbound: d.Group})
+		case DevicePolicyBlockAds:
+			rules = append(rules, SBRouteRule{SourceIPCIDR: d.Addresses, RuleSet: ads, Action: "reject"})
+		}
+	}
+	return rules
+}
+
+// ============================================================================
+// Service 方法
+// ============================================================================
+
+// loadLanDevices 加载设备列表
+func (s *Service) loadLanDevices() {
+	devices, err := LoadLanDevices(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("⚠️ 加载局域网设备失败: %v\n", err)
+		}
+		s.lanDevices = []LanDevice{}
+		return
+	}
+	s.lanDevices = devices
+}
+
+// resolveLanDevices 解析启用设备的来源地址（MAC 通过 ARP 表解析，离线设备跳过）
+func (s *Service) resolveLanDevices() []resolvedDevice {
+	s.mu.RLock()
+	devices := s.lanDevices
+	s.mu.RUnlock()
+	if len(devices) == 0 {
+		return nil
+	}
+
+	arp, _ := ReadARPTable()
+	result := make([]resolvedDevice, 0, len(devices))
+	for _, d := range devices {
+		if !d.Enabled || d.Policy == DevicePolicyGlobal {
+			continue
+		}
+		addrs, _ := deviceAddresses(d, arp)
+		if len(addrs) == 0 {
+			fmt.Printf("⚠️ 设备 %s 的 MAC %s 未在 ARP 表中找到，暂不生成规则\n", d.Name, d.MAC)
+			continue
+		}
+		result = append(result, resolvedDevice{LanDevice: d, Addresses: addrs})
+	}
+	return result
+}
+
+// macDeviceAddresses 生成 MAC 设备当前解析地址的摘要（用于检测 DHCP 地址变化）
+func macDeviceAddresses(devices []LanDevice, arp []ARPEntry) string {
+	var b strings.Builder
+	for _, d := range devices {
+		if !d.Enabled || d.Policy == DevicePolicyGlobal || d.MAC == "" {
+			continue
+		}
+		addrs, _ := deviceAddresses(d, arp)
+		sort.Strings(addrs)
+		fmt.Fprintf(&b, "%s=%s;", d.ID, strings.Join(addrs, ","))
+	}
+	return b.String()
+}
+
+// watchLanDevices 定期检查 ARP 表，MAC 设备地址变化且核心运行中时重新生成配置（阻塞，需在 goroutine 中调用）
+func (s *Service) watchLanDevices() {
+	ticker := time.NewTicker(lanDeviceWatchInterval)
+	defer ticker.Stop()
+
+	last, initialized := "", false
+	for range ticker.C {
+		s.mu.RLock()
+		devices := s.lanDevices
+		s.mu.RUnlock()
+
+		arp, err := ReadARPTable()
+		if err != nil {
+			continue
+		}
+		current := macDeviceAddresses(devices, arp)
+		changed := initialized && current != last
+		last, initialized = current, true
+		if !changed || !s.GetStatus().Running {
+			continue
+		}
+
+		fmt.Println("🔄 局域网设备地址已变化，重新生成配置")
+		if _, err := s.Reload(); err != nil {
+			fmt.Printf("⚠️ 局域网设备地址变化后重载失败: %v\n", err)
+		}
+	}
+}
+
+// GetLanDevices 获取设备列表及状态（核心运行时统计各设备连接流量）
+func (s *Service) GetLanDevices() []LanDeviceStatus {
+	s.mu.RLock()
+	devices := s.lanDevices
+	s.mu.RUnlock()
+
+	arp, _ := ReadARPTable()
+	var conns []coreConnection
+	hasTraffic := false
+	if s.GetStatus().Running {
+		if c, err := s.coreConnections(); err == nil {
+			conns, hasTraffic = c, true
+		}
+	}
+
+	result := make([]LanDeviceStatus, 0, len(devices))
+	for _, d := range devices {
+		status := LanDeviceStatus{LanDevice: d, HasTraffic: hasTraffic}
+		status.Addresses, status.Online = deviceAddresses(d, arp)
+		if status.Addresses == nil {
+			status.Addresses = []string{}
+		}
+		for _, c := range conns {
+			if matchCIDRStrings(net.ParseIP(c.Metadata.SourceIP), status.Addresses) {
+				status.Connections++
+				status.Upload += c.Upload
+				status.Download += c.Download
+			}
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// UpdateLanDevices 校验并保存设备列表
+func (s *Service) UpdateLanDevices(devices []LanDevice) error {
+	for i := range devices {
+		if devices[i].ID == "" {
+			devices[i].ID = uuid.New().String()
+		}
+		if devices[i].MAC != "" {
+			mac, err := normalizeMAC(devices[i].MAC)
+			if err != nil {
+				label := devices[i].Name
+				if label == "" {
+					label = fmt.Sprintf("#%d", i+1)
+				}
+				return fmt.Errorf("设备 %s: %w", label, err)
+			}
+			devices[i].MAC = mac
+		}
+	}
+	if err := ValidateLanDevices(devices, s.policyGroupNames()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := SaveLanDevices(s.dataDir, devices); err != nil {
+		return err
+	}
+	s.lanDevices = devices
+	return nil
+}
+
+// policyGroupNames 分流策略中启用的策略组名称
+func (s *Service) policyGroupNames() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.routingPolicy == nil {
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, g := range s.routingPolicy.Groups {
+		if g.Enabled {
+			names[g.Name] = true
+		}
+	}
+	return names
+}
+
+// coreConnection 核心 API 连接信息（mihomo 与 sing-box Clash API 一致）
+type coreConnection struct {
+	Metadata struct {
+		SourceIP string `json:"sourceIP"`
+	} `json:"metadata"`
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// coreConnections 通过核心 API 获取当前连接
+func (s *Service) coreConnections() ([]coreConnection, error) {
+	body, err := s.Controller().Connections()
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Connections []coreConnection `json:"connections"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return data.Connections, nil
+}
@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeCheckGroup 节点检测专用选择组（由检测入站固定使用）
+const NodeCheckGroup = "🩺 节点检测"
+
+// 检测类型
+const (
+	CheckTypeExitIP = "exit_ip" // 出口 IP 与国家
+	CheckTypeHTTP   = "http"    // 服务可达性
+)
+
+// CheckDefinition 检测项定义
+type CheckDefinition struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Type           string            `json:"type"` // exit_ip, http
+	Enabled        bool              `json:"enabled"`
+	URL            string            `json:"url"`
+	Method         string            `json:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Timeout        int               `json:"timeout,omitempty"`        // 毫秒
+	ExpectedStatus []int             `json:"expectedStatus,omitempty"` // 期望状态码，为空不检查
+	BodyPattern    string            `json:"bodyPattern,omitempty"`    // 响应体必须匹配的正则
+	FailPattern    string            `json:"failPattern,omitempty"`    // 响应体匹配则判定失败
+	RegionPattern  string            `json:"regionPattern,omitempty"`  // 从响应体提取地区（第一个分组）
+	IPField        string            `json:"ipField,omitempty"`        // exit_ip: JSON 中 IP 字段
+	CountryField   string            `json:"countryField,omitempty"`   // exit_ip: JSON 中国家字段
+}
+
+// CheckResult 单项检测结果
+type CheckResult struct {
+	CheckID    string    `json:"checkId"`
+	Name       string    `json:"name"`
+	Success    bool      `json:"success"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Country    string    `json:"country,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Latency    int64     `json:"latency"` // 毫秒
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// NodeCheckReport 节点检测报告
+type NodeCheckReport struct {
+	Node      string                  `json:"node"`
+	ExitIP    string                  `json:"exitIp,omitempty"`
+	Country   string                  `json:"country,omitempty"`
+	Results   map[string]*CheckResult `json:"results"`
+	CheckedAt time.Time               `json:"checkedAt"`
+}
+
+// NodeChecker 节点检测器（检测定义与结果缓存）
+type NodeChecker struct {
+	dataDir     string
+	definitions []CheckDefinition
+	reports     map[string]*NodeCheckReport
+	mu          sync.RWMutex
+	runMu       sync.Mutex // 检测组全局唯一，同一时间只能检测一个节点
+}
+
+// GetDefaultCheckDefinitions 默认检测项
+func GetDefaultCheckDefinitions() []CheckDefinition {
+	return []CheckDefinition{
+		{
+			ID:           "exit-ip",
+			Name:         "出口 IP",
+			Type:         CheckTypeExitIP,
+			Enabled:      true,
+			URL:          "https://ipwho.is/",
+			IPField:      "ip",
+			CountryField: "country_code",
+		},
+		{
+			ID:             "netflix",
+			Name:           "Netflix",
+			Type:           CheckTypeHTTP,
+			Enabled:        true,
+			URL:            "https://www.netflix.com/title/81280792",
+			ExpectedStatus: []int{200},
+		},
+		{
+			ID:            "openai",
+			Name:          "OpenAI",
+			Type:          CheckTypeHTTP,
+			Enabled:       true,
+			URL:           "https://api.openai.com/compliance/cookie_requirements",
+			FailPattern:   "unsupported_country",
+			RegionPattern: `"country(?:_code)?"\s*:\s*"([A-Za-z]{2})"`,
+		},
+		{
+			ID:            "youtube-premium",
+			Name:          "YouTube Premium",
+			Type:          CheckTypeHTTP,
+			Enabled:       true,
+			URL:           "https://www.youtube.com/premium",
+			Headers:       map[string]string{"Accept-Language": "en"},
+			FailPattern:   "Premium is not available in your country",
+			RegionPattern: `"countryCode":"([A-Z]{2})"`,
+		},
+	}
+}
+
+// NewNodeChecker 创建节点检测器
+func NewNodeChecker(dataDir string) *NodeChecker {
+	c := &NodeChecker{
+		dataDir:     dataDir,
+		definitions: GetDefaultCheckDefinitions(),
+		reports:     make(map[string]*NodeCheckReport),
+	}
+	c.load()
+	return c
+}
+
+func (c *NodeChecker) load() {
+	if data, err := os.ReadFile(filepath.Join(c.dataDir, "node_check_definitions.json")); err == nil {
+		var defs []CheckDefinition
+		if json.Unmarshal(data, &defs) == nil && len(defs) > 0 {
+			c.definitions = defs
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(c.dataDir, "node_check_results.json")); err == nil {
+		json.Unmarshal(data, &c.reports)
+	}
+}
+
+func (c *NodeChecker) saveDefinitions() error {
+	c.mu.RLock()
+	data, err := json.MarshalIndent(c.definitions, "", "  ")
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.dataDir, "node_check_definitions.json"), data, 0644)
+}
+
+func (c *NodeChecker) saveReports() error {
+	c.mu.RLock()
+	data, err := json.MarshalIndent(c.reports, "", "  ")
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.dataDir, "node_check_results.json"), data, 0644)
+}
+
+// GetDefinitions 获取检测项定义
+func (c *NodeChecker) GetDefinitions() []CheckDefinition {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]CheckDefinition, len(c.definitions))
+	copy(result, c.definitions)
+	return result
+}
+
+// UpdateDefinitions 更新检测项定义
+func (c *NodeChecker) UpdateDefinitions(defs []CheckDefinition) error {
+	seen := make(map[string]bool)
+	for i := range defs {
+		d := &defs[i]
+		if d.ID == "" || d.URL == "" {
+			return fmt.Errorf("检测项 ID 和 URL 不能为空")
+		}
+		if seen[d.ID] {
+			return fmt.Errorf("检测项 ID 重复: %s", d.ID)
+		}
+		seen[d.ID] = true
+		if d.Type == "" {
+			d.Type = CheckTypeHTTP
+		}
+		if d.Type != CheckTypeHTTP && d.Type != CheckTypeExitIP {
+			return fmt.Errorf("不支持的检测类型: %s", d.Type)
+		}
+		if _, err := url.ParseRequestURI(d.URL); err != nil {
+			return fmt.Errorf("检测项 %s URL 无效: %v", d.ID, err)
+		}
+		for _, pattern := range []string{d.BodyPattern, d.FailPattern, d.RegionPattern} {
+			if pattern == "" {
+				continue
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("检测项 %s 正则无效: %v", d.ID, err)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.definitions = defs
+	c.mu.Unlock()
+	return c.saveDefinitions()
+}
+
+// ResetDefinitions 重置为默认检测项
+func (c *NodeChecker) ResetDefinitions() error {
+	c.mu.Lock()
+	c.definitions = GetDefaultCheckDefinitions()
+	c.mu.Unlock()
+	return c.saveDefinitions()
+}
+
+// GetReports 获取检测结果缓存，node 为空时返回全部
+func (c *NodeChecker) GetReports(node string) map[string]*NodeCheckReport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[string]*NodeCheckReport)
+	for name, report := range c.reports {
+		if node == "" || name == node {
+			result[name] = report
+		}
+	}
+	return result
+}
+
+// RunNodeChecks 通过核心对指定节点执行检测，checkIDs 为空时执行所有启用的检测项
+func (s *Service) RunNodeChecks(node string, checkIDs []string) (*NodeCheckReport, error) {
+	s.mu.RLock()
+	running := s.running
+	checkPort := s.config.CheckPort
+	s.mu.RUnlock()
+
+	if !running {
+		return nil, fmt.Errorf("代理未运行，无法通过节点检测")
+	}
+	if checkPort <= 0 {
+		return nil, fmt.Errorf("节点检测端口未配置")
+	}
+
+	checker := s.nodeChecker
+	checker.runMu.Lock()
+	defer checker.runMu.Unlock()
+
+	// 将检测组切换到目标节点
+	if err := s.selectProxy(NodeCheckGroup, node); err != nil {
+		return nil, fmt.Errorf("切换检测节点失败: %w", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range checkIDs {
+		wanted[id] = true
+	}
+	defs := make([]CheckDefinition, 0)
+	for _, d := range checker.GetDefinitions() {
+		if len(wanted) > 0 {
+			if wanted[d.ID] {
+				defs = append(defs, d)
+			}
+		} else if d.Enabled {
+			defs = append(defs, d)
+		}
+	}
+
+	proxyURL, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", checkPort))
+	transport := &http.Transport{
+		Proxy:             http.ProxyURL(proxyURL),
+		DisableKeepAlives: true, // 每项检测独立连接，避免复用上一节点的连接
+	}
+	defer transport.CloseIdleConnections()
+
+	report := &NodeCheckReport{
+		Node:      node,
+		Results:   make(map[string]*CheckResult),
+		CheckedAt: time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, d := range defs {
+		wg.Add(1)
+		go func(def CheckDefinition) {
+			defer wg.Done()
+			result := runCheck(transport, def)
+			mu.Lock()
+			report.Results[def.ID] = result
+			if def.Type == CheckTypeExitIP && result.Success && report.ExitIP == "" {
+				report.ExitIP = result.IP
+				report.Country = result.Country
+			}
+			mu.Unlock()
+		}(d)
+	}
+	wg.Wait()
+
+	// 合并缓存（保留本次未执行检测项的旧结果）
+	checker.mu.Lock()
+	if old, ok := checker.reports[node]; ok {
+		for id, r := range old.Results {
+			if _, exists := report.Results[id]; !exists {
+				report.Results[id] = r
+			}
+		}
+		if report.ExitIP == "" {
+			report.ExitIP = old.ExitIP
+			report.Country = old.Country
+		}
+	}
+	checker.reports[node] = report
+	checker.mu.Unlock()
+	checker.saveReports()
+
+	return report, nil
+}
+
+// runCheck 执行单项检测
+func runCheck(transport http.RoundTripper, def CheckDefinition) *CheckResult {
+	result := &CheckResult{
+		CheckID:   def.ID,
+		Name:      def.Name,
+		CheckedAt: time.Now(),
+	}
+
+	timeout := time.Duration(def.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+
+	method := def.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, def.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+	for k, v := range def.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+	result.Latency = time.Since(start).Milliseconds()
+	result.StatusCode = resp.StatusCode
+
+	if def.RegionPattern != "" {
+		if re, err := regexp.Compile(def.RegionPattern); err == nil {
+			if m := re.FindSubmatch(body); len(m) > 1 {
+				result.Region = strings.ToUpper(string(m[1]))
+			}
+		}
+	}
+
+	if len(def.ExpectedStatus) > 0 {
+		matched := false
+		for _, code := range def.ExpectedStatus {
+			if code == resp.StatusCode {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			result.Error = fmt.Sprintf("状态码 %d 不符合预期", resp.StatusCode)
+			return result
+		}
+	}
+	if def.FailPattern != "" {
+		if re, err := regexp.Compile(def.FailPattern); err == nil && re.Match(body) {
+			result.Error = "响应内容表明服务不可用"
+			return result
+		}
+	}
+	if def.BodyPattern != "" {
+		if re, err := regexp.Compile(def.BodyPattern); err != nil || !re.Match(body) {
+			result.Error = "响应内容不匹配"
+			return result
+		}
+	}
+
+	if def.Type == CheckTypeExitIP {
+		var data map[string]interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			result.Error = "解析出口 IP 响应失败: " + err.Error()
+			return result
+		}
+		ipField := def.IPField
+		if ipField == "" {
+			ipField = "ip"
+		}
+		result.IP, _ = data[ipField].(string)
+		if def.CountryField != "" {
+			result.Country, _ = data[def.CountryField].(string)
+		}
+		if result.IP == "" {
+			result.Error = "响应中未找到 IP"
+			return result
+		}
+		if result.Region == "" {
+			result.Region = result.Country
+		}
+	}
+
+	result.Success = true
+	return result
+}
+
+// selectProxy 通过核心 API 切换选择组的节点
+func (s *Service) selectProxy(group, node string) error {
+	return s.Controller().SelectProxy(group, node)
+}
+
+// GetNodeChecker 获取节点检测器
+func (s *Service) GetNodeChecker() *NodeChecker {
+	return s.nodeChecker
+}
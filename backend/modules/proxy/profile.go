@@ -0,0 +1,562 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// 配置方案（Profile）
+// 一个方案打包代理设置、运行配置、分流策略与模板、核心类型、节点选择和配置覆盖，
+// 切换方案时整体替换并重新生成配置（运行中按需热重载或重启）
+// ============================================================================
+
+// ProfileNodeSelection 方案的节点选择
+type ProfileNodeSelection struct {
+	SubscriptionIDs []string `json:"subscriptionIds,omitempty"` // 使用的订阅，为空表示全部
+	ExcludeManual   bool     `json:"excludeManual,omitempty"`   // 不使用手动节点
+	Filter          string   `json:"filter,omitempty"`          // 保留名称匹配的节点（正则）
+	Exclude         string   `json:"exclude,omitempty"`         // 排除名称匹配的节点（正则）
+}
+
+// Profile 配置方案
+type Profile struct {
+	ID              string               `json:"id"`
+	Name            string               `json:"name"`
+	Description     string               `json:"description,omitempty"`
+	CoreType        string               `json:"coreType,omitempty"`
+	Config          *ProxyConfig         `json:"config,omitempty"`
+	Settings        *ProxySettings       `json:"settings,omitempty"`
+	RoutingPolicy   *RoutingPolicy       `json:"routingPolicy,omitempty"`
+	ConfigTemplate  *ConfigTemplate      `json:"configTemplate,omitempty"` // 无分流策略时使用
+	SingBoxTemplate *SingBoxTemplate     `json:"singboxTemplate,omitempty"`
+	Nodes           ProfileNodeSelection `json:"nodes"`
+	Overrides       *ConfigOverrides     `json:"overrides,omitempty"`
+	CreatedAt       time.Time            `json:"createdAt"`
+	UpdatedAt       time.Time            `json:"updatedAt"`
+}
+
+// ProfileState 方案列表与当前生效的方案
+type ProfileState struct {
+	Active   string    `json:"active,omitempty"`
+	Profiles []Profile `json:"profiles"`
+}
+
+// profilesPath 方案文件路径
+func profilesPath(dataDir string) string {
+	return filepath.Join(dataDir, "profiles.json")
+}
+
+// LoadProfiles 加载配置方案
+func LoadProfiles(dataDir string) (*ProfileState, error) {
+	data, err := os.ReadFile(profilesPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var state ProfileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	for i := range state.Profiles {
+		migrateProfileSettings(&state.Profiles[i])
+	}
+	return &state, nil
+}
+
+// SaveProfiles 保存配置方案
+func SaveProfiles(dataDir string, state *ProfileState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(profilesPath(dataDir), data, 0644)
+}
+
+// migrateProfileSettings 旧方案的设置缺少新增字段时填充默认值，并移除本机核心 API 密钥
+func migrateProfileSettings(p *Profile) {
+	if p.Settings != nil {
+		migrateFirewallSettings(&p.Settings.Firewall)
+	}
+	if p.Config != nil {
+		p.Config.Secret = ""
+	}
+}
+
+// Validate 校验方案内容（切换前整体校验，避免只应用一半）
+func (p *Profile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("方案名称不能为空")
+	}
+	if p.CoreType != "" && p.CoreType != "mihomo" && p.CoreType != "singbox" {
+		return fmt.Errorf("不支持的核心类型: %s", p.CoreType)
+	}
+	for _, pattern := range []string{p.Nodes.Filter, p.Nodes.Exclude} {
+		if pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("节点过滤正则无效 %q: %v", pattern, err)
+		}
+	}
+	if p.Settings != nil {
+		if err := p.Settings.DNS.Validate(); err != nil {
+			return err
+		}
+		if err := p.Settings.Firewall.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.RoutingPolicy != nil {
+		if err := p.RoutingPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.ConfigTemplate != nil {
+		if err := ValidateProxyGroups(p.ConfigTemplate.ProxyGroups); err != nil {
+			return err
+		}
+	}
+	if p.SingBoxTemplate != nil {
+		if err := p.SingBoxTemplate.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Overrides != nil {
+		for _, coreType := range []string{"mihomo", "singbox"} {
+			override := p.Overrides.forCore(coreType)
+			if strings.TrimSpace(override.Content) == "" {
+				continue
+			}
+			if _, err := parseOverride(coreType, override.Content); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filter 按方案的节点选择过滤节点
+func (sel ProfileNodeSelection) filter(nodes []ProxyNode) []ProxyNode {
+	subs := make(map[string]bool, len(sel.SubscriptionIDs))
+	for _, id := range sel.SubscriptionIDs {
+		subs[id] = true
+	}
+	var include, exclude *regexp.Regexp
+	if sel.Filter != "" {
+		include, _ = regexp.Compile(sel.Filter)
+	}
+	if sel.Exclude != "" {
+		exclude, _ = regexp.Compile(sel.Exclude)
+	}
+
+	result := make([]ProxyNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.IsManual {
+			if sel.ExcludeManual {
+				continue
+			}
+		} else if len(subs) > 0 && !subs[node.SubscriptionID] {
+			continue
+		}
+		if include != nil && !include.MatchString(node.Name) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(node.Name) {
+			continue
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// cloneProfile 深拷贝方案（方案内的策略与模板可能被后续修改）
+func cloneProfile(p *Profile) (*Profile, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var clone Profile
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+// ============================================================================
+// Service 方法
+// ============================================================================
+
+// loadProfiles 加载配置方案
+func (s *Service) loadProfiles() {
+	state, err := LoadProfiles(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("⚠️ 加载配置方案失败: %v\n", err)
+		}
+		state = &ProfileState{Profiles: []Profile{}}
+	}
+	s.profiles = state
+}
+
+// SetSettingsSaver 设置代理设置保存函数（切换方案时写入代理设置）
+func (s *Service) SetSettingsSaver(saver func(*ProxySettings) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settingsSaver = saver
+}
+
+// SetCoreSwitcher 设置核心切换函数（切换方案时同步核心模块）
+func (s *Service) SetCoreSwitcher(switcher func(coreType string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coreSwitcher = switcher
+}
+
+// activeNodeSelection 当前方案的节点选择（无生效方案时为 nil）
+func (s *Service) activeNodeSelection() *ProfileNodeSelection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.profiles == nil || s.profiles.Active == "" {
+		return nil
+	}
+	for i := range s.profiles.Profiles {
+		if s.profiles.Profiles[i].ID == s.profiles.Active {
+			sel := s.profiles.Profiles[i].Nodes
+			return &sel
+		}
+	}
+	return nil
+}
+
+// activeProfileName 当前方案名称，调用者需持有锁
+func (s *Service) activeProfileName() string {
+	if s.profiles == nil {
+		return ""
+	}
+	for _, p := range s.profiles.Profiles {
+		if p.ID == s.profiles.Active {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// GetProfiles 获取方案列表与当前方案 ID
+func (s *Service) GetProfiles() ([]Profile, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Profile{}, s.profiles.Profiles...), s.profiles.Active
+}
+
+// GetProfile 获取指定方案
+func (s *Service) GetProfile(id string) (*Profile, error) {
+	profiles, _ := s.GetProfiles()
+	for i := range profiles {
+		if profiles[i].ID == id {
+			return &profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("方案不存在: %s", id)
+}
+
+// captureProfile 以当前设置、策略、模板与覆盖构建方案内容
+func (s *Service) captureProfile(name, description string, nodes ProfileNodeSelection) (*Profile, error) {
+	var settings *ProxySettings
+	if s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+
+	s.mu.RLock()
+	config := *s.config
+	// 核心 API 密钥属于本机，不随方案保存、导出或切换
+	config.Secret = ""
+	profile := &Profile{
+		Name:            name,
+		Description:     description,
+		CoreType:        s.coreType,
+		Config:          &config,
+		Settings:        settings,
+		RoutingPolicy:   s.routingPolicy,
+		ConfigTemplate:  s.configTemplate,
+		SingBoxTemplate: LoadSingBoxTemplate(s.dataDir),
+		Nodes:           nodes,
+		Overrides:       s.overrides,
+	}
+	s.mu.RUnlock()
+
+	// 深拷贝，避免与运行中的对象共享
+	return cloneProfile(profile)
+}
+
+// saveProfileState 替换并保存方案列表
+func (s *Service) saveProfileState(update func(state *ProfileState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := &ProfileState{
+		Active:   s.profiles.Active,
+		Profiles: append([]Profile{}, s.profiles.Profiles...),
+	}
+	if err := update(state); err != nil {
+		return err
+	}
+	if err := SaveProfiles(s.dataDir, state); err != nil {
+		return err
+	}
+	s.profiles = state
+	return nil
+}
+
+// addProfile 分配 ID 并追加方案
+func (s *Service) addProfile(profile *Profile) (*Profile, error) {
+	profile.Name = strings.TrimSpace(profile.Name)
+	migrateProfileSettings(profile)
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	profile.ID = uuid.New().String()
+	profile.CreatedAt = now
+	profile.UpdatedAt = now
+
+	err := s.saveProfileState(func(state *ProfileState) error {
+		for _, p := range state.Profiles {
+			if p.Name == profile.Name {
+				return fmt.Errorf("方案名称重复: %s", profile.Name)
+			}
+		}
+		state.Profiles = append(state.Profiles, *profile)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// CreateProfile 以当前配置创建方案
+func (s *Service) CreateProfile(name, description string, nodes ProfileNodeSelection) (*Profile, error) {
+	profile, err := s.captureProfile(name, description, nodes)
+	if err != nil {
+		return nil, err
+	}
+	return s.addProfile(profile)
+}
+
+// CloneProfile 复制方案
+func (s *Service) CloneProfile(id, name string) (*Profile, error) {
+	source, err := s.GetProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := cloneProfile(source)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(name) == "" {
+		name = source.Name + " 副本"
+	}
+	profile.Name = name
+	return s.addProfile(profile)
+}
+
+// ImportProfile 导入方案（重新分配 ID）
+func (s *Service) ImportProfile(profile *Profile) (*Profile, error) {
+	return s.addProfile(profile)
+}
+
+// UpdateProfile 修改方案内容（保留 ID 与创建时间）
+func (s *Service) UpdateProfile(id string, profile *Profile) (*Profile, error) {
+	profile.Name = strings.TrimSpace(profile.Name)
+	migrateProfileSettings(profile)
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	err := s.saveProfileState(func(state *ProfileState) error {
+		for i := range state.Profiles {
+			if state.Profiles[i].ID != id && state.Profiles[i].Name == profile.Name {
+				return fmt.Errorf("方案名称重复: %s", profile.Name)
+			}
+		}
+		for i := range state.Profiles {
+			if state.Profiles[i].ID == id {
+				profile.ID = id
+				profile.CreatedAt = state.Profiles[i].CreatedAt
+				profile.UpdatedAt = time.Now()
+				state.Profiles[i] = *profile
+				return nil
+			}
+		}
+		return fmt.Errorf("方案不存在: %s", id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// CaptureProfile 用当前配置覆盖方案内容（保留名称、描述与节点选择）
+func (s *Service) CaptureProfile(id string) (*Profile, error) {
+	existing, err := s.GetProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.captureProfile(existing.Name, existing.Description, existing.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	return s.UpdateProfile(id, profile)
+}
+
+// DeleteProfile 删除方案（删除当前方案后不再过滤节点）
+func (s *Service) DeleteProfile(id string) error {
+	return s.saveProfileState(func(state *ProfileState) error {
+		for i := range state.Profiles {
+			if state.Profiles[i].ID == id {
+				state.Profiles = append(state.Profiles[:i], state.Profiles[i+1:]...)
+				if state.Active == id {
+					state.Active = ""
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("方案不存在: %s", id)
+	})
+}
+
+// SwitchProfile 切换方案：依次写入核心类型、设置、运行配置、策略、模板与覆盖，
+// 然后重新生成配置；运行中的核心按需热重载或重启
+// 任一步骤或重载失败时回滚到切换前的状态，重载成功后才标记为当前方案
+func (s *Service) SwitchProfile(id string) (*ReloadResult, error) {
+	profile, err := s.GetProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("方案无效: %w", err)
+	}
+	profile, err = cloneProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// 切换前快照当前配置，任一步骤或重载失败时整体回滚，避免只应用一半
+	snapshot, err := s.captureProfile("", "", ProfileNodeSelection{})
+	if err != nil {
+		return nil, err
+	}
+	wasRunning := s.GetStatus().Running
+
+	if err := s.applyProfile(profile); err != nil {
+		s.rollbackProfile(snapshot, wasRunning)
+		return nil, err
+	}
+
+	result, err := s.Reload()
+	if err != nil {
+		if !s.GetStatus().Running && isNoNodesError(err) {
+			// 核心未运行且暂无节点时，方案已切换，等待节点就绪后再生成
+			result = &ReloadResult{Method: ApplyMethodNone}
+		} else {
+			s.rollbackProfile(snapshot, wasRunning)
+			return nil, err
+		}
+	}
+
+	// 重载成功后才标记为当前方案
+	if err := s.saveProfileState(func(state *ProfileState) error {
+		state.Active = id
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	fmt.Printf("🔄 已切换配置方案: %s\n", profile.Name)
+	return result, nil
+}
+
+// applyProfile 依次写入方案中的核心类型、代理设置、代理配置、分流策略、模板与配置覆盖（不重载核心）
+func (s *Service) applyProfile(profile *Profile) error {
+	s.mu.RLock()
+	coreSwitcher := s.coreSwitcher
+	settingsSaver := s.settingsSaver
+	s.mu.RUnlock()
+
+	if profile.CoreType != "" && profile.CoreType != s.GetCoreType() {
+		if coreSwitcher != nil {
+			if err := coreSwitcher(profile.CoreType); err != nil {
+				return fmt.Errorf("切换核心失败: %w", err)
+			}
+		} else {
+			s.SetCoreType(profile.CoreType)
+		}
+	}
+	if profile.Settings != nil && settingsSaver != nil {
+		if err := settingsSaver(profile.Settings); err != nil {
+			return fmt.Errorf("保存代理设置失败: %w", err)
+		}
+	}
+	if profile.Config != nil {
+		config := *profile.Config
+		config.Secret = "" // 沿用本机密钥
+		if err := s.UpdateConfig(&config); err != nil {
+			return err
+		}
+	}
+	switch {
+	case profile.RoutingPolicy != nil:
+		if err := s.UpdateRoutingPolicy(profile.RoutingPolicy); err != nil {
+			return err
+		}
+	case profile.ConfigTemplate != nil:
+		s.mu.Lock()
+		s.configTemplate = profile.ConfigTemplate
+		err := s.saveConfigTemplate()
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if profile.SingBoxTemplate != nil {
+		if err := SaveSingBoxTemplate(s.dataDir, profile.SingBoxTemplate); err != nil {
+			return err
+		}
+	}
+	overrides := profile.Overrides
+	if overrides == nil {
+		overrides = &ConfigOverrides{}
+	}
+	if err := SaveConfigOverrides(s.dataDir, overrides); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.overrides = overrides
+	s.mu.Unlock()
+	return nil
+}
+
+// rollbackProfile 恢复切换前的快照，核心原本运行时重新加载恢复后的配置
+func (s *Service) rollbackProfile(snapshot *Profile, wasRunning bool) {
+	if err := s.applyProfile(snapshot); err != nil {
+		fmt.Printf("⚠️ 回滚配置方案失败: %v\n", err)
+		return
+	}
+	if !wasRunning {
+		return
+	}
+	// 重启失败时核心可能已停止，需重新启动
+	var err error
+	if s.GetStatus().Running {
+		_, err = s.Reload()
+	} else {
+		err = s.Start()
+	}
+	if err != nil {
+		fmt.Printf("⚠️ 回滚后恢复核心失败: %v\n", err)
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// 代理提供者模式
+// 订阅节点按订阅写入 providers/ 下的提供者文件，mihomo 配置通过 proxy-providers 引用，
+// 订阅更新后只需重写提供者文件并调用核心 API 刷新，无需重新生成配置或重启核心。
+// sing-box 1.12 不支持出站提供者，该模式下仍内联全部节点，节点变化时走热重载。
+// ============================================================================
+
+const (
+	providerDir                 = "providers" // 提供者文件目录（相对数据目录）
+	providerNamePrefix          = "sub-"      // 提供者名称前缀，后接订阅 ID
+	providerHealthCheckURL      = "https://www.gstatic.com/generate_204"
+	providerHealthCheckInterval = 300
+)
+
+// ProxyProvider mihomo 代理提供者
+type ProxyProvider struct {
+	Type        string               `yaml:"type"`
+	Path        string               `yaml:"path"`
+	HealthCheck *ProviderHealthCheck `yaml:"health-check,omitempty"`
+}
+
+// ProviderHealthCheck 提供者健康检查
+type ProviderHealthCheck struct {
+	Enable   bool   `yaml:"enable"`
+	URL      string `yaml:"url"`
+	Interval int    `yaml:"interval"`
+	Lazy     bool   `yaml:"lazy,omitempty"`
+}
+
+// providerFile 提供者文件内容
+type providerFile struct {
+	Proxies []map[string]interface{} `yaml:"proxies"`
+}
+
+// providerName 订阅对应的提供者名称
+func providerName(subscriptionID string) string {
+	return providerNamePrefix + subscriptionID
+}
+
+// buildProxyProviders 将订阅节点按订阅拆分为提供者，返回仍需内联的节点（手动节点）、提供者定义与文件内容
+func (g *ConfigGenerator) buildProxyProviders(nodes []ProxyNode) ([]ProxyNode, map[string]ProxyProvider, map[string][]byte) {
+	inline := make([]ProxyNode, 0)
+	grouped := make(map[string][]ProxyNode)
+	for _, node := range nodes {
+		if node.IsManual || node.SubscriptionID == "" {
+			inline = append(inline, node)
+			continue
+		}
+		name := providerName(node.SubscriptionID)
+		grouped[name] = append(grouped[name], node)
+	}
+
+	providers := make(map[string]ProxyProvider, len(grouped))
+	files := make(map[string][]byte, len(grouped))
+	for _, name := range sortedKeys(grouped) {
+		data, err := yaml.Marshal(providerFile{Proxies: g.convertProxies(grouped[name])})
+		if err != nil {
+			fmt.Printf("⚠️ 提供者 %s 序列化失败，节点改为内联: %v\n", name, err)
+			inline = append(inline, grouped[name]...)
+			continue
+		}
+		files[name] = []byte(decodeUnicodeEscapes(string(data)))
+		providers[name] = ProxyProvider{
+			Type: "file",
+			Path: filepath.Join(g.dataDir, providerDir, name+".yaml"),
+			HealthCheck: &ProviderHealthCheck{
+				Enable:   true,
+				URL:      providerHealthCheckURL,
+				Interval: providerHealthCheckInterval,
+				Lazy:     true,
+			},
+		}
+	}
+	return inline, providers, files
+}
+
+// sortedKeys 返回排序后的键，保证生成结果稳定
+func sortedKeys(m map[string][]ProxyNode) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedProviderNames 返回排序后的提供者名称
+func sortedProviderNames(providers map[string]ProxyProvider) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeProviderFiles 写入提供者文件并清理已不存在的订阅文件，返回内容有变化的提供者
+func writeProviderFiles(dataDir string, files map[string][]byte) ([]string, error) {
+	dir := filepath.Join(dataDir, providerDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	changed := make([]string, 0)
+	for name, data := range files {
+		path := filepath.Join(dir, name+".yaml")
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return nil, err
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	stale, _ := filepath.Glob(filepath.Join(dir, providerNamePrefix+"*.yaml"))
+	for _, path := range stale {
+		name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		if _, ok := files[name]; !ok {
+			os.Remove(path)
+		}
+	}
+	return changed, nil
+}
+
+// refreshProvider 通知 mihomo 重新读取提供者文件
+func (s *Service) refreshProvider(name string) error {
+	return s.Controller().RefreshProvider(name)
+}
+
+// refreshProviders 提供者模式下仅重写提供者文件并刷新，配置本身不变时无需重载
+// 返回 nil 表示需要完整重新加载（未启用、非 mihomo、核心未运行或配置已变化）
+func (s *Service) refreshProviders() (*ReloadResult, error) {
+	s.mu.RLock()
+	enabled := s.config.ProviderMode
+	eligible := enabled && s.coreType != "singbox" && s.running && s.restartKey() == s.startKey
+	s.mu.RUnlock()
+	if !eligible || s.nodeProvider == nil {
+		return nil, nil
+	}
+
+	nodes := s.nodeProvider()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("没有可用节点")
+	}
+	var settings *ProxySettings
+	if s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+	options := buildGeneratorOptions(s.config, s.configTemplate, s.routingPolicy, settings)
+	mihomoConfig, _, err := s.generateInMemory("mihomo", options, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 订阅集合或策略变化会改变配置内容，需要走完整重载
+	data, err := s.renderConfig(mihomoConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	configPath := filepath.Join(s.dataDir, "configs", "config.yaml")
+	current, err := os.ReadFile(configPath)
+	if err != nil || !bytes.Equal(current, data) {
+		return nil, nil
+	}
+
+	changed, err := writeProviderFiles(s.dataDir, mihomoConfig.providerFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range changed {
+		if err := s.refreshProvider(name); err != nil {
+			fmt.Printf("⚠️ 刷新提供者 %s 失败，改为重新加载配置: %v\n", name, err)
+			return nil, nil
+		}
+	}
+	if len(changed) > 0 {
+		s.addLog(fmt.Sprintf("[INFO] 已刷新节点提供者: %s", strings.Join(changed, ", ")))
+	}
+	return &ReloadResult{Method: ApplyMethodProviderRefresh, ConfigPath: configPath}, nil
+}
+
+// ApplyNodeChange 使节点变化生效：提供者模式下优先刷新提供者，否则重新加载配置
+func (s *Service) ApplyNodeChange() (*ReloadResult, error) {
+	result, err := s.refreshProviders()
+	if err != nil || result != nil {
+		return result, err
+	}
+	return s.Reload()
+}
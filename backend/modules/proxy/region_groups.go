@@ -1,191 +1,360 @@
 package proxy
 
-import "regexp"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RegionDefinition 地区分类定义（可由用户编辑）
+type RegionDefinition struct {
+	ID       string `json:"id"`       // 地区代码，如 hk
+	Name     string `json:"name"`     // 分组名称，如 "香港节点"
+	Icon     string `json:"icon"`     // 图标
+	Pattern  string `json:"pattern"`  // 匹配正则
+	Priority int    `json:"priority"` // 优先级，数值大的先匹配
+	Enabled  bool   `json:"enabled"`
+}
+
+// DisplayName 带图标的分组名称，如 "🇭🇰 香港节点"
+func (r RegionDefinition) DisplayName() string {
+	if r.Icon == "" {
+		return r.Name
+	}
+	return r.Icon + " " + r.Name
+}
 
 // RegionPattern 地区匹配模式
 type RegionPattern struct {
-	Name    string         // 分组名称，如 "🇭🇰 香港节点"
-	Icon    string         // 图标
-	Pattern *regexp.Regexp // 匹配正则
-}
-
-// RegionPatterns 地区正则表达式（参考 sing-box-subscribe）
-var RegionPatterns = []RegionPattern{
-	{
-		Name:    "🇭🇰 香港节点",
-		Icon:    "🇭🇰",
-		Pattern: regexp.MustCompile(`(?i)香港|沪港|呼港|中港|HKT|HKBN|HGC|WTT|CMI|穗港|广港|京港|🇭🇰|HK|Hongkong|Hong Kong|HongKong|HONG KONG`),
-	},
-	{
-		Name:    "🇨🇳 台湾节点",
-		Icon:    "🇨🇳",
-		Pattern: regexp.MustCompile(`(?i)台湾|台灣|臺灣|台北|台中|新北|彰化|CHT|HINET|🇨🇳|TW|Taiwan|TAIWAN`),
-	},
-	{
-		Name:    "🇸🇬 新加坡节点",
-		Icon:    "🇸🇬",
-		Pattern: regexp.MustCompile(`(?i)新加坡|狮城|獅城|沪新|京新|泉新|穗新|深新|杭新|广新|廣新|滬新|🇸🇬|SG|Singapore|SINGAPORE`),
-	},
-	{
-		Name:    "🇯🇵 日本节点",
-		Icon:    "🇯🇵",
-		Pattern: regexp.MustCompile(`(?i)日本|东京|東京|大阪|埼玉|京日|苏日|沪日|广日|上日|穗日|川日|中日|泉日|杭日|深日|🇯🇵|JP|Japan|JAPAN`),
-	},
-	{
-		Name:    "🇺🇸 美国节点",
-		Icon:    "🇺🇸",
-		Pattern: regexp.MustCompile(`(?i)美国|美國|京美|硅谷|凤凰城|洛杉矶|西雅图|圣何塞|芝加哥|哥伦布|纽约|广美|🇺🇸|US|USA|America|United States`),
-	},
-	{
-		Name:    "🇰🇷 韩国节点",
-		Icon:    "🇰🇷",
-		Pattern: regexp.MustCompile(`(?i)韩国|韓國|首尔|首爾|韩|韓|春川|🇰🇷|KOR|KR|Korea`),
-	},
-	{
-		Name:    "🇬🇧 英国节点",
-		Icon:    "🇬🇧",
-		Pattern: regexp.MustCompile(`(?i)英国|英國|伦敦|🇬🇧|UK|England|United Kingdom|Britain`),
-	},
-	{
-		Name:    "🇩🇪 德国节点",
-		Icon:    "🇩🇪",
-		Pattern: regexp.MustCompile(`(?i)德国|德國|法兰克福|🇩🇪|DE|GER|German|GERMAN`),
-	},
-	{
-		Name:    "🇫🇷 法国节点",
-		Icon:    "🇫🇷",
-		Pattern: regexp.MustCompile(`(?i)法国|法國|巴黎|🇫🇷|FR|France`),
-	},
-	{
-		Name:    "🇷🇺 俄罗斯节点",
-		Icon:    "🇷🇺",
-		Pattern: regexp.MustCompile(`(?i)俄罗斯|俄羅斯|毛子|俄国|🇷🇺|RU|RUS|Russia`),
-	},
-	{
-		Name:    "🇮🇳 印度节点",
-		Icon:    "🇮🇳",
-		Pattern: regexp.MustCompile(`(?i)印度|孟买|🇮🇳|IN|IND|India|Mumbai`),
-	},
-	{
-		Name:    "🇦🇺 澳大利亚节点",
-		Icon:    "🇦🇺",
-		Pattern: regexp.MustCompile(`(?i)澳大利亚|澳洲|墨尔本|悉尼|🇦🇺|AU|Australia|Sydney`),
-	},
-	{
-		Name:    "🇨🇦 加拿大节点",
-		Icon:    "🇨🇦",
-		Pattern: regexp.MustCompile(`(?i)加拿大|蒙特利尔|温哥华|多伦多|楓葉|枫叶|🇨🇦|CA|CAN|Canada|CANADA`),
-	},
-	{
-		Name:    "🇳🇱 荷兰节点",
-		Icon:    "🇳🇱",
-		Pattern: regexp.MustCompile(`(?i)荷兰|荷蘭|阿姆斯特丹|🇳🇱|NL|Netherlands|Amsterdam`),
-	},
-	{
-		Name:    "🇹🇷 土耳其节点",
-		Icon:    "🇹🇷",
-		Pattern: regexp.MustCompile(`(?i)土耳其|伊斯坦布尔|🇹🇷|TR|TUR|Turkey`),
-	},
-	{
-		Name:    "🇹🇭 泰国节点",
-		Icon:    "🇹🇭",
-		Pattern: regexp.MustCompile(`(?i)泰国|泰國|曼谷|🇹🇭|TH|Thailand`),
-	},
-	{
-		Name:    "🇻🇳 越南节点",
-		Icon:    "🇻🇳",
-		Pattern: regexp.MustCompile(`(?i)越南|胡志明市|🇻🇳|VN|Vietnam`),
-	},
-	{
-		Name:    "🇵🇭 菲律宾节点",
-		Icon:    "🇵🇭",
-		Pattern: regexp.MustCompile(`(?i)菲律宾|菲律賓|🇵🇭|PH|Philippines`),
-	},
-	{
-		Name:    "🇲🇾 马来西亚节点",
-		Icon:    "🇲🇾",
-		Pattern: regexp.MustCompile(`(?i)马来西亚|马来|馬來|🇲🇾|MY|Malaysia|MALAYSIA`),
-	},
-	{
-		Name:    "🇮🇩 印尼节点",
-		Icon:    "🇮🇩",
-		Pattern: regexp.MustCompile(`(?i)印尼|印度尼西亚|雅加达|🇮🇩|ID|IDN|Indonesia`),
-	},
-	{
-		Name:    "🇧🇷 巴西节点",
-		Icon:    "🇧🇷",
-		Pattern: regexp.MustCompile(`(?i)巴西|圣保罗|🇧🇷|BR|Brazil`),
-	},
-	{
-		Name:    "🇦🇷 阿根廷节点",
-		Icon:    "🇦🇷",
-		Pattern: regexp.MustCompile(`(?i)阿根廷|🇦🇷|AR|Argentina`),
-	},
-	{
-		Name:    "🇦🇪 阿联酋节点",
-		Icon:    "🇦🇪",
-		Pattern: regexp.MustCompile(`(?i)阿联酋|迪拜|🇦🇪|AE|Dubai|United Arab Emirates`),
-	},
-	{
-		Name:    "🇿🇦 南非节点",
-		Icon:    "🇿🇦",
-		Pattern: regexp.MustCompile(`(?i)南非|约翰内斯堡|🇿🇦|ZA|South Africa`),
-	},
-	{
-		Name:    "🇲🇽 墨西哥节点",
-		Icon:    "🇲🇽",
-		Pattern: regexp.MustCompile(`(?i)墨西哥|🇲🇽|MX|MEX|MEXICO`),
-	},
+	RegionDefinition
+	Regexp *regexp.Regexp
 }
 
-// ClassifyNodesByRegion 根据节点名称分类到各地区
-// 返回 map[地区名][]节点名
-func ClassifyNodesByRegion(nodeNames []string) map[string][]string {
-	result := make(map[string][]string)
-	classified := make(map[string]bool) // 记录已分类的节点
-
-	for _, region := range RegionPatterns {
-		var matched []string
-		for _, name := range nodeNames {
-			if region.Pattern.MatchString(name) {
-				matched = append(matched, name)
-				classified[name] = true
-			}
+// OtherRegionName 未匹配任何地区的节点分组
+const OtherRegionName = "🌍 其他节点"
+
+// regionWords 构造地区正则：中文/英文关键字直接匹配，短代码要求前后不是字母（避免 US 匹配 Russia）
+func regionWords(words string, codes string) string {
+	if codes == "" {
+		return "(?i)" + words
+	}
+	return "(?i)" + words + `|(?:^|[^a-z])(?:` + codes + `)(?:[^a-z]|$)`
+}
+
+// DefaultRegions 默认地区表（参考 sing-box-subscribe）
+func DefaultRegions() []RegionDefinition {
+	regions := []RegionDefinition{
+		{ID: "hk", Name: "香港节点", Icon: "🇭🇰", Pattern: regionWords(`香港|沪港|呼港|中港|HKT|HKBN|HGC|WTT|CMI|穗港|广港|京港|🇭🇰|Hongkong|Hong Kong`, `HK|HKG`)},
+		{ID: "tw", Name: "台湾节点", Icon: "🇹🇼", Pattern: regionWords(`台湾|台灣|臺灣|台北|台中|新北|彰化|CHT|HINET|🇹🇼|Taiwan`, `TW|TWN`)},
+		{ID: "mo", Name: "澳门节点", Icon: "🇲🇴", Pattern: regionWords(`澳门|澳門|🇲🇴|Macau|Macao`, `MO|MAC`)},
+		{ID: "sg", Name: "新加坡节点", Icon: "🇸🇬", Pattern: regionWords(`新加坡|狮城|獅城|沪新|京新|泉新|穗新|深新|杭新|广新|廣新|滬新|🇸🇬|Singapore`, `SG|SGP`)},
+		{ID: "jp", Name: "日本节点", Icon: "🇯🇵", Pattern: regionWords(`日本|东京|東京|大阪|埼玉|京日|苏日|沪日|广日|上日|穗日|川日|中日|泉日|杭日|深日|🇯🇵|Japan|Tokyo|Osaka`, `JP|JPN`)},
+		{ID: "us", Name: "美国节点", Icon: "🇺🇸", Pattern: regionWords(`美国|美國|京美|硅谷|凤凰城|洛杉矶|西雅图|圣何塞|芝加哥|哥伦布|纽约|广美|🇺🇸|America|United States|Los Angeles|San Jose|Seattle|New York`, `US|USA`)},
+		{ID: "kr", Name: "韩国节点", Icon: "🇰🇷", Pattern: regionWords(`韩国|韓國|首尔|首爾|春川|🇰🇷|Korea|Seoul`, `KR|KOR`)},
+		{ID: "gb", Name: "英国节点", Icon: "🇬🇧", Pattern: regionWords(`英国|英國|伦敦|倫敦|🇬🇧|England|United Kingdom|Britain|London`, `UK|GB|GBR`)},
+		{ID: "de", Name: "德国节点", Icon: "🇩🇪", Pattern: regionWords(`德国|德國|法兰克福|🇩🇪|German|Frankfurt`, `DE|DEU|GER`)},
+		{ID: "fr", Name: "法国节点", Icon: "🇫🇷", Pattern: regionWords(`法国|法國|巴黎|🇫🇷|France|Paris`, `FR|FRA`)},
+		{ID: "nl", Name: "荷兰节点", Icon: "🇳🇱", Pattern: regionWords(`荷兰|荷蘭|阿姆斯特丹|🇳🇱|Netherlands|Amsterdam`, `NL|NLD`)},
+		{ID: "it", Name: "意大利节点", Icon: "🇮🇹", Pattern: regionWords(`意大利|義大利|米兰|🇮🇹|Italy|Milan`, `IT|ITA`)},
+		{ID: "es", Name: "西班牙节点", Icon: "🇪🇸", Pattern: regionWords(`西班牙|马德里|🇪🇸|Spain|Madrid`, `ES|ESP`)},
+		{ID: "ch", Name: "瑞士节点", Icon: "🇨🇭", Pattern: regionWords(`瑞士|苏黎世|🇨🇭|Switzerland|Zurich`, `CH|CHE`)},
+		{ID: "se", Name: "瑞典节点", Icon: "🇸🇪", Pattern: regionWords(`瑞典|斯德哥尔摩|🇸🇪|Sweden|Stockholm`, `SE|SWE`)},
+		{ID: "pl", Name: "波兰节点", Icon: "🇵🇱", Pattern: regionWords(`波兰|波蘭|华沙|🇵🇱|Poland|Warsaw`, `PL|POL`)},
+		{ID: "ua", Name: "乌克兰节点", Icon: "🇺🇦", Pattern: regionWords(`乌克兰|烏克蘭|基辅|🇺🇦|Ukraine|Kyiv`, `UA|UKR`)},
+		{ID: "ru", Name: "俄罗斯节点", Icon: "🇷🇺", Pattern: regionWords(`俄罗斯|俄羅斯|毛子|俄国|莫斯科|🇷🇺|Russia|Moscow`, `RU|RUS`)},
+		{ID: "tr", Name: "土耳其节点", Icon: "🇹🇷", Pattern: regionWords(`土耳其|伊斯坦布尔|🇹🇷|Turkey|Türkiye|Istanbul`, `TR|TUR`)},
+		{ID: "il", Name: "以色列节点", Icon: "🇮🇱", Pattern: regionWords(`以色列|🇮🇱|Israel`, `IL|ISR`)},
+		{ID: "ae", Name: "阿联酋节点", Icon: "🇦🇪", Pattern: regionWords(`阿联酋|迪拜|🇦🇪|Dubai|United Arab Emirates`, `AE|UAE`)},
+		{ID: "in", Name: "印度节点", Icon: "🇮🇳", Pattern: regionWords(`印度|孟买|🇮🇳|India|Mumbai`, `IN|IND`)},
+		{ID: "th", Name: "泰国节点", Icon: "🇹🇭", Pattern: regionWords(`泰国|泰國|曼谷|🇹🇭|Thailand|Bangkok`, `TH|THA`)},
+		{ID: "vn", Name: "越南节点", Icon: "🇻🇳", Pattern: regionWords(`越南|胡志明市|河内|🇻🇳|Vietnam`, `VN|VNM`)},
+		{ID: "ph", Name: "菲律宾节点", Icon: "🇵🇭", Pattern: regionWords(`菲律宾|菲律賓|马尼拉|🇵🇭|Philippines|Manila`, `PH|PHL`)},
+		{ID: "my", Name: "马来西亚节点", Icon: "🇲🇾", Pattern: regionWords(`马来西亚|马来|馬來|吉隆坡|🇲🇾|Malaysia|Kuala Lumpur`, `MY|MYS`)},
+		{ID: "id", Name: "印尼节点", Icon: "🇮🇩", Pattern: regionWords(`印尼|印度尼西亚|雅加达|🇮🇩|Indonesia|Jakarta`, `ID|IDN`)},
+		{ID: "au", Name: "澳大利亚节点", Icon: "🇦🇺", Pattern: regionWords(`澳大利亚|澳洲|墨尔本|悉尼|🇦🇺|Australia|Sydney|Melbourne`, `AU|AUS`)},
+		{ID: "nz", Name: "新西兰节点", Icon: "🇳🇿", Pattern: regionWords(`新西兰|紐西蘭|奥克兰|🇳🇿|New Zealand|Auckland`, `NZ|NZL`)},
+		{ID: "ca", Name: "加拿大节点", Icon: "🇨🇦", Pattern: regionWords(`加拿大|蒙特利尔|温哥华|多伦多|楓葉|枫叶|🇨🇦|Canada|Toronto|Vancouver`, `CA|CAN`)},
+		{ID: "mx", Name: "墨西哥节点", Icon: "🇲🇽", Pattern: regionWords(`墨西哥|🇲🇽|Mexico`, `MX|MEX`)},
+		{ID: "br", Name: "巴西节点", Icon: "🇧🇷", Pattern: regionWords(`巴西|圣保罗|🇧🇷|Brazil|Sao Paulo`, `BR|BRA`)},
+		{ID: "ar", Name: "阿根廷节点", Icon: "🇦🇷", Pattern: regionWords(`阿根廷|🇦🇷|Argentina`, `AR|ARG`)},
+		{ID: "cl", Name: "智利节点", Icon: "🇨🇱", Pattern: regionWords(`智利|🇨🇱|Chile|Santiago`, `CL|CHL`)},
+		{ID: "za", Name: "南非节点", Icon: "🇿🇦", Pattern: regionWords(`南非|约翰内斯堡|🇿🇦|South Africa|Johannesburg`, `ZA|ZAF`)},
+		{ID: "eg", Name: "埃及节点", Icon: "🇪🇬", Pattern: regionWords(`埃及|开罗|🇪🇬|Egypt|Cairo`, `EG|EGY`)},
+	}
+	// 默认按列表顺序匹配
+	for i := range regions {
+		regions[i].Priority = (len(regions) - i) * 10
+		regions[i].Enabled = true
+	}
+	return regions
+}
+
+// ============================================================================
+// 当前生效的地区表（生成器共用）
+// ============================================================================
+
+var (
+	regionMu     sync.RWMutex
+	activeRegion = compileRegionsOrDefault(DefaultRegions())
+)
+
+// CompileRegions 校验并编译地区表，返回按优先级排序的启用地区
+func CompileRegions(regions []RegionDefinition) ([]RegionPattern, error) {
+	ids := make(map[string]bool, len(regions))
+	names := make(map[string]bool, len(regions))
+	patterns := make([]RegionPattern, 0, len(regions))
+	for i, r := range regions {
+		if r.ID == "" || r.Name == "" {
+			return nil, fmt.Errorf("地区 #%d 代码与名称不能为空", i+1)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("地区代码重复: %s", r.ID)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("地区名称重复: %s", r.Name)
+		}
+		if r.Name == OtherRegionName {
+			return nil, fmt.Errorf("地区名称不能使用保留名称: %s", r.Name)
 		}
-		if len(matched) > 0 {
-			result[region.Name] = matched
+		ids[r.ID] = true
+		names[r.Name] = true
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("地区 %s 正则不能为空", r.Name)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("地区 %s 正则无效: %v", r.Name, err)
+		}
+		if r.Enabled {
+			patterns = append(patterns, RegionPattern{RegionDefinition: r, Regexp: re})
 		}
 	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Priority > patterns[j].Priority
+	})
+	return patterns, nil
+}
 
-	// 未分类的节点放到"其他节点"
-	var others []string
-	for _, name := range nodeNames {
-		if !classified[name] {
-			others = append(others, name)
-		}
+func compileRegionsOrDefault(regions []RegionDefinition) []RegionPattern {
+	patterns, err := CompileRegions(regions)
+	if err != nil {
+		fmt.Printf("⚠️ 地区表无效，使用默认地区表: %v\n", err)
+		patterns, _ = CompileRegions(DefaultRegions())
 	}
-	if len(others) > 0 {
-		result["🌍 其他节点"] = others
+	return patterns
+}
+
+// SetRegionTable 设置生效的地区表
+func SetRegionTable(regions []RegionDefinition) error {
+	patterns, err := CompileRegions(regions)
+	if err != nil {
+		return err
+	}
+	regionMu.Lock()
+	activeRegion = patterns
+	regionMu.Unlock()
+	return nil
+}
+
+// ActiveRegions 获取生效的地区（按优先级排序）
+func ActiveRegions() []RegionPattern {
+	regionMu.RLock()
+	defer regionMu.RUnlock()
+	return activeRegion
+}
+
+// ClassifyNode 返回节点所属地区（按优先级取第一个匹配）
+func ClassifyNode(patterns []RegionPattern, nodeName string) (RegionPattern, bool) {
+	for _, region := range patterns {
+		if region.Regexp.MatchString(nodeName) {
+			return region, true
+		}
 	}
+	return RegionPattern{}, false
+}
 
+// ClassifyNodesByRegion 根据节点名称分类到各地区
+// 返回 map[地区名][]节点名（地区名带图标，如 "🇭🇰 香港节点"）
+func ClassifyNodesByRegion(nodeNames []string) map[string][]string {
+	result := make(map[string][]string)
+	patterns := ActiveRegions()
+	for _, name := range nodeNames {
+		region, ok := ClassifyNode(patterns, name)
+		key := OtherRegionName
+		if ok {
+			key = region.DisplayName()
+		}
+		result[key] = append(result[key], name)
+	}
 	return result
 }
 
-// GetRegionNames 获取有节点的地区名称列表（按定义顺序）
+// GetRegionNames 获取有节点的地区名称列表（按优先级顺序）
 func GetRegionNames(nodeNames []string) []string {
 	classified := ClassifyNodesByRegion(nodeNames)
 	var names []string
 
-	for _, region := range RegionPatterns {
-		if _, ok := classified[region.Name]; ok {
-			names = append(names, region.Name)
+	for _, region := range ActiveRegions() {
+		if _, ok := classified[region.DisplayName()]; ok {
+			names = append(names, region.DisplayName())
 		}
 	}
 
 	// 如果有其他节点，添加到最后
-	if _, ok := classified["🌍 其他节点"]; ok {
-		names = append(names, "🌍 其他节点")
+	if _, ok := classified[OtherRegionName]; ok {
+		names = append(names, OtherRegionName)
 	}
 
 	return names
 }
+
+// ============================================================================
+// 持久化与 Service 方法
+// ============================================================================
+
+func regionsPath(dataDir string) string {
+	return filepath.Join(dataDir, "regions.json")
+}
+
+// LoadRegions 加载用户地区表
+func LoadRegions(dataDir string) ([]RegionDefinition, error) {
+	data, err := os.ReadFile(regionsPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var regions []RegionDefinition
+	if err := json.Unmarshal(data, &regions); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// SaveRegions 保存用户地区表
+func SaveRegions(dataDir string, regions []RegionDefinition) error {
+	data, err := json.MarshalIndent(regions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(regionsPath(dataDir), data, 0644)
+}
+
+// RegionPreviewGroup 分类预览中的单个地区
+type RegionPreviewGroup struct {
+	ID    string   `json:"id,omitempty"`
+	Name  string   `json:"name"`
+	Icon  string   `json:"icon,omitempty"`
+	Nodes []string `json:"nodes"`
+}
+
+// loadRegions 加载地区表并设为生效（不存在时使用默认地区表）
+func (s *Service) loadRegions() {
+	regions, err := LoadRegions(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("⚠️ 加载地区表失败: %v\n", err)
+		}
+		regions = DefaultRegions()
+	}
+	if err := SetRegionTable(regions); err != nil {
+		fmt.Printf("⚠️ 地区表无效，使用默认地区表: %v\n", err)
+		regions = DefaultRegions()
+		SetRegionTable(regions)
+	}
+	s.regions = regions
+}
+
+// GetRegions 获取地区表
+func (s *Service) GetRegions() []RegionDefinition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]RegionDefinition{}, s.regions...)
+}
+
+// UpdateRegions 校验、保存并应用地区表
+func (s *Service) UpdateRegions(regions []RegionDefinition) error {
+	for i := range regions {
+		regions[i].ID = strings.ToLower(strings.TrimSpace(regions[i].ID))
+	}
+	if err := SetRegionTable(regions); err != nil {
+		return err
+	}
+	if err := SaveRegions(s.dataDir, regions); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.regions = regions
+	s.mu.Unlock()
+	return nil
+}
+
+// AddRegion 添加地区
+func (s *Service) AddRegion(region RegionDefinition) error {
+	regions := s.GetRegions()
+	return s.UpdateRegions(append(regions, region))
+}
+
+// UpdateRegion 修改指定地区
+func (s *Service) UpdateRegion(id string, region RegionDefinition) error {
+	regions := s.GetRegions()
+	for i := range regions {
+		if regions[i].ID == id {
+			if region.ID == "" {
+				region.ID = id
+			}
+			regions[i] = region
+			return s.UpdateRegions(regions)
+		}
+	}
+	return fmt.Errorf("地区不存在: %s", id)
+}
+
+// DeleteRegion 删除指定地区
+func (s *Service) DeleteRegion(id string) error {
+	regions := s.GetRegions()
+	for i := range regions {
+		if regions[i].ID == id {
+			return s.UpdateRegions(append(regions[:i], regions[i+1:]...))
+		}
+	}
+	return fmt.Errorf("地区不存在: %s", id)
+}
+
+// ResetRegions 恢复默认地区表
+func (s *Service) ResetRegions() error {
+	return s.UpdateRegions(DefaultRegions())
+}
+
+// PreviewRegions 预览当前节点的地区分类（regions 为空时使用生效的地区表）
+func (s *Service) PreviewRegions(regions []RegionDefinition) ([]RegionPreviewGroup, error) {
+	patterns := ActiveRegions()
+	if regions != nil {
+		var err error
+		if patterns, err = CompileRegions(regions); err != nil {
+			return nil, err
+		}
+	}
+	if s.nodeProvider == nil {
+		return nil, fmt.Errorf("节点提供者未设置")
+	}
+
+	groups := make([]RegionPreviewGroup, 0, len(patterns)+1)
+	index := make(map[string]int, len(patterns))
+	for _, p := range patterns {
+		index[p.ID] = len(groups)
+		groups = append(groups, RegionPreviewGroup{ID: p.ID, Name: p.Name, Icon: p.Icon, Nodes: []string{}})
+	}
+	others := RegionPreviewGroup{Name: OtherRegionName, Nodes: []string{}}
+	for _, node := range s.nodeProvider() {
+		if region, ok := ClassifyNode(patterns, node.Name); ok {
+			groups[index[region.ID]].Nodes = append(groups[index[region.ID]].Nodes, node.Name)
+		} else {
+			others.Nodes = append(others.Nodes, node.Name)
+		}
+	}
+	return append(groups, others), nil
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 配置生效方式
+const (
+	ApplyMethodNone      = "none"       // 核心未运行，仅生成配置
+	ApplyMethodHotReload = "hot-reload" // 通过核心 API / 信号热重载
+	ApplyMethodRestart   = "restart"    // 完整重启核心
+
+	ApplyMethodProviderRefresh = "provider-refresh" // 仅刷新代理提供者（配置未变化）
+)
+
+// ReloadResult 配置生效结果
+type ReloadResult struct {
+	Method     string `json:"method"`           // none, hot-reload, restart
+	Reason     string `json:"reason,omitempty"` // 需要重启或热重载失败的原因
+	ConfigPath string `json:"configPath,omitempty"`
+}
+
+// restartKey 计算需要完整重启才能生效的配置指纹（端口、TUN、核心类型等）
+// 调用者需持有锁
+func (s *Service) restartKey() string {
+	key := map[string]interface{}{
+		"coreType":           s.coreType,
+		"mixedPort":          s.config.MixedPort,
+		"socksPort":          s.config.SocksPort,
+		"redirPort":          s.config.RedirPort,
+		"tproxyPort":         s.config.TProxyPort,
+		"checkPort":          s.config.CheckPort,
+		"allowLan":           s.config.AllowLan,
+		"transparentMode":    s.config.TransparentMode,
+		"tunEnabled":         s.config.TunEnabled,
+		"externalController": s.config.ExternalController,
+		"secret":             s.config.Secret,
+		"controllerSocket":   s.config.ControllerSocket,
+	}
+	if s.settingsProvider != nil {
+		if settings := s.settingsProvider(); settings != nil {
+			key["tun"] = settings.TUN
+			key["firewall"] = settings.Firewall
+			key["routingMark"] = settings.RoutingMark
+		}
+	}
+	data, _ := json.Marshal(key)
+	return string(data)
+}
+
+// Reload 使配置变更生效：端口与 TUN 未变化时热重载，否则完整重启
+func (s *Service) Reload() (*ReloadResult, error) {
+	s.mu.RLock()
+	running := s.running
+	needRestart := running && s.restartKey() != s.startKey
+	s.mu.RUnlock()
+
+	if !running {
+		configPath, err := s.regenerateConfig()
+		if err != nil {
+			return nil, err
+		}
+		return &ReloadResult{Method: ApplyMethodNone, ConfigPath: configPath}, nil
+	}
+
+	if needRestart {
+		if err := s.Restart(); err != nil {
+			return nil, err
+		}
+		return &ReloadResult{
+			Method:     ApplyMethodRestart,
+			Reason:     "端口、TUN 或核心类型已变化",
+			ConfigPath: s.GetStatus().ConfigPath,
+		}, nil
+	}
+
+	configPath, err := s.regenerateConfig()
+	if err != nil {
+		// 新配置生成或校验失败，保持当前运行配置不变
+		return nil, err
+	}
+
+	if err := s.hotReload(configPath); err != nil {
+		fmt.Printf("⚠️ 热重载失败，改为重启核心: %v\n", err)
+		if err := s.Restart(); err != nil {
+			return nil, err
+		}
+		return &ReloadResult{
+			Method:     ApplyMethodRestart,
+			Reason:     "热重载失败: " + err.Error(),
+			ConfigPath: configPath,
+		}, nil
+	}
+
+	s.addLog(fmt.Sprintf("[INFO] 配置已热重载: %s", configPath))
+	return &ReloadResult{Method: ApplyMethodHotReload, ConfigPath: configPath}, nil
+}
+
+// hotReload 通知运行中的核心重新加载配置文件
+// mihomo: PUT /configs?force=true；sing-box: SIGHUP（run 命令收到后重新读取配置）
+func (s *Service) hotReload(configPath string) error {
+	return s.Controller().ReloadConfig(configPath)
+}
@@ -0,0 +1,970 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================================
+// 路由规则模拟：按生成的配置判断一个请求会命中哪条规则、走哪个出口
+// ============================================================================
+
+// RouteTestRequest 路由测试请求
+type RouteTestRequest struct {
+	Domain   string `json:"domain"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Network  string `json:"network"` // tcp, udp
+	Process  string `json:"process"`
+	SourceIP string `json:"sourceIp"`
+	CoreType string `json:"coreType,omitempty"` // 默认使用当前核心
+}
+
+// RouteTestSkip 无法评估而跳过的规则
+type RouteTestSkip struct {
+	Index  int    `json:"index"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// RouteTestResult 路由测试结果
+type RouteTestResult struct {
+	CoreType   string          `json:"coreType"`
+	ConfigPath string          `json:"configPath,omitempty"` // 为空表示使用内存生成的配置
+	Matched    bool            `json:"matched"`              // false 表示未命中规则，使用默认出口
+	RuleIndex  int             `json:"ruleIndex"`            // 命中规则序号（从 0 开始），未命中为 -1
+	Rule       string          `json:"rule"`
+	Policy     string          `json:"policy"`               // 命中的策略组 / 出站
+	Chain      []string        `json:"chain"`                // 策略组逐级选择链
+	Node       string          `json:"node"`                 // 最终出口
+	NodeSource string          `json:"nodeSource"`           // core: 核心当前选择；config: 配置默认值
+	ResolvedIP string          `json:"resolvedIp,omitempty"` // 模拟过程中解析得到的 IP
+	Skipped    []RouteTestSkip `json:"skipped,omitempty"`
+}
+
+// geoRuleSetURLRegex meta-rules-dat 的 sing-box 规则集地址
+var geoRuleSetURLRegex = regexp.MustCompile(`meta-rules-dat@sing/geo/(geosite|geoip)/([^/]+)\.srs$`)
+
+// ruleSetMatcher 已加载的规则集
+type ruleSetMatcher struct {
+	domains   *domainSet
+	cidrs     []*net.IPNet
+	classical []mihomoRule  // mihomo classical 规则集
+	headless  []SBRouteRule // sing-box source 规则集
+}
+
+// routeSimulator 单次模拟的上下文
+type routeSimulator struct {
+	dataDir string
+	mode    string
+	domain  string
+	ip      net.IP
+	port    int
+	network string
+	process string
+	srcIP   net.IP
+
+	resolved     bool // 已尝试解析域名
+	allowResolve bool // sing-box 命中 resolve 动作后允许解析
+	resolvedIP   string
+
+	geo        *geoDataReader
+	providers  map[string]RuleProvider
+	sbRuleSets map[string]SBRuleSet
+	loaded     map[string]*ruleSetMatcher
+}
+
+// TestRoute 模拟请求的路由结果
+func (s *Service) TestRoute(req RouteTestRequest) (*RouteTestResult, error) {
+	sim, err := newRouteSimulator(s.dataDir, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	coreType := s.coreType
+	configPath := s.configPath
+	sim.mode = strings.ToLower(s.config.Mode)
+	s.mu.RUnlock()
+
+	if req.CoreType != "" {
+		if req.CoreType != "mihomo" && req.CoreType != "singbox" {
+			return nil, fmt.Errorf("不支持的核心类型: %s", req.CoreType)
+		}
+		if req.CoreType != coreType {
+			configPath = ""
+		}
+		coreType = req.CoreType
+	}
+	if configPath == "" {
+		if coreType == "singbox" {
+			configPath = filepath.Join(s.dataDir, "configs", "singbox-config.json")
+		} else {
+			configPath = filepath.Join(s.dataDir, "configs", "config.yaml")
+		}
+	}
+
+	// 优先使用已生成的配置文件，不存在时在内存中生成
+	var mihomoConfig *MihomoConfig
+	var singboxConfig *SingBoxConfig
+	if data, err := os.ReadFile(configPath); err == nil {
+		if coreType == "singbox" {
+			singboxConfig = &SingBoxConfig{}
+			err = json.Unmarshal(data, singboxConfig)
+		} else {
+			mihomoConfig = &MihomoConfig{}
+			err = yaml.Unmarshal(data, mihomoConfig)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析配置文件失败: %v", err)
+		}
+	} else {
+		configPath = ""
+		if s.nodeProvider == nil {
+			return nil, fmt.Errorf("节点提供者未设置")
+		}
+		var settings *ProxySettings
+		if s.settingsProvider != nil {
+			settings = s.settingsProvider()
+		}
+		s.mu.RLock()
+		options := buildGeneratorOptions(s.config, s.configTemplate, s.routingPolicy, settings)
+		s.mu.RUnlock()
+		if mihomoConfig, singboxConfig, err = s.generateInMemory(coreType, options, s.nodeProvider()); err != nil {
+			return nil, err
+		}
+		// 按写入文件的内容（含配置覆盖）测试
+		if s.GetConfigOverrides().forCore(coreType).active() {
+			data, err := s.renderConfig(mihomoConfig, singboxConfig)
+			if err != nil {
+				return nil, err
+			}
+			if singboxConfig != nil {
+				singboxConfig = &SingBoxConfig{}
+				err = json.Unmarshal(data, singboxConfig)
+			} else {
+				mihomoConfig = &MihomoConfig{}
+				err = yaml.Unmarshal(data, mihomoConfig)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := &RouteTestResult{CoreType: coreType, ConfigPath: configPath, RuleIndex: -1}
+	var defaults map[string]string
+	if singboxConfig != nil {
+		sim.evalSingBox(singboxConfig, result)
+		defaults = singBoxGroupDefaults(singboxConfig)
+	} else {
+		sim.evalMihomo(mihomoConfig, result)
+		defaults = mihomoGroupDefaults(mihomoConfig)
+	}
+	result.ResolvedIP = sim.resolvedIP
+
+	// 解析策略组到具体节点：核心运行时使用当前选择，否则使用配置默认值
+	selections, source := defaults, "config"
+	if s.GetStatus().Running {
+		if now, err := s.coreSelections(); err == nil {
+			selections, source = now, "core"
+		}
+	}
+	result.Chain, result.Node = resolvePolicyChain(result.Policy, selections)
+	result.NodeSource = source
+	return result, nil
+}
+
+func newRouteSimulator(dataDir string, req RouteTestRequest) (*routeSimulator, error) {
+	sim := &routeSimulator{
+		dataDir: dataDir,
+		domain:  strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Domain)), "."),
+		port:    req.Port,
+		network: strings.ToLower(req.Network),
+		process: req.Process,
+		geo:     newGeoDataReader(dataDir),
+		loaded:  make(map[string]*ruleSetMatcher),
+	}
+	if ip := net.ParseIP(sim.domain); ip != nil {
+		sim.ip, sim.domain = ip, ""
+	}
+	if req.IP != "" {
+		if sim.ip = net.ParseIP(strings.TrimSpace(req.IP)); sim.ip == nil {
+			return nil, fmt.Errorf("IP 地址无效: %s", req.IP)
+		}
+	}
+	if sim.domain == "" && sim.ip == nil {
+		return nil, fmt.Errorf("请提供域名或 IP")
+	}
+	if req.SourceIP != "" {
+		if sim.srcIP = net.ParseIP(strings.TrimSpace(req.SourceIP)); sim.srcIP == nil {
+			return nil, fmt.Errorf("来源 IP 无效: %s", req.SourceIP)
+		}
+	}
+	if sim.network == "" {
+		sim.network = "tcp"
+	}
+	if sim.network != "tcp" && sim.network != "udp" {
+		return nil, fmt.Errorf("network 必须为 tcp 或 udp")
+	}
+	if sim.port < 0 || sim.port > 65535 {
+		return nil, fmt.Errorf("端口无效: %d", sim.port)
+	}
+	return sim, nil
+}
+
+// destIP 获取目标 IP，必要时解析域名（noResolve 时不解析）
+func (sim *routeSimulator) destIP(noResolve bool) net.IP {
+	if sim.ip != nil || sim.domain == "" || noResolve || sim.resolved {
+		return sim.ip
+	}
+	sim.resolved = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, sim.domain)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+	sim.ip = addrs[0].IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			sim.ip = addr.IP
+			break
+		}
+	}
+	sim.resolvedIP = sim.ip.String()
+	return sim.ip
+}
+
+// protocol 按端口推断嗅探得到的协议
+func (sim *routeSimulator) protocol() string {
+	switch {
+	case sim.port == 53:
+		return "dns"
+	case sim.port == 443 && sim.network == "udp":
+		return "quic"
+	case sim.port == 443:
+		return "tls"
+	case sim.port == 80:
+		return "http"
+	}
+	return ""
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified())
+}
+
+// matchPortSpec 检查端口是否在端口列表或范围（a:b）内
+func matchPortSpec(port int, ports []int, ranges []string) bool {
+	if port == 0 {
+		return false
+	}
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	for _, r := range ranges {
+		lo, hi, _ := strings.Cut(r, ":")
+		start, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		end, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if lo == "" {
+			start, err1 = 0, nil
+		}
+		if hi == "" {
+			end, err2 = 65535, nil
+		}
+		if err1 == nil && err2 == nil && port >= start && port <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDROrIP 解析网段，单个 IP 视为主机网段
+func parseCIDROrIP(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("IP 无效: %s", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// matchCIDRStrings 检查 IP 是否属于字符串形式的网段列表
+func matchCIDRStrings(ip net.IP, cidrs []string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, c := range cidrs {
+		if n, err := parseCIDROrIP(c); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// intList 统一端口字段（JSON 解码后可能为 float64 或 []interface{}）
+func intList(v interface{}) []int {
+	switch val := v.(type) {
+	case int:
+		return []int{val}
+	case float64:
+		return []int{int(val)}
+	case []int:
+		return val
+	case []interface{}:
+		result := make([]int, 0, len(val))
+		for _, item := range val {
+			if f, ok := item.(float64); ok {
+				result = append(result, int(f))
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// ============================================================================
+// mihomo 规则
+// ============================================================================
+
+// mihomoRule 解析后的 mihomo 规则
+type mihomoRule struct {
+	Type    string
+	Payload string
+	Target  string
+	Params  []string
+	Sub     []mihomoRule // AND / OR / NOT 子规则
+}
+
+// parseMihomoRule 解析规则行，withTarget 为 false 时用于规则集与逻辑子规则
+func parseMihomoRule(line string, withTarget bool) (mihomoRule, error) {
+	line = strings.TrimSpace(line)
+	typ, rest, _ := strings.Cut(line, ",")
+	r := mihomoRule{Type: strings.ToUpper(strings.TrimSpace(typ))}
+
+	switch r.Type {
+	case "MATCH":
+		r.Target = strings.TrimSpace(rest)
+		return r, nil
+	case "AND", "OR", "NOT":
+		rest = strings.TrimSpace(rest)
+		end := matchingParen(rest)
+		if end < 0 {
+			return r, fmt.Errorf("逻辑规则括号不匹配")
+		}
+		r.Payload = rest[:end+1]
+		for _, group := range splitParenGroups(r.Payload[1:end]) {
+			sub, err := parseMihomoRule(group, false)
+			if err != nil {
+				return r, err
+			}
+			r.Sub = append(r.Sub, sub)
+		}
+		rest = strings.TrimPrefix(strings.TrimSpace(rest[end+1:]), ",")
+		if withTarget {
+			r.Target, rest, _ = strings.Cut(rest, ",")
+		}
+		if rest != "" {
+			r.Params = strings.Split(rest, ",")
+		}
+		return r, nil
+	}
+
+	parts := strings.Split(rest, ",")
+	r.Payload = strings.TrimSpace(parts[0])
+	parts = parts[1:]
+	if withTarget && len(parts) > 0 {
+		r.Target, parts = strings.TrimSpace(parts[0]), parts[1:]
+	}
+	for _, p := range parts {
+		r.Params = append(r.Params, strings.TrimSpace(p))
+	}
+	return r, nil
+}
+
+// matchingParen 返回与开头括号匹配的位置
+func matchingParen(s string) int {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+		if depth == 0 && i == 0 {
+			return -1
+		}
+	}
+	return -1
+}
+
+// splitParenGroups 拆分 (A),(B) 形式的顶层分组
+func splitParenGroups(s string) []string {
+	var groups []string
+	depth, start := 0, -1
+	for i, c := range s {
+		switch c {
+		case '(':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && start >= 0 {
+				groups = append(groups, s[start:i])
+			}
+		}
+	}
+	return groups
+}
+
+func (r mihomoRule) hasParam(param string) bool {
+	for _, p := range r.Params {
+		if strings.EqualFold(p, param) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMihomo 检查 mihomo 规则是否命中，无法评估时返回错误
+func (sim *routeSimulator) matchMihomo(r mihomoRule) (bool, error) {
+	noResolve := r.hasParam("no-resolve")
+	payload := strings.ToLower(r.Payload)
+
+	switch r.Type {
+	case "MATCH":
+		return true, nil
+	case "DOMAIN":
+		return sim.domain != "" && sim.domain == payload, nil
+	case "DOMAIN-SUFFIX":
+		return sim.domain != "" && (sim.domain == payload || strings.HasSuffix(sim.domain, "."+payload)), nil
+	case "DOMAIN-KEYWORD":
+		return sim.domain != "" && strings.Contains(sim.domain, payload), nil
+	case "DOMAIN-REGEX":
+		re, err := regexp.Compile(r.Payload)
+		if err != nil {
+			return false, err
+		}
+		return sim.domain != "" && re.MatchString(sim.domain), nil
+	case "GEOSITE":
+		set, err := sim.geo.Site(payload)
+		if err != nil {
+			return false, err
+		}
+		return set.match(sim.domain), nil
+	case "GEOIP":
+		ip := sim.destIP(noResolve)
+		if payload == "lan" || payload == "private" {
+			return isPrivateIP(ip), nil
+		}
+		if ip == nil {
+			return false, nil
+		}
+		cidrs, err := sim.geo.IP(payload)
+		if err != nil {
+			return false, err
+		}
+		return matchCIDRs(ip, cidrs), nil
+	case "IP-CIDR", "IP-CIDR6":
+		return matchCIDRStrings(sim.destIP(noResolve), []string{r.Payload}), nil
+	case "SRC-IP-CIDR":
+		return matchCIDRStrings(sim.srcIP, []string{r.Payload}), nil
+	case "DST-PORT":
+		ports, ranges, err := parsePorts(r.Payload)
+		if err != nil {
+			return false, err
+		}
+		return matchPortSpec(sim.port, ports, ranges), nil
+	case "NETWORK":
+		return strings.EqualFold(sim.network, r.Payload), nil
+	case "PROCESS-NAME":
+		return sim.process != "" && strings.EqualFold(sim.process, r.Payload), nil
+	case "RULE-SET":
+		m, err := sim.mihomoRuleSet(r.Payload)
+		if err != nil {
+			return false, err
+		}
+		return sim.matchRuleSet(m, noResolve)
+	case "AND", "OR", "NOT":
+		for _, sub := range r.Sub {
+			ok, err := sim.matchMihomo(sub)
+			if err != nil {
+				return false, err
+			}
+			if r.Type == "AND" && !ok {
+				return false, nil
+			}
+			if r.Type == "OR" && ok {
+				return true, nil
+			}
+			if r.Type == "NOT" {
+				return !ok, nil
+			}
+		}
+		return r.Type == "AND", nil
+	}
+	return false, fmt.Errorf("暂不支持模拟 %s 规则", r.Type)
+}
+
+// evalMihomo 按顺序评估 mihomo 规则
+func (sim *routeSimulator) evalMihomo(config *MihomoConfig, result *RouteTestResult) {
+	sim.providers = config.RuleProviders
+
+	switch strings.ToLower(config.Mode) {
+	case "global":
+		result.Rule, result.Policy = "mode: global", "GLOBAL"
+		return
+	case "direct":
+		result.Rule, result.Policy = "mode: direct", PolicyDirect
+		return
+	}
+
+	for i, line := range config.Rules {
+		r, err := parseMihomoRule(line, true)
+		if err == nil {
+			var ok bool
+			if ok, err = sim.matchMihomo(r); err == nil && ok {
+				result.Matched, result.RuleIndex, result.Rule, result.Policy = true, i, line, r.Target
+				return
+			}
+		}
+		if err != nil {
+			result.Skipped = append(result.Skipped, RouteTestSkip{Index: i, Rule: line, Reason: err.Error()})
+		}
+	}
+	// 无规则命中时 mihomo 直连
+	result.Policy = PolicyDirect
+}
+
+// mihomoRuleSet 加载 mihomo 规则提供者（yaml / text 本地文件，mrs 回退到本地 Geo 数据）
+func (sim *routeSimulator) mihomoRuleSet(name string) (*ruleSetMatcher, error) {
+	if m, ok := sim.loaded[name]; ok {
+		return m, nil
+	}
+	provider, ok := sim.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("规则集 %s 未定义", name)
+	}
+
+	var m *ruleSetMatcher
+	path, local := localRuleSetPath(sim.dataDir, provider.Path)
+	if local && provider.Format != "mrs" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseMihomoRuleSetEntries(data, provider.Format)
+		if err != nil {
+			return nil, fmt.Errorf("规则集 %s 解析失败: %v", name, err)
+		}
+		m = &ruleSetMatcher{domains: newDomainSet()}
+		for _, entry := range entries {
+			switch provider.Behavior {
+			case "domain":
+				m.domains.addMihomo(entry)
+			case "ipcidr":
+				if n, err := parseCIDROrIP(entry); err == nil {
+					m.cidrs = append(m.cidrs, n)
+				}
+			default:
+				if r, err := parseMihomoRule(entry, false); err == nil {
+					m.classical = append(m.classical, r)
+				}
+			}
+		}
+	} else if geo := mihomoGeoRegex.FindStringSubmatch(provider.URL); geo != nil {
+		var err error
+		if m, err = sim.geoRuleSet(geo[1], geo[2]); err != nil {
+			return nil, err
+		}
+	} else if local {
+		return nil, fmt.Errorf("规则集 %s 为 mrs 二进制格式，无法模拟", name)
+	} else {
+		return nil, fmt.Errorf("规则集 %s 尚未下载", name)
+	}
+	sim.loaded[name] = m
+	return m, nil
+}
+
+// parseMihomoRuleSetEntries 读取 yaml（payload 列表）或 text（逐行）规则集条目
+func parseMihomoRuleSetEntries(data []byte, format string) ([]string, error) {
+	if format == "text" {
+		var entries []string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+		return entries, nil
+	}
+	var doc struct {
+		Payload []string `yaml:"payload"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Payload, nil
+}
+
+// geoRuleSet 使用本地 geosite.dat / geoip.dat 构造规则集
+func (sim *routeSimulator) geoRuleSet(kind, code string) (*ruleSetMatcher, error) {
+	if kind == "geoip" {
+		cidrs, err := sim.geo.IP(code)
+		if err != nil {
+			return nil, err
+		}
+		return &ruleSetMatcher{cidrs: cidrs}, nil
+	}
+	set, err := sim.geo.Site(code)
+	if err != nil {
+		return nil, err
+	}
+	return &ruleSetMatcher{domains: set}, nil
+}
+
+// matchRuleSet 检查规则集是否命中
+func (sim *routeSimulator) matchRuleSet(m *ruleSetMatcher, noResolve bool) (bool, error) {
+	if m.domains != nil && m.domains.match(sim.domain) {
+		return true, nil
+	}
+	if len(m.cidrs) > 0 && matchCIDRs(sim.destIP(noResolve), m.cidrs) {
+		return true, nil
+	}
+	for _, r := range m.classical {
+		if ok, err := sim.matchMihomo(r); err == nil && ok {
+			return true, nil
+		}
+	}
+	for _, r := range m.headless {
+		ok, err := sim.matchSingBox(r)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ============================================================================
+// sing-box 规则
+// ============================================================================
+
+// matchSingBox 检查 sing-box 路由规则是否命中（字段组内为或，组间为与）
+func (sim *routeSimulator) matchSingBox(r SBRouteRule) (bool, error) {
+	ok, err := sim.matchSingBoxConditions(r)
+	if err != nil {
+		return false, err
+	}
+	return ok != r.Invert, nil
+}
+
+func (sim *routeSimulator) matchSingBoxConditions(r SBRouteRule) (bool, error) {
+	if r.Type == "logical" {
+		for _, sub := range r.Rules {
+			ok, err := sim.matchSingBox(sub)
+			if err != nil {
+				return false, err
+			}
+			if r.Mode == "and" && !ok {
+				return false, nil
+			}
+			if r.Mode == "or" && ok {
+				return true, nil
+			}
+		}
+		return r.Mode == "and", nil
+	}
+
+	// 模拟请求从主入站（TUN / 混合端口）进入
+	if len(r.Inbound) > 0 && !containsString(r.Inbound, "tun-in") && !containsString(r.Inbound, "mixed-in") {
+		return false, nil
+	}
+	if r.ClashMode != "" && !strings.EqualFold(r.ClashMode, sim.mode) {
+		return false, nil
+	}
+	if r.Network != "" && !strings.EqualFold(r.Network, sim.network) {
+		return false, nil
+	}
+	if r.Protocol != nil && !containsString(ruleSetTags(r.Protocol), sim.protocol()) {
+		return false, nil
+	}
+	if len(r.ProcessName) > 0 && (sim.process == "" || !containsFold(r.ProcessName, sim.process)) {
+		return false, nil
+	}
+	if len(r.SourceIPCIDR) > 0 && !matchCIDRStrings(sim.srcIP, r.SourceIPCIDR) {
+		return false, nil
+	}
+	if len(r.SourcePort) > 0 || len(r.SourcePortRange) > 0 {
+		return false, nil // 请求不含来源端口
+	}
+	if ports := intList(r.Port); (len(ports) > 0 || len(r.PortRange) > 0) && !matchPortSpec(sim.port, ports, r.PortRange) {
+		return false, nil
+	}
+
+	// 目标地址组
+	tags := ruleSetTags(r.RuleSet)
+	if len(r.Domain) == 0 && len(r.DomainSuffix) == 0 && len(r.DomainKeyword) == 0 && len(r.DomainRegex) == 0 &&
+		len(r.IPCIDR) == 0 && !r.IPIsPrivate && len(tags) == 0 {
+		return true, nil
+	}
+	if sim.domain != "" {
+		if containsString(r.Domain, sim.domain) {
+			return true, nil
+		}
+		for _, suffix := range r.DomainSuffix {
+			suffix = strings.ToLower(suffix)
+			if strings.HasPrefix(suffix, ".") && strings.HasSuffix(sim.domain, suffix) {
+				return true, nil
+			}
+			if sim.domain == suffix || strings.HasSuffix(sim.domain, "."+suffix) {
+				return true, nil
+			}
+		}
+		for _, keyword := range r.DomainKeyword {
+			if strings.Contains(sim.domain, strings.ToLower(keyword)) {
+				return true, nil
+			}
+		}
+		for _, expr := range r.DomainRegex {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return false, err
+			}
+			if re.MatchString(sim.domain) {
+				return true, nil
+			}
+		}
+	}
+	ip := sim.destIP(!sim.allowResolve)
+	if matchCIDRStrings(ip, r.IPCIDR) || (r.IPIsPrivate && isPrivateIP(ip)) {
+		return true, nil
+	}
+	for _, tag := range tags {
+		m, err := sim.singBoxRuleSet(tag)
+		if err != nil {
+			return false, err
+		}
+		ok, err := sim.matchRuleSet(m, !sim.allowResolve)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// evalSingBox 按顺序评估 sing-box 路由规则
+func (sim *routeSimulator) evalSingBox(config *SingBoxConfig, result *RouteTestResult) {
+	if config.Route == nil {
+		config.Route = &SBRoute{}
+	}
+	sim.sbRuleSets = make(map[string]SBRuleSet, len(config.Route.RuleSet))
+	for _, rs := range config.Route.RuleSet {
+		sim.sbRuleSets[rs.Tag] = rs
+	}
+
+	for i, r := range config.Route.Rules {
+		text, _ := json.Marshal(r)
+		ok, err := sim.matchSingBox(r)
+		if err != nil {
+			result.Skipped = append(result.Skipped, RouteTestSkip{Index: i, Rule: string(text), Reason: err.Error()})
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		policy := r.Outbound
+		switch r.Action {
+		case "sniff", "route-options":
+			continue // 非终止动作
+		case "resolve":
+			sim.allowResolve = true
+			continue
+		case "reject":
+			policy = PolicyReject
+		case "hijack-dns":
+			policy = "hijack-dns"
+		case "direct":
+			policy = "direct"
+		}
+		result.Matched, result.RuleIndex, result.Rule, result.Policy = true, i, string(text), policy
+		return
+	}
+
+	result.Rule = "final"
+	result.Policy = config.Route.Final
+	if result.Policy == "" && len(config.Outbounds) > 0 {
+		result.Policy = config.Outbounds[0].Tag
+	}
+}
+
+// singBoxRuleSet 加载 sing-box 规则集（source 格式本地文件，binary 回退到本地 Geo 数据）
+func (sim *routeSimulator) singBoxRuleSet(tag string) (*ruleSetMatcher, error) {
+	if m, ok := sim.loaded[tag]; ok {
+		return m, nil
+	}
+	rs, ok := sim.sbRuleSets[tag]
+	if !ok {
+		return nil, fmt.Errorf("规则集 %s 未定义", tag)
+	}
+
+	var m *ruleSetMatcher
+	if path, local := localRuleSetPath(sim.dataDir, rs.Path); local && rs.Format == "source" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var doc struct {
+			Rules []SBRouteRule `json:"rules"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("规则集 %s 解析失败: %v", tag, err)
+		}
+		m = &ruleSetMatcher{headless: doc.Rules}
+	} else if kind, code, ok := singBoxGeoSource(rs); ok {
+		var err error
+		if m, err = sim.geoRuleSet(kind, code); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("规则集 %s 为 binary 格式，无法模拟", tag)
+	}
+	sim.loaded[tag] = m
+	return m, nil
+}
+
+// singBoxGeoSource 推断规则集对应的 Geo 数据（meta-rules-dat 地址，或 geosite-x / geoip-x 文件名与标签）
+func singBoxGeoSource(rs SBRuleSet) (string, string, bool) {
+	if m := geoRuleSetURLRegex.FindStringSubmatch(rs.URL); m != nil {
+		return m[1], m[2], true
+	}
+	for _, name := range []string{path.Base(rs.URL), filepath.Base(rs.Path), rs.Tag} {
+		name = strings.TrimSuffix(name, ".srs")
+		if kind, code, ok := strings.Cut(name, "-"); ok && (kind == "geosite" || kind == "geoip") {
+			return kind, code, true
+		}
+	}
+	return "", "", false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================
+// 策略组解析
+// ============================================================================
+
+// mihomoGroupDefaults 配置中各策略组的默认选择（首个成员）
+func mihomoGroupDefaults(config *MihomoConfig) map[string]string {
+	defaults := make(map[string]string, len(config.ProxyGroups))
+	for _, g := range config.ProxyGroups {
+		if len(g.Proxies) > 0 {
+			defaults[g.Name] = g.Proxies[0]
+		}
+	}
+	return defaults
+}
+
+// singBoxGroupDefaults 配置中各出站组的默认选择
+func singBoxGroupDefaults(config *SingBoxConfig) map[string]string {
+	defaults := make(map[string]string)
+	for _, o := range config.Outbounds {
+		if o.Default != "" {
+			defaults[o.Tag] = o.Default
+		} else if len(o.Outbounds) > 0 {
+			defaults[o.Tag] = o.Outbounds[0]
+		}
+	}
+	return defaults
+}
+
+// coreSelections 通过核心 API 获取各策略组当前选择
+func (s *Service) coreSelections() (map[string]string, error) {
+	body, err := s.Controller().Proxies()
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Proxies map[string]struct {
+			Now string `json:"now"`
+		} `json:"proxies"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	selections := make(map[string]string, len(data.Proxies))
+	for name, p := range data.Proxies {
+		if p.Now != "" {
+			selections[name] = p.Now
+		}
+	}
+	return selections, nil
+}
+
+// resolvePolicyChain 沿策略组选择逐级解析到最终出口
+func resolvePolicyChain(policy string, selections map[string]string) ([]string, string) {
+	chain := []string{policy}
+	visited := map[string]bool{policy: true}
+	current := policy
+	for {
+		next, ok := selections[current]
+		if !ok || visited[next] {
+			break
+		}
+		chain = append(chain, next)
+		visited[next] = true
+		current = next
+	}
+	return chain, current
+}
@@ -0,0 +1,806 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// 核心无关的分流策略模型
+// 同一份策略同时翻译为 mihomo 与 sing-box 配置，切换核心不丢失分流自定义
+// ============================================================================
+
+// 内置出口（两种核心通用的名称）
+const (
+	PolicyDirect = "DIRECT"
+	PolicyReject = "REJECT"
+)
+
+// NodeSelector 节点选择器：决定代理组自动包含哪些节点
+type NodeSelector struct {
+	All    bool   `json:"all,omitempty"`    // 所有节点
+	Manual bool   `json:"manual,omitempty"` // 仅手动添加的节点
+	Filter string `json:"filter,omitempty"` // 节点名称正则（无匹配时退回所有节点）
+}
+
+// PolicyGroup 策略组
+type PolicyGroup struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"` // select, url-test, fallback, load-balance
+	Icon        string        `json:"icon,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Enabled     bool          `json:"enabled"`
+	Members     []string      `json:"members,omitempty"` // 其他策略组名称或 DIRECT / REJECT
+	Nodes       *NodeSelector `json:"nodes,omitempty"`   // 自动包含的节点
+	URL         string        `json:"url,omitempty"`
+	Interval    int           `json:"interval,omitempty"` // 秒
+	Tolerance   int           `json:"tolerance,omitempty"`
+	Lazy        bool          `json:"lazy,omitempty"`
+	Hidden      bool          `json:"hidden,omitempty"`
+	Strategy    string        `json:"strategy,omitempty"` // load-balance: consistent-hashing, round-robin, sticky-sessions（仅 mihomo）
+}
+
+// PolicyRule 分流规则
+type PolicyRule struct {
+	// DOMAIN, DOMAIN-SUFFIX, DOMAIN-KEYWORD, DOMAIN-REGEX, IP-CIDR, IP-CIDR6, SRC-IP-CIDR,
+	// DST-PORT, SRC-PORT, NETWORK, PROCESS-NAME, GEOIP, GEOSITE, RULE-SET, MATCH
+	Type        string `json:"type"`
+	Payload     string `json:"payload,omitempty"`
+	Target      string `json:"target"` // 策略组名称或 DIRECT / REJECT
+	NoResolve   bool   `json:"noResolve,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// RuleSetSource 规则集在某个核心下的来源
+type RuleSetSource struct {
+	URL    string `json:"url,omitempty"`
+	Path   string `json:"path,omitempty"`   // 本地文件存在时优先使用
+	Format string `json:"format,omitempty"` // mihomo: mrs, yaml, text；sing-box: binary, source
+}
+
+// PolicyRuleSet 规则集（同一名称在两种核心下分别指定来源）
+type PolicyRuleSet struct {
+	Name        string         `json:"name"`
+	Behavior    string         `json:"behavior"` // domain, ipcidr, classical
+	Description string         `json:"description,omitempty"`
+	Interval    int            `json:"interval,omitempty"` // 更新间隔（秒）
+	Mihomo      *RuleSetSource `json:"mihomo,omitempty"`
+	SingBox     *RuleSetSource `json:"singbox,omitempty"`
+}
+
+// PolicyDNS DNS 策略（为空的字段使用核心默认配置）
+type PolicyDNS struct {
+	Nameservers       []string `json:"nameservers,omitempty"`       // 默认（海外）DNS
+	DirectNameservers []string `json:"directNameservers,omitempty"` // 直连（国内）DNS
+	DirectRuleSets    []string `json:"directRuleSets,omitempty"`    // 使用直连 DNS 解析的规则集
+}
+
+// PolicyRuleBlock 命名规则块，启用时插入在主规则之前（可由定时任务切换）
+type PolicyRuleBlock struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Enabled     bool         `json:"enabled"`
+	Rules       []PolicyRule `json:"rules"`
+}
+
+// RoutingPolicy 分流策略
+type RoutingPolicy struct {
+	Groups     []PolicyGroup     `json:"groups"`
+	Rules      []PolicyRule      `json:"rules"`
+	RuleBlocks []PolicyRuleBlock `json:"ruleBlocks,omitempty"`
+	RuleSets   []PolicyRuleSet   `json:"ruleSets"`
+	DNS        PolicyDNS         `json:"dns"`
+}
+
+// 合法取值
+var (
+	policyGroupTypes = map[string]bool{"select": true, "url-test": true, "fallback": true, "load-balance": true}
+	policyRuleTypes  = map[string]bool{
+		"DOMAIN": true, "DOMAIN-SUFFIX": true, "DOMAIN-KEYWORD": true, "DOMAIN-REGEX": true,
+		"IP-CIDR": true, "IP-CIDR6": true, "SRC-IP-CIDR": true, "DST-PORT": true, "SRC-PORT": true,
+		"NETWORK": true, "PROCESS-NAME": true, "GEOIP": true, "GEOSITE": true, "RULE-SET": true, "MATCH": true,
+	}
+)
+
+func routingPolicyPath(dataDir string) string {
+	return filepath.Join(dataDir, "routing_policy.json")
+}
+
+// LoadRoutingPolicy 从文件加载分流策略
+func LoadRoutingPolicy(dataDir string) (*RoutingPolicy, error) {
+	data, err := os.ReadFile(routingPolicyPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var policy RoutingPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SaveRoutingPolicy 保存分流策略到文件
+func SaveRoutingPolicy(dataDir string, policy *RoutingPolicy) error {
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(routingPolicyPath(dataDir), data, 0644)
+}
+
+// Validate 校验策略：组类型、规则类型、规则目标与规则集引用
+func (p *RoutingPolicy) Validate() error {
+	groups := make(map[string]bool)
+	for _, g := range p.Groups {
+		if g.Name == "" {
+			return fmt.Errorf("策略组名称不能为空")
+		}
+		if groups[g.Name] {
+			return fmt.Errorf("策略组名称重复: %s", g.Name)
+		}
+		if g.Name == PolicyDirect || g.Name == PolicyReject {
+			return fmt.Errorf("策略组名称不能使用内置出口: %s", g.Name)
+		}
+		if !policyGroupTypes[g.Type] {
+			return fmt.Errorf("策略组 %s 类型无效: %s", g.Name, g.Type)
+		}
+		if err := validateLoadBalance(g.Name, g.Type, g.Strategy); err != nil {
+			return err
+		}
+		if g.Nodes != nil && g.Nodes.Filter != "" {
+			if _, err := regexp.Compile(g.Nodes.Filter); err != nil {
+				return fmt.Errorf("策略组 %s 节点过滤正则无效: %v", g.Name, err)
+			}
+		}
+		groups[g.Name] = true
+	}
+	isTarget := func(name string) bool {
+		return groups[name] || name == PolicyDirect || name == PolicyReject
+	}
+
+	for _, g := range p.Groups {
+		for _, m := range g.Members {
+			if !isTarget(m) {
+				return fmt.Errorf("策略组 %s 引用了不存在的策略组: %s", g.Name, m)
+			}
+		}
+	}
+
+	ruleSets := make(map[string]bool)
+	for _, rs := range p.RuleSets {
+		if rs.Name == "" {
+			return fmt.Errorf("规则集名称不能为空")
+		}
+		ruleSets[rs.Name] = true
+	}
+
+	validateRules := func(prefix string, rules []PolicyRule) error {
+		for i, r := range rules {
+			ruleType := strings.ToUpper(r.Type)
+			if !policyRuleTypes[ruleType] {
+				return fmt.Errorf("%s规则 %d 类型无效: %s", prefix, i, r.Type)
+			}
+			if ruleType != "MATCH" && r.Payload == "" {
+				return fmt.Errorf("%s规则 %d 缺少匹配内容", prefix, i)
+			}
+			if ruleType == "RULE-SET" && !ruleSets[r.Payload] {
+				return fmt.Errorf("%s规则 %d 引用了不存在的规则集: %s", prefix, i, r.Payload)
+			}
+			if !isTarget(r.Target) {
+				return fmt.Errorf("%s规则 %d 目标策略组不存在: %s", prefix, i, r.Target)
+			}
+		}
+		return nil
+	}
+	if err := validateRules("", p.Rules); err != nil {
+		return err
+	}
+
+	blocks := make(map[string]bool)
+	for _, b := range p.RuleBlocks {
+		if b.Name == "" {
+			return fmt.Errorf("规则块名称不能为空")
+		}
+		if blocks[b.Name] {
+			return fmt.Errorf("规则块名称重复: %s", b.Name)
+		}
+		blocks[b.Name] = true
+		for _, r := range b.Rules {
+			if strings.EqualFold(r.Type, "MATCH") {
+				return fmt.Errorf("规则块 %s 不能包含 MATCH 规则", b.Name)
+			}
+		}
+		if err := validateRules("规则块 "+b.Name+" ", b.Rules); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range p.DNS.DirectRuleSets {
+		if !ruleSets[name] {
+			return fmt.Errorf("DNS 策略引用了不存在的规则集: %s", name)
+		}
+	}
+	return nil
+}
+
+// effectiveRules 生效的规则：已启用规则块在前，主规则在后
+func (p *RoutingPolicy) effectiveRules() []PolicyRule {
+	rules := make([]PolicyRule, 0, len(p.Rules))
+	for _, b := range p.RuleBlocks {
+		if b.Enabled {
+			rules = append(rules, b.Rules...)
+		}
+	}
+	return append(rules, p.Rules...)
+}
+
+// ToConfigTemplate 转换为 mihomo 配置模板（供旧版模板接口展示与编辑）
+func (p *RoutingPolicy) ToConfigTemplate() *ConfigTemplate {
+	tpl := &ConfigTemplate{
+		ProxyGroups:   make([]ProxyGroupTemplate, 0, len(p.Groups)),
+		Rules:         make([]RuleTemplate, 0, len(p.Rules)),
+		RuleProviders: make([]RuleProviderTemplate, 0, len(p.RuleSets)),
+	}
+	for _, g := range p.Groups {
+		gt := ProxyGroupTemplate{
+			Name:        g.Name,
+			Type:        g.Type,
+			Icon:        g.Icon,
+			Description: g.Description,
+			Enabled:     g.Enabled,
+			Proxies:     append([]string{}, g.Members...),
+			URL:         g.URL,
+			Interval:    g.Interval,
+			Tolerance:   g.Tolerance,
+			Lazy:        g.Lazy,
+			Hidden:      g.Hidden,
+			Strategy:    g.Strategy,
+		}
+		if g.Nodes != nil {
+			gt.UseAll = true
+			if g.Nodes.Manual {
+				gt.Filter = "__MANUAL__"
+			} else {
+				gt.Filter = g.Nodes.Filter
+			}
+		}
+		tpl.ProxyGroups = append(tpl.ProxyGroups, gt)
+	}
+	for _, r := range p.Rules {
+		tpl.Rules = append(tpl.Rules, RuleTemplate{
+			Type:        r.Type,
+			Payload:     r.Payload,
+			Proxy:       r.Target,
+			NoResolve:   r.NoResolve,
+			Description: r.Description,
+		})
+	}
+	for _, rs := range p.RuleSets {
+		if rs.Mihomo == nil {
+			continue
+		}
+		providerType := "http"
+		if rs.Mihomo.URL == "" {
+			providerType = "file"
+		}
+		tpl.RuleProviders = append(tpl.RuleProviders, RuleProviderTemplate{
+			Name:        rs.Name,
+			Type:        providerType,
+			Behavior:    rs.Behavior,
+			URL:         rs.Mihomo.URL,
+			Path:        rs.Mihomo.Path,
+			Interval:    rs.Interval,
+			Format:      rs.Mihomo.Format,
+			Description: rs.Description,
+		})
+	}
+	return tpl
+}
+
+// withConfigTemplate 应用 mihomo 模板的分组、规则与规则提供者，保留 sing-box 规则集来源与 DNS 策略
+func (p *RoutingPolicy) withConfigTemplate(tpl *ConfigTemplate) *RoutingPolicy {
+	migrated, _ := MigrateRoutingPolicy(tpl, nil)
+	existing := make(map[string]*RuleSetSource)
+	for _, rs := range p.RuleSets {
+		if rs.SingBox != nil {
+			existing[rs.Name] = rs.SingBox
+		}
+	}
+	for i := range migrated.RuleSets {
+		if src, ok := existing[migrated.RuleSets[i].Name]; ok {
+			migrated.RuleSets[i].SingBox = src
+		}
+	}
+	migrated.DNS = p.DNS
+	migrated.RuleBlocks = p.RuleBlocks
+	return migrated
+}
+
+// ============================================================================
+// 从旧模板迁移
+// ============================================================================
+
+// 无法按 URL 推导 sing-box 来源的默认规则集
+var singBoxRuleSetAliases = map[string]string{
+	"ai-domain": "geosite/category-ai-!cn",
+}
+
+// mihomoGeoRegex 匹配 MetaCubeX meta-rules-dat 的 mihomo 规则路径
+var mihomoGeoRegex = regexp.MustCompile(`meta-rules-dat@meta/geo/(geosite|geoip)/([^/]+)\.mrs$`)
+
+// MigrationIssue 迁移时未能并入分流策略的模板内容
+type MigrationIssue struct {
+	Source string `json:"source"` // 如 singbox.rules[3]、singbox.proxyGroups[1]
+	Item   string `json:"item"`   // 原始内容
+	Reason string `json:"reason"`
+}
+
+// MigrationReport 迁移报告：sing-box 模板中已翻译的分组、规则与未能翻译的条目
+type MigrationReport struct {
+	TranslatedGroups int              `json:"translatedGroups"`
+	TranslatedRules  int              `json:"translatedRules"`
+	Issues           []MigrationIssue `json:"issues,omitempty"`
+}
+
+// MigrateRoutingPolicy 从 mihomo 配置模板与 sing-box 模板迁移为分流策略
+// 分组与规则以 mihomo 模板为准（此前仅 mihomo 生成器使用模板）；sing-box 模板中 mihomo 模板没有的分组与
+// 单条件规则翻译后并入策略（规则插入在 MATCH 之前），无法翻译的条目列入迁移报告；
+// sing-box 规则集来源优先取 sing-box 模板中的同名规则集，其次按 MetaCubeX sing 分支推导
+func MigrateRoutingPolicy(tpl *ConfigTemplate, sb *SingBoxTemplate) (*RoutingPolicy, *MigrationReport) {
+	if tpl == nil {
+		tpl = GetDefaultConfigTemplate()
+	}
+	policy := &RoutingPolicy{
+		Groups:   make([]PolicyGroup, 0, len(tpl.ProxyGroups)),
+		Rules:    make([]PolicyRule, 0, len(tpl.Rules)),
+		RuleSets: make([]PolicyRuleSet, 0, len(tpl.RuleProviders)),
+	}
+
+	for _, g := range tpl.ProxyGroups {
+		pg := PolicyGroup{
+			Name:        g.Name,
+			Type:        g.Type,
+			Icon:        g.Icon,
+			Description: g.Description,
+			// 与生成器一致：未填写说明的新建分组视为启用
+			Enabled:   g.Enabled || g.Description == "",
+			URL:       g.URL,
+			Interval:  g.Interval,
+			Tolerance: g.Tolerance,
+			Lazy:      g.Lazy,
+			Hidden:    g.Hidden,
+			Strategy:  g.Strategy,
+		}
+		if g.UseAll {
+			switch g.Filter {
+			case "__MANUAL__":
+				pg.Nodes = &NodeSelector{Manual: true}
+			case "":
+				pg.Nodes = &NodeSelector{All: true}
+			default:
+				pg.Nodes = &NodeSelector{Filter: g.Filter}
+			}
+		} else {
+			pg.Members = append([]string{}, g.Proxies...)
+		}
+		policy.Groups = append(policy.Groups, pg)
+	}
+
+	for _, r := range tpl.Rules {
+		policy.Rules = append(policy.Rules, PolicyRule{
+			Type:        r.Type,
+			Payload:     r.Payload,
+			Target:      r.Proxy,
+			NoResolve:   r.NoResolve,
+			Description: r.Description,
+		})
+	}
+
+	sbSets := make(map[string]SingBoxRuleSetTemplate)
+	if sb != nil {
+		for _, rs := range sb.RuleSets {
+			sbSets[rs.Tag] = rs
+		}
+	}
+	sbRuleSetNames := make(map[string]string) // sing-box 规则集标签 -> 策略规则集名称
+	for _, p := range tpl.RuleProviders {
+		if tag := singBoxRuleSetTag(p); tag != "" {
+			sbRuleSetNames[tag] = p.Name
+		}
+		policy.RuleSets = append(policy.RuleSets, PolicyRuleSet{
+			Name:        p.Name,
+			Behavior:    p.Behavior,
+			Description: p.Description,
+			Interval:    p.Interval,
+			Mihomo:      &RuleSetSource{URL: p.URL, Path: p.Path, Format: p.Format},
+			SingBox:     deriveSingBoxSource(p, sbSets),
+		})
+	}
+	report := &MigrationReport{}
+	if sb != nil && !isDefaultSingBoxTemplate(sb) {
+		migrateSingBoxTemplate(policy, sb, sbRuleSetNames, report)
+	}
+	return policy, report
+}
+
+// singBoxRuleSetTag 推导 mihomo 规则提供者对应的 sing-box 规则集标签（如 geosite-google），无法推导时返回空
+func singBoxRuleSetTag(p RuleProviderTemplate) string {
+	if m := mihomoGeoRegex.FindStringSubmatch(p.URL); m != nil {
+		return m[1] + "-" + m[2]
+	}
+	if alias, ok := singBoxRuleSetAliases[p.Name]; ok {
+		return path.Dir(alias) + "-" + path.Base(alias)
+	}
+	return ""
+}
+
+// deriveSingBoxSource 推导 mihomo 规则提供者对应的 sing-box 规则集来源
+func deriveSingBoxSource(p RuleProviderTemplate, sbSets map[string]SingBoxRuleSetTemplate) *RuleSetSource {
+	tag := singBoxRuleSetTag(p)
+	if tag == "" {
+		return nil
+	}
+	kind, name, _ := strings.Cut(tag, "-")
+
+	if rs, ok := sbSets[tag]; ok {
+		return &RuleSetSource{URL: rs.URL, Path: rs.Path, Format: rs.Format}
+	}
+
+	return &RuleSetSource{
+		URL:    fmt.Sprintf("https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@sing/geo/%s/%s.srs", kind, name),
+		Path:   path.Join(SingBoxRulesetSubDir, tag+".srs"), // 相对数据目录，已下载时优先使用
+		Format: "binary",
+	}
+}
+
+// isDefaultSingBoxTemplate 是否为未修改的默认 sing-box 模板（其分组与规则由默认配置模板覆盖，无需迁移）
+func isDefaultSingBoxTemplate(sb *SingBoxTemplate) bool {
+	current, err := json.Marshal(sb)
+	if err != nil {
+		return false
+	}
+	defaults, _ := json.Marshal(GetDefaultSingBoxTemplate())
+	return string(current) == string(defaults)
+}
+
+// sing-box 分组类型对应的策略组类型
+var singBoxGroupTypes = map[string]string{"selector": "select", "urltest": "url-test"}
+
+// migrateSingBoxTemplate 将 sing-box 模板中策略缺少的分组与规则并入策略，无法翻译的条目写入报告
+func migrateSingBoxTemplate(policy *RoutingPolicy, sb *SingBoxTemplate, ruleSetNames map[string]string, report *MigrationReport) {
+	groups := make(map[string]bool)
+	for _, g := range policy.Groups {
+		groups[g.Name] = true
+	}
+
+	// 分组：标签或名称已在策略中的视为同一分组
+	pending := make([]SingBoxProxyGroupTemplate, 0)
+	pendingIndex := make([]int, 0)
+	for i, g := range sb.ProxyGroups {
+		if groups[g.Tag] || groups[g.Name] {
+			continue
+		}
+		if _, ok := singBoxGroupTypes[g.Type]; !ok {
+			report.addIssue(fmt.Sprintf("singbox.proxyGroups[%d]", i), g, fmt.Sprintf("不支持的分组类型 %s", g.Type))
+			continue
+		}
+		pending = append(pending, g)
+		pendingIndex = append(pendingIndex, i)
+		groups[g.Tag] = true
+	}
+	for i, g := range pending {
+		pg := PolicyGroup{
+			Name:        g.Tag,
+			Type:        singBoxGroupTypes[g.Type],
+			Icon:        g.Icon,
+			Description: g.Name,
+			Enabled:     g.Enabled,
+			URL:         g.URL,
+			Tolerance:   g.Tolerance,
+		}
+		if d, err := time.ParseDuration(g.Interval); err == nil {
+			pg.Interval = int(d.Seconds())
+		}
+		var dropped []string
+		for _, out := range g.Outbounds {
+			if member, ok := policyTargetName(out, groups); ok {
+				pg.Members = append(pg.Members, member)
+			} else {
+				dropped = append(dropped, out)
+			}
+		}
+		if len(g.Outbounds) == 0 {
+			pg.Nodes = &NodeSelector{All: true} // 生成时动态填充所有节点
+		}
+		if len(dropped) > 0 {
+			report.addIssue(fmt.Sprintf("singbox.proxyGroups[%d]", pendingIndex[i]), dropped,
+				fmt.Sprintf("分组 %s 的成员不是策略组或内置出口（节点请通过节点选择器添加），已忽略", g.Tag))
+			if len(pg.Members) == 0 {
+				pg.Nodes = &NodeSelector{All: true}
+			}
+		}
+		policy.Groups = append(policy.Groups, pg)
+		report.TranslatedGroups++
+	}
+
+	// 规则：翻译为单条件策略规则，已存在的规则不重复添加
+	existing := make(map[PolicyRule]bool)
+	for _, r := range policy.Rules {
+		existing[PolicyRule{Type: r.Type, Payload: r.Payload, Target: r.Target}] = true
+	}
+	translated := make([]PolicyRule, 0)
+	for i, r := range sb.Rules {
+		rules, reason := translateSingBoxRule(r, groups, ruleSetNames)
+		if reason != "" {
+			report.addIssue(fmt.Sprintf("singbox.rules[%d]", i), r, reason)
+			continue
+		}
+		for _, pr := range rules {
+			if existing[pr] {
+				continue
+			}
+			existing[pr] = true
+			translated = append(translated, pr)
+		}
+	}
+	if len(translated) > 0 {
+		at := len(policy.Rules)
+		if at > 0 && strings.EqualFold(policy.Rules[at-1].Type, "MATCH") {
+			at--
+		}
+		rules := append(append(append([]PolicyRule{}, policy.Rules[:at]...), translated...), policy.Rules[at:]...)
+		policy.Rules = rules
+		report.TranslatedRules = len(translated)
+	}
+
+	// sing-box 专属规则仍由 sing-box 模板生成，不并入两种核心共用的策略
+	for i, r := range sb.CustomRules {
+		report.addIssue(fmt.Sprintf("singbox.customRules[%d]", i), r, "sing-box 专属规则，仅在 sing-box 下生效，未并入分流策略")
+	}
+}
+
+// translateSingBoxRule 将 sing-box 规则翻译为策略规则，无法翻译时返回原因
+// 同一字段的多个值在 sing-box 中为“或”关系，拆分为多条规则；多个字段为“且”关系，无法拆分
+func translateSingBoxRule(r SingBoxRuleTemplate, groups map[string]bool, ruleSetNames map[string]string) ([]PolicyRule, string) {
+	switch {
+	case r.Type == "logical" || len(r.Rules) > 0:
+		return nil, "逻辑规则无法翻译为单条件规则"
+	case r.Invert:
+		return nil, "取反规则无法翻译"
+	case r.Action != "" && r.Action != "route":
+		return nil, fmt.Sprintf("动作 %s 没有对应的分流规则", r.Action)
+	case len(r.Inbound) > 0 || len(r.Protocol) > 0 || r.ClashMode != "" || r.IPIsPrivate:
+		return nil, "规则包含 inbound、protocol、clash_mode 或 ip_is_private 条件，无对应的分流规则类型"
+	}
+	target, ok := policyTargetName(r.Outbound, groups)
+	if !ok {
+		return nil, fmt.Sprintf("出站 %s 不是策略组或内置出口", r.Outbound)
+	}
+
+	type condition struct {
+		ruleType string
+		payloads []string
+	}
+	var conditions []condition
+	add := func(ruleType string, payloads []string) {
+		if len(payloads) > 0 {
+			conditions = append(conditions, condition{ruleType, payloads})
+		}
+	}
+	if r.Network != "" {
+		add("NETWORK", []string{r.Network})
+	}
+	add("DOMAIN", r.Domain)
+	add("DOMAIN-SUFFIX", r.DomainSuffix)
+	add("DOMAIN-KEYWORD", r.DomainKeyword)
+	add("DOMAIN-REGEX", r.DomainRegex)
+	add("IP-CIDR", r.IPCIDR)
+	add("SRC-IP-CIDR", r.SourceIPCIDR)
+	add("PROCESS-NAME", r.ProcessName)
+
+	dstPorts, err := singBoxPorts(r.Port, r.PortRange)
+	if err != nil {
+		return nil, err.Error()
+	}
+	add("DST-PORT", dstPorts)
+	srcPorts, err := singBoxPorts(r.SourcePort, r.SourcePortRange)
+	if err != nil {
+		return nil, err.Error()
+	}
+	add("SRC-PORT", srcPorts)
+
+	var sets []string
+	for _, tag := range singBoxRuleSetTags(r.RuleSet) {
+		name, ok := ruleSetNames[tag]
+		if !ok {
+			return nil, fmt.Sprintf("规则集 %s 没有对应的策略规则集", tag)
+		}
+		sets = append(sets, name)
+	}
+	add("RULE-SET", sets)
+
+	switch len(conditions) {
+	case 0:
+		return nil, "规则没有可识别的匹配条件"
+	case 1:
+	default:
+		return nil, "多个匹配条件组合（且关系）无法拆分为单条件规则"
+	}
+
+	c := conditions[0]
+	rules := make([]PolicyRule, 0, len(c.payloads))
+	for _, payload := range c.payloads {
+		ruleType := c.ruleType
+		switch {
+		case ruleType == "IP-CIDR" && strings.Contains(payload, ":"):
+			ruleType = "IP-CIDR6"
+		case ruleType == "DOMAIN-SUFFIX":
+			// sing-box 以 . 开头的后缀仅匹配子域名，mihomo DOMAIN-SUFFIX 同时匹配域名本身
+			payload = strings.TrimPrefix(payload, ".")
+		}
+		rules = append(rules, PolicyRule{Type: ruleType, Payload: payload, Target: target})
+	}
+	return rules, ""
+}
+
+// policyTargetName 将 sing-box 出站标签转换为策略目标
+func policyTargetName(outbound string, groups map[string]bool) (string, bool) {
+	switch outbound {
+	case "direct", PolicyDirect:
+		return PolicyDirect, true
+	case "block", PolicyReject:
+		return PolicyReject, true
+	}
+	return outbound, groups[outbound]
+}
+
+// singBoxPorts 合并端口与端口范围（1000:2000 转为 1000-2000）
+func singBoxPorts(ports []int, ranges []string) ([]string, error) {
+	result := make([]string, 0, len(ports)+len(ranges))
+	for _, p := range ports {
+		result = append(result, strconv.Itoa(p))
+	}
+	for _, r := range ranges {
+		from, to, ok := strings.Cut(r, ":")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("端口范围 %s 缺少起止端口，无法翻译", r)
+		}
+		result = append(result, from+"-"+to)
+	}
+	return result, nil
+}
+
+// singBoxRuleSetTags 解析 rule_set 字段（字符串或字符串数组）
+func singBoxRuleSetTags(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []string:
+		return val
+	case []interface{}:
+		tags := make([]string, 0, len(val))
+		for _, item := range val {
+			if tag, ok := item.(string); ok {
+				tags = append(tags, tag)
+			}
+		}
+		return tags
+	}
+	return nil
+}
+
+// addIssue 记录未能翻译的条目
+func (r *MigrationReport) addIssue(source string, item interface{}, reason string) {
+	data, _ := json.Marshal(item)
+	r.Issues = append(r.Issues, MigrationIssue{Source: source, Item: string(data), Reason: reason})
+}
+
+// ============================================================================
+// Service 方法
+// ============================================================================
+
+// loadRoutingPolicy 加载分流策略，不存在时从配置模板与 sing-box 模板迁移
+func (s *Service) loadRoutingPolicy() {
+	policy, err := LoadRoutingPolicy(s.dataDir)
+	if err == nil {
+		s.routingPolicy = policy
+		return
+	}
+
+	policy, report := MigrateRoutingPolicy(s.configTemplate, LoadSingBoxTemplate(s.dataDir))
+	s.routingPolicy = policy
+	for _, issue := range report.Issues {
+		fmt.Printf("⚠️ 迁移分流策略跳过 %s: %s\n", issue.Source, issue.Reason)
+	}
+	if os.IsNotExist(err) {
+		if err := SaveRoutingPolicy(s.dataDir, s.routingPolicy); err != nil {
+			fmt.Printf("⚠️ 保存分流策略失败: %v\n", err)
+			return
+		}
+		fmt.Println("✓ 已从配置模板迁移分流策略")
+		return
+	}
+	// 文件损坏时不覆盖，使用迁移结果运行
+	fmt.Printf("⚠️ 分流策略文件解析失败，暂用配置模板: %v\n", err)
+}
+
+// GetRoutingPolicy 获取分流策略
+func (s *Service) GetRoutingPolicy() *RoutingPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.routingPolicy
+}
+
+// UpdateRoutingPolicy 校验并保存分流策略，同时刷新 mihomo 模板视图
+func (s *Service) UpdateRoutingPolicy(policy *RoutingPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := SaveRoutingPolicy(s.dataDir, policy); err != nil {
+		return err
+	}
+	s.routingPolicy = policy
+	s.configTemplate = policy.ToConfigTemplate()
+
+	// 直接写模板文件，避免再次同步覆盖策略
+	templateFile := filepath.Join(s.dataDir, "config_template.json")
+	data, err := json.MarshalIndent(s.configTemplate, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(templateFile, data, 0644)
+}
+
+// MigrateRoutingPolicyFromTemplates 重新从配置模板与 sing-box 模板迁移分流策略（保留 DNS 策略与规则块）
+// 返回迁移报告，列出 sing-box 模板中未能翻译的条目
+func (s *Service) MigrateRoutingPolicyFromTemplates() (*RoutingPolicy, *MigrationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policy, report := MigrateRoutingPolicy(s.configTemplate, LoadSingBoxTemplate(s.dataDir))
+	if s.routingPolicy != nil {
+		policy.DNS = s.routingPolicy.DNS
+		policy.RuleBlocks = s.routingPolicy.RuleBlocks
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, report, err
+	}
+	if err := SaveRoutingPolicy(s.dataDir, policy); err != nil {
+		return nil, report, err
+	}
+	s.routingPolicy = policy
+	return policy, report, nil
+}
+
+// SetRuleBlockEnabled 启用或停用规则块
+func (s *Service) SetRuleBlockEnabled(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.routingPolicy == nil {
+		return fmt.Errorf("分流策略未加载")
+	}
+
+	policy := *s.routingPolicy
+	policy.RuleBlocks = append([]PolicyRuleBlock(nil), s.routingPolicy.RuleBlocks...)
+	for i := range policy.RuleBlocks {
+		if policy.RuleBlocks[i].Name == name {
+			policy.RuleBlocks[i].Enabled = enabled
+			if err := SaveRoutingPolicy(s.dataDir, &policy); err != nil {
+				return err
+			}
+			s.routingPolicy = &policy
+			return nil
+		}
+	}
+	return fmt.Errorf("规则块不存在: %s", name)
+}
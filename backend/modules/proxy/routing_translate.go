@@ -0,0 +1,612 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// 分流策略 -> 核心配置翻译
+// 两种核心共用同一套组成员解析，保证同一请求命中相同的策略组
+// ============================================================================
+
+// resolvedGroup 解析成员后的策略组
+type resolvedGroup struct {
+	PolicyGroup
+	members  []string // 节点名称、策略组名称或 DIRECT / REJECT
+	explicit []string // 显式成员中仍然有效的部分
+}
+
+// resolveGroups 解析启用的策略组成员：显式成员 + 节点选择器选中的节点
+// 引用未启用或不存在的策略组会被移除，成员为空时回退为 DIRECT
+func (p *RoutingPolicy) resolveGroups(nodeNames, manualNames []string) []resolvedGroup {
+	enabled := make(map[string]bool)
+	for _, g := range p.Groups {
+		if g.Enabled {
+			enabled[g.Name] = true
+		}
+	}
+	nodeSet := make(map[string]bool, len(nodeNames))
+	for _, name := range nodeNames {
+		nodeSet[name] = true
+	}
+
+	result := make([]resolvedGroup, 0, len(p.Groups))
+	for _, g := range p.Groups {
+		if !g.Enabled {
+			continue
+		}
+		members := make([]string, 0)
+		seen := make(map[string]bool)
+		add := func(name string) {
+			if !seen[name] {
+				seen[name] = true
+				members = append(members, name)
+			}
+		}
+
+		for _, m := range g.Members {
+			if enabled[m] || nodeSet[m] || m == PolicyDirect || m == PolicyReject {
+				add(m)
+			}
+		}
+		explicit := append([]string{}, members...)
+		for _, name := range selectNodes(g.Nodes, nodeNames, manualNames) {
+			add(name)
+		}
+
+		if len(members) == 0 {
+			members = []string{PolicyDirect}
+		}
+		result = append(result, resolvedGroup{PolicyGroup: g, members: members, explicit: explicit})
+	}
+	return result
+}
+
+// selectNodes 按选择器挑选节点
+func selectNodes(sel *NodeSelector, nodeNames, manualNames []string) []string {
+	if sel == nil {
+		return nil
+	}
+	switch {
+	case sel.Manual:
+		return manualNames
+	case sel.Filter != "":
+		matched := filterNodes(sel.Filter, nodeNames)
+		// 与模板生成保持一致：没有匹配时使用全部节点
+		if len(matched) == 0 {
+			return nodeNames
+		}
+		return matched
+	case sel.All:
+		return nodeNames
+	}
+	return nil
+}
+
+// filterNodes 返回名称匹配正则的节点（正则无效时为空）
+func filterNodes(pattern string, nodeNames []string) []string {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	matched := make([]string, 0)
+	for _, name := range nodeNames {
+		if re.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// localRuleSetPath 规则集本地文件存在时返回绝对路径（相对路径按数据目录解析）
+// 数据目录为空（导出给其他客户端）时不使用本地文件
+func localRuleSetPath(dataDir, p string) (string, bool) {
+	if p == "" || dataDir == "" {
+		return "", false
+	}
+	abs := p
+	if !filepath.IsAbs(p) {
+		abs = filepath.Join(dataDir, p)
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return "", false
+	}
+	return abs, true
+}
+
+// ============================================================================
+// mihomo
+// ============================================================================
+
+// translatePolicyToMihomo 将分流策略翻译为 mihomo 代理组、规则提供者与规则
+// providerNames 非空时（提供者模式），按选择器挑选订阅节点的策略组改为 use + filter 引用提供者
+func translatePolicyToMihomo(p *RoutingPolicy, nodes []ProxyNode, providerNames []string, dataDir string) ([]ProxyGroup, map[string]RuleProvider, []string) {
+	nodeNames := make([]string, 0, len(nodes))
+	manualNames := make([]string, 0)
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+		if node.IsManual {
+			manualNames = append(manualNames, node.Name)
+		}
+	}
+
+	groups := make([]ProxyGroup, 0, len(p.Groups))
+	for _, g := range p.resolveGroups(nodeNames, manualNames) {
+		group := ProxyGroup{
+			Name:     g.Name,
+			Type:     g.Type,
+			Proxies:  g.members,
+			URL:      g.URL,
+			Interval: g.Interval,
+		}
+		if g.Type == "load-balance" {
+			group.Strategy = g.Strategy
+		}
+		if len(providerNames) > 0 && g.Nodes != nil && !g.Nodes.Manual && (g.Nodes.All || g.Nodes.Filter != "") {
+			// 提供者节点由核心按 filter 选取；配置内的手动节点按同一规则直接列出（不回退为全部节点）
+			group.Use = providerNames
+			group.Filter = g.Nodes.Filter
+			inline := nodeNames
+			if !g.Nodes.All {
+				inline = filterNodes(g.Nodes.Filter, nodeNames)
+			}
+			group.Proxies = append([]string{}, g.explicit...)
+			for _, name := range inline {
+				if !containsString(group.Proxies, name) {
+					group.Proxies = append(group.Proxies, name)
+				}
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	providers := make(map[string]RuleProvider)
+	for _, rs := range p.RuleSets {
+		if rs.Mihomo == nil {
+			continue
+		}
+		provider := RuleProvider{
+			Type:     "http",
+			Behavior: rs.Behavior,
+			URL:      rs.Mihomo.URL,
+			Path:     rs.Mihomo.Path,
+			Interval: rs.Interval,
+			Format:   rs.Mihomo.Format,
+		}
+		if local, ok := localRuleSetPath(dataDir, rs.Mihomo.Path); ok || rs.Mihomo.URL == "" {
+			provider.Type = "file"
+			provider.URL = ""
+			provider.Interval = 0
+			if ok {
+				provider.Path = local
+			}
+		}
+		providers[rs.Name] = provider
+	}
+
+	enabled := make(map[string]bool)
+	for _, g := range groups {
+		enabled[g.Name] = true
+	}
+	rules := make([]string, 0, len(p.Rules))
+	for _, r := range p.effectiveRules() {
+		ruleType := strings.ToUpper(r.Type)
+		if ruleType == "RULE-SET" {
+			if _, ok := providers[r.Payload]; !ok {
+				fmt.Printf("⚠️ 规则集 %s 没有 mihomo 来源，跳过规则\n", r.Payload)
+				continue
+			}
+		}
+		target := r.Target
+		if !enabled[target] && target != PolicyDirect && target != PolicyReject {
+			continue
+		}
+		switch {
+		case ruleType == "MATCH":
+			rules = append(rules, ruleType+","+target)
+		case r.NoResolve:
+			rules = append(rules, ruleType+","+r.Payload+","+target+",no-resolve")
+		default:
+			rules = append(rules, ruleType+","+r.Payload+","+target)
+		}
+	}
+	return groups, providers, rules
+}
+
+// applyPolicyMihomoDNS 应用 DNS 策略
+func applyPolicyMihomoDNS(dns *DNSConfig, policy PolicyDNS) {
+	if dns == nil {
+		return
+	}
+	if len(policy.Nameservers) > 0 {
+		dns.Nameserver = policy.Nameservers
+	}
+	direct := dns.DirectNameserver
+	if len(policy.DirectNameservers) > 0 {
+		direct = policy.DirectNameservers
+		dns.DirectNameserver = direct
+		for key := range dns.NameserverPolicy {
+			dns.NameserverPolicy[key] = direct
+		}
+	}
+	if len(policy.DirectRuleSets) > 0 && dns.NameserverPolicy == nil {
+		dns.NameserverPolicy = make(map[string][]string)
+	}
+	for _, name := range policy.DirectRuleSets {
+		dns.NameserverPolicy["rule-set:"+name] = direct
+	}
+}
+
+// ============================================================================
+// sing-box
+// ============================================================================
+
+// singBoxOutboundName 将内置出口名称转换为 sing-box 出站标签
+func singBoxOutboundName(name string) string {
+	switch name {
+	case PolicyDirect:
+		return "direct"
+	case PolicyReject:
+		return "block"
+	}
+	return name
+}
+
+// singBoxGroupType 策略组类型映射（sing-box 仅有 selector 与 urltest，load-balance 以最接近的 urltest 代替）
+func singBoxGroupType(groupType string) string {
+	if groupType == "select" {
+		return "selector"
+	}
+	// url-test / fallback 以 urltest 实现（load-balance 在翻译时拒绝）
+	return "urltest"
+}
+
+// singBoxGeoRuleSet 为 GEOSITE / GEOIP 规则生成 sing-box 规则集（优先本地文件）
+func singBoxGeoRuleSet(tag string) SBRuleSet {
+	if dir := GetSingBoxRulesetDir(); dir != "" {
+		localPath := filepath.Join(dir, tag+".srs")
+		if fileExists(localPath) {
+			return SBRuleSet{Tag: tag, Type: "local", Format: "binary", Path: localPath}
+		}
+	}
+	return singBoxRemoteGeoRuleSet(tag)
+}
+
+// singBoxRemoteGeoRuleSet GEOSITE / GEOIP 规则集的远程来源
+func singBoxRemoteGeoRuleSet(tag string) SBRuleSet {
+	base := OfficialRuleSetBaseURL
+	if strings.HasPrefix(tag, "geoip-") {
+		base = "https://raw.githubusercontent.com/SagerNet/sing-geoip/rule-set"
+	}
+	return SBRuleSet{Tag: tag, Type: "remote", Format: "binary", URL: base + "/" + tag + ".srs"}
+}
+
+// policyRuleToSingBox 将单条规则翻译为 sing-box 路由规则，返回引用的 GEO 规则集标签
+func policyRuleToSingBox(r PolicyRule) (SBRouteRule, string, error) {
+	var rule SBRouteRule
+	var geoTag string
+	values := []string{r.Payload}
+
+	switch strings.ToUpper(r.Type) {
+	case "DOMAIN":
+		rule.Domain = values
+	case "DOMAIN-SUFFIX":
+		rule.DomainSuffix = values
+	case "DOMAIN-KEYWORD":
+		rule.DomainKeyword = values
+	case "DOMAIN-REGEX":
+		rule.DomainRegex = []string{r.Payload}
+	case "IP-CIDR", "IP-CIDR6":
+		rule.IPCIDR = values
+	case "SRC-IP-CIDR":
+		rule.SourceIPCIDR = values
+	case "DST-PORT":
+		ports, ranges, err := parsePorts(r.Payload)
+		if err != nil {
+			return rule, "", err
+		}
+		if len(ports) > 0 {
+			rule.Port = ports
+		}
+		rule.PortRange = ranges
+	case "SRC-PORT":
+		ports, ranges, err := parsePorts(r.Payload)
+		if err != nil {
+			return rule, "", err
+		}
+		rule.SourcePort, rule.SourcePortRange = ports, ranges
+	case "NETWORK":
+		rule.Network = strings.ToLower(r.Payload)
+	case "PROCESS-NAME":
+		rule.ProcessName = values
+	case "GEOIP":
+		if strings.EqualFold(r.Payload, "LAN") || strings.EqualFold(r.Payload, "private") {
+			rule.IPIsPrivate = true
+		} else {
+			geoTag = "geoip-" + strings.ToLower(r.Payload)
+			rule.RuleSet = geoTag
+		}
+	case "GEOSITE":
+		geoTag = "geosite-" + strings.ToLower(r.Payload)
+		rule.RuleSet = geoTag
+	case "RULE-SET":
+		rule.RuleSet = r.Payload
+	default:
+		return rule, "", fmt.Errorf("sing-box 不支持规则类型 %s", r.Type)
+	}
+
+	switch r.Target {
+	case PolicyReject:
+		rule.Action = "reject"
+	default:
+		rule.Outbound = singBoxOutboundName(r.Target)
+	}
+	return rule, geoTag, nil
+}
+
+// parsePorts 解析端口列表（mihomo 以 / 或 , 分隔，范围写作 a-b；sing-box 范围写作 a:b）
+func parsePorts(payload string) ([]int, []string, error) {
+	var ports []int
+	var ranges []string
+	items := strings.FieldsFunc(payload, func(r rune) bool { return r == '/' || r == ',' })
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.ContainsAny(item, "-:") {
+			ranges = append(ranges, strings.Replace(item, "-", ":", 1))
+			continue
+		}
+		port, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("端口无效: %s", item)
+		}
+		ports = append(ports, port)
+	}
+	return ports, ranges, nil
+}
+
+// singBoxPolicyRouting sing-box 翻译结果
+type singBoxPolicyRouting struct {
+	Groups   []SBOutbound
+	Rules    []SBRouteRule
+	RuleSets []SBRuleSet
+	Final    string
+}
+
+// translatePolicyToSingBox 将分流策略翻译为 sing-box 代理组、路由规则与规则集
+// sing-box 没有负载均衡出站，启用的 load-balance 策略组返回错误（与 sing-box 模板校验一致）
+func translatePolicyToSingBox(p *RoutingPolicy, nodeTags, manualTags []string, dataDir string) (singBoxPolicyRouting, error) {
+	var result singBoxPolicyRouting
+
+	for _, g := range p.resolveGroups(nodeTags, manualTags) {
+		if g.Type == "load-balance" {
+			return result, fmt.Errorf("策略组 %s: sing-box 没有负载均衡出站，请改用 url-test（按延迟自动选择）或使用 mihomo 核心", g.Name)
+		}
+		outbounds := make([]string, 0, len(g.members))
+		for _, m := range g.members {
+			outbounds = append(outbounds, singBoxOutboundName(m))
+		}
+		out := SBOutbound{
+			Tag:       g.Name,
+			Type:      singBoxGroupType(g.Type),
+			Outbounds: outbounds,
+		}
+		if out.Type == "urltest" {
+			out.URL = g.URL
+			if g.Interval > 0 {
+				out.Interval = fmt.Sprintf("%ds", g.Interval)
+			}
+			out.Tolerance = g.Tolerance
+		}
+		result.Groups = append(result.Groups, out)
+	}
+
+	sets := make(map[string]bool)
+	for _, rs := range p.RuleSets {
+		if rs.SingBox == nil {
+			continue
+		}
+		set := SBRuleSet{Tag: rs.Name, Type: "remote", Format: rs.SingBox.Format, URL: rs.SingBox.URL}
+		if set.Format == "" {
+			set.Format = "binary"
+			if strings.HasSuffix(rs.SingBox.URL, ".json") || strings.HasSuffix(rs.SingBox.Path, ".json") {
+				set.Format = "source"
+			}
+		}
+		if local, ok := localRuleSetPath(dataDir, rs.SingBox.Path); ok || rs.SingBox.URL == "" {
+			set.Type = "local"
+			set.URL = ""
+			set.Path = rs.SingBox.Path
+			if ok {
+				set.Path = local
+			}
+		}
+		result.RuleSets = append(result.RuleSets, set)
+		sets[rs.Name] = true
+	}
+
+	enabled := make(map[string]bool)
+	for _, g := range result.Groups {
+		enabled[g.Tag] = true
+	}
+	for _, r := range p.effectiveRules() {
+		if r.Target != PolicyDirect && r.Target != PolicyReject && !enabled[r.Target] {
+			continue
+		}
+		if strings.EqualFold(r.Type, "MATCH") {
+			result.Final = singBoxOutboundName(r.Target)
+			// MATCH 之后的规则不会被匹配
+			break
+		}
+		if strings.EqualFold(r.Type, "RULE-SET") && !sets[r.Payload] {
+			fmt.Printf("⚠️ 规则集 %s 没有 sing-box 来源，跳过规则\n", r.Payload)
+			continue
+		}
+		rule, geoTag, err := policyRuleToSingBox(r)
+		if err != nil {
+			fmt.Printf("⚠️ %v，跳过规则\n", err)
+			continue
+		}
+		if geoTag != "" && !sets[geoTag] {
+			result.RuleSets = append(result.RuleSets, singBoxGeoRuleSet(geoTag))
+			sets[geoTag] = true
+		}
+		result.Rules = append(result.Rules, rule)
+	}
+	return result, nil
+}
+
+// policyBaseRouteRules 分流规则之前的基础规则：嗅探、DNS 劫持与 Clash 模式切换
+func policyBaseRouteRules(groups []SBOutbound) []SBRouteRule {
+	rules := []SBRouteRule{
+		{Inbound: []string{"tun-in", "mixed-in"}, Action: "sniff"},
+		{
+			Type:   "logical",
+			Mode:   "or",
+			Rules:  []SBRouteRule{{Port: 53}, {Protocol: "dns"}},
+			Action: "hijack-dns",
+		},
+		{ClashMode: "direct", Action: "direct"},
+	}
+	// global 模式走 GLOBAL 组，不存在时使用第一个手动选择组
+	global := ""
+	for _, g := range groups {
+		if g.Tag == "GLOBAL" {
+			global = g.Tag
+			break
+		}
+		if global == "" && g.Type == "selector" {
+			global = g.Tag
+		}
+	}
+	if global != "" {
+		rules = append(rules, SBRouteRule{ClashMode: "global", Outbound: global})
+	}
+	return rules
+}
+
+// ensureSingBoxRuleSets 补全 DNS 与路由规则引用但未定义的 GEO 规则集
+func ensureSingBoxRuleSets(config *SingBoxConfig) {
+	if config.Route == nil {
+		return
+	}
+	defined := make(map[string]bool)
+	for _, rs := range config.Route.RuleSet {
+		defined[rs.Tag] = true
+	}
+	add := func(ref interface{}) {
+		var tags []string
+		switch v := ref.(type) {
+		case string:
+			tags = []string{v}
+		case []string:
+			tags = v
+		}
+		for _, tag := range tags {
+			if defined[tag] || !(strings.HasPrefix(tag, "geosite-") || strings.HasPrefix(tag, "geoip-")) {
+				continue
+			}
+			config.Route.RuleSet = append(config.Route.RuleSet, singBoxGeoRuleSet(tag))
+			defined[tag] = true
+		}
+	}
+
+	var walkDNS func(rules []SBDNSRule)
+	walkDNS = func(rules []SBDNSRule) {
+		for _, r := range rules {
+			add(r.RuleSet)
+			walkDNS(r.Rules)
+		}
+	}
+	var walkRoute func(rules []SBRouteRule)
+	walkRoute = func(rules []SBRouteRule) {
+		for _, r := range rules {
+			add(r.RuleSet)
+			walkRoute(r.Rules)
+		}
+	}
+	if config.DNS != nil {
+		walkDNS(config.DNS.Rules)
+	}
+	walkRoute(config.Route.Rules)
+}
+
+// applyPolicySingBoxDNS 应用 DNS 策略（替换默认海外 / 国内 DNS 服务器地址）
+func applyPolicySingBoxDNS(dns *SBDNS, policy PolicyDNS) {
+	if dns == nil {
+		return
+	}
+	directTag := ""
+	for i := range dns.Servers {
+		server := &dns.Servers[i]
+		switch server.Tag {
+		case "google", "proxyDns":
+			if len(policy.Nameservers) > 0 {
+				setSingBoxDNSServer(server, policy.Nameservers[0])
+			}
+		case "local", "localDns":
+			directTag = server.Tag
+			if len(policy.DirectNameservers) > 0 {
+				setSingBoxDNSServer(server, policy.DirectNameservers[0])
+			}
+		}
+	}
+	if directTag != "" && len(policy.DirectRuleSets) > 0 {
+		dns.Rules = append([]SBDNSRule{{
+			RuleSet: policy.DirectRuleSets,
+			Server:  directTag,
+		}}, dns.Rules...)
+	}
+}
+
+// setSingBoxDNSServer 解析 DNS 地址（https://、tls://、quic://、h3://、udp://、tcp://、dhcp:// 或纯 IP）
+func setSingBoxDNSServer(server *SBDNSServer, address string) {
+	server.Type = "udp"
+	server.Server = address
+	server.ServerPort = 0
+	if address == "system" || address == "system://" {
+		server.Type = "local"
+		server.Server = ""
+		return
+	}
+	if !strings.Contains(address, "://") {
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			server.Server = host
+			server.ServerPort, _ = strconv.Atoi(port)
+		}
+		return
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return
+	}
+	server.Type = u.Scheme
+	if server.Type == "http3" {
+		server.Type = "h3"
+	}
+	if server.Type == "dhcp" {
+		// dhcp://网卡名，system / auto 表示默认网卡
+		server.Server = ""
+		if name := u.Hostname(); name != "system" && name != "auto" {
+			server.Interface = name
+		}
+		return
+	}
+	server.Server = u.Hostname()
+	if port := u.Port(); port != "" {
+		server.ServerPort, _ = strconv.Atoi(port)
+	}
+	if (server.Type == "https" || server.Type == "h3") && u.Path != "" && u.Path != "/dns-query" {
+		server.Path = u.Path
+	}
+}
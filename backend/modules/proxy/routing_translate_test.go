@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testRequest 模拟一次请求
+type testRequest struct {
+	name    string
+	domain  string
+	ip      string
+	port    int
+	network string
+	process string
+}
+
+// testPolicy 覆盖主要规则类型、规则块、未启用分组与规则集的分流策略
+func testPolicy() *RoutingPolicy {
+	return &RoutingPolicy{
+		Groups: []PolicyGroup{
+			{Name: "Proxy", Type: "select", Enabled: true, Members: []string{"Auto", PolicyDirect}, Nodes: &NodeSelector{All: true}},
+			{Name: "Auto", Type: "url-test", Enabled: true, Nodes: &NodeSelector{Filter: "HK"}, URL: providerHealthCheckURL, Interval: 300},
+			{Name: "Streaming", Type: "select", Enabled: true, Members: []string{"Proxy", "Disabled"}, Nodes: &NodeSelector{Manual: true}},
+			{Name: "Disabled", Type: "select", Enabled: false, Nodes: &NodeSelector{All: true}},
+		},
+		RuleBlocks: []PolicyRuleBlock{
+			{Name: "ads", Enabled: true, Rules: []PolicyRule{{Type: "DOMAIN-SUFFIX", Payload: "ads.example", Target: PolicyReject}}},
+			{Name: "off", Enabled: false, Rules: []PolicyRule{{Type: "DOMAIN-SUFFIX", Payload: "google.com", Target: PolicyReject}}},
+		},
+		Rules: []PolicyRule{
+			{Type: "DOMAIN", Payload: "exact.example", Target: PolicyDirect},
+			{Type: "DOMAIN-SUFFIX", Payload: "google.com", Target: "Proxy"},
+			{Type: "DOMAIN-KEYWORD", Payload: "netflix", Target: "Streaming"},
+			{Type: "DOMAIN", Payload: "disabled.example", Target: "Disabled"},
+			{Type: "IP-CIDR", Payload: "1.1.1.0/24", Target: "Auto", NoResolve: true},
+			{Type: "GEOIP", Payload: "LAN", Target: PolicyDirect},
+			{Type: "DST-PORT", Payload: "22/6000-6010", Target: PolicyDirect},
+			{Type: "PROCESS-NAME", Payload: "steam", Target: PolicyDirect},
+			{Type: "NETWORK", Payload: "UDP", Target: PolicyReject},
+			{Type: "RULE-SET", Payload: "streaming", Target: "Streaming"},
+			{Type: "GEOSITE", Payload: "CN", Target: PolicyDirect},
+			{Type: "GEOIP", Payload: "CN", Target: PolicyDirect},
+			{Type: "MATCH", Target: "Proxy"},
+		},
+		RuleSets: []PolicyRuleSet{
+			{
+				Name:     "streaming",
+				Behavior: "domain",
+				Mihomo:   &RuleSetSource{Path: "ruleset/streaming.yaml", Format: "yaml"},
+				SingBox:  &RuleSetSource{Path: "ruleset/streaming.json", Format: "source"},
+			},
+		},
+	}
+}
+
+func testNodes() []ProxyNode {
+	return []ProxyNode{
+		{Name: "HK-01"},
+		{Name: "JP-01"},
+		{Name: "Home", IsManual: true},
+	}
+}
+
+// writeTestRuleSets 写入 streaming 规则集的本地文件（两种核心格式内容相同）
+func writeTestRuleSets(t *testing.T, dataDir string) {
+	t.Helper()
+	files := map[string]string{
+		"ruleset/streaming.yaml": "payload:\n  - '+.video.example'\n",
+		"ruleset/streaming.json": `{"version":1,"rules":[{"domain_suffix":["video.example"]}]}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dataDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testSimulator 创建路由模拟器：不解析域名，GEO 数据预先填入缓存（geosite:cn = baidu.com，geoip:cn = 114.114.114.0/24）
+func testSimulator(t *testing.T, dataDir string, req testRequest) *routeSimulator {
+	t.Helper()
+	sim, err := newRouteSimulator(dataDir, RouteTestRequest{
+		Domain:  req.domain,
+		IP:      req.ip,
+		Port:    req.port,
+		Network: req.network,
+		Process: req.process,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.mode = "rule"
+	sim.resolved = true
+
+	site := newDomainSet()
+	site.suffix = append(site.suffix, "baidu.com")
+	sim.geo.sites["cn"] = site
+	_, cn, _ := net.ParseCIDR("114.114.114.0/24")
+	sim.geo.ips["cn"] = []*net.IPNet{cn}
+	return sim
+}
+
+// simulateBothCores 使用生产环境的路由模拟器分别评估两种核心的配置，返回命中的策略
+func simulateBothCores(t *testing.T, dataDir string, mihomo *MihomoConfig, singBox *SingBoxConfig, req testRequest) (string, string) {
+	t.Helper()
+	results := make([]*RouteTestResult, 2)
+	for i := range results {
+		results[i] = &RouteTestResult{RuleIndex: -1}
+		sim := testSimulator(t, dataDir, req)
+		if i == 0 {
+			sim.evalMihomo(mihomo, results[i])
+		} else {
+			sim.evalSingBox(singBox, results[i])
+		}
+		if len(results[i].Skipped) > 0 {
+			t.Fatalf("规则无法评估: %+v", results[i].Skipped)
+		}
+	}
+	return results[0].Policy, policyFromSingBox(results[1].Policy)
+}
+
+func policyFromSingBox(outbound string) string {
+	switch outbound {
+	case "direct":
+		return PolicyDirect
+	case "block":
+		return PolicyReject
+	}
+	return outbound
+}
+
+func TestRoutingPolicyEquivalentOnBothCores(t *testing.T) {
+	dataDir := t.TempDir()
+	writeTestRuleSets(t, dataDir)
+	policy := testPolicy()
+
+	// 与生成器相同的翻译结果：mihomo 规则与提供者；sing-box 基础规则 + 策略规则、规则集与 final
+	_, providers, rules := translatePolicyToMihomo(policy, testNodes(), nil, dataDir)
+	mihomoConfig := &MihomoConfig{Mode: "rule", Rules: rules, RuleProviders: providers}
+	routing, err := translatePolicyToSingBox(policy, []string{"HK-01", "JP-01", "Home"}, []string{"Home"}, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	singBoxConfig := &SingBoxConfig{Route: &SBRoute{
+		Rules:   append(policyBaseRouteRules(routing.Groups), routing.Rules...),
+		RuleSet: routing.RuleSets,
+		Final:   routing.Final,
+	}}
+
+	tests := []testRequest{
+		{name: "规则块拒绝广告", domain: "x.ads.example", port: 443, network: "tcp"},
+		{name: "未启用规则块不生效", domain: "www.google.com", port: 443, network: "tcp"},
+		{name: "完整域名", domain: "exact.example", port: 443, network: "tcp"},
+		{name: "完整域名不匹配子域", domain: "a.exact.example", port: 443, network: "tcp"},
+		{name: "域名关键字", domain: "www.netflix.com", port: 443, network: "tcp"},
+		{name: "指向未启用分组的规则被跳过", domain: "disabled.example", port: 443, network: "tcp"},
+		{name: "IP 段", ip: "1.1.1.1", port: 443, network: "tcp"},
+		{name: "局域网地址", ip: "192.168.1.10", port: 443, network: "tcp"},
+		{name: "单个端口", ip: "8.8.8.8", port: 22, network: "tcp"},
+		{name: "端口范围", ip: "8.8.8.8", port: 6005, network: "tcp"},
+		{name: "进程名", domain: "cdn.example", port: 443, network: "tcp", process: "steam"},
+		{name: "网络类型", domain: "quic.example", port: 443, network: "udp"},
+		{name: "规则集", domain: "www.video.example", port: 443, network: "tcp"},
+		{name: "GEOSITE", domain: "www.baidu.com", port: 443, network: "tcp"},
+		{name: "GEOIP", ip: "114.114.114.114", port: 443, network: "tcp"},
+		{name: "兜底规则", domain: "unknown.example", port: 443, network: "tcp"},
+	}
+	want := map[string]string{
+		"规则块拒绝广告":       PolicyReject,
+		"未启用规则块不生效":     "Proxy",
+		"完整域名":          PolicyDirect,
+		"完整域名不匹配子域":     "Proxy",
+		"域名关键字":         "Streaming",
+		"指向未启用分组的规则被跳过": "Proxy",
+		"IP 段":    "Auto",
+		"局域网地址":   PolicyDirect,
+		"单个端口":    PolicyDirect,
+		"端口范围":    PolicyDirect,
+		"进程名":     PolicyDirect,
+		"网络类型":    PolicyReject,
+		"规则集":     "Streaming",
+		"GEOSITE": PolicyDirect,
+		"GEOIP":   PolicyDirect,
+		"兜底规则":    "Proxy",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mihomo, singBox := simulateBothCores(t, dataDir, mihomoConfig, singBoxConfig, tt)
+			if mihomo != singBox {
+				t.Fatalf("两种核心结果不一致: mihomo=%s sing-box=%s", mihomo, singBox)
+			}
+			if mihomo != want[tt.name] {
+				t.Fatalf("命中 %s，期望 %s", mihomo, want[tt.name])
+			}
+		})
+	}
+}
+
+func TestRoutingPolicyGroupsEquivalentOnBothCores(t *testing.T) {
+	policy := testPolicy()
+	groups, _, _ := translatePolicyToMihomo(policy, testNodes(), nil, t.TempDir())
+	routing, err := translatePolicyToSingBox(policy, []string{"HK-01", "JP-01", "Home"}, []string{"Home"}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mihomo := make(map[string][]string)
+	for _, g := range groups {
+		mihomo[g.Name] = g.Proxies
+	}
+	singBox := make(map[string][]string)
+	for _, g := range routing.Groups {
+		members := make([]string, 0, len(g.Outbounds))
+		for _, m := range g.Outbounds {
+			members = append(members, policyFromSingBox(m))
+		}
+		singBox[g.Tag] = members
+	}
+
+	want := map[string][]string{
+		"Proxy":     {"Auto", PolicyDirect, "HK-01", "JP-01", "Home"},
+		"Auto":      {"HK-01"},
+		"Streaming": {"Proxy", "Home"},
+	}
+	if !reflect.DeepEqual(mihomo, want) {
+		t.Fatalf("mihomo 策略组 = %v，期望 %v", mihomo, want)
+	}
+	if !reflect.DeepEqual(singBox, want) {
+		t.Fatalf("sing-box 策略组 = %v，期望 %v", singBox, want)
+	}
+}
+
+func TestMigrateRoutingPolicy(t *testing.T) {
+	googleURL := "https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@meta/geo/geosite/google.mrs"
+	cnURL := "https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@meta/geo/geoip/cn.mrs"
+	tpl := &ConfigTemplate{
+		ProxyGroups: []ProxyGroupTemplate{
+			{Name: "Proxy", Type: "select", UseAll: true},
+			{Name: "Manual", Type: "select", UseAll: true, Filter: "__MANUAL__", Description: "手动节点", Enabled: true},
+			{Name: "HK", Type: "url-test", UseAll: true, Filter: "HK|香港", Description: "香港", Enabled: false},
+			{Name: "Final", Type: "select", Proxies: []string{"Proxy", "DIRECT"}},
+		},
+		Rules: []RuleTemplate{
+			{Type: "RULE-SET", Payload: "google", Proxy: "Proxy"},
+			{Type: "IP-CIDR", Payload: "10.0.0.0/8", Proxy: "DIRECT", NoResolve: true},
+			{Type: "MATCH", Proxy: "Final"},
+		},
+		RuleProviders: []RuleProviderTemplate{
+			{Name: "google", Type: "http", Behavior: "domain", URL: googleURL, Format: "mrs", Interval: 86400},
+			{Name: "cn-ip", Type: "http", Behavior: "ipcidr", URL: cnURL, Format: "mrs"},
+			{Name: "ai-domain", Type: "http", Behavior: "domain", URL: "https://example.com/ai.mrs"},
+			{Name: "custom", Type: "file", Behavior: "classical", Path: "./rules/custom.yaml"},
+		},
+	}
+	sb := &SingBoxTemplate{
+		ProxyGroups: []SingBoxProxyGroupTemplate{
+			{Tag: "Proxy", Type: "selector", Enabled: true},
+			{Tag: "Gaming", Type: "selector", Name: "游戏", Enabled: true, Outbounds: []string{"Proxy", "direct", "node-1"}},
+			{Tag: "LB", Type: "loadbalance", Enabled: true},
+		},
+		Rules: []SingBoxRuleTemplate{
+			{RuleSet: "geosite-google", Outbound: "Proxy"},
+			{DomainSuffix: []string{"steampowered.com", ".steamcontent.com"}, Outbound: "Gaming"},
+			{IPCIDR: []string{"2001:db8::/32"}, Outbound: "block"},
+			{PortRange: []string{"27000:27100"}, Outbound: "Gaming"},
+			{Domain: []string{"a.example"}, Network: "udp", Outbound: "Proxy"},
+			{Type: "logical", Mode: "and", Rules: []SingBoxRuleTemplate{{Domain: []string{"b.example"}}}, Outbound: "Proxy"},
+			{Domain: []string{"c.example"}, Outbound: "Missing"},
+		},
+		RuleSets: []SingBoxRuleSetTemplate{
+			{Tag: "geosite-google", Type: "remote", Format: "binary", URL: "https://mirror.example/google.srs"},
+		},
+		CustomRules: []SingBoxRuleTemplate{
+			{ClashMode: "direct", Outbound: "direct"},
+		},
+	}
+
+	policy, report := MigrateRoutingPolicy(tpl, sb)
+
+	wantGroups := []PolicyGroup{
+		{Name: "Proxy", Type: "select", Enabled: true, Nodes: &NodeSelector{All: true}},
+		{Name: "Manual", Type: "select", Description: "手动节点", Enabled: true, Nodes: &NodeSelector{Manual: true}},
+		{Name: "HK", Type: "url-test", Description: "香港", Enabled: false, Nodes: &NodeSelector{Filter: "HK|香港"}},
+		{Name: "Final", Type: "select", Enabled: true, Members: []string{"Proxy", "DIRECT"}},
+		{Name: "Gaming", Type: "select", Description: "游戏", Enabled: true, Members: []string{"Proxy", "DIRECT"}},
+	}
+	if !reflect.DeepEqual(policy.Groups, wantGroups) {
+		t.Fatalf("策略组 = %+v，期望 %+v", policy.Groups, wantGroups)
+	}
+
+	// sing-box 规则翻译后插入在 MATCH 之前，已存在的规则不重复添加
+	wantRules := []PolicyRule{
+		{Type: "RULE-SET", Payload: "google", Target: "Proxy"},
+		{Type: "IP-CIDR", Payload: "10.0.0.0/8", Target: "DIRECT", NoResolve: true},
+		{Type: "DOMAIN-SUFFIX", Payload: "steampowered.com", Target: "Gaming"},
+		{Type: "DOMAIN-SUFFIX", Payload: "steamcontent.com", Target: "Gaming"},
+		{Type: "IP-CIDR6", Payload: "2001:db8::/32", Target: "REJECT"},
+		{Type: "DST-PORT", Payload: "27000-27100", Target: "Gaming"},
+		{Type: "MATCH", Target: "Final"},
+	}
+	if !reflect.DeepEqual(policy.Rules, wantRules) {
+		t.Fatalf("规则 = %+v，期望 %+v", policy.Rules, wantRules)
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("迁移结果无效: %v", err)
+	}
+
+	// 无法翻译的条目全部列入报告
+	if report.TranslatedGroups != 1 || report.TranslatedRules != 4 {
+		t.Fatalf("翻译数量 = %d 组 / %d 条规则，期望 1 / 4", report.TranslatedGroups, report.TranslatedRules)
+	}
+	var issues []string
+	for _, issue := range report.Issues {
+		issues = append(issues, issue.Source)
+	}
+	wantIssues := []string{
+		"singbox.proxyGroups[2]",
+		"singbox.proxyGroups[1]",
+		"singbox.rules[4]",
+		"singbox.rules[5]",
+		"singbox.rules[6]",
+		"singbox.customRules[0]",
+	}
+	if !reflect.DeepEqual(issues, wantIssues) {
+		t.Fatalf("迁移报告 = %v，期望 %v", issues, wantIssues)
+	}
+
+	sources := []struct {
+		name    string
+		mihomo  *RuleSetSource
+		singBox *RuleSetSource
+	}{
+		{
+			name:    "google",
+			mihomo:  &RuleSetSource{URL: googleURL, Format: "mrs"},
+			singBox: &RuleSetSource{URL: "https://mirror.example/google.srs", Format: "binary"},
+		},
+		{
+			name:   "cn-ip",
+			mihomo: &RuleSetSource{URL: cnURL, Format: "mrs"},
+			singBox: &RuleSetSource{
+				URL:    "https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@sing/geo/geoip/cn.srs",
+				Path:   SingBoxRulesetSubDir + "/geoip-cn.srs",
+				Format: "binary",
+			},
+		},
+		{
+			name:   "ai-domain",
+			mihomo: &RuleSetSource{URL: "https://example.com/ai.mrs"},
+			singBox: &RuleSetSource{
+				URL:    "https://testingcf.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@sing/geo/geosite/category-ai-!cn.srs",
+				Path:   SingBoxRulesetSubDir + "/geosite-category-ai-!cn.srs",
+				Format: "binary",
+			},
+		},
+		{
+			name:   "custom",
+			mihomo: &RuleSetSource{Path: "./rules/custom.yaml"},
+		},
+	}
+	if len(policy.RuleSets) != len(sources) {
+		t.Fatalf("规则集数量 = %d，期望 %d", len(policy.RuleSets), len(sources))
+	}
+	for i, tt := range sources {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := policy.RuleSets[i]
+			if rs.Name != tt.name {
+				t.Fatalf("规则集名称 = %s，期望 %s", rs.Name, tt.name)
+			}
+			if !reflect.DeepEqual(rs.Mihomo, tt.mihomo) {
+				t.Fatalf("mihomo 来源 = %+v，期望 %+v", rs.Mihomo, tt.mihomo)
+			}
+			if !reflect.DeepEqual(rs.SingBox, tt.singBox) {
+				t.Fatalf("sing-box 来源 = %+v，期望 %+v", rs.SingBox, tt.singBox)
+			}
+		})
+	}
+}
+
+func TestRoutingPolicyConfigTemplateRoundTrip(t *testing.T) {
+	policy, report := MigrateRoutingPolicy(GetDefaultConfigTemplate(), GetDefaultSingBoxTemplate())
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("默认模板迁移结果无效: %v", err)
+	}
+	if len(report.Issues) > 0 || report.TranslatedGroups > 0 || report.TranslatedRules > 0 {
+		t.Fatalf("默认 sing-box 模板不应产生迁移条目: %+v", report)
+	}
+
+	again, _ := MigrateRoutingPolicy(policy.ToConfigTemplate(), nil)
+	if !reflect.DeepEqual(again.Groups, policy.Groups) {
+		t.Fatalf("策略组往返后不一致")
+	}
+	if !reflect.DeepEqual(again.Rules, policy.Rules) {
+		t.Fatalf("规则往返后不一致")
+	}
+	if len(again.RuleSets) != len(policy.RuleSets) {
+		t.Fatalf("规则集数量往返后不一致: %d != %d", len(again.RuleSets), len(policy.RuleSets))
+	}
+	for i := range policy.RuleSets {
+		if !reflect.DeepEqual(again.RuleSets[i].Mihomo, policy.RuleSets[i].Mihomo) {
+			t.Fatalf("规则集 %s 的 mihomo 来源往返后不一致", policy.RuleSets[i].Name)
+		}
+	}
+}
+
+func TestRoutingPolicyLoadBalanceRejectedOnSingBox(t *testing.T) {
+	policy := testPolicy()
+	policy.Groups = append(policy.Groups, PolicyGroup{
+		Name: "Balance", Type: "load-balance", Enabled: true, Strategy: LoadBalanceRoundRobin, Nodes: &NodeSelector{All: true},
+	})
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("load-balance 策略组应可用于 mihomo: %v", err)
+	}
+	if _, err := translatePolicyToSingBox(policy, []string{"HK-01"}, nil, t.TempDir()); err == nil {
+		t.Fatal("sing-box 应拒绝 load-balance 策略组")
+	}
+	if err := (&SingBoxTemplate{ProxyGroups: []SingBoxProxyGroupTemplate{{Tag: "Balance", Type: "load-balance"}}}).Validate(); err == nil {
+		t.Fatal("sing-box 模板应拒绝 load-balance 代理组")
+	}
+}
@@ -0,0 +1,552 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============================================================================
+// 定时策略：按 cron 表达式切换代理模式、选择节点、启停规则块
+// ============================================================================
+
+// 定时动作类型
+const (
+	ScheduleActionMode         = "mode"          // 切换 ProxyConfig.Mode
+	ScheduleActionSelect       = "select"        // 通过核心 API 选择节点
+	ScheduleActionEnableBlock  = "enable-block"  // 启用规则块
+	ScheduleActionDisableBlock = "disable-block" // 停用规则块
+)
+
+const scheduleEventLimit = 500 // 保留的事件数
+
+// ScheduleAction 定时动作
+type ScheduleAction struct {
+	Type  string `json:"type"`
+	Mode  string `json:"mode,omitempty"`  // mode: rule, global, direct
+	Group string `json:"group,omitempty"` // select: 选择组
+	Node  string `json:"node,omitempty"`  // select: 节点或策略组
+	Block string `json:"block,omitempty"` // enable-block / disable-block: 规则块名称
+}
+
+// Schedule 定时任务
+type Schedule struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Description   string           `json:"description,omitempty"`
+	Enabled       bool             `json:"enabled"`
+	Cron          string           `json:"cron"` // 分 时 日 月 周，或 @hourly / @daily / @weekly / @monthly
+	Actions       []ScheduleAction `json:"actions"`
+	OverrideUntil *time.Time       `json:"overrideUntil,omitempty"` // 手动覆盖：此时间之前不执行
+	LastRun       *time.Time       `json:"lastRun,omitempty"`
+}
+
+// ScheduleEvent 定时任务执行记录
+type ScheduleEvent struct {
+	Time         time.Time `json:"time"`
+	ScheduleID   string    `json:"scheduleId"`
+	ScheduleName string    `json:"scheduleName"`
+	Action       string    `json:"action"`
+	Success      bool      `json:"success"`
+	Message      string    `json:"message,omitempty"`
+	Manual       bool      `json:"manual"`
+}
+
+// ScheduleState 定时任务列表与全局覆盖
+type ScheduleState struct {
+	Schedules     []Schedule `json:"schedules"`
+	OverrideUntil *time.Time `json:"overrideUntil,omitempty"` // 全局覆盖：此时间之前所有任务暂停
+}
+
+// ScheduleView 定时任务及下次执行时间
+type ScheduleView struct {
+	Schedule
+	NextRun *time.Time `json:"nextRun,omitempty"`
+}
+
+// Scheduler 定时任务引擎
+type Scheduler struct {
+	service *Service
+	dataDir string
+	state   ScheduleState
+	specs   map[string]*cronSpec
+	events  []ScheduleEvent
+	mu      sync.RWMutex
+	runMu   sync.Mutex // 串行执行任务
+}
+
+// NewScheduler 创建定时任务引擎并加载任务与事件记录
+func NewScheduler(service *Service) *Scheduler {
+	sc := &Scheduler{
+		service: service,
+		dataDir: service.dataDir,
+		state:   ScheduleState{Schedules: []Schedule{}},
+		specs:   make(map[string]*cronSpec),
+		events:  []ScheduleEvent{},
+	}
+	if data, err := os.ReadFile(sc.statePath()); err == nil {
+		if err := json.Unmarshal(data, &sc.state); err != nil {
+			fmt.Printf("⚠️ 加载定时任务失败: %v\n", err)
+		}
+	}
+	if data, err := os.ReadFile(sc.eventsPath()); err == nil {
+		json.Unmarshal(data, &sc.events)
+	}
+	for _, sch := range sc.state.Schedules {
+		if spec, err := parseCron(sch.Cron); err == nil {
+			sc.specs[sch.ID] = spec
+		}
+	}
+	return sc
+}
+
+func (sc *Scheduler) statePath() string {
+	return filepath.Join(sc.dataDir, "schedules.json")
+}
+
+func (sc *Scheduler) eventsPath() string {
+	return filepath.Join(sc.dataDir, "schedule_events.json")
+}
+
+// saveState 保存任务列表（调用者需持有写锁）
+func (sc *Scheduler) saveState() error {
+	data, err := json.MarshalIndent(sc.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sc.statePath(), data, 0644)
+}
+
+// Run 按分钟检查并执行到期任务（阻塞，需在 goroutine 中调用）
+func (sc *Scheduler) Run() {
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(time.Until(next))
+		sc.tick(next)
+	}
+}
+
+// tick 执行 t 时刻到期的任务
+func (sc *Scheduler) tick(t time.Time) {
+	sc.mu.Lock()
+	globalOverride := sc.state.OverrideUntil
+	due := make([]Schedule, 0)
+	for _, sch := range sc.state.Schedules {
+		spec := sc.specs[sch.ID]
+		if !sch.Enabled || spec == nil || !spec.Match(t) {
+			continue
+		}
+		if globalOverride != nil && t.Before(*globalOverride) {
+			sc.recordLocked(sch, "skip", true, fmt.Sprintf("全局覆盖至 %s", globalOverride.Format("2006-01-02 15:04")), false)
+			continue
+		}
+		if sch.OverrideUntil != nil && t.Before(*sch.OverrideUntil) {
+			sc.recordLocked(sch, "skip", true, fmt.Sprintf("手动覆盖至 %s", sch.OverrideUntil.Format("2006-01-02 15:04")), false)
+			continue
+		}
+		due = append(due, sch)
+	}
+	sc.mu.Unlock()
+
+	for _, sch := range due {
+		sc.execute(sch, false)
+	}
+}
+
+// execute 执行任务动作：先修改模式与规则块并热重载，再通过核心 API 选择节点
+func (sc *Scheduler) execute(sch Schedule, manual bool) []ScheduleEvent {
+	sc.runMu.Lock()
+	defer sc.runMu.Unlock()
+
+	fmt.Printf("🔄 执行定时任务: %s\n", sch.Name)
+	events := make([]ScheduleEvent, 0, len(sch.Actions)+1)
+	needReload := false
+	selects := make([]ScheduleAction, 0)
+	for _, action := range sch.Actions {
+		var err error
+		desc := describeScheduleAction(action)
+		switch action.Type {
+		case ScheduleActionMode:
+			if err = sc.service.SetMode(action.Mode); err == nil {
+				needReload = true
+			}
+		case ScheduleActionEnableBlock, ScheduleActionDisableBlock:
+			if err = sc.service.SetRuleBlockEnabled(action.Block, action.Type == ScheduleActionEnableBlock); err == nil {
+				needReload = true
+			}
+		case ScheduleActionSelect:
+			selects = append(selects, action)
+			continue
+		default:
+			err = fmt.Errorf("未知动作类型: %s", action.Type)
+		}
+		events = append(events, sc.record(sch, desc, err, manual))
+	}
+
+	running := sc.service.GetStatus().Running
+	if needReload && running {
+		result, err := sc.service.Reload()
+		desc := "reload"
+		if result != nil {
+			desc = "reload (" + result.Method + ")"
+		}
+		events = append(events, sc.record(sch, desc, err, manual))
+	}
+	for _, action := range selects {
+		var err error
+		if !running {
+			err = fmt.Errorf("核心未运行")
+		} else {
+			err = sc.service.selectProxy(action.Group, action.Node)
+		}
+		events = append(events, sc.record(sch, describeScheduleAction(action), err, manual))
+	}
+
+	now := time.Now()
+	sc.mu.Lock()
+	for i := range sc.state.Schedules {
+		if sc.state.Schedules[i].ID == sch.ID {
+			sc.state.Schedules[i].LastRun = &now
+		}
+	}
+	sc.saveState()
+	sc.mu.Unlock()
+	return events
+}
+
+// describeScheduleAction 动作描述（用于事件记录）
+func describeScheduleAction(a ScheduleAction) string {
+	switch a.Type {
+	case ScheduleActionMode:
+		return "mode=" + a.Mode
+	case ScheduleActionSelect:
+		return fmt.Sprintf("select %s -> %s", a.Group, a.Node)
+	case ScheduleActionEnableBlock:
+		return "enable-block " + a.Block
+	case ScheduleActionDisableBlock:
+		return "disable-block " + a.Block
+	}
+	return a.Type
+}
+
+// record 记录动作执行结果
+func (sc *Scheduler) record(sch Schedule, action string, err error, manual bool) ScheduleEvent {
+	message := ""
+	if err != nil {
+		message = err.Error()
+		fmt.Printf("⚠️ 定时任务 %s 执行 %s 失败: %v\n", sch.Name, action, err)
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.recordLocked(sch, action, err == nil, message, manual)
+}
+
+// recordLocked 追加事件并持久化（调用者需持有锁）
+func (sc *Scheduler) recordLocked(sch Schedule, action string, success bool, message string, manual bool) ScheduleEvent {
+	event := ScheduleEvent{
+		Time:         time.Now(),
+		ScheduleID:   sch.ID,
+		ScheduleName: sch.Name,
+		Action:       action,
+		Success:      success,
+		Message:      message,
+		Manual:       manual,
+	}
+	sc.events = append(sc.events, event)
+	if len(sc.events) > scheduleEventLimit {
+		sc.events = sc.events[len(sc.events)-scheduleEventLimit:]
+	}
+	if data, err := json.Marshal(sc.events); err == nil {
+		os.WriteFile(sc.eventsPath(), data, 0644)
+	}
+	return event
+}
+
+// Get 获取任务列表、全局覆盖与下次执行时间
+func (sc *Scheduler) Get() ([]ScheduleView, *time.Time) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	now := time.Now()
+	views := make([]ScheduleView, 0, len(sc.state.Schedules))
+	for _, sch := range sc.state.Schedules {
+		view := ScheduleView{Schedule: sch}
+		if spec := sc.specs[sch.ID]; spec != nil && sch.Enabled {
+			if next, ok := spec.Next(now); ok {
+				view.NextRun = &next
+			}
+		}
+		views = append(views, view)
+	}
+	return views, sc.state.OverrideUntil
+}
+
+// Update 校验并替换任务列表
+func (sc *Scheduler) Update(schedules []Schedule) error {
+	blocks := make(map[string]bool)
+	if policy := sc.service.GetRoutingPolicy(); policy != nil {
+		for _, b := range policy.RuleBlocks {
+			blocks[b.Name] = true
+		}
+	}
+
+	specs := make(map[string]*cronSpec, len(schedules))
+	ids := make(map[string]bool, len(schedules))
+	for i := range schedules {
+		sch := &schedules[i]
+		if sch.ID == "" {
+			sch.ID = uuid.New().String()
+		}
+		if ids[sch.ID] {
+			return fmt.Errorf("任务 ID 重复: %s", sch.ID)
+		}
+		ids[sch.ID] = true
+		if sch.Name == "" {
+			return fmt.Errorf("任务 #%d 名称不能为空", i+1)
+		}
+		spec, err := parseCron(sch.Cron)
+		if err != nil {
+			return fmt.Errorf("任务 %s: %w", sch.Name, err)
+		}
+		specs[sch.ID] = spec
+		if len(sch.Actions) == 0 {
+			return fmt.Errorf("任务 %s 没有动作", sch.Name)
+		}
+		for _, a := range sch.Actions {
+			if err := validateScheduleAction(a, blocks); err != nil {
+				return fmt.Errorf("任务 %s: %w", sch.Name, err)
+			}
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// 保留执行时间（前端回传时可能不带）
+	lastRun := make(map[string]*time.Time)
+	for _, sch := range sc.state.Schedules {
+		lastRun[sch.ID] = sch.LastRun
+	}
+	for i := range schedules {
+		if schedules[i].LastRun == nil {
+			schedules[i].LastRun = lastRun[schedules[i].ID]
+		}
+	}
+	sc.state.Schedules = schedules
+	sc.specs = specs
+	return sc.saveState()
+}
+
+func validateScheduleAction(a ScheduleAction, blocks map[string]bool) error {
+	switch a.Type {
+	case ScheduleActionMode:
+		if a.Mode != "rule" && a.Mode != "global" && a.Mode != "direct" {
+			return fmt.Errorf("模式无效: %s", a.Mode)
+		}
+	case ScheduleActionSelect:
+		if a.Group == "" || a.Node == "" {
+			return fmt.Errorf("选择节点动作需要指定策略组与节点")
+		}
+	case ScheduleActionEnableBlock, ScheduleActionDisableBlock:
+		if !blocks[a.Block] {
+			return fmt.Errorf("规则块不存在: %s", a.Block)
+		}
+	default:
+		return fmt.Errorf("未知动作类型: %s", a.Type)
+	}
+	return nil
+}
+
+// RunNow 立即执行任务（忽略覆盖），返回本次执行记录
+func (sc *Scheduler) RunNow(id string) ([]ScheduleEvent, error) {
+	sc.mu.RLock()
+	var target *Schedule
+	for i := range sc.state.Schedules {
+		if sc.state.Schedules[i].ID == id {
+			sch := sc.state.Schedules[i]
+			target = &sch
+		}
+	}
+	sc.mu.RUnlock()
+	if target == nil {
+		return nil, fmt.Errorf("任务不存在: %s", id)
+	}
+	return sc.execute(*target, true), nil
+}
+
+// SetOverride 设置手动覆盖：id 为空时暂停全部任务，until 为 nil 时取消覆盖
+func (sc *Scheduler) SetOverride(id string, until *time.Time) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if id == "" {
+		sc.state.OverrideUntil = until
+		return sc.saveState()
+	}
+	for i := range sc.state.Schedules {
+		if sc.state.Schedules[i].ID == id {
+			sc.state.Schedules[i].OverrideUntil = until
+			return sc.saveState()
+		}
+	}
+	return fmt.Errorf("任务不存在: %s", id)
+}
+
+// Events 获取最近的执行记录（新记录在前）
+func (sc *Scheduler) Events(limit int) []ScheduleEvent {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	result := make([]ScheduleEvent, 0, len(sc.events))
+	for i := len(sc.events) - 1; i >= 0; i-- {
+		result = append(result, sc.events[i])
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
+
+// ============================================================================
+// cron 表达式（分 时 日 月 周）
+// ============================================================================
+
+// cronSpec 解析后的 cron 表达式
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64 // 位集合
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron 解析 cron 表达式，支持 *、*/n、a-b、a-b/n 与逗号列表
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron 表达式需要 5 个字段（分 时 日 月 周）: %s", expr)
+	}
+
+	spec := &cronSpec{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("分钟字段: %w", err)
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("小时字段: %w", err)
+	}
+	if spec.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("日期字段: %w", err)
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("月份字段: %w", err)
+	}
+	if spec.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("星期字段: %w", err)
+	}
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1 // 7 与 0 均表示周日
+	}
+	return spec, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("步长无效: %s", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(a)
+			hi, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("范围无效: %s", part)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("取值无效: %s", part)
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("取值超出范围 %d-%d: %s", min, max, part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Match 检查时间是否满足表达式
+func (c *cronSpec) Match(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	return c.matchDay(t)
+}
+
+// matchDay 日与周的匹配（均指定时满足其一即可）
+func (c *cronSpec) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next 计算 after 之后的下一次执行时间（一年内）
+func (c *cronSpec) Next(after time.Time) (time.Time, bool) {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(1, 0, 0)
+	for t.Before(end) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.Match(t) {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
@@ -3,6 +3,7 @@ package proxy
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -38,6 +40,14 @@ type ProxyStatus struct {
 	Uptime          int64     `json:"uptime"`
 	ConfigPath      string    `json:"configPath,omitempty"`
 	ApiAddress      string    `json:"apiAddress,omitempty"`
+
+	// 进程守护
+	CrashLoop bool          `json:"crashLoop"`         // 连续崩溃已停止自动重启
+	Crashes   []CrashRecord `json:"crashes,omitempty"` // 崩溃历史
+
+	// 当前配置方案
+	ProfileID   string `json:"profileId,omitempty"`
+	ProfileName string `json:"profileName,omitempty"`
 }
 
 type ProxyConfig struct {
@@ -50,11 +60,17 @@ type ProxyConfig struct {
 	Mode               string `json:"mode" yaml:"mode"`
 	LogLevel           string `json:"logLevel" yaml:"log-level"`
 	ExternalController string `json:"externalController" yaml:"external-controller"`
+	Secret             string `json:"secret" yaml:"secret"`                      // 核心 API 密钥（首次运行自动生成）
+	ControllerSocket   string `json:"controllerSocket" yaml:"controller-socket"` // 核心 API Unix 套接字绝对路径（仅 mihomo，设置后不再监听 TCP）
 	TunEnabled         bool   `json:"tunEnabled" yaml:"tun-enabled"`
-	TunStack           string `json:"tunStack" yaml:"tun-stack"`               // system, gvisor, mixed
-	TransparentMode    string `json:"transparentMode" yaml:"transparent-mode"` // off, tun, tproxy, redirect
-	AutoStart          bool   `json:"autoStart" yaml:"auto-start"`             // 开机自动启动
-	AutoStartDelay     int    `json:"autoStartDelay" yaml:"auto-start-delay"`  // 自动启动延迟（秒）
+	TunStack           string `json:"tunStack" yaml:"tun-stack"`                    // system, gvisor, mixed
+	TransparentMode    string `json:"transparentMode" yaml:"transparent-mode"`      // off, tun, tproxy, redirect
+	AutoStart          bool   `json:"autoStart" yaml:"auto-start"`                  // 开机自动启动
+	AutoStartDelay     int    `json:"autoStartDelay" yaml:"auto-start-delay"`       // 自动启动延迟（秒）
+	CheckPort          int    `json:"checkPort" yaml:"check-port"`                  // 节点检测入站端口（仅监听 127.0.0.1）
+	StopTimeout        int    `json:"stopTimeout" yaml:"stop-timeout"`              // 停止时等待核心退出的秒数，超时强制结束
+	CrashRestartLimit  int    `json:"crashRestartLimit" yaml:"crash-restart-limit"` // 连续崩溃自动重启上限
+	ProviderMode       bool   `json:"providerMode" yaml:"provider-mode"`            // 订阅节点以代理提供者引用（仅 mihomo）
 }
 
 // NodeProvider 节点提供者接口
@@ -70,6 +86,10 @@ type Service struct {
 	configGenerator  *ConfigGenerator
 	singboxGenerator *SingboxGenerator
 	configTemplate   *ConfigTemplate
+	routingPolicy    *RoutingPolicy     // 核心无关的分流策略（两种核心共用）
+	lanDevices       []LanDevice        // 局域网设备策略
+	overrides        *ConfigOverrides   // 用户配置覆盖（生成后合并）
+	regions          []RegionDefinition // 地区分类表
 	process          *exec.Cmd
 	running          bool
 	startTime        time.Time
@@ -82,12 +102,51 @@ type Service struct {
 	// 设置提供者（从设置模块获取代理设置）
 	settingsProvider SettingsProvider
 
+	// 配置方案（切换时通过以下函数写入设置模块与核心模块）
+	profiles      *ProfileState
+	settingsSaver func(*ProxySettings) error
+	coreSwitcher  func(coreType string) error
+
+	// 导入 Clash 配置时写入手动节点
+	manualNodeImporter ManualNodeImporter
+
+	// 第三方客户端导出令牌
+	exports []ExportToken
+
 	// 日志收集
-	logs  []string
-	logMu sync.RWMutex
+	logStore *LogStore
 
 	// 启动回调（用于通知其他模块 VPN 已启动）
 	onStartCallback func()
+
+	// 最近一次配置校验失败信息
+	lastValidationError *ConfigValidationError
+
+	// 启动时的端口/TUN 指纹，用于判断能否热重载
+	startKey string
+
+	// 进程守护
+	processDone        chan struct{}
+	supervising        bool // 是否需要在异常退出后自动重启
+	superviseGen       int  // 每次启动/停止递增，用于取消过期的重启
+	consecutiveCrashes int
+	crashLoop          bool
+	crashes            []CrashRecord
+
+	// 节点出口与服务可达性检测
+	nodeChecker *NodeChecker
+
+	// 定时策略
+	scheduler *Scheduler
+
+	// 透明代理 nftables 规则
+	panelPort     int
+	firewall      *FirewallRuleset // 已安装的规则，nil 表示未安装
+	firewallError string
+
+	// 主机变更日志（独立锁，持有 mu 时也可写入）
+	journal   []SystemChange
+	journalMu sync.Mutex
 }
 
 func NewService(dataDir string) *Service {
@@ -119,13 +178,29 @@ func NewService(dataDir string) *Service {
 			TransparentMode:    defaultTransparentMode,
 			AutoStart:          false,
 			AutoStartDelay:     15, // 默认延迟 15 秒
+			CheckPort:          7899,
+			StopTimeout:        10,
+			CrashRestartLimit:  5,
 		},
 		configGenerator:  NewConfigGenerator(dataDir),
 		singboxGenerator: NewSingboxGenerator(dataDir),
 		configTemplate:   GetDefaultConfigTemplate(),
+		nodeChecker:      NewNodeChecker(dataDir),
+		logStore:         NewLogStore(dataDir),
 	}
 	s.loadConfig()
+	s.ensureControllerSecret()
 	s.loadConfigTemplate()
+	s.loadRoutingPolicy()
+	s.loadLanDevices()
+	s.loadConfigOverrides()
+	s.loadRegions()
+	s.loadProfiles()
+	s.loadExports()
+	s.loadJournal()
+	s.scheduler = NewScheduler(s)
+	go s.scheduler.Run()
+	go s.watchLanDevices()
 	return s
 }
 
@@ -213,6 +288,16 @@ func (s *Service) loadConfig() {
 	if s.config.AutoStartDelay == 0 {
 		s.config.AutoStartDelay = defaults.AutoStartDelay
 	}
+	// CheckPort 为 0 表示关闭节点检测入站，不恢复默认值
+	if s.config.CheckPort < 0 {
+		s.config.CheckPort = 0
+	}
+	if s.config.StopTimeout == 0 {
+		s.config.StopTimeout = defaults.StopTimeout
+	}
+	if s.config.CrashRestartLimit == 0 {
+		s.config.CrashRestartLimit = defaults.CrashRestartLimit
+	}
 }
 
 func (s *Service) saveConfig() error {
@@ -221,7 +306,7 @@ func (s *Service) saveConfig() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configFile, data, 0644)
+	return writePrivateFile(configFile, data)
 }
 
 func (s *Service) GetStatus() *ProxyStatus {
@@ -239,6 +324,13 @@ func (s *Service) GetStatus() *ProxyStatus {
 		TransparentMode: s.config.TransparentMode,
 		ConfigPath:      s.configPath,
 		ApiAddress:      s.config.ExternalController,
+		CrashLoop:       s.crashLoop,
+		ProfileID:       s.profiles.Active,
+		ProfileName:     s.activeProfileName(),
+	}
+	if len(s.crashes) > 0 {
+		status.Crashes = make([]CrashRecord, len(s.crashes))
+		copy(status.Crashes, s.crashes)
 	}
 
 	if s.running {
@@ -266,16 +358,27 @@ func (s *Service) Start() error {
 	// 每次启动都重新生成配置（确保配置是最新的）
 	configPath, err := s.regenerateConfig()
 	if err != nil {
-		// 如果重新生成失败，尝试使用已有配置
-		if s.coreType == "singbox" {
-			configPath = filepath.Join(s.dataDir, "configs", "singbox-config.json")
-		} else {
-			configPath = filepath.Join(s.dataDir, "configs", "config.yaml")
-		}
-		if _, err := os.Stat(configPath); os.IsNotExist(err) {
-			return fmt.Errorf("配置文件未找到，请先生成配置")
+		// 重新生成或校验失败，回退到最近一次通过校验的配置
+		configPath = s.lastGoodConfigPath(s.coreType)
+		if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
+			var validationErr *ConfigValidationError
+			if errors.As(err, &validationErr) {
+				return err
+			}
+			// 没有可用副本时使用已有配置，但仍需通过校验
+			if s.coreType == "singbox" {
+				configPath = filepath.Join(s.dataDir, "configs", "singbox-config.json")
+			} else {
+				configPath = filepath.Join(s.dataDir, "configs", "config.yaml")
+			}
+			if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
+				return fmt.Errorf("配置文件未找到，请先生成配置")
+			}
+			if vErr := s.validateConfig(configPath); vErr != nil {
+				return vErr
+			}
 		}
-		fmt.Printf("⚠️ 重新生成配置失败，使用已有配置: %v\n", err)
+		fmt.Printf("⚠️ 重新生成配置失败，使用最近可用配置 %s: %v\n", configPath, err)
 	}
 
 	s.mu.Lock()         // 重新获取锁
@@ -295,42 +398,15 @@ func (s *Service) Start() error {
 		s.prepareSystemForTUN()
 	}
 
-	// 构建命令 - 根据核心类型使用不同参数
-	// Mihomo: -d <workdir> -f <config>
-	// Sing-Box: run -D <workdir> -c <config>
-	if s.coreType == "singbox" {
-		s.process = exec.Command(corePath, "run", "-D", s.dataDir, "-c", configPath)
-		// 启用已弃用的特殊出站（direct），代理组需要引用"直连"
-		s.process.Env = append(os.Environ(), "ENABLE_DEPRECATED_SPECIAL_OUTBOUNDS=true")
-	} else {
-		s.process = exec.Command(corePath, "-d", s.dataDir, "-f", configPath)
-	}
-	s.process.Dir = s.dataDir
-
-	// 创建管道捕获输出
-	stdout, _ := s.process.StdoutPipe()
-	stderr, _ := s.process.StderrPipe()
-
-	if err := s.process.Start(); err != nil {
-		return fmt.Errorf("启动核心失败: %w", err)
+	// 启动核心并交由守护协程监控
+	if err := s.launchCore(corePath, configPath); err != nil {
+		return err
 	}
-
-	// 启动日志收集
-	go s.collectLogs(stdout)
-	go s.collectLogs(stderr)
-
-	s.running = true
-	s.startTime = time.Now()
-	s.configPath = configPath
-
-	// 监控进程
-	go func() {
-		s.process.Wait()
-		s.mu.Lock()
-		s.running = false
-		s.process = nil
-		s.mu.Unlock()
-	}()
+	s.startKey = s.restartKey()
+	s.supervising = true
+	s.superviseGen++
+	s.consecutiveCrashes = 0
+	s.crashLoop = false
 
 	// 根据透明代理模式自动设置系统代理（macOS/Windows）
 	if s.config.TransparentMode == "off" {
@@ -367,29 +443,36 @@ func (s *Service) configureAllBrowsers() {
 func (s *Service) Stop() error {
 	s.mu.Lock()
 
-	if !s.running {
+	// 未运行且没有等待中的自动重启
+	if !s.running && !s.supervising {
 		s.mu.Unlock()
 		return nil
 	}
 
-	wasTunEnabled := s.config.TunEnabled || s.config.TransparentMode == "tun"
-
-	if s.process != nil && s.process.Process != nil {
-		if err := s.process.Process.Kill(); err != nil {
-			s.mu.Unlock()
-			return fmt.Errorf("failed to stop core: %w", err)
-		}
-		s.process.Wait()
-	}
+	cmd := s.process
+	done := s.processDone
+	timeout := time.Duration(s.config.StopTimeout) * time.Second
 
+	// 先标记为主动停止，守护协程不会再重启
 	s.running = false
 	s.process = nil
+	s.processDone = nil
+	s.supervising = false
+	s.superviseGen++
 	s.mu.Unlock()
 
-	// 恢复系统环境（在锁外执行）
-	if wasTunEnabled {
-		s.restoreSystemAfterTUN()
-	}
+	// SIGTERM 优雅退出（让核心写入缓存文件），超时后强制结束
+	terminateProcess(cmd, done, timeout)
+
+	s.removeFirewall()
+	s.restoreSystemState()
+	return nil
+}
+
+// restoreSystemState 核心停止后恢复系统环境（主机变更、系统代理、浏览器）
+func (s *Service) restoreSystemState() {
+	// 撤销主机变更（在锁外执行）
+	s.restoreSystemAfterTUN()
 
 	// 清除系统代理设置（macOS/Windows）
 	if err := system.ClearSystemProxy(); err != nil {
@@ -402,8 +485,6 @@ func (s *Service) Stop() error {
 	if err := system.RestoreAllBrowsersProxy(); err != nil {
 		fmt.Printf("⚠️ 恢复浏览器设置失败: %v\n", err)
 	}
-
-	return nil
 }
 
 func (s *Service) Restart() error {
@@ -413,6 +494,16 @@ func (s *Service) Restart() error {
 	return s.Start()
 }
 
+// ReloadNodes 节点集合变化后重新生成配置，运行中则热重载使其生效
+func (s *Service) ReloadNodes() error {
+	result, err := s.ApplyNodeChange()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ 节点变更已生效 (%s)\n", result.Method)
+	return nil
+}
+
 // collectLogs 收集日志输出
 func (s *Service) collectLogs(reader io.Reader) {
 	scanner := bufio.NewScanner(reader)
@@ -424,49 +515,50 @@ func (s *Service) collectLogs(reader io.Reader) {
 	}
 }
 
-// addLog 添加日志
+// addLog 添加日志（解析为结构化日志后写入存储）
 func (s *Service) addLog(line string) {
-	s.logMu.Lock()
-	defer s.logMu.Unlock()
-
-	s.logs = append(s.logs, line)
-	// 保留最近 1000 条日志
-	if len(s.logs) > 1000 {
-		s.logs = s.logs[len(s.logs)-1000:]
+	if line == "" {
+		return
 	}
+	s.logStore.Append(parseCoreLogLine(line, time.Now()))
 }
 
-// GetLogs 获取日志
+// GetLogs 获取最近的原始日志行
 func (s *Service) GetLogs(limit int) []string {
-	s.logMu.RLock()
-	defer s.logMu.RUnlock()
-
-	if limit <= 0 || limit > len(s.logs) {
-		limit = len(s.logs)
+	entries := s.logStore.Query(LogFilter{Limit: limit})
+	result := make([]string, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.Raw
 	}
+	return result
+}
 
-	start := len(s.logs) - limit
-	if start < 0 {
-		start = 0
-	}
+// QueryLogs 按级别、关键字和时间范围查询结构化日志
+func (s *Service) QueryLogs(filter LogFilter) []LogEntry {
+	return s.logStore.Query(filter)
+}
 
-	result := make([]string, limit)
-	copy(result, s.logs[start:])
-	return result
+// SubscribeLogs 订阅实时日志
+func (s *Service) SubscribeLogs() (chan LogEntry, func()) {
+	return s.logStore.Subscribe()
 }
 
 // ClearLogs 清除日志
 func (s *Service) ClearLogs() {
-	s.logMu.Lock()
-	defer s.logMu.Unlock()
-	s.logs = nil
+	s.logStore.Clear()
 }
 
-// SetNodeProvider 设置节点提供者
+// SetNodeProvider 设置节点提供者（按当前配置方案的节点选择过滤）
 func (s *Service) SetNodeProvider(provider NodeProvider) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.nodeProvider = provider
+	s.nodeProvider = func() []ProxyNode {
+		nodes := provider()
+		if sel := s.activeNodeSelection(); sel != nil {
+			return sel.filter(nodes)
+		}
+		return nodes
+	}
 }
 
 // SetSettingsProvider 设置代理设置提供者
@@ -567,9 +659,17 @@ func (s *Service) GetConfig() *ProxyConfig {
 }
 
 func (s *Service) UpdateConfig(config *ProxyConfig) error {
+	if err := validateProxyConfig(config); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// 未提供密钥时沿用当前密钥，核心 API 不允许无密钥运行
+	if config.Secret == "" {
+		config.Secret = s.config.Secret
+	}
 	s.config = config
 	return s.saveConfig()
 }
@@ -579,89 +679,186 @@ func (s *Service) PatchConfig(updates map[string]interface{}) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	patched := *s.config
+	applyConfigPatch(&patched, updates)
+	if patched.Secret == "" {
+		patched.Secret = s.config.Secret
+	}
+	if err := validateProxyConfig(&patched); err != nil {
+		return err
+	}
+	*s.config = patched
+	return s.saveConfig()
+}
+
+// ValidateConfigPatch 校验部分更新应用后的配置（不保存）
+func (s *Service) ValidateConfigPatch(updates map[string]interface{}) error {
+	s.mu.RLock()
+	patched := *s.config
+	s.mu.RUnlock()
+
+	applyConfigPatch(&patched, updates)
+	return validateProxyConfig(&patched)
+}
+
+// validateProxyConfig 校验核心 API 套接字与节点检测端口
+func validateProxyConfig(config *ProxyConfig) error {
+	if err := validateControllerSocket(config.ControllerSocket); err != nil {
+		return err
+	}
+	return validateCheckPort(config)
+}
+
+// validateCheckPort 校验节点检测端口（0 表示关闭），不得与其他入站或核心 API 端口冲突
+func validateCheckPort(config *ProxyConfig) error {
+	port := config.CheckPort
+	if port == 0 {
+		return nil
+	}
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("节点检测端口无效: %d", port)
+	}
+	type namedPort struct {
+		name string
+		port int
+	}
+	ports := []namedPort{
+		{"混合代理", config.MixedPort},
+		{"SOCKS", config.SocksPort},
+		{"透明代理 (redir)", config.RedirPort},
+		{"TProxy", config.TProxyPort},
+	}
+	if _, p, err := net.SplitHostPort(config.ExternalController); err == nil {
+		if controllerPort, err := strconv.Atoi(p); err == nil {
+			ports = append(ports, namedPort{"核心 API", controllerPort})
+		}
+	}
+	for _, other := range ports {
+		if other.port == port {
+			return fmt.Errorf("节点检测端口 %d 与%s端口冲突", port, other.name)
+		}
+	}
+	return nil
+}
+
+// applyConfigPatch 将部分更新应用到配置（只更新传入的字段）
+func applyConfigPatch(config *ProxyConfig, updates map[string]interface{}) {
 	// 根据传入的字段更新配置
 	if v, ok := updates["mixedPort"]; ok {
 		if val, ok := v.(float64); ok {
-			s.config.MixedPort = int(val)
+			config.MixedPort = int(val)
 		}
 	}
 	if v, ok := updates["socksPort"]; ok {
 		if val, ok := v.(float64); ok {
-			s.config.SocksPort = int(val)
+			config.SocksPort = int(val)
 		}
 	}
 	if v, ok := updates["redirPort"]; ok {
 		if val, ok := v.(float64); ok {
-			s.config.RedirPort = int(val)
+			config.RedirPort = int(val)
 		}
 	}
 	if v, ok := updates["tproxyPort"]; ok {
 		if val, ok := v.(float64); ok {
-			s.config.TProxyPort = int(val)
+			config.TProxyPort = int(val)
 		}
 	}
 	if v, ok := updates["allowLan"]; ok {
 		if val, ok := v.(bool); ok {
-			s.config.AllowLan = val
+			config.AllowLan = val
 		}
 	}
 	if v, ok := updates["ipv6"]; ok {
 		if val, ok := v.(bool); ok {
-			s.config.IPv6 = val
+			config.IPv6 = val
 		}
 	}
 	if v, ok := updates["mode"]; ok {
 		if val, ok := v.(string); ok {
-			s.config.Mode = val
+			config.Mode = val
 		}
 	}
 	if v, ok := updates["logLevel"]; ok {
 		if val, ok := v.(string); ok {
-			s.config.LogLevel = val
+			config.LogLevel = val
 		}
 	}
 	if v, ok := updates["externalController"]; ok {
 		if val, ok := v.(string); ok {
-			s.config.ExternalController = val
+			config.ExternalController = val
+		}
+	}
+	if v, ok := updates["secret"]; ok {
+		if val, ok := v.(string); ok {
+			config.Secret = val
+		}
+	}
+	if v, ok := updates["controllerSocket"]; ok {
+		if val, ok := v.(string); ok {
+			config.ControllerSocket = val
 		}
 	}
 	if v, ok := updates["tunEnabled"]; ok {
 		if val, ok := v.(bool); ok {
-			s.config.TunEnabled = val
+			config.TunEnabled = val
 		}
 	}
 	if v, ok := updates["tunStack"]; ok {
 		if val, ok := v.(string); ok {
-			s.config.TunStack = val
+			config.TunStack = val
 		}
 	}
 	if v, ok := updates["transparentMode"]; ok {
 		if val, ok := v.(string); ok {
-			s.config.TransparentMode = val
+			config.TransparentMode = val
 		}
 	}
 	if v, ok := updates["autoStart"]; ok {
 		if val, ok := v.(bool); ok {
-			s.config.AutoStart = val
+			config.AutoStart = val
 		}
 	}
 	if v, ok := updates["autoStartDelay"]; ok {
 		if val, ok := v.(float64); ok {
-			s.config.AutoStartDelay = int(val)
+			config.AutoStartDelay = int(val)
+		}
+	}
+	if v, ok := updates["checkPort"]; ok {
+		if val, ok := v.(float64); ok {
+			config.CheckPort = int(val)
+		}
+	}
+	if v, ok := updates["stopTimeout"]; ok {
+		if val, ok := v.(float64); ok {
+			config.StopTimeout = int(val)
+		}
+	}
+	if v, ok := updates["crashRestartLimit"]; ok {
+		if val, ok := v.(float64); ok {
+			config.CrashRestartLimit = int(val)
+		}
+	}
+	if v, ok := updates["providerMode"]; ok {
+		if val, ok := v.(bool); ok {
+			config.ProviderMode = val
 		}
 	}
-
-	return s.saveConfig()
 }
 
 func (s *Service) findCorePath() string {
+	return s.findCorePathFor(s.coreType)
+}
+
+// findCorePathFor 查找指定类型的核心文件
+func (s *Service) findCorePathFor(coreType string) string {
 	coresDir := filepath.Join(s.dataDir, "cores")
 	arch := runtime.GOARCH
 	goos := runtime.GOOS
 
 	// 精确匹配
 	var binName string
-	if s.coreType == "singbox" {
+	if coreType == "singbox" {
 		binName = fmt.Sprintf("sing-box-%s-%s", goos, arch)
 	} else {
 		binName = fmt.Sprintf("mihomo-%s-%s", goos, arch)
@@ -692,109 +889,207 @@ func (s *Service) findCorePath() string {
 	return ""
 }
 
-// GenerateConfig 生成配置文件
-func (s *Service) GenerateConfig(nodes []ProxyNode) (string, error) {
+// buildGeneratorOptions 根据代理配置、模板与设置构建生成选项
+func buildGeneratorOptions(config *ProxyConfig, template *ConfigTemplate, policy *RoutingPolicy, settings *ProxySettings) ConfigGeneratorOptions {
 	// 根据透明代理模式设置
-	enableTUN := s.config.TransparentMode == "tun"
-	enableTProxy := s.config.TransparentMode == "tproxy" || s.config.TransparentMode == "redirect"
+	enableTUN := config.TransparentMode == "tun"
+	enableTProxy := config.TransparentMode == "tproxy" || config.TransparentMode == "redirect"
 
 	options := ConfigGeneratorOptions{
-		MixedPort:          s.config.MixedPort,
-		AllowLan:           s.config.AllowLan,
-		Mode:               s.config.Mode,
-		LogLevel:           s.config.LogLevel,
-		IPv6:               s.config.IPv6,
-		ExternalController: s.config.ExternalController,
+		MixedPort:          config.MixedPort,
+		AllowLan:           config.AllowLan,
+		Mode:               config.Mode,
+		LogLevel:           config.LogLevel,
+		IPv6:               config.IPv6,
+		ExternalController: config.ExternalController,
+		Secret:             config.Secret,
+		ControllerSocket:   config.ControllerSocket,
 		EnableDNS:          true,
 		EnhancedMode:       "fake-ip",
 		EnableTUN:          enableTUN,
 		EnableTProxy:       enableTProxy,
-		TProxyPort:         s.config.TProxyPort,
-		Template:           s.configTemplate, // 使用配置模板
+		TProxyPort:         config.TProxyPort,
+		RedirPort:          config.RedirPort,
+		CheckPort:          config.CheckPort,
+		ProviderMode:       config.ProviderMode,
+		Template:           template, // 使用配置模板
+		Policy:             policy,   // 分流策略优先
 	}
 
 	// 从代理设置获取优化配置
-	if s.settingsProvider != nil {
-		settings := s.settingsProvider()
-		if settings != nil {
-			// 性能优化
-			options.UnifiedDelay = settings.UnifiedDelay
-			options.TCPConcurrent = settings.TCPConcurrent
-			options.FindProcessMode = settings.FindProcessMode
-			options.GlobalClientFingerprint = settings.GlobalClientFingerprint
-			options.KeepAliveInterval = settings.KeepAliveInterval
-			options.KeepAliveIdle = settings.KeepAliveIdle
-			options.DisableKeepAlive = settings.DisableKeepAlive
-
-			// GEO 数据
-			options.GeodataMode = settings.GeodataMode
-			options.GeodataLoader = settings.GeodataLoader
-			options.GeositeMatcher = settings.GeositeMatcher
-			options.GeoAutoUpdate = settings.GeoAutoUpdate
-			options.GeoUpdateInterval = settings.GeoUpdateInterval
-			options.GlobalUA = settings.GlobalUA
-			options.ETagSupport = settings.ETagSupport
-
-			// TUN 设置
-			options.TUNSettings = &settings.TUN
+	if settings != nil {
+		// 性能优化
+		options.UnifiedDelay = settings.UnifiedDelay
+		options.TCPConcurrent = settings.TCPConcurrent
+		options.FindProcessMode = settings.FindProcessMode
+		options.GlobalClientFingerprint = settings.GlobalClientFingerprint
+		options.KeepAliveInterval = settings.KeepAliveInterval
+		options.KeepAliveIdle = settings.KeepAliveIdle
+		options.DisableKeepAlive = settings.DisableKeepAlive
+
+		// GEO 数据
+		options.GeodataMode = settings.GeodataMode
+		options.GeodataLoader = settings.GeodataLoader
+		options.GeositeMatcher = settings.GeositeMatcher
+		options.GeoAutoUpdate = settings.GeoAutoUpdate
+		options.GeoUpdateInterval = settings.GeoUpdateInterval
+		options.GlobalUA = settings.GlobalUA
+		options.ETagSupport = settings.ETagSupport
+
+		// TUN 设置
+		options.TUNSettings = &settings.TUN
+
+		// DNS 策略与监听地址（透明代理的 DNS 劫持使用同一端口）
+		options.DNSSettings = &settings.DNS
+		options.DNSListen = settings.DNS.Listen
+	}
+
+	// 透明代理模式下核心出站打标（nftables 规则据此放行）
+	if enableTProxy {
+		options.RoutingMark = firewallRoutingMark(settings)
+	}
+
+	return options
+}
+
+// buildSingBoxOptions 将通用生成选项转换为 sing-box 1.12+ 生成选项
+func buildSingBoxOptions(options ConfigGeneratorOptions) SingBoxGeneratorOptions {
+	sbOpts := SingBoxGeneratorOptions{
+		Mode:                     "system",
+		FakeIP:                   options.EnhancedMode == "fake-ip",
+		MixedPort:                options.MixedPort,
+		LogLevel:                 options.LogLevel,
+		Sniff:                    true,
+		SniffOverrideDestination: true,
+		CheckPort:                options.CheckPort,
+		Policy:                   options.Policy,
+		Devices:                  options.Devices,
+		DNSSettings:              options.DNSSettings,
+	}
+	// TUN 模式设置
+	if options.EnableTUN {
+		sbOpts.Mode = "tun"
+		if options.TUNSettings != nil {
+			sbOpts.TUNStack = options.TUNSettings.Stack
+			sbOpts.TUNMTU = options.TUNSettings.MTU
+			sbOpts.StrictRoute = options.TUNSettings.StrictRoute
+			sbOpts.AutoRedirect = options.TUNSettings.AutoRedirect
 		}
 	}
+	// 透明代理入站
+	if options.EnableTProxy {
+		sbOpts.TProxyPort = options.TProxyPort
+		sbOpts.RedirPort = getOrDefaultInt(options.RedirPort, 7892)
+		_, sbOpts.DNSPort = dnsListenAddress(options.DNSSettings)
+		sbOpts.RoutingMark = options.RoutingMark
+	}
+	// Clash API
+	if options.ExternalController != "" {
+		sbOpts.ClashAPIAddr = options.ExternalController
+	} else {
+		sbOpts.ClashAPIAddr = "127.0.0.1:9090"
+	}
+	sbOpts.ClashAPISecret = options.Secret
 
-	var configPath string
+	return sbOpts
+}
 
-	if s.coreType == "singbox" {
-		// 生成 sing-box 1.12+ 配置
-		sbOpts := SingBoxGeneratorOptions{
-			Mode:                     "system",
-			FakeIP:                   options.EnhancedMode == "fake-ip",
-			MixedPort:                options.MixedPort,
-			LogLevel:                 options.LogLevel,
-			Sniff:                    true,
-			SniffOverrideDestination: true,
-		}
-		// TUN 模式设置
-		if options.EnableTUN {
-			sbOpts.Mode = "tun"
-			if options.TUNSettings != nil {
-				sbOpts.TUNStack = options.TUNSettings.Stack
-				sbOpts.TUNMTU = options.TUNSettings.MTU
-				sbOpts.StrictRoute = options.TUNSettings.StrictRoute
-				sbOpts.AutoRedirect = options.TUNSettings.AutoRedirect
-			}
-		}
-		// Clash API
-		if options.ExternalController != "" {
-			sbOpts.ClashAPIAddr = options.ExternalController
-		} else {
-			sbOpts.ClashAPIAddr = "127.0.0.1:9090"
-		}
+// GenerateConfig 生成配置文件，生成后使用核心校验
+func (s *Service) GenerateConfig(nodes []ProxyNode) (string, error) {
+	coreType := s.GetCoreType()
+	var settings *ProxySettings
+	if s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+	options := buildGeneratorOptions(s.config, s.configTemplate, s.routingPolicy, settings)
+	if options.ControllerSocket != "" && coreType != "singbox" {
+		// mihomo 不会创建套接字所在目录
+		os.MkdirAll(filepath.Dir(options.ControllerSocket), 0755)
+	}
 
-		config, err := s.singboxGenerator.GenerateConfigV112(nodes, sbOpts)
-		if err != nil {
-			return "", err
-		}
-		path, err := s.singboxGenerator.SaveConfigV112(config, "singbox-config.json")
-		if err != nil {
-			return "", err
-		}
-		configPath = path
-	} else {
-		// 生成 Mihomo/Clash 配置
-		config, err := s.configGenerator.GenerateConfig(nodes, options)
-		if err != nil {
-			return "", err
-		}
-		path, err := s.configGenerator.SaveConfig(config, "config.yaml")
-		if err != nil {
-			return "", err
-		}
-		configPath = path
+	mihomoConfig, singboxConfig, err := s.generateInMemory(coreType, options, nodes)
+	if err != nil {
+		return "", err
 	}
 
+	configPath, err := s.commitRenderedConfig(coreType, mihomoConfig, singboxConfig)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
 	s.configPath = configPath
+	s.mu.Unlock()
+
+	return configPath, nil
+}
+
+// commitRenderedConfig 写入待生效文件并用对应核心校验，通过后替换正在使用的配置并保存可用副本
+// 校验失败时正在使用的配置保持不变（待生效文件保留以便查看错误位置）
+func (s *Service) commitRenderedConfig(coreType string, mihomoConfig *MihomoConfig, singboxConfig *SingBoxConfig) (string, error) {
+	// 最后一步合并用户配置覆盖
+	pendingPath, err := s.saveRenderedConfig(mihomoConfig, singboxConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.validateConfigFor(coreType, pendingPath); err != nil {
+		if validationErr, ok := err.(*ConfigValidationError); ok {
+			s.mu.Lock()
+			s.lastValidationError = validationErr
+			s.mu.Unlock()
+		}
+		return "", err
+	}
+	configPath, err := commitPendingConfig(pendingPath)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.lastValidationError = nil
+	s.mu.Unlock()
+	s.saveLastGoodConfig(coreType, configPath)
 	return configPath, nil
 }
 
+// CommitSingBoxConfig 校验并保存 sing-box 配置（与启动、重载相同的校验与可用副本流程）
+func (s *Service) CommitSingBoxConfig(config *SingBoxConfig) (string, error) {
+	return s.commitRenderedConfig("singbox", nil, config)
+}
+
+// generateInMemory 按生成选项在内存中生成配置（不写入文件），返回值按核心类型二选一
+func (s *Service) generateInMemory(coreType string, options ConfigGeneratorOptions, nodes []ProxyNode) (*MihomoConfig, *SingBoxConfig, error) {
+	options.Devices = s.resolveLanDevices()
+	if coreType == "singbox" {
+		if options.ProviderMode {
+			fmt.Println("⚠️ sing-box 1.12 不支持出站提供者，订阅节点仍内联生成")
+		}
+		config, err := s.singboxGenerator.GenerateConfigV112(nodes, s.singBoxOptions(options))
+		return nil, config, err
+	}
+	config, err := s.configGenerator.GenerateConfig(nodes, options)
+	return config, nil, err
+}
+
+// singBoxOptions 转换为 sing-box 生成选项并加载 sing-box 模板
+func (s *Service) singBoxOptions(options ConfigGeneratorOptions) SingBoxGeneratorOptions {
+	sbOpts := buildSingBoxOptions(options)
+	sbOpts.Template = LoadSingBoxTemplate(s.dataDir)
+	return sbOpts
+}
+
+// SingBoxGeneratorOptions 按当前代理配置构建 sing-box 生成选项（核心 API 密钥始终取自本地配置）
+func (s *Service) SingBoxGeneratorOptions() SingBoxGeneratorOptions {
+	var settings *ProxySettings
+	if s.settingsProvider != nil {
+		settings = s.settingsProvider()
+	}
+	s.mu.RLock()
+	options := buildGeneratorOptions(s.config, s.configTemplate, s.routingPolicy, settings)
+	s.mu.RUnlock()
+	options.Devices = s.resolveLanDevices()
+	return s.singBoxOptions(options)
+}
+
 // SetCoreType 设置核心类型
 func (s *Service) SetCoreType(coreType string) {
 	s.mu.Lock()
@@ -927,7 +1222,16 @@ func (s *Service) saveConfigTemplate() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(templateFile, data, 0644)
+	if err := os.WriteFile(templateFile, data, 0644); err != nil {
+		return err
+	}
+
+	// 模板修改同步到分流策略（保留 sing-box 规则集来源与 DNS 策略）
+	if s.routingPolicy != nil {
+		s.routingPolicy = s.routingPolicy.withConfigTemplate(s.configTemplate)
+		return SaveRoutingPolicy(s.dataDir, s.routingPolicy)
+	}
+	return nil
 }
 
 // GetConfigTemplate 获取配置模板
@@ -939,6 +1243,9 @@ func (s *Service) GetConfigTemplate() *ConfigTemplate {
 
 // UpdateProxyGroups 更新代理组
 func (s *Service) UpdateProxyGroups(groups []ProxyGroupTemplate) error {
+	if err := ValidateProxyGroups(groups); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.configTemplate.ProxyGroups = groups
@@ -1006,9 +1313,11 @@ func (s *Service) ResetConfigTemplate() {
 }
 
 // prepareSystemForTUN 准备系统环境以启用 TUN 模式
-// 主要处理：1. 释放 53 端口（停止占用的服务）
+// 主要处理：1. 释放 53 端口（停止占用的服务，需在设置中开启）
 //
 //  2. 设置 IP 转发
+//
+// 所有修改写入主机变更日志，停止后按相反顺序撤销
 func (s *Service) prepareSystemForTUN() {
 	// 检查是否为 Linux
 	if runtime.GOOS != "linux" {
@@ -1019,47 +1328,57 @@ func (s *Service) prepareSystemForTUN() {
 	s.releasePort53()
 
 	// 2. 启用 IP 转发
-	exec.Command("sysctl", "-w", "net.ipv4.ip_forward=1").Run()
-	exec.Command("sysctl", "-w", "net.ipv6.conf.all.forwarding=1").Run()
+	s.setSysctlJournaled("net.ipv4.ip_forward", "1")
+	s.setSysctlJournaled("net.ipv6.conf.all.forwarding", "1")
 	s.addLog("已启用 IP 转发")
 }
 
-// releasePort53 释放 53 端口
+// releasePort53 释放 53 端口（各步骤需在 TUN 准备设置中显式开启）
 func (s *Service) releasePort53() {
+	prep := s.systemPrepSettings()
+
+	// 改写 resolv.conf 与端口是否占用无关：DNS 指向核心
+	if prep.RewriteResolvConf {
+		if err := s.writeFileJournaled(resolvConfPath, []byte("nameserver 127.0.0.1\n")); err != nil {
+			s.addLog("警告：改写 resolv.conf 失败: " + err.Error())
+		} else {
+			s.addLog("已备份 resolv.conf 并配置 DNS 指向核心")
+		}
+	}
+
 	// 检查 53 端口是否被占用
 	if !s.isPortInUse(53) {
 		s.addLog("53 端口未被占用，无需处理")
 		return
 	}
 
-	s.addLog("检测到 53 端口被占用，正在释放...")
+	if !prep.StopResolvers && !prep.KillPort53 {
+		s.addLog("警告：53 端口被占用，未开启释放端口选项，TUN 模式 DNS 可能无法正常工作")
+		return
+	}
 
-	// 方法 1: 停止 systemd-resolved（最常见的占用者）
-	if s.isServiceActive("systemd-resolved") {
-		s.addLog("检测到 systemd-resolved 服务，正在停止...")
-		exec.Command("systemctl", "stop", "systemd-resolved").Run()
-		exec.Command("systemctl", "disable", "systemd-resolved").Run()
+	s.addLog("检测到 53 端口被占用，正在释放...")
 
-		// 备份并修改 resolv.conf
-		if _, err := os.Stat("/etc/resolv.conf.bak"); os.IsNotExist(err) {
-			exec.Command("cp", "/etc/resolv.conf", "/etc/resolv.conf.bak").Run()
+	if prep.StopResolvers {
+		// 方法 1: 停止 systemd-resolved（最常见的占用者）
+		if s.isServiceActive("systemd-resolved") {
+			s.addLog("检测到 systemd-resolved 服务，正在停止...")
+			s.stopServiceJournaled("systemd-resolved", true)
+			s.addLog("已停止 systemd-resolved")
 		}
-		// 删除符号链接并创建新文件，指向 Mihomo 的 DNS
-		os.Remove("/etc/resolv.conf")
-		os.WriteFile("/etc/resolv.conf", []byte("nameserver 127.0.0.1\n"), 0644)
-		s.addLog("已停止 systemd-resolved 并配置 DNS 指向 Mihomo")
-	}
 
-	// 方法 2: 停止 dnsmasq（另一个常见的 DNS 服务）
-	if s.isServiceActive("dnsmasq") {
-		s.addLog("检测到 dnsmasq 服务，正在停止...")
-		exec.Command("systemctl", "stop", "dnsmasq").Run()
-		s.addLog("已停止 dnsmasq")
+		// 方法 2: 停止 dnsmasq（另一个常见的 DNS 服务）
+		if s.isServiceActive("dnsmasq") {
+			s.addLog("检测到 dnsmasq 服务，正在停止...")
+			s.stopServiceJournaled("dnsmasq", false)
+			s.addLog("已停止 dnsmasq")
+		}
 	}
 
-	// 方法 3: 使用 fuser 强制杀死占用 53 端口的进程
-	if s.isPortInUse(53) {
+	// 方法 3: 使用 fuser 强制杀死占用 53 端口的进程（不可恢复，仅记录）
+	if prep.KillPort53 && s.isPortInUse(53) {
 		s.addLog("尝试使用 fuser 释放 53 端口...")
+		s.recordChange(SystemChange{Kind: ChangeProcess, Target: "53/udp,53/tcp", Action: "kill"})
 		exec.Command("fuser", "-k", "53/udp").Run()
 		exec.Command("fuser", "-k", "53/tcp").Run()
 		time.Sleep(time.Millisecond * 500)
@@ -1102,21 +1421,13 @@ func (s *Service) isServiceActive(serviceName string) bool {
 	return string(output) == "active\n"
 }
 
-// restoreSystemAfterTUN 恢复系统环境
+// restoreSystemAfterTUN 按变更日志撤销 TUN 准备对主机的修改
 func (s *Service) restoreSystemAfterTUN() {
-	if runtime.GOOS != "linux" {
-		return
-	}
-
-	// 恢复 resolv.conf
-	if _, err := os.Stat("/etc/resolv.conf.bak"); err == nil {
-		exec.Command("cp", "/etc/resolv.conf.bak", "/etc/resolv.conf").Run()
-		s.addLog("已恢复 resolv.conf")
+	for _, r := range s.RestoreSystemChanges() {
+		if r.Error != "" {
+			s.addLog(fmt.Sprintf("警告：撤销 %s %s 失败: %s", r.Change.Kind, r.Change.Target, r.Error))
+		}
 	}
-
-	// 重新启动 systemd-resolved
-	exec.Command("systemctl", "start", "systemd-resolved").Run()
-	s.addLog("已重新启动 systemd-resolved")
 }
 
 // ============================================================================
@@ -1158,6 +1469,9 @@ func (s *Service) GetSingBoxTemplate() *SingBoxTemplate {
 
 // UpdateSingBoxTemplate 更新 Sing-Box 模板配置
 func (s *Service) UpdateSingBoxTemplate(template *SingBoxTemplate) error {
+	if err := template.Validate(); err != nil {
+		return err
+	}
 	return SaveSingBoxTemplate(s.dataDir, template)
 }
 
@@ -72,6 +72,12 @@ type ProxySettings struct {
 
 	// === 嗅探设置 ===
 	Sniffer SnifferSettings `json:"sniffer" yaml:"sniffer"`
+
+	// === 透明代理防火墙 ===
+	Firewall FirewallSettings `json:"firewall" yaml:"firewall"`
+
+	// === TUN 主机准备（破坏性操作需显式开启） ===
+	SystemPrep SystemPrepSettings `json:"systemPrep" yaml:"system-prep"`
 }
 
 // DNSSettings DNS 设置
@@ -98,6 +104,23 @@ type DNSSettings struct {
 	Fallback              []string `json:"fallback" yaml:"fallback"`
 	ProxyServerNameserver []string `json:"proxyServerNameserver" yaml:"proxy-server-nameserver"`
 	DirectNameserver      []string `json:"directNameserver" yaml:"direct-nameserver"`
+
+	// 高级策略
+	NameserverPolicy []DNSNameserverPolicy `json:"nameserverPolicy" yaml:"nameserver-policy"` // 按域名指定上游
+	Hosts            []DNSHost             `json:"hosts" yaml:"hosts"`                        // 自定义解析
+	ClientSubnet     string                `json:"clientSubnet" yaml:"client-subnet"`         // ECS：向上游附带的客户端子网
+}
+
+// DNSNameserverPolicy 按域名指定上游 DNS
+type DNSNameserverPolicy struct {
+	Match       string   `json:"match" yaml:"match"` // 域名（example.com、+.example.com、*.example.com，可逗号分隔）、geosite:xx 或 rule-set:xx
+	Nameservers []string `json:"nameservers" yaml:"nameservers"`
+}
+
+// DNSHost 自定义解析记录
+type DNSHost struct {
+	Domain string   `json:"domain" yaml:"domain"`
+	IPs    []string `json:"ips" yaml:"ips"`
 }
 
 // TUNSettings TUN 设置
@@ -137,6 +160,26 @@ type SnifferSettings struct {
 	SkipDomain      []string `json:"skipDomain" yaml:"skip-domain"`
 }
 
+// FirewallSettings TPROXY / REDIRECT 模式的 nftables 规则设置 (Linux)
+type FirewallSettings struct {
+	Managed      bool     `json:"managed" yaml:"managed"`            // 由面板自动安装/移除规则
+	IPv6         bool     `json:"ipv6" yaml:"ipv6"`                  // 同时接管 IPv6 流量
+	ProxyLocal   bool     `json:"proxyLocal" yaml:"proxy-local"`     // 接管本机发出的流量
+	DNSHijack    bool     `json:"dnsHijack" yaml:"dns-hijack"`       // 将局域网 53 端口重定向到核心 DNS
+	TProxyMark   int      `json:"tproxyMark" yaml:"tproxy-mark"`     // TPROXY 策略路由标记
+	RouteTable   int      `json:"routeTable" yaml:"route-table"`     // TPROXY 策略路由表
+	RulePriority int      `json:"rulePriority" yaml:"rule-priority"` // 策略路由优先级
+	BypassCIDRs  []string `json:"bypassCidrs" yaml:"bypass-cidrs"`   // 额外直连的目标网段
+	BypassPorts  []int    `json:"bypassPorts" yaml:"bypass-ports"`   // 额外直连的本机服务端口
+}
+
+// SystemPrepSettings 启用 TUN 时对主机的修改（均记入变更日志，停止后撤销）
+type SystemPrepSettings struct {
+	StopResolvers     bool `json:"stopResolvers" yaml:"stop-resolvers"`          // 停止 systemd-resolved / dnsmasq 以释放 53 端口
+	RewriteResolvConf bool `json:"rewriteResolvConf" yaml:"rewrite-resolv-conf"` // 改写 /etc/resolv.conf 指向核心
+	KillPort53        bool `json:"killPort53" yaml:"kill-port-53"`               // fuser -k 结束占用 53 端口的进程（不可恢复）
+}
+
 // GetDefaultProxySettings 获取默认代理设置 (Linux 网关最优配置)
 func GetDefaultProxySettings() *ProxySettings {
 	return &ProxySettings{
@@ -197,29 +240,23 @@ func GetDefaultProxySettings() *ProxySettings {
 
 		// DNS 设置
 		DNS: DNSSettings{
-			Enable:           true,
-			Listen:           "0.0.0.0:1053",
-			PreferH3:         true,
-			CacheAlgorithm:   "arc",
-			IPv6:             false,
-			UseHosts:         true,
-			UseSystemHosts:   false,
-			RespectRules:     true,
-			EnhancedMode:     "fake-ip",
-			FakeIPRange:      "198.18.0.1/16",
-			FakeIPRange6:     "fc00::/18",
-			FakeIPFilterMode: "blacklist",
-			FakeIPFilter: []string{
-				"geosite:cn",
-				"geosite:private",
-				"*.lan",
-				"*.local",
-				"*.localhost",
-			},
+			Enable:                true,
+			Listen:                "0.0.0.0:1053",
+			PreferH3:              true,
+			CacheAlgorithm:        "arc",
+			IPv6:                  false,
+			UseHosts:              true,
+			UseSystemHosts:        false,
+			RespectRules:          true,
+			EnhancedMode:          "fake-ip",
+			FakeIPRange:           "198.18.0.1/16",
+			FakeIPRange6:          "fc00::/18",
+			FakeIPFilterMode:      "blacklist",
+			FakeIPFilter:          append([]string{}, defaultFakeIPFilter...),
 			DefaultNameserver:     []string{"223.5.5.5", "119.29.29.29"},
 			Nameserver:            []string{"https://dns.google/dns-query", "https://cloudflare-dns.com/dns-query"},
 			Fallback:              []string{"https://dns.google/dns-query", "https://cloudflare-dns.com/dns-query"},
-			ProxyServerNameserver: []string{"223.5.5.5", "119.29.29.29"},
+			ProxyServerNameserver: append([]string{}, defaultProxyServerNameserver...),
 			DirectNameserver:      []string{"223.5.5.5", "119.29.29.29"},
 		},
 
@@ -262,5 +299,8 @@ func GetDefaultProxySettings() *ProxySettings {
 			SniffQUIC:       true,
 			SkipDomain:      []string{"+.push.apple.com"},
 		},
+
+		// 透明代理防火墙
+		Firewall: defaultFirewallSettings(),
 	}
 }
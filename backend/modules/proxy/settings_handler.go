@@ -72,6 +72,8 @@ func (h *SettingsHandler) loadSettings() error {
 	if settings.AutoStartDelay == 0 {
 		settings.AutoStartDelay = 15 // 默认延迟 15 秒
 	}
+	migrateDNSSettings(&settings.DNS)
+	migrateFirewallSettings(&settings.Firewall)
 
 	h.settings = &settings
 	return nil
@@ -115,6 +117,31 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 		return
 	}
 
+	if err := settings.DNS.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+	if h.proxyService != nil && h.proxyService.GetCoreType() == "singbox" {
+		if err := settings.DNS.ValidateSingBox(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    2,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	migrateFirewallSettings(&settings.Firewall)
+	if err := settings.Firewall.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    2,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	h.mu.Lock()
 	h.settings = &settings
 	err := h.saveSettings()
@@ -129,16 +156,30 @@ func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
 	}
 
 	// 同步到 proxy 服务
+	var reloadResult *ReloadResult
 	if h.proxyService != nil {
 		h.proxyService.PatchConfig(map[string]interface{}{
 			"autoStart":      settings.AutoStart,
 			"autoStartDelay": float64(settings.AutoStartDelay),
 		})
+
+		// 核心运行中时使新设置生效（优先热重载）
+		if h.proxyService.GetStatus().Running {
+			result, err := h.proxyService.Reload()
+			if err != nil {
+				respondApplyError(c, err)
+				return
+			}
+			reloadResult = result
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    0,
 		"message": "Settings updated successfully",
+		"data": gin.H{
+			"reload": reloadResult,
+		},
 	})
 }
 
@@ -251,6 +292,14 @@ func (h *SettingsHandler) GetCurrentSettings() *ProxySettings {
 	return &copy
 }
 
+// ReplaceSettings 替换并保存设置（切换配置方案时调用）
+func (h *SettingsHandler) ReplaceSettings(settings *ProxySettings) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.settings = settings
+	return h.saveSettings()
+}
+
 // === 预设配置 ===
 
 // getGatewayPreset Linux 网关预设
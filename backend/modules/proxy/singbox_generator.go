@@ -580,7 +580,7 @@ func (g *SingboxGenerator) SaveConfig(config *SingboxConfig, filename string) (s
 		return "", err
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := writePrivateFile(filePath, data); err != nil {
 		return "", err
 	}
 
@@ -632,7 +632,34 @@ func (g *SingboxGenerator) GenerateConfigV112(nodes []ProxyNode, opts SingBoxGen
 	}
 
 	// 生成代理组（传入手动节点名称列表）
-	proxyGroups := g.generateProxyGroupsV112(nodeOutbounds, manualNodeNames)
+	var proxyGroups []SBOutbound
+	var policyRouting singBoxPolicyRouting
+	if opts.Policy != nil {
+		nodeTags := make([]string, 0, len(nodeOutbounds))
+		for _, n := range nodeOutbounds {
+			nodeTags = append(nodeTags, n.Tag)
+		}
+		var err error
+		if policyRouting, err = translatePolicyToSingBox(opts.Policy, nodeTags, manualNodeNames, g.dataDir); err != nil {
+			return nil, err
+		}
+		proxyGroups = policyRouting.Groups
+	} else {
+		proxyGroups = g.generateProxyGroupsV112(nodeOutbounds, manualNodeNames)
+	}
+
+	// 节点检测选择器（配合检测入站使用）
+	if opts.CheckPort > 0 && len(nodeOutbounds) > 0 {
+		tags := make([]string, 0, len(nodeOutbounds))
+		for _, n := range nodeOutbounds {
+			tags = append(tags, n.Tag)
+		}
+		proxyGroups = append(proxyGroups, SBOutbound{
+			Tag:       NodeCheckGroup,
+			Type:      "selector",
+			Outbounds: tags,
+		})
+	}
 
 	// 组合所有 outbounds
 	// 顺序: 代理组 -> 节点 -> 特殊出站(direct/block/dns-out)
@@ -649,22 +676,104 @@ func (g *SingboxGenerator) GenerateConfigV112(nodes []ProxyNode, opts SingBoxGen
 	config.Outbounds = allOutbounds
 
 	// 添加路由规则
-	config.Route.Rules = GetDefaultRouteRules()
-	config.Route.RuleSet = GetDefaultRuleSets()
+	if opts.Policy != nil {
+		config.Route.Rules = append(policyBaseRouteRules(proxyGroups), policyRouting.Rules...)
+		config.Route.RuleSet = policyRouting.RuleSets
+		if policyRouting.Final != "" {
+			config.Route.Final = policyRouting.Final
+		} else if len(proxyGroups) > 0 {
+			config.Route.Final = proxyGroups[0].Tag
+		}
+		applyPolicySingBoxDNS(config.DNS, opts.Policy.DNS)
+	} else {
+		config.Route.Rules = GetDefaultRouteRules()
+		config.Route.RuleSet = GetDefaultRuleSets()
+	}
+
+	// 代理设置中的 DNS 策略
+	if err := applyDNSSettingsSingBox(config.DNS, opts.DNSSettings); err != nil {
+		return nil, err
+	}
+
+	// 模板自定义规则（sing-box 专属匹配条件），优先于分流规则
+	if opts.Template != nil && len(opts.Template.CustomRules) > 0 {
+		if err := opts.Template.Validate(); err != nil {
+			return nil, err
+		}
+		custom := make([]SBRouteRule, 0, len(opts.Template.CustomRules))
+		for _, r := range opts.Template.CustomRules {
+			custom = append(custom, r.ToRouteRule())
+		}
+		config.Route.Rules = insertAfterBaseRules(config.Route.Rules, custom)
+	}
+	// 局域网设备规则优先于全局规则
+	if len(opts.Devices) > 0 {
+		config.Route.Rules = insertAfterBaseRules(config.Route.Rules, singBoxDeviceRules(opts.Devices, config))
+	}
+	if opts.Policy != nil || opts.Template != nil || len(opts.Devices) > 0 || opts.DNSSettings != nil {
+		ensureSingBoxRuleSets(config)
+	}
+
+	// 透明代理入站：TPROXY / REDIRECT 与 DNS 劫持入站
+	if inbounds := singBoxTransparentInbounds(opts); len(inbounds) > 0 {
+		tags := make([]string, 0, len(inbounds))
+		ports := make(map[int]bool, len(inbounds))
+		for _, in := range inbounds {
+			tags = append(tags, in.Tag)
+			ports[in.ListenPort] = true
+		}
+		// 模板的 http/socks 默认端口可能与透明代理端口冲突，冲突时由 mixed 入站代替
+		kept := config.Inbounds[:0]
+		for _, in := range config.Inbounds {
+			if !ports[in.ListenPort] {
+				kept = append(kept, in)
+			}
+		}
+		config.Inbounds = append(kept, inbounds...)
+		for i := range config.Route.Rules {
+			if config.Route.Rules[i].Action == "sniff" && len(config.Route.Rules[i].Inbound) > 0 {
+				config.Route.Rules[i].Inbound = append(config.Route.Rules[i].Inbound, tags...)
+				break
+			}
+		}
+	}
+	if opts.RoutingMark > 0 {
+		config.Route.DefaultMark = opts.RoutingMark
+	}
+
+	// 节点检测入站：固定走检测选择器，优先于所有规则
+	if opts.CheckPort > 0 && len(nodeOutbounds) > 0 {
+		config.Inbounds = append(config.Inbounds, SBInbound{
+			Tag:        "node-check-in",
+			Type:       "mixed",
+			Listen:     "127.0.0.1",
+			ListenPort: opts.CheckPort,
+		})
+		config.Route.Rules = append([]SBRouteRule{{
+			Inbound:  []string{"node-check-in"},
+			Outbound: NodeCheckGroup,
+		}}, config.Route.Rules...)
+	}
+
+	// 写入前校验出站与规则集引用
+	if err := validateSingBoxRoute(config); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
 func (g *SingboxGenerator) generateProxyGroupsV112(nodes []SBOutbound, manualNodeNames []string) []SBOutbound {
-	// 地区过滤关键字 (与 Mihomo 保持一致)
-	regionFilters := map[string][]string{
-		"HongKong":  {"🇭🇰", "HK", "hk", "香港", "港", "HongKong", "Hong Kong", "HONG KONG", "沪港", "呼港", "中港", "HKT", "HKBN", "HGC", "WTT", "CMI", "穗港", "广港", "京港"},
-		"Taiwan":    {"🇨🇳", "🇹🇼", "TW", "tw", "台湾", "台灣", "臺灣", "台北", "台中", "新北", "彰化", "CHT", "HINET", "Taiwan", "TAIWAN"},
-		"Japan":     {"🇯🇵", "JP", "jp", "日本", "东京", "東京", "大阪", "埼玉", "京日", "苏日", "沪日", "广日", "上日", "穗日", "川日", "中日", "泉日", "杭日", "深日", "Japan", "JAPAN"},
-		"Singapore": {"🇸🇬", "SG", "sg", "新加坡", "狮城", "獅城", "沪新", "京新", "泉新", "穗新", "深新", "杭新", "广新", "廣新", "滬新", "Singapore", "SINGAPORE"},
-		"America":   {"🇺🇸", "US", "us", "美国", "美國", "京美", "硅谷", "凤凰城", "洛杉矶", "西雅图", "圣何塞", "芝加哥", "哥伦布", "纽约", "广美", "America", "United States", "USA"},
+	// 获取基础代理组
+	groups := GetSingBoxProxyGroups()
+
+	// 与基础代理组同名的地区（如 香港节点），其余地区的节点归入 其他节点
+	groupTags := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		groupTags[group.Tag] = true
 	}
+	patterns := ActiveRegions()
 
-	// 分类节点
+	// 分类节点（与 Mihomo 共用地区表）
 	regionGroups := make(map[string][]string)
 	otherNodes := []string{}
 	allNodeTags := []string{}
@@ -683,32 +792,12 @@ func (g *SingboxGenerator) generateProxyGroupsV112(nodes []SBOutbound, manualNod
 			continue
 		}
 
-		matched := false
-		for region, keywords := range regionFilters {
-			if matchesKeywords(node.Tag, keywords) {
-				regionGroups[region] = append(regionGroups[region], node.Tag)
-				matched = true
-				break
-			}
-		}
-		if !matched {
+		if region, ok := ClassifyNode(patterns, node.Tag); ok && groupTags[region.Name] {
+			regionGroups[region.Name] = append(regionGroups[region.Name], node.Tag)
+		} else {
 			otherNodes = append(otherNodes, node.Tag)
 		}
 	}
-	regionGroups["Manual"] = manualNodeNames // 使用传入的手动节点名称列表
-	regionGroups["Others"] = otherNodes
-
-	// 获取基础代理组
-	groups := GetSingBoxProxyGroups()
-
-	// 地区名称映射（英文 -> 中文）
-	regionNameMap := map[string]string{
-		"HongKong":  "香港节点",
-		"Taiwan":    "台湾节点",
-		"Japan":     "日本节点",
-		"Singapore": "新加坡节点",
-		"America":   "美国节点",
-	}
 
 	// 填充节点
 	for i := range groups {
@@ -717,15 +806,8 @@ func (g *SingboxGenerator) generateProxyGroupsV112(nodes []SBOutbound, manualNod
 			// 自动测速添加所有节点
 			groups[i].Outbounds = allNodeTags
 		case "香港节点", "台湾节点", "日本节点", "新加坡节点", "美国节点":
-			// 地区组填充对应节点（需要反向查找英文 key）
-			var englishKey string
-			for k, v := range regionNameMap {
-				if v == groups[i].Tag {
-					englishKey = k
-					break
-				}
-			}
-			if regionNodes, ok := regionGroups[englishKey]; ok && len(regionNodes) > 0 {
+			// 地区组填充对应节点
+			if regionNodes, ok := regionGroups[groups[i].Tag]; ok && len(regionNodes) > 0 {
 				groups[i].Outbounds = regionNodes
 			} else {
 				// 如果没有节点，添加 节点选择 作为后备
@@ -775,17 +857,6 @@ func (g *SingboxGenerator) generateProxyGroupsV112(nodes []SBOutbound, manualNod
 	return validGroups
 }
 
-// matchesKeywords 检查名称是否匹配关键字
-func matchesKeywords(name string, keywords []string) bool {
-	nameLower := strings.ToLower(name)
-	for _, kw := range keywords {
-		if strings.Contains(nameLower, strings.ToLower(kw)) {
-			return true
-		}
-	}
-	return false
-}
-
 // SaveConfigV112 保存 1.12+ 配置到文件
 func (g *SingboxGenerator) SaveConfigV112(config *SingBoxConfig, filename string) (string, error) {
 	configDir := filepath.Join(g.dataDir, "configs")
@@ -804,7 +875,7 @@ func (g *SingboxGenerator) SaveConfigV112(config *SingBoxConfig, filename string
 		return "", err
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := writePrivateFile(filePath, data); err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Sing-Box 规则模板转换与校验
+// ============================================================================
+
+// singBoxRuleActions 规则模板允许的动作
+var singBoxRuleActions = map[string]bool{
+	"": true, "route": true, "route-options": true, "reject": true,
+	"hijack-dns": true, "sniff": true, "resolve": true,
+}
+
+// ruleSetTags 统一 rule_set 字段（JSON 解码后可能为 string、[]string 或 []interface{}）
+func ruleSetTags(ref interface{}) []string {
+	switch v := ref.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		tags := make([]string, 0, len(v))
+		for _, item := range v {
+			if tag, ok := item.(string); ok && tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return tags
+	}
+	return nil
+}
+
+// hasMatch 规则是否包含匹配条件
+func (r SingBoxRuleTemplate) hasMatch() bool {
+	return len(r.Inbound) > 0 || r.Network != "" || len(r.Protocol) > 0 ||
+		len(r.Domain) > 0 || len(r.DomainSuffix) > 0 || len(r.DomainKeyword) > 0 || len(r.DomainRegex) > 0 ||
+		len(r.IPCIDR) > 0 || r.IPIsPrivate || len(r.SourceIPCIDR) > 0 ||
+		len(r.Port) > 0 || len(r.PortRange) > 0 || len(r.SourcePort) > 0 || len(r.SourcePortRange) > 0 ||
+		len(r.ProcessName) > 0 || r.ClashMode != "" || len(ruleSetTags(r.RuleSet)) > 0
+}
+
+// ToRouteRule 转换为 sing-box 路由规则（DIRECT / REJECT 转换为内置出站）
+func (r SingBoxRuleTemplate) ToRouteRule() SBRouteRule {
+	rule := SBRouteRule{
+		Inbound:         r.Inbound,
+		Network:         r.Network,
+		Domain:          r.Domain,
+		DomainSuffix:    r.DomainSuffix,
+		DomainKeyword:   r.DomainKeyword,
+		DomainRegex:     r.DomainRegex,
+		IPCIDR:          r.IPCIDR,
+		IPIsPrivate:     r.IPIsPrivate,
+		SourceIPCIDR:    r.SourceIPCIDR,
+		PortRange:       r.PortRange,
+		SourcePort:      r.SourcePort,
+		SourcePortRange: r.SourcePortRange,
+		ProcessName:     r.ProcessName,
+		ClashMode:       r.ClashMode,
+		Type:            r.Type,
+		Mode:            r.Mode,
+		Invert:          r.Invert,
+		Action:          r.Action,
+	}
+	// interface{} 字段仅在非空时赋值，避免输出 null
+	if len(r.Protocol) > 0 {
+		rule.Protocol = r.Protocol
+	}
+	if len(r.Port) > 0 {
+		rule.Port = r.Port
+	}
+	if tags := ruleSetTags(r.RuleSet); len(tags) == 1 {
+		rule.RuleSet = tags[0]
+	} else if len(tags) > 1 {
+		rule.RuleSet = tags
+	}
+	for _, sub := range r.Rules {
+		rule.Rules = append(rule.Rules, sub.ToRouteRule())
+	}
+
+	if r.Outbound == PolicyReject && r.Action == "" {
+		rule.Action = "reject"
+	} else if r.Outbound != "" {
+		rule.Outbound = singBoxOutboundName(r.Outbound)
+	}
+	return rule
+}
+
+// validate 校验规则结构（不检查出站是否存在）
+func (r SingBoxRuleTemplate) validate(nested bool) error {
+	if r.Type == "logical" {
+		if r.Mode != "and" && r.Mode != "or" {
+			return fmt.Errorf("逻辑规则 mode 必须为 and 或 or")
+		}
+		if len(r.Rules) == 0 {
+			return fmt.Errorf("逻辑规则缺少子规则")
+		}
+		if r.hasMatch() {
+			return fmt.Errorf("逻辑规则不能直接包含匹配条件，请放入子规则")
+		}
+		for i, sub := range r.Rules {
+			if err := sub.validate(true); err != nil {
+				return fmt.Errorf("子规则 #%d: %w", i+1, err)
+			}
+		}
+	} else {
+		if r.Type != "" && r.Type != "default" {
+			return fmt.Errorf("未知规则类型 %s", r.Type)
+		}
+		if len(r.Rules) > 0 || r.Mode != "" {
+			return fmt.Errorf("仅逻辑规则可以包含子规则")
+		}
+		if !r.hasMatch() {
+			return fmt.Errorf("规则缺少匹配条件")
+		}
+		if r.Network != "" && r.Network != "tcp" && r.Network != "udp" {
+			return fmt.Errorf("network 必须为 tcp 或 udp")
+		}
+		for _, pr := range append(append([]string{}, r.PortRange...), r.SourcePortRange...) {
+			if !strings.Contains(pr, ":") {
+				return fmt.Errorf("端口范围 %s 格式错误，应为 起始:结束", pr)
+			}
+		}
+	}
+
+	if nested {
+		if r.Outbound != "" || r.Action != "" {
+			return fmt.Errorf("子规则不能设置出站或动作")
+		}
+		return nil
+	}
+	if !singBoxRuleActions[r.Action] {
+		return fmt.Errorf("未知动作 %s", r.Action)
+	}
+	if (r.Action == "" || r.Action == "route") && r.Outbound == "" {
+		return fmt.Errorf("规则缺少出站")
+	}
+	return nil
+}
+
+// Validate 校验模板规则结构
+func (t *SingBoxTemplate) Validate() error {
+	for _, g := range t.ProxyGroups {
+		switch g.Type {
+		case "selector", "urltest":
+		case "load-balance":
+			return fmt.Errorf("代理组 %s: sing-box 没有负载均衡出站，请改用 urltest（按延迟自动选择）", g.Tag)
+		default:
+			return fmt.Errorf("代理组 %s 类型无效: %s（仅支持 selector、urltest）", g.Tag, g.Type)
+		}
+	}
+	for i, r := range t.Rules {
+		if err := r.validate(false); err != nil {
+			return fmt.Errorf("规则 #%d: %w", i+1, err)
+		}
+	}
+	for i, r := range t.CustomRules {
+		if err := r.validate(false); err != nil {
+			return fmt.Errorf("自定义规则 #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// insertAfterBaseRules 将规则插入到嗅探、DNS 劫持与 Clash 模式规则之后
+func insertAfterBaseRules(rules, extra []SBRouteRule) []SBRouteRule {
+	if len(extra) == 0 {
+		return rules
+	}
+	idx := 0
+	for idx < len(rules) {
+		r := rules[idx]
+		if r.Action != "sniff" && r.Action != "hijack-dns" && r.ClashMode == "" && len(r.Inbound) == 0 {
+			break
+		}
+		idx++
+	}
+	result := make([]SBRouteRule, 0, len(rules)+len(extra))
+	result = append(result, rules[:idx]...)
+	result = append(result, extra...)
+	return append(result, rules[idx:]...)
+}
+
+// validateSingBoxRoute 写入配置前校验路由规则引用的出站与规则集
+func validateSingBoxRoute(config *SingBoxConfig) error {
+	if config.Route == nil {
+		return nil
+	}
+	outbounds := make(map[string]bool, len(config.Outbounds))
+	for _, o := range config.Outbounds {
+		outbounds[o.Tag] = true
+	}
+	ruleSets := make(map[string]bool, len(config.Route.RuleSet))
+	for _, rs := range config.Route.RuleSet {
+		ruleSets[rs.Tag] = true
+	}
+
+	var check func(rules []SBRouteRule, path string) error
+	check = func(rules []SBRouteRule, path string) error {
+		for i, r := range rules {
+			where := fmt.Sprintf("%s#%d", path, i+1)
+			if r.Outbound != "" && !outbounds[r.Outbound] {
+				return fmt.Errorf("sing-box 路由规则 %s 引用了不存在的出站: %s", where, r.Outbound)
+			}
+			for _, tag := range ruleSetTags(r.RuleSet) {
+				if !ruleSets[tag] {
+					return fmt.Errorf("sing-box 路由规则 %s 引用了不存在的规则集: %s", where, tag)
+				}
+			}
+			if err := check(r.Rules, where+"."); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(config.Route.Rules, ""); err != nil {
+		return err
+	}
+	if config.Route.Final != "" && !outbounds[config.Route.Final] {
+		return fmt.Errorf("sing-box 默认出站不存在: %s", config.Route.Final)
+	}
+	return nil
+}
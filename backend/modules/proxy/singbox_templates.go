@@ -25,11 +25,36 @@ type SingBoxProxyGroupTemplate struct {
 	Tolerance   int      `json:"tolerance,omitempty"`
 }
 
-// SingBoxRuleTemplate Sing-Box 规则模板
+// SingBoxRuleTemplate Sing-Box 规则模板（字段与 SBRouteRule 对应）
 type SingBoxRuleTemplate struct {
-	RuleSet  interface{} `json:"rule_set,omitempty"` // string or []string
-	Outbound string      `json:"outbound,omitempty"`
-	Action   string      `json:"action,omitempty"`
+	// 匹配条件
+	Inbound         []string    `json:"inbound,omitempty"`
+	Network         string      `json:"network,omitempty"` // tcp, udp
+	Protocol        []string    `json:"protocol,omitempty"`
+	Domain          []string    `json:"domain,omitempty"`
+	DomainSuffix    []string    `json:"domain_suffix,omitempty"`
+	DomainKeyword   []string    `json:"domain_keyword,omitempty"`
+	DomainRegex     []string    `json:"domain_regex,omitempty"`
+	IPCIDR          []string    `json:"ip_cidr,omitempty"`
+	IPIsPrivate     bool        `json:"ip_is_private,omitempty"`
+	SourceIPCIDR    []string    `json:"source_ip_cidr,omitempty"`
+	Port            []int       `json:"port,omitempty"`
+	PortRange       []string    `json:"port_range,omitempty"` // 1000:2000
+	SourcePort      []int       `json:"source_port,omitempty"`
+	SourcePortRange []string    `json:"source_port_range,omitempty"`
+	ProcessName     []string    `json:"process_name,omitempty"`
+	ClashMode       string      `json:"clash_mode,omitempty"`
+	RuleSet         interface{} `json:"rule_set,omitempty"` // string or []string
+
+	// 逻辑规则
+	Type   string                `json:"type,omitempty"` // logical
+	Mode   string                `json:"mode,omitempty"` // and, or
+	Rules  []SingBoxRuleTemplate `json:"rules,omitempty"`
+	Invert bool                  `json:"invert,omitempty"`
+
+	// 动作
+	Outbound string `json:"outbound,omitempty"`
+	Action   string `json:"action,omitempty"`
 }
 
 // SingBoxRuleSetTemplate Sing-Box 规则集模板
@@ -46,6 +71,8 @@ type SingBoxTemplate struct {
 	ProxyGroups []SingBoxProxyGroupTemplate `json:"proxyGroups"`
 	Rules       []SingBoxRuleTemplate       `json:"rules"`
 	RuleSets    []SingBoxRuleSetTemplate    `json:"ruleSets"`
+	// CustomRules sing-box 专属规则，插入在分流策略规则之前
+	CustomRules []SingBoxRuleTemplate `json:"customRules,omitempty"`
 }
 
 // GetSingBoxTUNTemplate 获取 TUN 模式配置模板
@@ -259,6 +286,22 @@ func GetSingBoxSystemTemplate(opts SingBoxGeneratorOptions) *SingBoxConfig {
 	return config
 }
 
+// singBoxTransparentInbounds 透明代理入站（TPROXY / REDIRECT 及 DNS 劫持）
+func singBoxTransparentInbounds(opts SingBoxGeneratorOptions) []SBInbound {
+	inbounds := make([]SBInbound, 0, 3)
+	if opts.TProxyPort > 0 {
+		inbounds = append(inbounds, SBInbound{Tag: "tproxy-in", Type: "tproxy", Listen: "::", ListenPort: opts.TProxyPort})
+	}
+	if opts.RedirPort > 0 {
+		inbounds = append(inbounds, SBInbound{Tag: "redirect-in", Type: "redirect", Listen: "::", ListenPort: opts.RedirPort})
+	}
+	// nftables 将局域网 53 端口重定向到此入站，经嗅探后由 hijack-dns 处理
+	if opts.DNSPort > 0 {
+		inbounds = append(inbounds, SBInbound{Tag: "dns-in", Type: "direct", Listen: "0.0.0.0", ListenPort: opts.DNSPort})
+	}
+	return inbounds
+}
+
 // ============================================================================
 // DNS 模板
 // ============================================================================
@@ -57,6 +57,7 @@ type SBDNS struct {
 	Final            string        `json:"final,omitempty"`
 	Strategy         string        `json:"strategy,omitempty"` // prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only
 	IndependentCache bool          `json:"independent_cache,omitempty"`
+	ClientSubnet     string        `json:"client_subnet,omitempty"` // ECS
 	ReverseMapping   bool          `json:"reverse_mapping,omitempty"`
 	Fakeip           *SBFakeIP     `json:"fakeip,omitempty"`
 }
@@ -68,6 +69,10 @@ type SBDNSServer struct {
 	ServerPort   int    `json:"server_port,omitempty"` // DNS 服务器端口 (sing-box 1.12+)
 	Detour       string `json:"detour,omitempty"`      // 已弃用，保留兼容
 	ClientSubnet string `json:"client_subnet,omitempty"`
+	Path         string `json:"path,omitempty"`      // https / h3 请求路径
+	Interface    string `json:"interface,omitempty"` // dhcp 网卡
+	// hosts 专用
+	Predefined map[string][]string `json:"predefined,omitempty"`
 	// FakeIP 专用
 	Inet4Range string `json:"inet4_range,omitempty"`
 	Inet6Range string `json:"inet6_range,omitempty"`
@@ -81,6 +86,7 @@ type SBDNSRule struct {
 	RuleSet      interface{} `json:"rule_set,omitempty"` // string 或 []string
 	Domain       []string    `json:"domain,omitempty"`
 	DomainSuffix []string    `json:"domain_suffix,omitempty"`
+	DomainRegex  []string    `json:"domain_regex,omitempty"`
 	Outbound     string      `json:"outbound,omitempty"`
 
 	// 逻辑规则
@@ -270,6 +276,7 @@ type SBRoute struct {
 	AutoDetectInterface   bool              `json:"auto_detect_interface,omitempty"`
 	DefaultInterface      string            `json:"default_interface,omitempty"`
 	DefaultDomainResolver *SBDomainResolver `json:"default_domain_resolver,omitempty"`
+	DefaultMark           int               `json:"default_mark,omitempty"`
 }
 
 type SBDomainResolver struct {
@@ -292,6 +299,11 @@ type SBRouteRule struct {
 	ClashMode     string      `json:"clash_mode,omitempty"`
 	RuleSet       interface{} `json:"rule_set,omitempty"` // string 或 []string
 
+	Network         string   `json:"network,omitempty"` // tcp, udp
+	SourcePort      []int    `json:"source_port,omitempty"`
+	SourcePortRange []string `json:"source_port_range,omitempty"`
+	ProcessName     []string `json:"process_name,omitempty"`
+
 	// 逻辑规则
 	Type   string        `json:"type,omitempty"` // logical
 	Mode   string        `json:"mode,omitempty"` // and, or
@@ -341,6 +353,9 @@ type SingBoxGeneratorOptions struct {
 	ClashAPIAddr   string `json:"clashApiAddr"`
 	ClashAPISecret string `json:"clashApiSecret"`
 
+	// 节点检测端口（0 表示不生成检测入站）
+	CheckPort int `json:"checkPort"`
+
 	// TUN 设置
 	TUNStack     string `json:"tunStack"` // system, gvisor, mixed
 	TUNMTU       int    `json:"tunMtu"`
@@ -359,4 +374,19 @@ type SingBoxGeneratorOptions struct {
 
 	// 日志
 	LogLevel string `json:"logLevel"`
+
+	// 分流策略（可选，设置后替代内置代理组与路由规则）
+	Policy *RoutingPolicy `json:"-"`
+	// sing-box 模板（自定义规则）
+	Template *SingBoxTemplate `json:"-"`
+	// 局域网设备策略（已解析来源地址）
+	Devices []resolvedDevice `json:"-"`
+	// 代理设置中的 DNS 策略
+	DNSSettings *DNSSettings `json:"-"`
+
+	// 透明代理入站（0 表示不生成），由 nftables 规则引流
+	TProxyPort  int `json:"tproxyPort"`
+	RedirPort   int `json:"redirPort"`
+	DNSPort     int `json:"dnsPort"`     // 局域网 DNS 劫持的目标端口
+	RoutingMark int `json:"routingMark"` // 核心出站标记
 }
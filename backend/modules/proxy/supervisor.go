@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 核心进程守护参数
+const (
+	maxCrashHistory   = 20               // 保留的崩溃记录条数
+	crashLogLines     = 30               // 每次崩溃记录的日志行数
+	crashBackoffBase  = time.Second      // 首次重启等待
+	crashBackoffMax   = time.Minute      // 最大重启等待
+	crashStableUptime = 2 * time.Minute  // 运行超过该时长视为稳定，重置连续崩溃计数
+	defaultStopWait   = 10 * time.Second // 默认 SIGTERM 等待时间
+)
+
+// CrashRecord 核心崩溃记录
+type CrashRecord struct {
+	Time     time.Time `json:"time"`
+	ExitCode int       `json:"exitCode"`
+	Signal   string    `json:"signal,omitempty"`
+	Uptime   int64     `json:"uptime"`            // 崩溃前运行秒数
+	Action   string    `json:"action"`            // restart: 已安排重启, give-up: 达到崩溃循环上限
+	Backoff  int64     `json:"backoff,omitempty"` // 重启等待毫秒
+	LastLogs []string  `json:"lastLogs"`
+}
+
+// launchCore 启动核心进程并交由守护协程监控（调用者需持有写锁）
+func (s *Service) launchCore(corePath, configPath string) error {
+	// 构建命令 - 根据核心类型使用不同参数
+	// Mihomo: -d <workdir> -f <config>
+	// Sing-Box: run -D <workdir> -c <config>
+	var cmd *exec.Cmd
+	if s.coreType == "singbox" {
+		cmd = exec.Command(corePath, "run", "-D", s.dataDir, "-c", configPath)
+		// 启用已弃用的特殊出站（direct），代理组需要引用"直连"
+		cmd.Env = append(os.Environ(), "ENABLE_DEPRECATED_SPECIAL_OUTBOUNDS=true")
+	} else {
+		cmd = exec.Command(corePath, "-d", s.dataDir, "-f", configPath)
+	}
+	cmd.Dir = s.dataDir
+
+	// 创建管道捕获输出
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("启动核心失败: %w", err)
+	}
+
+	// 启动日志收集（守护协程等两路输出读完再回收进程，崩溃前的输出才能完整记录）
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		s.collectLogs(stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		s.collectLogs(stderr)
+	}()
+
+	done := make(chan struct{})
+	s.process = cmd
+	s.processDone = done
+	s.running = true
+	s.startTime = time.Now()
+	s.configPath = configPath
+
+	// 监控进程
+	go s.watchProcess(cmd, done, &readers)
+
+	// TPROXY / REDIRECT 模式安装 nftables 规则
+	s.applyFirewall()
+	return nil
+}
+
+// watchProcess 等待核心退出，非主动停止时记录崩溃并按退避策略重启
+func (s *Service) watchProcess(cmd *exec.Cmd, done chan struct{}, readers *sync.WaitGroup) {
+	defer close(done)
+	// Wait 会关闭输出管道，必须在日志读取到 EOF 之后调用
+	readers.Wait()
+	cmd.Wait()
+
+	s.mu.Lock()
+	if s.process != cmd {
+		// 主动停止或已被替换
+		s.mu.Unlock()
+		return
+	}
+
+	uptime := time.Since(s.startTime)
+	if uptime > crashStableUptime {
+		s.consecutiveCrashes = 0
+	}
+	s.consecutiveCrashes++
+
+	record := CrashRecord{
+		Time:     time.Now(),
+		ExitCode: -1,
+		Uptime:   int64(uptime.Seconds()),
+		LastLogs: s.GetLogs(crashLogLines),
+	}
+	if state := cmd.ProcessState; state != nil {
+		record.ExitCode = state.ExitCode()
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			record.Signal = ws.Signal().String()
+		}
+	}
+
+	s.running = false
+	s.process = nil
+	s.processDone = nil
+
+	limit := s.config.CrashRestartLimit
+	giveUp := !s.supervising || s.consecutiveCrashes > limit
+	var backoff time.Duration
+	if giveUp {
+		record.Action = "give-up"
+		s.crashLoop = s.supervising
+		s.supervising = false
+	} else {
+		backoff = crashBackoffBase << uint(s.consecutiveCrashes-1)
+		if backoff > crashBackoffMax {
+			backoff = crashBackoffMax
+		}
+		record.Action = "restart"
+		record.Backoff = backoff.Milliseconds()
+	}
+
+	s.crashes = append(s.crashes, record)
+	if len(s.crashes) > maxCrashHistory {
+		s.crashes = s.crashes[len(s.crashes)-maxCrashHistory:]
+	}
+	gen := s.superviseGen
+	crashCount := s.consecutiveCrashes
+	s.mu.Unlock()
+
+	msg := fmt.Sprintf("[ERROR] 核心异常退出 (exit=%d", record.ExitCode)
+	if record.Signal != "" {
+		msg += ", signal=" + record.Signal
+	}
+	msg += ")"
+	s.addLog(msg)
+
+	// 核心已退出，先移除规则避免流量被引向无人监听的端口（重启后重新安装）
+	s.removeFirewall()
+
+	if giveUp {
+		fmt.Printf("❌ 核心连续崩溃 %d 次，停止自动重启\n", crashCount)
+		s.restoreSystemState()
+		return
+	}
+
+	fmt.Printf("⚠️ 核心异常退出，%v 后自动重启 (第 %d 次)\n", backoff, crashCount)
+	go s.restartAfterCrash(gen, backoff)
+}
+
+// restartAfterCrash 退避等待后重新拉起核心（期间被停止或重新启动则放弃）
+func (s *Service) restartAfterCrash(gen int, backoff time.Duration) {
+	time.Sleep(backoff)
+
+	s.mu.Lock()
+	if gen != s.superviseGen || !s.supervising || s.running {
+		s.mu.Unlock()
+		return
+	}
+
+	corePath := s.findCorePath()
+	var err error
+	if corePath == "" {
+		err = fmt.Errorf("核心文件未找到")
+	} else {
+		err = s.launchCore(corePath, s.configPath)
+	}
+	if err == nil {
+		s.mu.Unlock()
+		s.addLog("[INFO] 核心已自动重启")
+		fmt.Println("✓ 核心已自动重启")
+		return
+	}
+
+	s.supervising = false
+	s.crashLoop = true
+	s.mu.Unlock()
+
+	fmt.Printf("❌ 自动重启核心失败: %v\n", err)
+	s.restoreSystemState()
+}
+
+// terminateProcess 先发送 SIGTERM，超时后 SIGKILL（Windows 直接结束）
+func terminateProcess(cmd *exec.Cmd, done chan struct{}, timeout time.Duration) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	if timeout <= 0 {
+		timeout = defaultStopWait
+	}
+
+	if runtime.GOOS == "windows" {
+		cmd.Process.Kill()
+	} else if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		cmd.Process.Kill()
+	}
+
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		fmt.Printf("⚠️ 核心 %v 内未退出，强制结束\n", timeout)
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// GetCrashHistory 获取崩溃记录
+func (s *Service) GetCrashHistory() []CrashRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]CrashRecord, len(s.crashes))
+	copy(result, s.crashes)
+	return result
+}
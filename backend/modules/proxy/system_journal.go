@@ -0,0 +1,392 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// 主机变更日志
+// TUN 准备与透明代理对主机的每一项修改都先写入日志，停止、异常退出后启动
+// 或手动恢复时按相反顺序撤销
+// ============================================================================
+
+// 变更类型
+const (
+	ChangeService  = "service"  // 停止/禁用系统服务
+	ChangeFile     = "file"     // 覆盖系统文件
+	ChangeSysctl   = "sysctl"   // 修改内核参数
+	ChangeFirewall = "firewall" // 安装 nftables 表
+	ChangeProcess  = "process"  // 结束占用端口的进程（不可恢复）
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// SystemChange 一条主机变更记录
+type SystemChange struct {
+	Kind     string    `json:"kind"`
+	Target   string    `json:"target"`             // 服务名 / 文件路径 / sysctl 键 / nft 表
+	Action   string    `json:"action"`             // stop, stop-disable, overwrite, set, install, kill
+	Previous string    `json:"previous,omitempty"` // 原状态：服务 active/inactive、sysctl 原值、文件原符号链接目标
+	Enabled  bool      `json:"enabled,omitempty"`  // 服务原先是否开机启动
+	Backup   string    `json:"backup,omitempty"`   // 文件备份路径（为空且无符号链接表示原先不存在）
+	FileMode uint32    `json:"fileMode,omitempty"`
+	Undo     []string  `json:"undo,omitempty"` // 防火墙清理命令
+	Time     time.Time `json:"time"`
+}
+
+// SystemRestoreResult 单条变更的撤销结果
+type SystemRestoreResult struct {
+	Change SystemChange `json:"change"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// SystemPrepStep TUN 准备步骤预览
+type SystemPrepStep struct {
+	Step        string `json:"step"`
+	Description string `json:"description"`
+	Destructive bool   `json:"destructive"` // 需要在设置中显式开启
+	Enabled     bool   `json:"enabled"`     // 启动 TUN 时是否会执行
+	Needed      bool   `json:"needed"`      // 当前系统状态下是否需要
+}
+
+// journalPath 变更日志文件路径
+func journalPath(dataDir string) string {
+	return filepath.Join(dataDir, "system_journal.json")
+}
+
+// LoadSystemJournal 加载主机变更日志
+func LoadSystemJournal(dataDir string) ([]SystemChange, error) {
+	data, err := os.ReadFile(journalPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	var changes []SystemChange
+	if err := json.Unmarshal(data, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// SaveSystemJournal 保存主机变更日志（为空时删除文件）
+func SaveSystemJournal(dataDir string, changes []SystemChange) error {
+	if len(changes) == 0 {
+		err := os.Remove(journalPath(dataDir))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(journalPath(dataDir), data, 0644)
+}
+
+// loadJournal 启动时加载变更日志
+func (s *Service) loadJournal() {
+	changes, err := LoadSystemJournal(s.dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("⚠️ 加载主机变更日志失败: %v\n", err)
+		}
+		changes = []SystemChange{}
+	}
+	s.journal = changes
+}
+
+// recordChange 写入一条变更（同一目标只保留最早的原状态）
+func (s *Service) recordChange(change SystemChange) {
+	if s.hasChange(change.Kind, change.Target) {
+		return
+	}
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	change.Time = time.Now()
+	s.journal = append(s.journal, change)
+	if err := SaveSystemJournal(s.dataDir, s.journal); err != nil {
+		fmt.Printf("⚠️ 保存主机变更日志失败: %v\n", err)
+	}
+}
+
+// hasChange 目标是否已有未撤销的变更
+func (s *Service) hasChange(kind, target string) bool {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	for _, c := range s.journal {
+		if c.Kind == kind && c.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetChange 删除已由其他途径撤销的变更
+func (s *Service) forgetChange(kind, target string) {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	kept := s.journal[:0]
+	for _, c := range s.journal {
+		if c.Kind != kind || c.Target != target {
+			kept = append(kept, c)
+		}
+	}
+	s.journal = kept
+	if err := SaveSystemJournal(s.dataDir, s.journal); err != nil {
+		fmt.Printf("⚠️ 保存主机变更日志失败: %v\n", err)
+	}
+}
+
+// GetSystemJournal 获取尚未撤销的主机变更
+func (s *Service) GetSystemJournal() []SystemChange {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	return append([]SystemChange{}, s.journal...)
+}
+
+// RestoreSystemChanges 按相反顺序撤销全部变更，撤销失败的记录保留以便重试
+// 撤销期间不持有 journalMu，避免与持有 mu 再记录变更的启动流程互相等待
+func (s *Service) RestoreSystemChanges() []SystemRestoreResult {
+	s.journalMu.Lock()
+	changes := s.journal
+	s.journal = []SystemChange{}
+	s.journalMu.Unlock()
+
+	results := make([]SystemRestoreResult, 0, len(changes))
+	failed := make([]SystemChange, 0)
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		result := SystemRestoreResult{Change: change}
+		if err := undoChange(change); err != nil {
+			result.Error = err.Error()
+			fmt.Printf("⚠️ 撤销主机变更失败 [%s %s]: %v\n", change.Kind, change.Target, err)
+			if change.Kind != ChangeProcess {
+				failed = append([]SystemChange{change}, failed...)
+			}
+		} else {
+			s.addLog(fmt.Sprintf("[INFO] 已撤销主机变更: %s %s", change.Kind, change.Target))
+			if change.Kind == ChangeFirewall {
+				s.mu.Lock()
+				s.firewall = nil
+				s.mu.Unlock()
+			}
+		}
+		results = append(results, result)
+	}
+
+	// 撤销期间新记录的变更排在失败记录之后
+	s.journalMu.Lock()
+	s.journal = append(failed, s.journal...)
+	if err := SaveSystemJournal(s.dataDir, s.journal); err != nil {
+		fmt.Printf("⚠️ 保存主机变更日志失败: %v\n", err)
+	}
+	s.journalMu.Unlock()
+	return results
+}
+
+// RestoreSystemIfStopped 手动撤销主机变更，核心运行或等待自动重启时拒绝（撤销会移除其正在使用的规则与参数）
+func (s *Service) RestoreSystemIfStopped() ([]SystemRestoreResult, error) {
+	s.mu.RLock()
+	running := s.running || s.supervising
+	s.mu.RUnlock()
+	if running {
+		return nil, fmt.Errorf("核心运行中，请先停止代理再撤销主机变更")
+	}
+	return s.RestoreSystemChanges(), nil
+}
+
+// RecoverSystemChanges 启动时撤销上次异常退出遗留的主机变更
+func (s *Service) RecoverSystemChanges() {
+	if len(s.GetSystemJournal()) > 0 {
+		fmt.Println("🔄 检测到上次未正常停止，正在撤销遗留的主机变更...")
+		for _, r := range s.RestoreSystemChanges() {
+			if r.Error == "" {
+				fmt.Printf("✓ 已撤销 %s %s\n", r.Change.Kind, r.Change.Target)
+			}
+		}
+	}
+	// 兼容未记录日志的旧版本残留规则
+	s.CleanupStaleFirewall()
+}
+
+// undoChange 撤销单条变更
+func undoChange(change SystemChange) error {
+	switch change.Kind {
+	case ChangeService:
+		if strings.Contains(change.Action, "disable") && change.Enabled {
+			if output, err := exec.Command("systemctl", "enable", change.Target).CombinedOutput(); err != nil {
+				return fmt.Errorf("启用服务失败: %s", firewallOutput(output, err))
+			}
+		}
+		if change.Previous == "active" {
+			if output, err := exec.Command("systemctl", "start", change.Target).CombinedOutput(); err != nil {
+				return fmt.Errorf("启动服务失败: %s", firewallOutput(output, err))
+			}
+		}
+	case ChangeFile:
+		if err := os.Remove(change.Target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		switch {
+		case change.Previous != "":
+			return os.Symlink(change.Previous, change.Target)
+		case change.Backup != "":
+			data, err := os.ReadFile(change.Backup)
+			if err != nil {
+				return fmt.Errorf("读取备份失败: %w", err)
+			}
+			mode := os.FileMode(change.FileMode)
+			if mode == 0 {
+				mode = 0644
+			}
+			if err := os.WriteFile(change.Target, data, mode); err != nil {
+				return err
+			}
+			os.Remove(change.Backup)
+		}
+	case ChangeSysctl:
+		if output, err := exec.Command("sysctl", "-w", change.Target+"="+change.Previous).CombinedOutput(); err != nil {
+			return fmt.Errorf("恢复内核参数失败: %s", firewallOutput(output, err))
+		}
+	case ChangeFirewall:
+		runFirewallCommands(change.Undo)
+	case ChangeProcess:
+		return fmt.Errorf("已结束的进程无法自动恢复，请手动重新启动相关服务")
+	}
+	return nil
+}
+
+// ============================================================================
+// 记录并执行主机修改
+// ============================================================================
+
+// stopServiceJournaled 记录服务原状态后停止（可选禁用）
+func (s *Service) stopServiceJournaled(name string, disable bool) {
+	previous := "inactive"
+	if s.isServiceActive(name) {
+		previous = "active"
+	}
+	enabled := exec.Command("systemctl", "is-enabled", "--quiet", name).Run() == nil
+	action := "stop"
+	if disable {
+		action = "stop-disable"
+	}
+	s.recordChange(SystemChange{Kind: ChangeService, Target: name, Action: action, Previous: previous, Enabled: enabled})
+
+	exec.Command("systemctl", "stop", name).Run()
+	if disable {
+		exec.Command("systemctl", "disable", name).Run()
+	}
+}
+
+// writeFileJournaled 备份原文件（或符号链接）后写入新内容
+func (s *Service) writeFileJournaled(path string, content []byte) error {
+	change := SystemChange{Kind: ChangeFile, Target: path, Action: "overwrite"}
+	// 已记录过的文件不再备份，避免用修改后的内容覆盖原始备份
+	if info, err := os.Lstat(path); err == nil && !s.hasChange(ChangeFile, path) {
+		if info.Mode()&os.ModeSymlink != 0 {
+			change.Previous, _ = os.Readlink(path)
+		} else {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("备份 %s 失败: %w", path, err)
+			}
+			backupDir := filepath.Join(s.dataDir, "system-backup")
+			os.MkdirAll(backupDir, 0755)
+			change.Backup = filepath.Join(backupDir, strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", "_"))
+			change.FileMode = uint32(info.Mode().Perm())
+			if err := os.WriteFile(change.Backup, data, 0600); err != nil {
+				return fmt.Errorf("备份 %s 失败: %w", path, err)
+			}
+		}
+	}
+	s.recordChange(change)
+
+	os.Remove(path)
+	return os.WriteFile(path, content, 0644)
+}
+
+// setSysctlJournaled 记录原值后修改内核参数（已是目标值时跳过）
+func (s *Service) setSysctlJournaled(key, value string) {
+	output, err := exec.Command("sysctl", "-n", key).Output()
+	if err != nil {
+		return
+	}
+	previous := strings.TrimSpace(string(output))
+	if previous == value {
+		return
+	}
+	s.recordChange(SystemChange{Kind: ChangeSysctl, Target: key, Action: "set", Previous: previous})
+	exec.Command("sysctl", "-w", key+"="+value).Run()
+}
+
+// ============================================================================
+// TUN 准备步骤预览
+// ============================================================================
+
+// systemPrepSettings 当前 TUN 准备设置
+func (s *Service) systemPrepSettings() SystemPrepSettings {
+	if s.settingsProvider != nil {
+		if settings := s.settingsProvider(); settings != nil {
+			return settings.SystemPrep
+		}
+	}
+	return SystemPrepSettings{}
+}
+
+// PreviewSystemPrepare 预览启动 TUN 时将对主机执行的修改（不修改系统）
+func (s *Service) PreviewSystemPrepare() []SystemPrepStep {
+	prep := s.systemPrepSettings()
+	linux := runtime.GOOS == "linux"
+	port53 := linux && s.isPortInUse(53)
+	sysctlDiffers := func(key string) bool {
+		output, err := exec.Command("sysctl", "-n", key).Output()
+		return err == nil && strings.TrimSpace(string(output)) != "1"
+	}
+	resolvedActive := linux && s.isServiceActive("systemd-resolved")
+	dnsmasqActive := linux && s.isServiceActive("dnsmasq")
+
+	return []SystemPrepStep{
+		{
+			Step:        "ip-forward",
+			Description: "启用 net.ipv4.ip_forward 与 net.ipv6.conf.all.forwarding（停止后恢复原值）",
+			Enabled:     linux,
+			Needed:      linux && (sysctlDiffers("net.ipv4.ip_forward") || sysctlDiffers("net.ipv6.conf.all.forwarding")),
+		},
+		{
+			Step:        "stop-systemd-resolved",
+			Description: "停止并禁用 systemd-resolved 以释放 53 端口",
+			Destructive: true,
+			Enabled:     linux && prep.StopResolvers,
+			Needed:      port53 && resolvedActive,
+		},
+		{
+			Step:        "stop-dnsmasq",
+			Description: "停止 dnsmasq 以释放 53 端口",
+			Destructive: true,
+			Enabled:     linux && prep.StopResolvers,
+			Needed:      port53 && dnsmasqActive,
+		},
+		{
+			Step:        "rewrite-resolv-conf",
+			Description: "备份 /etc/resolv.conf 并改为 nameserver 127.0.0.1",
+			Destructive: true,
+			Enabled:     linux && prep.RewriteResolvConf,
+			Needed:      linux && resolvedActive,
+		},
+		{
+			Step:        "kill-port-53",
+			Description: "使用 fuser -k 结束仍占用 53 端口的进程（不可恢复）",
+			Destructive: true,
+			Enabled:     linux && prep.KillPort53,
+			Needed:      port53,
+		},
+	}
+}
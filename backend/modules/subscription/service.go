@@ -58,6 +58,8 @@ type Service struct {
 	subscriptions map[string]*Subscription
 	stopChan      chan struct{}
 	mu            sync.RWMutex
+
+	onUpdated func() // 订阅节点更新成功后回调（用于刷新代理提供者）
 }
 
 func NewService(dataDir string) *Service {
@@ -108,12 +110,35 @@ func (s *Service) checkAndUpdate() {
 	s.mu.RUnlock()
 
 	// 更新订阅
+	updated := false
 	for _, sub := range subs {
-		s.updateSubscription(sub)
+		if s.updateSubscription(sub) == nil {
+			updated = true
+		}
 	}
 	if len(subs) > 0 {
 		s.saveSubscriptions()
 	}
+	if updated {
+		s.notifyUpdated()
+	}
+}
+
+// SetOnUpdated 设置订阅节点更新成功后的回调
+func (s *Service) SetOnUpdated(callback func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onUpdated = callback
+}
+
+// notifyUpdated 异步通知订阅节点已更新
+func (s *Service) notifyUpdated() {
+	s.mu.RLock()
+	callback := s.onUpdated
+	s.mu.RUnlock()
+	if callback != nil {
+		go callback()
+	}
 }
 
 // 停止定时更新
@@ -341,7 +366,11 @@ func (s *Service) Update(id string) error {
 		return err
 	}
 
-	return s.saveSubscriptions()
+	if err := s.saveSubscriptions(); err != nil {
+		return err
+	}
+	s.notifyUpdated()
+	return nil
 }
 
 func (s *Service) UpdateAll() error {
@@ -352,11 +381,20 @@ func (s *Service) UpdateAll() error {
 	}
 	s.mu.RUnlock()
 
+	updated := false
 	for _, sub := range subs {
-		s.updateSubscription(sub)
+		if s.updateSubscription(sub) == nil {
+			updated = true
+		}
 	}
 
-	return s.saveSubscriptions()
+	if err := s.saveSubscriptions(); err != nil {
+		return err
+	}
+	if updated {
+		s.notifyUpdated()
+	}
+	return nil
 }
 
 func (s *Service) updateSubscription(sub *Subscription) error {
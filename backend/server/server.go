@@ -119,7 +119,10 @@ func (s *Server) setupRoutes() {
 
 		// 代理模块
 		s.proxyHandler = proxy.NewHandler(s.config.DataDir)
+		s.proxyHandler.SetTrustedProxies(s.config.Server.TrustedProxies)
 		s.proxyHandler.RegisterRoutes(api.Group("/proxy"))
+		// 客户端配置下载（令牌认证，不经过登录中间件）
+		s.proxyHandler.RegisterExportRoutes(s.router.Group("/api/export"))
 
 		// 代理设置模块
 		settingsHandler := proxy.NewSettingsHandler(s.config.DataDir)
@@ -132,6 +135,15 @@ func (s *Server) setupRoutes() {
 			return settingsHandler.GetCurrentSettings()
 		})
 
+		// 切换配置方案时写入代理设置
+		s.proxyHandler.GetService().SetSettingsSaver(settingsHandler.ReplaceSettings)
+
+		// 透明代理规则放行面板端口，并撤销上次异常退出遗留的主机变更
+		s.proxyHandler.GetService().SetPanelPort(s.config.Server.Port)
+		// WebSocket 代理按当前核心地址与密钥连接
+		s.wsHub.SetCoreDialer(s.proxyHandler.GetService().DialCoreStream)
+		s.proxyHandler.GetService().RecoverSystemChanges()
+
 		// 检查自动启动
 		s.proxyHandler.GetService().AutoStartIfEnabled()
 
@@ -145,6 +157,9 @@ func (s *Server) setupRoutes() {
 			fmt.Printf("🔄 核心已切换为: %s\n", coreType)
 		})
 
+		// 切换配置方案时通过核心模块切换（校验核心已安装并持久化）
+		s.proxyHandler.GetService().SetCoreSwitcher(coreHandler.GetService().SwitchCore)
+
 		// 初始化时同步核心类型
 		s.proxyHandler.GetService().SetCoreType(coreHandler.GetService().GetCurrentCore())
 
@@ -161,6 +176,10 @@ func (s *Server) setupRoutes() {
 			nodes := nodeHandler.GetService().ListAll()
 			result := make([]proxy.ProxyNode, 0, len(nodes))
 			for _, n := range nodes {
+				// 被健康检查隔离的节点不进入代理组
+				if n.Quarantined {
+					continue
+				}
 				result = append(result, proxy.ProxyNode{
 					Name:       n.Name,
 					Type:       n.Type,
@@ -168,14 +187,49 @@ func (s *Server) setupRoutes() {
 					ServerPort: n.ServerPort,
 					Config:     n.Config,
 					IsManual:   n.IsManual,
+
+					SubscriptionID: n.SubscriptionID,
 				})
 			}
 			return result
 		})
 
+		// 导入 Clash 配置时将内联节点添加为手动节点
+		s.proxyHandler.GetService().SetManualNodeImporter(func(name, nodeType, server string, port int, config map[string]interface{}) error {
+			_, err := nodeHandler.GetService().AddManualAdvanced(name, nodeType, server, port, config)
+			return err
+		})
+
+		// 隔离节点集合变化时重新生成配置
+		nodeHandler.GetService().SetOnQuarantineChange(func() {
+			fmt.Println("🩺 隔离节点集合已变化，重新加载配置...")
+			if err := s.proxyHandler.GetService().ReloadNodes(); err != nil {
+				fmt.Printf("⚠️ 重新加载配置失败: %v\n", err)
+			}
+		})
+
+		// 订阅更新后使新节点生效（提供者模式下只刷新提供者）
+		subHandler.GetService().SetOnUpdated(func() {
+			if !s.proxyHandler.GetService().GetStatus().Running {
+				return
+			}
+			fmt.Println("🔄 订阅已更新，刷新节点...")
+			if err := s.proxyHandler.GetService().ReloadNodes(); err != nil {
+				fmt.Printf("⚠️ 刷新节点失败: %v\n", err)
+			}
+		})
+
+		// 定时测速完成后推送到前端
+		nodeHandler.GetService().SetOnLatencyTestComplete(func(result *node.LatencyTestResult) {
+			s.wsHub.Broadcast("latency_test_complete", result)
+		})
+
 		// 系统管理模块
 		systemHandler := system.NewHandler(s.config.DataDir)
+		systemHandler.SetProxyAddressProvider(s.proxyHandler.GetService().MixedProxyAddress)
 		systemHandler.RegisterRoutes(api.Group("/system"))
+		// 主机变更日志与恢复
+		s.proxyHandler.RegisterSystemRoutes(api.Group("/system"))
 
 		// 规则集模块 (Mihomo)
 		rulesetService := ruleset.NewService(s.config.DataDir)
@@ -204,12 +258,17 @@ func (s *Server) setupRoutes() {
 		})
 	}
 
-	// WebSocket 路由
+	// WebSocket 路由（核心流式接口由后端携带核心 API 密钥代理，需要登录）
 	ws := s.router.Group("/ws")
+	ws.Use(s.authHandler.WebSocketAuthMiddleware())
 	{
 		ws.GET("/traffic", s.wsHub.HandleTraffic)
 		ws.GET("/logs", s.wsHub.HandleLogs)
 		ws.GET("/connections", s.wsHub.HandleConnections)
+		ws.GET("/events", s.wsHub.HandleEvents)
+		if s.proxyHandler != nil {
+			ws.GET("/core-logs", s.proxyHandler.StreamLogs)
+		}
 	}
 
 	// 前端路由 fallback (SPA)
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Event 面板推送事件
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+	Time int64       `json:"time"`
+}
+
+// eventClient 事件订阅客户端
+type eventClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// Broadcast 向所有事件订阅客户端推送事件
+func (h *Hub) Broadcast(eventType string, data interface{}) {
+	msg, err := json.Marshal(Event{
+		Type: eventType,
+		Data: data,
+		Time: time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("[WebSocket] 事件序列化失败: %v", err)
+		return
+	}
+
+	select {
+	case h.broadcast <- msg:
+	default:
+		log.Printf("[WebSocket] 事件队列已满，丢弃事件: %s", eventType)
+	}
+}
+
+// HandleEvents 处理面板事件 WebSocket（测速完成等通知）
+func (h *Hub) HandleEvents(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("[WebSocket] 升级连接失败: %v", err)
+		return
+	}
+
+	client := &eventClient{
+		conn: conn,
+		send: make(chan []byte, 64),
+	}
+	h.register <- client
+
+	// 写循环
+	go func() {
+		defer conn.Close()
+		for msg := range client.send {
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 读循环仅用于检测断开
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	h.unregister <- client
+}
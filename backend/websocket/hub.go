@@ -16,36 +16,76 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// Hub WebSocket 连接管理中心 (代理模式)
-type Hub struct{}
+// Hub WebSocket 连接管理中心 (代理模式 + 面板事件推送)
+type Hub struct {
+	clients    map[*eventClient]bool
+	register   chan *eventClient
+	unregister chan *eventClient
+	broadcast  chan []byte
+
+	coreDialer CoreDialer
+}
+
+// CoreDialer 连接核心流式接口（地址与密钥由代理模块按当前配置提供）
+type CoreDialer func(path, rawQuery string) (*websocket.Conn, error)
+
+// SetCoreDialer 设置核心流式接口连接方式
+func (h *Hub) SetCoreDialer(dialer CoreDialer) {
+	h.coreDialer = dialer
+}
 
 // NewHub 创建 Hub
 func NewHub() *Hub {
-	return &Hub{}
+	return &Hub{
+		clients:    make(map[*eventClient]bool),
+		register:   make(chan *eventClient),
+		unregister: make(chan *eventClient),
+		broadcast:  make(chan []byte, 256),
+	}
 }
 
-// Run 运行 Hub (保留接口兼容)
+// Run 运行 Hub 事件循环（代理连接不经过此循环）
 func (h *Hub) Run() {
-	// 代理模式不需要运行循环
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+		case client := <-h.unregister:
+			if _, ok := h.clients[client]; ok {
+				delete(h.clients, client)
+				close(client.send)
+			}
+		case msg := <-h.broadcast:
+			for client := range h.clients {
+				select {
+				case client.send <- msg:
+				default:
+					// 客户端过慢，断开
+					delete(h.clients, client)
+					close(client.send)
+				}
+			}
+		}
+	}
 }
 
-// HandleTraffic 处理流量 WebSocket (代理到 Mihomo)
+// HandleTraffic 处理流量 WebSocket (代理到核心)
 func (h *Hub) HandleTraffic(c *gin.Context) {
-	h.proxyMihomoWebSocket(c, "/traffic")
+	h.proxyCoreWebSocket(c, "/traffic")
 }
 
-// HandleLogs 处理日志 WebSocket (代理到 Mihomo)
+// HandleLogs 处理日志 WebSocket (代理到核心)
 func (h *Hub) HandleLogs(c *gin.Context) {
-	h.proxyMihomoWebSocket(c, "/logs")
+	h.proxyCoreWebSocket(c, "/logs")
 }
 
-// HandleConnections 处理连接 WebSocket (代理到 Mihomo)
+// HandleConnections 处理连接 WebSocket (代理到核心)
 func (h *Hub) HandleConnections(c *gin.Context) {
-	h.proxyMihomoWebSocket(c, "/connections")
+	h.proxyCoreWebSocket(c, "/connections")
 }
 
-// proxyMihomoWebSocket 代理 Mihomo WebSocket
-func (h *Hub) proxyMihomoWebSocket(c *gin.Context, path string) {
+// proxyCoreWebSocket 代理核心 WebSocket 流式接口
+func (h *Hub) proxyCoreWebSocket(c *gin.Context, path string) {
 	log.Printf("[WebSocket] 收到代理请求: %s", path)
 
 	// 升级前端连接为 WebSocket
@@ -56,34 +96,29 @@ func (h *Hub) proxyMihomoWebSocket(c *gin.Context, path string) {
 	}
 	defer clientConn.Close()
 
-	// 连接到 Mihomo WebSocket
-	// Mihomo API 默认监听 127.0.0.1:9090
-	mihomoURL := "ws://127.0.0.1:9090" + path
-
-	// 转发所有查询参数 (token, level 等)
-	queryString := c.Request.URL.RawQuery
-	if queryString != "" {
-		mihomoURL += "?" + queryString
+	if h.coreDialer == nil {
+		clientConn.WriteMessage(websocket.TextMessage, []byte(`{"error":"核心接口未配置"}`))
+		return
 	}
 
-	log.Printf("[WebSocket] 连接 Mihomo: %s", mihomoURL)
-	mihomoConn, _, err := websocket.DefaultDialer.Dial(mihomoURL, nil)
+	// 连接核心 WebSocket，转发所有查询参数 (level 等)
+	coreConn, err := h.coreDialer(path, c.Request.URL.RawQuery)
 	if err != nil {
-		log.Printf("[WebSocket] 连接 Mihomo 失败: %v", err)
-		clientConn.WriteMessage(websocket.TextMessage, []byte(`{"error":"无法连接到 Mihomo: `+err.Error()+`"}`))
+		log.Printf("[WebSocket] 连接核心失败: %v", err)
+		clientConn.WriteMessage(websocket.TextMessage, []byte(`{"error":"无法连接到核心: `+err.Error()+`"}`))
 		return
 	}
-	defer mihomoConn.Close()
-	log.Printf("[WebSocket] 已连接 Mihomo, 开始转发")
+	defer coreConn.Close()
+	log.Printf("[WebSocket] 已连接核心, 开始转发")
 
 	// 双向转发
 	done := make(chan struct{})
 
-	// Mihomo -> 前端
+	// 核心 -> 前端
 	go func() {
 		defer close(done)
 		for {
-			msgType, msg, err := mihomoConn.ReadMessage()
+			msgType, msg, err := coreConn.ReadMessage()
 			if err != nil {
 				return
 			}
@@ -93,14 +128,14 @@ func (h *Hub) proxyMihomoWebSocket(c *gin.Context, path string) {
 		}
 	}()
 
-	// 前端 -> Mihomo
+	// 前端 -> 核心
 	go func() {
 		for {
 			msgType, msg, err := clientConn.ReadMessage()
 			if err != nil {
 				return
 			}
-			if err := mihomoConn.WriteMessage(msgType, msg); err != nil {
+			if err := coreConn.WriteMessage(msgType, msg); err != nil {
 				return
 			}
 		}
@@ -0,0 +1,33 @@
+// Panel event push (/ws/events) - notifications broadcast by the backend, e.g. scheduled latency tests
+import { getWsUrl } from './mihomo'
+
+export interface PanelEvent<T = unknown> {
+  type: string
+  data?: T
+  time: number // Unix seconds
+}
+
+// Payload of 'latency_test_complete'
+export interface LatencyTestResult {
+  scope: string
+  total: number
+  success: number
+  failed: number
+  startedAt: string
+  duration: number // ms
+  results: Record<string, number> // node ID -> delay (0 = timeout)
+}
+
+export const eventsApi = {
+  createEventsWs(onEvent: (event: PanelEvent) => void): WebSocket {
+    const ws = new WebSocket(getWsUrl('/ws/events'))
+    ws.onmessage = (e) => {
+      try {
+        onEvent(JSON.parse(e.data))
+      } catch {
+        // ignore parse errors
+      }
+    }
+    return ws
+  },
+}
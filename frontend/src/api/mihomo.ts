@@ -3,6 +3,18 @@
 // Use backend proxy (avoid CORS issues)
 const getProxyApiBase = () => '/api/proxy/mihomo'
 
+// Backend WebSocket proxy URL (browsers cannot set headers on WebSocket, so the login token goes in the query)
+export const getWsUrl = (path: string, params: Record<string, string> = {}) => {
+  const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
+  const query = new URLSearchParams(params)
+  const token = localStorage.getItem('SkyNeT-token')
+  if (token) {
+    query.set('token', token)
+  }
+  const qs = query.toString()
+  return `${protocol}//${window.location.host}${path}${qs ? `?${qs}` : ''}`
+}
+
 // Direct access to Mihomo API (only for WebSocket)
 const getDirectApiBase = () => {
   const host = window.location.hostname || '127.0.0.1'
@@ -157,9 +169,7 @@ export const mihomoApi = {
 
   // Connections real-time update WebSocket (via backend WebSocket proxy)
   createConnectionsWs(onMessage: (data: unknown) => void): WebSocket {
-    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
-    const host = window.location.host
-    const ws = new WebSocket(`${protocol}//${host}/ws/connections`)
+    const ws = new WebSocket(getWsUrl('/ws/connections'))
     ws.onmessage = (e) => {
       try {
         const data = JSON.parse(e.data)
@@ -173,9 +183,7 @@ export const mihomoApi = {
 
   // Traffic stats (via backend WebSocket proxy)
   createTrafficWs(onMessage: (data: { up: number; down: number }) => void): WebSocket {
-    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
-    const host = window.location.host
-    const ws = new WebSocket(`${protocol}//${host}/ws/traffic`)
+    const ws = new WebSocket(getWsUrl('/ws/traffic'))
     ws.onmessage = (e) => {
       try {
         const data = JSON.parse(e.data)
@@ -189,9 +197,7 @@ export const mihomoApi = {
 
   // Logs (via backend WebSocket proxy)
   createLogsWs(onMessage: (data: LogEntry) => void, level = 'info'): WebSocket {
-    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
-    const host = window.location.host
-    const ws = new WebSocket(`${protocol}//${host}/ws/logs?level=${level}`)
+    const ws = new WebSocket(getWsUrl('/ws/logs', { level }))
     ws.onmessage = (e) => {
       try {
         const data = JSON.parse(e.data)
@@ -202,6 +208,39 @@ export const mihomoApi = {
     }
     return ws
   },
+
+  // Core logs captured by the backend (works for both mihomo and sing-box)
+  createCoreLogsWs(onMessage: (data: LogEntry, time: string) => void, level = 'info'): WebSocket {
+    const ws = new WebSocket(getWsUrl('/ws/core-logs', { level }))
+    ws.onmessage = (e) => {
+      try {
+        const data: CoreLogEntry = JSON.parse(e.data)
+        onMessage({ type: coreLogType(data.level), payload: data.raw || data.message }, data.time)
+      } catch {
+        // ignore parse errors
+      }
+    }
+    return ws
+  },
+}
+
+// Structured entry pushed by /ws/core-logs
+export interface CoreLogEntry {
+  time: string
+  level: 'debug' | 'info' | 'warn' | 'error' | 'fatal'
+  component?: string
+  message: string
+  raw: string
+}
+
+const coreLogType = (level: CoreLogEntry['level']): LogEntry['type'] => {
+  switch (level) {
+    case 'warn': return 'warning'
+    case 'error':
+    case 'fatal': return 'error'
+    case 'debug': return 'debug'
+    default: return 'info'
+  }
 }
 
 export interface LogEntry {
@@ -13,7 +13,6 @@ export interface SingBoxGenerateOptions {
   httpPort?: number            // HTTP 代理端口
   socksPort?: number           // SOCKS5 代理端口
   clashApiAddr: string         // Clash API 地址
-  clashApiSecret?: string      // Clash API 密钥
   tunStack: 'system' | 'gvisor' | 'mixed'  // TUN 栈类型
   tunMtu?: number              // TUN MTU
   dnsStrategy: 'prefer_ipv4' | 'prefer_ipv6' | 'ipv4_only' | 'ipv6_only'
@@ -25,7 +24,13 @@ export interface SingBoxGenerateResult {
   configPath: string
   nodeCount: number
   mode: string
-  validationError?: string  // 配置验证错误信息
+}
+
+// 核心校验错误（code 2）
+export interface SingBoxValidationError {
+  configPath: string
+  errors: { file?: string; path?: string; message: string }[]
+  output?: string
 }
 
 // Sing-Box 设置 (持久化保存)
@@ -37,7 +42,6 @@ export interface SingBoxSettings {
   socksPort: number
   // Clash API
   clashApiAddr: string
-  clashApiSecret: string
   // TUN 设置
   tunStack: 'system' | 'gvisor' | 'mixed'
   tunMtu: number
@@ -117,7 +121,6 @@ export const getPlatformDefaultSettings = (): SingBoxSettings => {
     httpPort: 7891,
     socksPort: 7892,
     clashApiAddr: '127.0.0.1:9090',
-    clashApiSecret: '',
     tunStack: 'system',
     tunMtu: 9000,
     autoRedirect: true,
@@ -137,17 +140,20 @@ export const getPlatformDefaultSettings = (): SingBoxSettings => {
 // 默认设置 (根据平台自动选择)
 export const defaultSingBoxSettings: SingBoxSettings = getPlatformDefaultSettings()
 
-// 生成配置响应
+// 生成配置响应（校验失败时 code 为 2，data 为校验错误）
 export interface SingBoxGenerateResponse {
   code: number
   message: string
-  data: SingBoxGenerateResult
+  data?: SingBoxGenerateResult | SingBoxValidationError
 }
 
 export const singboxApi = {
   // 生成 Sing-Box 配置
   generateConfig: async (options: Partial<SingBoxGenerateOptions>): Promise<SingBoxGenerateResponse> => {
-    const res = await client.post('/proxy/singbox/generate', options)
+    // 校验失败返回 400，仍需读取结构化错误
+    const res = await client.post('/proxy/singbox/generate', options, {
+      validateStatus: (status) => status < 500,
+    })
     return res.data
   },
 
@@ -249,8 +249,9 @@ export default function ConfigGeneratorPage() {
         const result = await singboxApi.generateConfig(settings)
         
         // 检查是否有验证错误 (code === 2 表示验证失败)
-        if (result.code === 2 && result.data?.validationError) {
-          setErrorMessage(result.data.validationError)
+        if (result.code === 2 && result.data && 'errors' in result.data) {
+          const errors = result.data.errors.map((e) => (e.path ? `${e.path}: ${e.message}` : e.message))
+          setErrorMessage(errors.join('\n') || result.message)
           setShowError(true)
           return
         }
@@ -264,7 +265,7 @@ export default function ConfigGeneratorPage() {
         
         // 验证成功提示
         toast.success(t('configGenerator.generateSuccess') || '配置生成成功', {
-          description: `${t('configGenerator.validationPassed') || '配置验证通过'} - ${result.data?.nodeCount || 0} ${t('nodes.title') || '节点'}`
+          description: `${t('configGenerator.validationPassed') || '配置验证通过'} - ${(result.data && 'nodeCount' in result.data ? result.data.nodeCount : 0)} ${t('nodes.title') || '节点'}`
         })
       } else {
         // 生成 Mihomo 配置
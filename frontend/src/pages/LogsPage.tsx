@@ -37,12 +37,12 @@ export default function LogsPage() {
       wsRef.current?.close()
       // 切换级别时清空日志
       setLogs([])
-      wsRef.current = mihomoApi.createLogsWs((data) => {
+      wsRef.current = mihomoApi.createCoreLogsWs((data, time) => {
         if (pausedRef.current) return
         const logItem: LogItem = {
           ...data,
           id: idRef.current++,
-          time: new Date().toLocaleTimeString()
+          time: new Date(time).toLocaleTimeString()
         }
         setLogs(prev => [...prev.slice(-500), logItem])
       }, level)
@@ -3,6 +3,7 @@ import { useTranslation } from 'react-i18next'
 import { Plus, RefreshCw, Trash2, Zap, Copy, Loader2, Globe, Server, Search, X, Filter, ChevronDown, Link } from 'lucide-react'
 import { nodeApi, Node } from '@/api/node'
 import { subscriptionApi, Subscription } from '@/api/subscription'
+import { eventsApi, LatencyTestResult } from '@/api/events'
 import { cn, getLatencyColor, formatLatency } from '@/lib/utils'
 import { useThemeStore } from '@/stores/themeStore'
 import { AddNodeDialog } from '@/components/AddNodeDialog'
@@ -56,6 +57,37 @@ export default function NodesPage() {
     fetchData()
   }, [])
 
+  // 订阅面板事件：定时测速完成后直接更新延迟（带自动重连）
+  useEffect(() => {
+    let eventsWs: WebSocket | null = null
+    let reconnectTimer: ReturnType<typeof setTimeout> | null = null
+    let closed = false
+
+    const connectEventsWs = () => {
+      eventsWs = eventsApi.createEventsWs((event) => {
+        if (event.type !== 'latency_test_complete' || !event.data) return
+        const { results } = event.data as LatencyTestResult
+        setNodes(prev => prev.map(n =>
+          n.id in results ? { ...n, delay: results[n.id] } : n
+        ))
+      })
+      eventsWs.onclose = () => {
+        // 3秒后重连
+        if (!closed) reconnectTimer = setTimeout(connectEventsWs, 3000)
+      }
+      eventsWs.onerror = () => {
+        eventsWs?.close()
+      }
+    }
+
+    connectEventsWs()
+    return () => {
+      closed = true
+      if (reconnectTimer) clearTimeout(reconnectTimer)
+      eventsWs?.close()
+    }
+  }, [])
+
   const fetchData = async () => {
     try {
       setLoading(true)
@@ -537,18 +537,6 @@ export default function SingBoxSettingsPage() {
             themeStyle={themeStyle}
           />
         </FormField>
-        <FormField
-          label={t('singboxSettings.apiSecret')}
-          description={t('singboxSettings.apiSecretDesc')}
-          themeStyle={themeStyle}
-        >
-          <TextInput
-            value={settings.clashApiSecret}
-            onChange={(v) => updateSettings('clashApiSecret', v)}
-            placeholder={t('singboxSettings.apiSecretPlaceholder')}
-            themeStyle={themeStyle}
-          />
-        </FormField>
       </SettingsSection>
 
       {/* 日志设置 */}